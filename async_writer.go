@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// asyncWriter writes data to the underlying writer in a separate goroutine.
+// Writes don't block: the data is queued in memory until the underlying
+// writer accepts it, so a slow client can't stall the encoder
+type asyncWriter struct {
+	w io.Writer
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  [][]byte
+	closed bool
+	err    error
+
+	done chan struct{}
+}
+
+func newAsyncWriter(w io.Writer) *asyncWriter {
+	aw := &asyncWriter{
+		w:    w,
+		done: make(chan struct{}),
+	}
+	aw.cond = sync.NewCond(&aw.mu)
+
+	go aw.run()
+
+	return aw
+}
+
+func (aw *asyncWriter) Write(p []byte) (int, error) {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+
+	if aw.err != nil {
+		return 0, aw.err
+	}
+
+	// The caller may reuse the buffer after the write, so we need to copy it
+	aw.queue = append(aw.queue, append([]byte(nil), p...))
+	aw.cond.Signal()
+
+	return len(p), nil
+}
+
+func (aw *asyncWriter) run() {
+	defer close(aw.done)
+
+	for {
+		aw.mu.Lock()
+
+		for len(aw.queue) == 0 && !aw.closed {
+			aw.cond.Wait()
+		}
+
+		if len(aw.queue) == 0 {
+			aw.mu.Unlock()
+			return
+		}
+
+		chunk := aw.queue[0]
+		aw.queue[0] = nil
+		aw.queue = aw.queue[1:]
+
+		aw.mu.Unlock()
+
+		if _, err := aw.w.Write(chunk); err != nil {
+			aw.mu.Lock()
+			aw.err = err
+			aw.queue = nil
+			aw.mu.Unlock()
+
+			return
+		}
+	}
+}
+
+// Close waits until all the queued data is written to the underlying writer.
+// When discard is true, the data that isn't written yet is dropped
+func (aw *asyncWriter) Close(discard bool) error {
+	aw.mu.Lock()
+	aw.closed = true
+	if discard {
+		aw.queue = nil
+	}
+	aw.cond.Signal()
+	aw.mu.Unlock()
+
+	<-aw.done
+
+	return aw.err
+}
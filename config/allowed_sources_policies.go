@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
+)
+
+func configureAllowedSourcesPolicies() error {
+	var names []string
+	configurators.StringSlice(&names, "IMGPROXY_ALLOWED_SOURCES_POLICIES")
+
+	for _, name := range names {
+		if !tenantNameRe.MatchString(name) {
+			return fmt.Errorf("Invalid allowed sources policy name: %s", name)
+		}
+
+		var sources []*regexp.Regexp
+		configurators.Patterns(&sources, fmt.Sprintf("IMGPROXY_ALLOWED_SOURCES_POLICY_%s", strings.ToUpper(name)))
+
+		if len(sources) == 0 {
+			return fmt.Errorf("Allowed sources of the policy %s are not set", name)
+		}
+
+		AllowedSourcesPolicies[name] = sources
+	}
+
+	return nil
+}
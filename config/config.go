@@ -22,23 +22,34 @@ var (
 	WriteTimeout      int
 	KeepAliveTimeout  int
 	DownloadTimeout   int
+	DecodeTimeout     float64
 	Concurrency       int
 	RequestsQueueSize int
 	MaxClients        int
 
+	InfoBatchMaxSize     int
+	InfoBatchConcurrency int
+
 	TTL                     int
 	CacheControlPassthrough bool
 	SetCanonicalHeader      bool
+	SetFormatHeader         bool
 
 	SoReuseport bool
 
 	PathPrefix string
 
-	MaxSrcResolution   int
-	MaxSrcFileSize     int
-	MaxAnimationFrames int
-	MaxSvgCheckBytes   int
-	MaxRedirects       int
+	MaxSrcResolution      int
+	MaxSrcResolutionLimit int
+	MaxSrcFileSize        int
+	MaxAnimationFrames    int
+	AnimationConcurrency  int
+	EnableVideoThumbnails bool
+	VideoThumbnailFPS     int
+	MaxSvgCheckBytes      int
+	MaxRedirects          int
+	MaxDpr                float64
+	MaxProcessingOptions  int
 
 	JpegProgressive       bool
 	PngInterlaced         bool
@@ -53,6 +64,10 @@ var (
 	AutoRotate            bool
 	EnforceThumbnail      bool
 	ReturnAttachment      bool
+	Reproducible          bool
+	ProcessingBudget      int
+	BlankThreshold        float64
+	Background            string
 
 	EnableWebpDetection bool
 	EnforceWebp         bool
@@ -61,12 +76,22 @@ var (
 	EnableClientHints   bool
 
 	PreferredFormats []imagetype.Type
+	DefaultFormat    string
 
 	SkipProcessingFormats []imagetype.Type
 
+	PassthroughUnsupportedFormats bool
+	PassthroughSmallerSource      bool
+	PassthroughGif                bool
+	AllowPassthrough              bool
+	StreamResult                  bool
+
 	UseLinearColorspace bool
 	DisableShrinkOnLoad bool
 
+	Sharpening   float64
+	MaxBlurSigma float64
+
 	Keys          [][]byte
 	Salts         [][]byte
 	SignatureSize int
@@ -77,21 +102,26 @@ var (
 
 	UserAgent string
 
+	SourceRequests []SourceRequest
+
 	IgnoreSslVerification bool
 	DevelopmentErrorsMode bool
 
-	AllowedSources []*regexp.Regexp
+	AllowedSources         []*regexp.Regexp
+	AllowedSourcesPolicies map[string][]*regexp.Regexp
 
-	SanitizeSvg bool
+	SanitizeSvg     bool
+	SanitizeSvgMode string
 
 	CookiePassthrough bool
 	CookieBaseURL     string
 
 	LocalFileSystemRoot string
 
-	S3Enabled  bool
-	S3Region   string
-	S3Endpoint string
+	S3Enabled      bool
+	S3Region       string
+	S3Endpoint     string
+	S3UsePathStyle bool
 
 	GCSEnabled  bool
 	GCSKey      string
@@ -112,6 +142,13 @@ var (
 	SwiftConnectTimeoutSeconds int
 	SwiftTimeoutSeconds        int
 
+	SFTPEnabled      bool
+	SFTPUser         string
+	SFTPPassword     string
+	SFTPKey          string
+	SFTPKnownHosts   string
+	SFTPAllowedHosts []string
+
 	ETagEnabled bool
 	ETagBuster  string
 
@@ -120,6 +157,9 @@ var (
 	Presets     []string
 	OnlyPresets bool
 
+	Tenants      []Tenant
+	TenantHeader string
+
 	WatermarkData    string
 	WatermarkPath    string
 	WatermarkURL     string
@@ -134,12 +174,24 @@ var (
 	DataDogEnable        bool
 	DataDogEnableMetrics bool
 
+	OpenTelemetryEnable bool
+
 	NewRelicAppName string
 	NewRelicKey     string
 	NewRelicLabels  map[string]string
 
 	PrometheusBind      string
 	PrometheusNamespace string
+	PrometheusExemplars bool
+
+	PrometheusDurationBuckets             []float64
+	PrometheusRequestDurationBuckets      []float64
+	PrometheusDownloadDurationBuckets     []float64
+	PrometheusProcessingDurationBuckets   []float64
+	PrometheusNativeHistogramBucketFactor float64
+	PrometheusEnableReset                 bool
+
+	StatsdAddr string
 
 	BugsnagKey   string
 	BugsnagStage string
@@ -157,7 +209,8 @@ var (
 
 	ReportDownloadingErrors bool
 
-	EnableDebugHeaders bool
+	EnableDebugHeaders   bool
+	EnableWarningsHeader bool
 
 	FreeMemoryInterval             int
 	DownloadBufferSize             int
@@ -187,23 +240,33 @@ func Reset() {
 	WriteTimeout = 10
 	KeepAliveTimeout = 10
 	DownloadTimeout = 5
+	DecodeTimeout = 0
 	Concurrency = runtime.NumCPU() * 2
 	RequestsQueueSize = 0
+	InfoBatchMaxSize = 100
+	InfoBatchConcurrency = 8
 	MaxClients = 2048
 
 	TTL = 31536000
 	CacheControlPassthrough = false
 	SetCanonicalHeader = false
+	SetFormatHeader = false
 
 	SoReuseport = false
 
 	PathPrefix = ""
 
 	MaxSrcResolution = 16800000
+	MaxSrcResolutionLimit = 0
 	MaxSrcFileSize = 0
 	MaxAnimationFrames = 1
+	AnimationConcurrency = 1
+	EnableVideoThumbnails = false
+	VideoThumbnailFPS = 10
 	MaxSvgCheckBytes = 32 * 1024
 	MaxRedirects = 10
+	MaxDpr = 8
+	MaxProcessingOptions = 100
 
 	JpegProgressive = false
 	PngInterlaced = false
@@ -218,6 +281,10 @@ func Reset() {
 	AutoRotate = true
 	EnforceThumbnail = false
 	ReturnAttachment = false
+	Reproducible = false
+	ProcessingBudget = 0
+	BlankThreshold = 2
+	Background = ""
 
 	EnableWebpDetection = false
 	EnforceWebp = false
@@ -233,12 +300,22 @@ func Reset() {
 		imagetype.AVIF,
 		imagetype.ICO,
 	}
+	DefaultFormat = "preferred"
 
 	SkipProcessingFormats = make([]imagetype.Type, 0)
 
+	PassthroughUnsupportedFormats = false
+	PassthroughSmallerSource = false
+	PassthroughGif = false
+	AllowPassthrough = false
+	StreamResult = false
+
 	UseLinearColorspace = false
 	DisableShrinkOnLoad = false
 
+	Sharpening = 0
+	MaxBlurSigma = 100
+
 	Keys = make([][]byte, 0)
 	Salts = make([][]byte, 0)
 	SignatureSize = 32
@@ -249,12 +326,16 @@ func Reset() {
 
 	UserAgent = fmt.Sprintf("imgproxy/%s", version.Version())
 
+	SourceRequests = make([]SourceRequest, 0)
+
 	IgnoreSslVerification = false
 	DevelopmentErrorsMode = false
 
 	AllowedSources = make([]*regexp.Regexp, 0)
+	AllowedSourcesPolicies = make(map[string][]*regexp.Regexp)
 
 	SanitizeSvg = true
+	SanitizeSvgMode = "strip"
 
 	CookiePassthrough = false
 	CookieBaseURL = ""
@@ -263,6 +344,7 @@ func Reset() {
 	S3Enabled = false
 	S3Region = ""
 	S3Endpoint = ""
+	S3UsePathStyle = true
 	GCSEnabled = false
 	GCSKey = ""
 	ABSEnabled = false
@@ -278,6 +360,12 @@ func Reset() {
 	SwiftDomain = ""
 	SwiftConnectTimeoutSeconds = 10
 	SwiftTimeoutSeconds = 60
+	SFTPEnabled = false
+	SFTPUser = ""
+	SFTPPassword = ""
+	SFTPKey = ""
+	SFTPKnownHosts = ""
+	SFTPAllowedHosts = make([]string, 0)
 
 	ETagEnabled = false
 	ETagBuster = ""
@@ -287,6 +375,9 @@ func Reset() {
 	Presets = make([]string, 0)
 	OnlyPresets = false
 
+	Tenants = make([]Tenant, 0)
+	TenantHeader = ""
+
 	WatermarkData = ""
 	WatermarkPath = ""
 	WatermarkURL = ""
@@ -300,12 +391,24 @@ func Reset() {
 
 	DataDogEnable = false
 
+	OpenTelemetryEnable = false
+
 	NewRelicAppName = ""
 	NewRelicKey = ""
 	NewRelicLabels = make(map[string]string)
 
 	PrometheusBind = ""
 	PrometheusNamespace = ""
+	PrometheusExemplars = false
+
+	PrometheusDurationBuckets = make([]float64, 0)
+	PrometheusRequestDurationBuckets = make([]float64, 0)
+	PrometheusDownloadDurationBuckets = make([]float64, 0)
+	PrometheusProcessingDurationBuckets = make([]float64, 0)
+	PrometheusNativeHistogramBucketFactor = 0
+	PrometheusEnableReset = false
+
+	StatsdAddr = ""
 
 	BugsnagKey = ""
 	BugsnagStage = "production"
@@ -324,6 +427,7 @@ func Reset() {
 	ReportDownloadingErrors = true
 
 	EnableDebugHeaders = false
+	EnableWarningsHeader = false
 
 	FreeMemoryInterval = 10
 	DownloadBufferSize = 0
@@ -343,29 +447,46 @@ func Configure() error {
 	configurators.Int(&WriteTimeout, "IMGPROXY_WRITE_TIMEOUT")
 	configurators.Int(&KeepAliveTimeout, "IMGPROXY_KEEP_ALIVE_TIMEOUT")
 	configurators.Int(&DownloadTimeout, "IMGPROXY_DOWNLOAD_TIMEOUT")
+	configurators.Float(&DecodeTimeout, "IMGPROXY_DECODE_TIMEOUT")
 	configurators.Int(&Concurrency, "IMGPROXY_CONCURRENCY")
 	configurators.Int(&RequestsQueueSize, "IMGPROXY_REQUESTS_QUEUE_SIZE")
 	configurators.Int(&MaxClients, "IMGPROXY_MAX_CLIENTS")
 
+	configurators.Int(&InfoBatchMaxSize, "IMGPROXY_INFO_BATCH_MAX_SIZE")
+	configurators.Int(&InfoBatchConcurrency, "IMGPROXY_INFO_BATCH_CONCURRENCY")
+
 	configurators.Int(&TTL, "IMGPROXY_TTL")
 	configurators.Bool(&CacheControlPassthrough, "IMGPROXY_CACHE_CONTROL_PASSTHROUGH")
 	configurators.Bool(&SetCanonicalHeader, "IMGPROXY_SET_CANONICAL_HEADER")
+	configurators.Bool(&SetFormatHeader, "IMGPROXY_SET_FORMAT_HEADER")
 
 	configurators.Bool(&SoReuseport, "IMGPROXY_SO_REUSEPORT")
 
 	configurators.String(&PathPrefix, "IMGPROXY_PATH_PREFIX")
 
 	configurators.MegaInt(&MaxSrcResolution, "IMGPROXY_MAX_SRC_RESOLUTION")
+	configurators.MegaInt(&MaxSrcResolutionLimit, "IMGPROXY_MAX_SRC_RESOLUTION_LIMIT")
 	configurators.Int(&MaxSrcFileSize, "IMGPROXY_MAX_SRC_FILE_SIZE")
 	configurators.Int(&MaxSvgCheckBytes, "IMGPROXY_MAX_SVG_CHECK_BYTES")
 
 	configurators.Int(&MaxAnimationFrames, "IMGPROXY_MAX_ANIMATION_FRAMES")
+	configurators.Int(&AnimationConcurrency, "IMGPROXY_ANIMATION_CONCURRENCY")
+	configurators.Bool(&EnableVideoThumbnails, "IMGPROXY_ENABLE_VIDEO_THUMBNAILS")
+	configurators.Int(&VideoThumbnailFPS, "IMGPROXY_VIDEO_THUMBNAIL_FPS")
 
 	configurators.Int(&MaxRedirects, "IMGPROXY_MAX_REDIRECTS")
 
+	configurators.Float(&MaxDpr, "IMGPROXY_MAX_DPR")
+
+	configurators.Int(&MaxProcessingOptions, "IMGPROXY_MAX_PROCESSING_OPTIONS")
+
 	configurators.Patterns(&AllowedSources, "IMGPROXY_ALLOWED_SOURCES")
+	if err := configureAllowedSourcesPolicies(); err != nil {
+		return err
+	}
 
 	configurators.Bool(&SanitizeSvg, "IMGPROXY_SANITIZE_SVG")
+	configurators.String(&SanitizeSvgMode, "IMGPROXY_SANITIZE_SVG_MODE")
 
 	configurators.Bool(&JpegProgressive, "IMGPROXY_JPEG_PROGRESSIVE")
 	configurators.Bool(&PngInterlaced, "IMGPROXY_PNG_INTERLACED")
@@ -382,6 +503,10 @@ func Configure() error {
 	configurators.Bool(&AutoRotate, "IMGPROXY_AUTO_ROTATE")
 	configurators.Bool(&EnforceThumbnail, "IMGPROXY_ENFORCE_THUMBNAIL")
 	configurators.Bool(&ReturnAttachment, "IMGPROXY_RETURN_ATTACHMENT")
+	configurators.Bool(&Reproducible, "IMGPROXY_REPRODUCIBLE")
+	configurators.Int(&ProcessingBudget, "IMGPROXY_PROCESSING_BUDGET")
+	configurators.Float(&BlankThreshold, "IMGPROXY_BLANK_THRESHOLD")
+	configurators.String(&Background, "IMGPROXY_BACKGROUND")
 
 	configurators.Bool(&EnableWebpDetection, "IMGPROXY_ENABLE_WEBP_DETECTION")
 	configurators.Bool(&EnforceWebp, "IMGPROXY_ENFORCE_WEBP")
@@ -395,13 +520,24 @@ func Configure() error {
 		return err
 	}
 
+	configurators.String(&DefaultFormat, "IMGPROXY_DEFAULT_FORMAT")
+
 	if err := configurators.ImageTypes(&SkipProcessingFormats, "IMGPROXY_SKIP_PROCESSING_FORMATS"); err != nil {
 		return err
 	}
 
+	configurators.Bool(&PassthroughUnsupportedFormats, "IMGPROXY_PASSTHROUGH_UNSUPPORTED_FORMATS")
+	configurators.Bool(&PassthroughSmallerSource, "IMGPROXY_PASSTHROUGH_SMALLER_SOURCE")
+	configurators.Bool(&PassthroughGif, "IMGPROXY_PASSTHROUGH_GIF")
+	configurators.Bool(&AllowPassthrough, "IMGPROXY_ALLOW_PASSTHROUGH")
+	configurators.Bool(&StreamResult, "IMGPROXY_STREAM_RESULT")
+
 	configurators.Bool(&UseLinearColorspace, "IMGPROXY_USE_LINEAR_COLORSPACE")
 	configurators.Bool(&DisableShrinkOnLoad, "IMGPROXY_DISABLE_SHRINK_ON_LOAD")
 
+	configurators.Float(&Sharpening, "IMGPROXY_SHARPENING")
+	configurators.Float(&MaxBlurSigma, "IMGPROXY_MAX_BLUR_SIGMA")
+
 	if err := configurators.Hex(&Keys, "IMGPROXY_KEY"); err != nil {
 		return err
 	}
@@ -423,6 +559,10 @@ func Configure() error {
 
 	configurators.String(&UserAgent, "IMGPROXY_USER_AGENT")
 
+	if err := configureSourceRequests(); err != nil {
+		return err
+	}
+
 	configurators.Bool(&IgnoreSslVerification, "IMGPROXY_IGNORE_SSL_VERIFICATION")
 	configurators.Bool(&DevelopmentErrorsMode, "IMGPROXY_DEVELOPMENT_ERRORS_MODE")
 
@@ -434,6 +574,7 @@ func Configure() error {
 	configurators.Bool(&S3Enabled, "IMGPROXY_USE_S3")
 	configurators.String(&S3Region, "IMGPROXY_S3_REGION")
 	configurators.String(&S3Endpoint, "IMGPROXY_S3_ENDPOINT")
+	configurators.Bool(&S3UsePathStyle, "IMGPROXY_S3_USE_PATH_STYLE")
 
 	configurators.Bool(&GCSEnabled, "IMGPROXY_USE_GCS")
 	configurators.String(&GCSKey, "IMGPROXY_GCS_KEY")
@@ -453,6 +594,13 @@ func Configure() error {
 	configurators.Int(&SwiftConnectTimeoutSeconds, "IMGPROXY_SWIFT_CONNECT_TIMEOUT_SECONDS")
 	configurators.Int(&SwiftTimeoutSeconds, "IMGPROXY_SWIFT_TIMEOUT_SECONDS")
 
+	configurators.Bool(&SFTPEnabled, "IMGPROXY_USE_SFTP")
+	configurators.String(&SFTPUser, "IMGPROXY_SFTP_USER")
+	configurators.String(&SFTPPassword, "IMGPROXY_SFTP_PASSWORD")
+	configurators.String(&SFTPKey, "IMGPROXY_SFTP_KEY")
+	configurators.String(&SFTPKnownHosts, "IMGPROXY_SFTP_KNOWN_HOSTS")
+	configurators.StringSlice(&SFTPAllowedHosts, "IMGPROXY_SFTP_ALLOWED_HOSTS")
+
 	configurators.Bool(&ETagEnabled, "IMGPROXY_USE_ETAG")
 	configurators.String(&ETagBuster, "IMGPROXY_ETAG_BUSTER")
 
@@ -464,6 +612,11 @@ func Configure() error {
 	}
 	configurators.Bool(&OnlyPresets, "IMGPROXY_ONLY_PRESETS")
 
+	if err := configureTenants(); err != nil {
+		return err
+	}
+	configurators.String(&TenantHeader, "IMGPROXY_TENANT_HEADER")
+
 	configurators.String(&WatermarkData, "IMGPROXY_WATERMARK_DATA")
 	configurators.String(&WatermarkPath, "IMGPROXY_WATERMARK_PATH")
 	configurators.String(&WatermarkURL, "IMGPROXY_WATERMARK_URL")
@@ -478,12 +631,31 @@ func Configure() error {
 	configurators.Bool(&DataDogEnable, "IMGPROXY_DATADOG_ENABLE")
 	configurators.Bool(&DataDogEnableMetrics, "IMGPROXY_DATADOG_ENABLE_ADDITIONAL_METRICS")
 
+	configurators.Bool(&OpenTelemetryEnable, "IMGPROXY_OPEN_TELEMETRY_ENABLE")
+
 	configurators.String(&NewRelicAppName, "IMGPROXY_NEW_RELIC_APP_NAME")
 	configurators.String(&NewRelicKey, "IMGPROXY_NEW_RELIC_KEY")
 	configurators.StringMap(&NewRelicLabels, "IMGPROXY_NEW_RELIC_LABELS")
 
 	configurators.String(&PrometheusBind, "IMGPROXY_PROMETHEUS_BIND")
 	configurators.String(&PrometheusNamespace, "IMGPROXY_PROMETHEUS_NAMESPACE")
+	configurators.Bool(&PrometheusExemplars, "IMGPROXY_PROMETHEUS_EXEMPLARS")
+	if err := configurators.FloatSlice(&PrometheusDurationBuckets, "IMGPROXY_PROMETHEUS_DURATION_BUCKETS"); err != nil {
+		return err
+	}
+	if err := configurators.FloatSlice(&PrometheusRequestDurationBuckets, "IMGPROXY_PROMETHEUS_REQUEST_DURATION_BUCKETS"); err != nil {
+		return err
+	}
+	if err := configurators.FloatSlice(&PrometheusDownloadDurationBuckets, "IMGPROXY_PROMETHEUS_DOWNLOAD_DURATION_BUCKETS"); err != nil {
+		return err
+	}
+	if err := configurators.FloatSlice(&PrometheusProcessingDurationBuckets, "IMGPROXY_PROMETHEUS_PROCESSING_DURATION_BUCKETS"); err != nil {
+		return err
+	}
+	configurators.Float(&PrometheusNativeHistogramBucketFactor, "IMGPROXY_PROMETHEUS_NATIVE_HISTOGRAM_BUCKET_FACTOR")
+	configurators.Bool(&PrometheusEnableReset, "IMGPROXY_PROMETHEUS_ENABLE_RESET")
+
+	configurators.String(&StatsdAddr, "IMGPROXY_STATSD_ADDR")
 
 	configurators.String(&BugsnagKey, "IMGPROXY_BUGSNAG_KEY")
 	configurators.String(&BugsnagStage, "IMGPROXY_BUGSNAG_STAGE")
@@ -497,11 +669,18 @@ func Configure() error {
 	configurators.String(&AirbrakeEnv, "IMGPROXY_AIRBRAKE_ENVIRONMENT")
 	configurators.Bool(&ReportDownloadingErrors, "IMGPROXY_REPORT_DOWNLOADING_ERRORS")
 	configurators.Bool(&EnableDebugHeaders, "IMGPROXY_ENABLE_DEBUG_HEADERS")
+	configurators.Bool(&EnableWarningsHeader, "IMGPROXY_ENABLE_WARNINGS_HEADER")
 
 	configurators.Int(&FreeMemoryInterval, "IMGPROXY_FREE_MEMORY_INTERVAL")
 	configurators.Int(&DownloadBufferSize, "IMGPROXY_DOWNLOAD_BUFFER_SIZE")
 	configurators.Int(&BufferPoolCalibrationThreshold, "IMGPROXY_BUFFER_POOL_CALIBRATION_THRESHOLD")
 
+	if err := checkConfigFileKeys(); err != nil {
+		return err
+	}
+
+	startupFileValues = configurators.FileValues()
+
 	if len(Keys) != len(Salts) {
 		return fmt.Errorf("Number of keys and number of salts should be equal. Keys: %d, salts: %d", len(Keys), len(Salts))
 	}
@@ -535,6 +714,10 @@ func Configure() error {
 		return fmt.Errorf("Download timeout should be greater than 0, now - %d\n", DownloadTimeout)
 	}
 
+	if DecodeTimeout < 0 {
+		return fmt.Errorf("Decode timeout should be greater than or equal to 0, now - %f\n", DecodeTimeout)
+	}
+
 	if Concurrency <= 0 {
 		return fmt.Errorf("Concurrency should be greater than 0, now - %d\n", Concurrency)
 	}
@@ -547,6 +730,14 @@ func Configure() error {
 		return fmt.Errorf("Concurrency should be greater than or equal 0, now - %d\n", MaxClients)
 	}
 
+	if InfoBatchMaxSize <= 0 {
+		return fmt.Errorf("Info batch max size should be greater than 0, now - %d\n", InfoBatchMaxSize)
+	}
+
+	if InfoBatchConcurrency <= 0 {
+		return fmt.Errorf("Info batch concurrency should be greater than 0, now - %d\n", InfoBatchConcurrency)
+	}
+
 	if TTL <= 0 {
 		return fmt.Errorf("TTL should be greater than 0, now - %d\n", TTL)
 	}
@@ -555,6 +746,10 @@ func Configure() error {
 		return fmt.Errorf("Max src resolution should be greater than 0, now - %d\n", MaxSrcResolution)
 	}
 
+	if MaxSrcResolutionLimit < 0 {
+		return fmt.Errorf("Max src resolution limit should be greater than or equal to 0, now - %d\n", MaxSrcResolutionLimit)
+	}
+
 	if MaxSrcFileSize < 0 {
 		return fmt.Errorf("Max src file size should be greater than or equal to 0, now - %d\n", MaxSrcFileSize)
 	}
@@ -563,6 +758,38 @@ func Configure() error {
 		return fmt.Errorf("Max animation frames should be greater than 0, now - %d\n", MaxAnimationFrames)
 	}
 
+	if AnimationConcurrency <= 0 {
+		return fmt.Errorf("Animation concurrency should be greater than 0, now - %d\n", AnimationConcurrency)
+	}
+
+	if VideoThumbnailFPS <= 0 {
+		return fmt.Errorf("Video thumbnail FPS should be greater than 0, now - %d\n", VideoThumbnailFPS)
+	}
+
+	if Sharpening < 0 {
+		return fmt.Errorf("Sharpening should be greater than or equal to 0, now - %f\n", Sharpening)
+	}
+
+	if MaxBlurSigma <= 0 {
+		return fmt.Errorf("Max blur sigma should be greater than 0, now - %f\n", MaxBlurSigma)
+	}
+
+	if MaxDpr <= 0 {
+		return fmt.Errorf("Max DPR should be greater than 0, now - %f\n", MaxDpr)
+	}
+
+	if MaxProcessingOptions < 0 {
+		return fmt.Errorf("Max processing options should be greater than or equal to 0, now - %d\n", MaxProcessingOptions)
+	}
+
+	if ProcessingBudget < 0 {
+		return fmt.Errorf("Processing budget should be greater than or equal to 0, now - %d\n", ProcessingBudget)
+	}
+
+	if BlankThreshold < 0 {
+		return fmt.Errorf("Blank threshold should be greater than or equal to 0, now - %f\n", BlankThreshold)
+	}
+
 	if PngQuantizationColors < 2 {
 		return fmt.Errorf("Png quantization colors should be greater than 1, now - %d\n", PngQuantizationColors)
 	} else if PngQuantizationColors > 256 {
@@ -585,6 +812,14 @@ func Configure() error {
 		return fmt.Errorf("At least one preferred format should be specified")
 	}
 
+	if _, ok := imagetype.Types[DefaultFormat]; !ok && DefaultFormat != "preferred" && DefaultFormat != "source" {
+		return fmt.Errorf("Default format should be either preferred, source, or an image format, now - %s\n", DefaultFormat)
+	}
+
+	if SanitizeSvgMode != "strip" && SanitizeSvgMode != "reject" {
+		return fmt.Errorf("SVG sanitization mode should be either strip or reject, now - %s\n", SanitizeSvgMode)
+	}
+
 	if IgnoreSslVerification {
 		log.Warning("Ignoring SSL verification is very unsafe")
 	}
@@ -605,7 +840,7 @@ func Configure() error {
 		}
 	}
 
-	if _, ok := os.LookupEnv("IMGPROXY_USE_GCS"); !ok && len(GCSKey) > 0 {
+	if !configurators.IsSet("IMGPROXY_USE_GCS") && len(GCSKey) > 0 {
 		log.Warning("Set IMGPROXY_USE_GCS to true since it may be required by future versions to enable GCS support")
 		GCSEnabled = true
 	}
@@ -624,6 +859,23 @@ func Configure() error {
 		return fmt.Errorf("Can't use the same binding for the main server and Prometheus")
 	}
 
+	if err := validateDurationBuckets("Prometheus duration buckets", PrometheusDurationBuckets); err != nil {
+		return err
+	}
+	if err := validateDurationBuckets("Prometheus request duration buckets", PrometheusRequestDurationBuckets); err != nil {
+		return err
+	}
+	if err := validateDurationBuckets("Prometheus download duration buckets", PrometheusDownloadDurationBuckets); err != nil {
+		return err
+	}
+	if err := validateDurationBuckets("Prometheus processing duration buckets", PrometheusProcessingDurationBuckets); err != nil {
+		return err
+	}
+
+	if PrometheusNativeHistogramBucketFactor != 0 && PrometheusNativeHistogramBucketFactor <= 1 {
+		return fmt.Errorf("Prometheus native histogram bucket factor should be greater than 1 or equal to 0, now - %f", PrometheusNativeHistogramBucketFactor)
+	}
+
 	if FreeMemoryInterval <= 0 {
 		return fmt.Errorf("Free memory interval should be greater than zero")
 	}
@@ -640,3 +892,16 @@ func Configure() error {
 
 	return nil
 }
+
+func validateDurationBuckets(name string, buckets []float64) error {
+	for i, b := range buckets {
+		if b <= 0 {
+			return fmt.Errorf("%s should be greater than 0, now - %v", name, buckets)
+		}
+		if i > 0 && b <= buckets[i-1] {
+			return fmt.Errorf("%s should be in strictly increasing order, now - %v", name, buckets)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
+)
+
+// LoadFile reads the config file set with IMGPROXY_CONFIG_FILE. It should be called
+// before anything reads the config so the config file values are available to everyone
+func LoadFile() error {
+	return loadConfigFile(os.Getenv("IMGPROXY_CONFIG_FILE"))
+}
+
+// loadConfigFile reads the config file and passes its values to the configurators.
+// Config file keys are the names of the environment variables
+// without the IMGPROXY_ prefix in any case, e.g. `max_src_resolution`
+func loadConfigFile(path string) error {
+	if len(path) == 0 {
+		configurators.SetFileValues(nil)
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Can't read config file %s: %s", path, err)
+	}
+
+	raw := make(map[string]interface{})
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("Unknown config file format: %s", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Can't parse config file %s: %s", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+
+	for key, value := range raw {
+		str, err := configFileValue(value)
+		if err != nil {
+			return fmt.Errorf("Invalid value of %s in config file: %s", key, err)
+		}
+
+		values["IMGPROXY_"+strings.ToUpper(key)] = str
+	}
+
+	configurators.SetFileValues(values)
+
+	return nil
+}
+
+// outOfConfigureKeys are the keys that are read outside of Configure
+// or only when some feature is enabled, so configurators may not request them
+// before the config file keys are checked
+var outOfConfigureKeys = map[string]struct{}{
+	"IMGPROXY_PPROF_BIND":       {},
+	"IMGPROXY_LOG_MEM_STATS":    {},
+	"IMGPROXY_VIPS_LEAK_CHECK":  {},
+	"IMGPROXY_VIPS_CACHE_TRACE": {},
+	"IMGPROXY_SYSLOG_ENABLE":    {},
+	"IMGPROXY_SYSLOG_NETWORK":   {},
+	"IMGPROXY_SYSLOG_ADDRESS":   {},
+	"IMGPROXY_SYSLOG_TAG":       {},
+	"IMGPROXY_SYSLOG_LEVEL":     {},
+}
+
+// checkConfigFileKeys returns an error if the config file contains keys
+// that weren't used by any configurator
+func checkConfigFileKeys() error {
+	var unknown []string
+
+	for _, name := range configurators.UnknownFileValues() {
+		if _, ok := outOfConfigureKeys[name]; !ok {
+			unknown = append(unknown, strings.ToLower(strings.TrimPrefix(name, "IMGPROXY_")))
+		}
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Unknown config file keys: %s", strings.Join(unknown, ", "))
+}
+
+// configFileValue converts the config file value to the string in the format
+// that is used in environment variables. Lists are joined with commas
+func configFileValue(value interface{}) (string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return configFileScalar(value)
+	}
+
+	parts := make([]string, len(list))
+
+	for i, v := range list {
+		str, err := configFileScalar(v)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = str
+	}
+
+	return strings.Join(parts, ","), nil
+}
+
+func configFileScalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", value)
+	}
+}
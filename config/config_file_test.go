@@ -0,0 +1,137 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+)
+
+type ConfigFileTestSuite struct {
+	suite.Suite
+}
+
+func (s *ConfigFileTestSuite) SetupTest() {
+	Reset()
+}
+
+func (s *ConfigFileTestSuite) TearDownTest() {
+	os.Unsetenv("IMGPROXY_CONFIG_FILE")
+	os.Unsetenv("IMGPROXY_TTL")
+
+	configurators.SetFileValues(nil)
+}
+
+func (s *ConfigFileTestSuite) writeConfigFile(name, content string) {
+	path := filepath.Join(s.T().TempDir(), name)
+	require.Nil(s.T(), os.WriteFile(path, []byte(content), 0644))
+
+	os.Setenv("IMGPROXY_CONFIG_FILE", path)
+}
+
+func (s *ConfigFileTestSuite) TestYAML() {
+	s.writeConfigFile("imgproxy.yml", `
+bind: ":9090"
+ttl: 100
+max_src_resolution: 20.5
+enable_webp_detection: true
+preferred_formats: [png, webp]
+`)
+
+	require.Nil(s.T(), LoadFile())
+	require.Nil(s.T(), Configure())
+
+	require.Equal(s.T(), ":9090", Bind)
+	require.Equal(s.T(), 100, TTL)
+	require.Equal(s.T(), 20500000, MaxSrcResolution)
+	require.True(s.T(), EnableWebpDetection)
+	require.Equal(s.T(), []imagetype.Type{imagetype.PNG, imagetype.WEBP}, PreferredFormats)
+}
+
+func (s *ConfigFileTestSuite) TestTOML() {
+	s.writeConfigFile("imgproxy.toml", `
+bind = ":9090"
+ttl = 100
+max_src_resolution = 20.5
+enable_webp_detection = true
+preferred_formats = ["png", "webp"]
+`)
+
+	require.Nil(s.T(), LoadFile())
+	require.Nil(s.T(), Configure())
+
+	require.Equal(s.T(), ":9090", Bind)
+	require.Equal(s.T(), 100, TTL)
+	require.Equal(s.T(), 20500000, MaxSrcResolution)
+	require.True(s.T(), EnableWebpDetection)
+	require.Equal(s.T(), []imagetype.Type{imagetype.PNG, imagetype.WEBP}, PreferredFormats)
+}
+
+func (s *ConfigFileTestSuite) TestEnvPrecedence() {
+	s.writeConfigFile("imgproxy.yml", `
+bind: ":9090"
+ttl: 100
+`)
+	os.Setenv("IMGPROXY_TTL", "200")
+
+	require.Nil(s.T(), LoadFile())
+	require.Nil(s.T(), Configure())
+
+	require.Equal(s.T(), ":9090", Bind)
+	require.Equal(s.T(), 200, TTL)
+}
+
+func (s *ConfigFileTestSuite) TestUnknownKeys() {
+	s.writeConfigFile("imgproxy.yml", `
+ttl: 100
+unknown_key: 1
+another_unknown_key: 2
+`)
+
+	require.Nil(s.T(), LoadFile())
+
+	err := Configure()
+
+	require.EqualError(s.T(), err, "Unknown config file keys: another_unknown_key, unknown_key")
+}
+
+func (s *ConfigFileTestSuite) TestOutOfConfigureKeys() {
+	s.writeConfigFile("imgproxy.yml", `
+pprof_bind: ":8089"
+log_mem_stats: true
+vips_leak_check: true
+vips_cache_trace: true
+syslog_enable: false
+syslog_network: udp
+syslog_address: "127.0.0.1:514"
+syslog_tag: imgproxy
+syslog_level: info
+`)
+
+	require.Nil(s.T(), LoadFile())
+	require.Nil(s.T(), Configure())
+}
+
+func (s *ConfigFileTestSuite) TestInvalidValue() {
+	s.writeConfigFile("imgproxy.yml", `
+ttl:
+  value: 100
+`)
+
+	require.Error(s.T(), LoadFile())
+}
+
+func (s *ConfigFileTestSuite) TestUnknownFormat() {
+	s.writeConfigFile("imgproxy.json", `{"ttl": 100}`)
+
+	require.Error(s.T(), LoadFile())
+}
+
+func TestConfigFile(t *testing.T) {
+	suite.Run(t, new(ConfigFileTestSuite))
+}
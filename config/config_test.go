@@ -0,0 +1,160 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func (s *ConfigTestSuite) SetupTest() {
+	Reset()
+}
+
+func (s *ConfigTestSuite) TearDownTest() {
+	os.Unsetenv("IMGPROXY_PROMETHEUS_REQUEST_DURATION_BUCKETS")
+	os.Unsetenv("IMGPROXY_FORMAT_QUALITY")
+}
+
+func (s *ConfigTestSuite) TestPrometheusHistogramDurationBuckets() {
+	os.Setenv("IMGPROXY_PROMETHEUS_REQUEST_DURATION_BUCKETS", "0.005, 0.01,0.05")
+
+	require.Nil(s.T(), Configure())
+
+	require.Equal(s.T(), []float64{0.005, 0.01, 0.05}, PrometheusRequestDurationBuckets)
+	require.Empty(s.T(), PrometheusDownloadDurationBuckets)
+}
+
+func (s *ConfigTestSuite) TestPrometheusHistogramDurationBucketsOrder() {
+	os.Setenv("IMGPROXY_PROMETHEUS_REQUEST_DURATION_BUCKETS", "0.01,0.01,0.05")
+
+	err := Configure()
+
+	require.EqualError(s.T(), err, "Prometheus request duration buckets should be in strictly increasing order, now - [0.01 0.01 0.05]")
+}
+
+func (s *ConfigTestSuite) TestPrometheusHistogramDurationBucketsInvalid() {
+	os.Setenv("IMGPROXY_PROMETHEUS_REQUEST_DURATION_BUCKETS", "0.01,fast")
+
+	err := Configure()
+
+	require.EqualError(s.T(), err, "Invalid IMGPROXY_PROMETHEUS_REQUEST_DURATION_BUCKETS: 0.01,fast")
+}
+
+func (s *ConfigTestSuite) TestSharpening() {
+	os.Setenv("IMGPROXY_SHARPENING", "0.5")
+	defer os.Unsetenv("IMGPROXY_SHARPENING")
+
+	require.Nil(s.T(), Configure())
+	require.Equal(s.T(), 0.5, Sharpening)
+}
+
+func (s *ConfigTestSuite) TestSharpeningNegative() {
+	os.Setenv("IMGPROXY_SHARPENING", "-1")
+	defer os.Unsetenv("IMGPROXY_SHARPENING")
+
+	require.Error(s.T(), Configure())
+}
+
+func (s *ConfigTestSuite) TestMaxBlurSigma() {
+	os.Setenv("IMGPROXY_MAX_BLUR_SIGMA", "20")
+	defer os.Unsetenv("IMGPROXY_MAX_BLUR_SIGMA")
+
+	require.Nil(s.T(), Configure())
+	require.Equal(s.T(), 20.0, MaxBlurSigma)
+}
+
+func (s *ConfigTestSuite) TestMaxBlurSigmaZero() {
+	os.Setenv("IMGPROXY_MAX_BLUR_SIGMA", "0")
+	defer os.Unsetenv("IMGPROXY_MAX_BLUR_SIGMA")
+
+	require.Error(s.T(), Configure())
+}
+
+func (s *ConfigTestSuite) TestFormatQuality() {
+	os.Setenv("IMGPROXY_FORMAT_QUALITY", "avif=40, jpeg=80,webp=75")
+
+	require.Nil(s.T(), Configure())
+
+	require.Equal(s.T(), map[imagetype.Type]int{
+		imagetype.AVIF: 40,
+		imagetype.JPEG: 80,
+		imagetype.WEBP: 75,
+	}, FormatQuality)
+}
+
+func (s *ConfigTestSuite) TestFormatQualityUnknownFormat() {
+	os.Setenv("IMGPROXY_FORMAT_QUALITY", "jpeg=80,jxl=60")
+
+	require.Nil(s.T(), Configure())
+
+	require.Equal(s.T(), map[imagetype.Type]int{
+		imagetype.AVIF: 50,
+		imagetype.JPEG: 80,
+	}, FormatQuality)
+}
+
+func (s *ConfigTestSuite) TestFormatQualityInvalid() {
+	os.Setenv("IMGPROXY_FORMAT_QUALITY", "jpeg=best")
+
+	require.EqualError(s.T(), Configure(), "Invalid quality: jpeg=best")
+}
+
+func (s *ConfigTestSuite) TestDecodeTimeout() {
+	os.Setenv("IMGPROXY_DECODE_TIMEOUT", "0.5")
+	defer os.Unsetenv("IMGPROXY_DECODE_TIMEOUT")
+
+	require.Nil(s.T(), Configure())
+	require.Equal(s.T(), 0.5, DecodeTimeout)
+}
+
+func (s *ConfigTestSuite) TestDecodeTimeoutNegative() {
+	os.Setenv("IMGPROXY_DECODE_TIMEOUT", "-1")
+	defer os.Unsetenv("IMGPROXY_DECODE_TIMEOUT")
+
+	require.EqualError(s.T(), Configure(), "Decode timeout should be greater than or equal to 0, now - -1.000000\n")
+}
+
+func (s *ConfigTestSuite) TestAnimationConcurrency() {
+	os.Setenv("IMGPROXY_ANIMATION_CONCURRENCY", "4")
+	defer os.Unsetenv("IMGPROXY_ANIMATION_CONCURRENCY")
+
+	require.Nil(s.T(), Configure())
+	require.Equal(s.T(), 4, AnimationConcurrency)
+}
+
+func (s *ConfigTestSuite) TestAnimationConcurrencyZero() {
+	os.Setenv("IMGPROXY_ANIMATION_CONCURRENCY", "0")
+	defer os.Unsetenv("IMGPROXY_ANIMATION_CONCURRENCY")
+
+	require.EqualError(s.T(), Configure(), "Animation concurrency should be greater than 0, now - 0\n")
+}
+
+func (s *ConfigTestSuite) TestDefaultFormat() {
+	defer os.Unsetenv("IMGPROXY_DEFAULT_FORMAT")
+
+	for _, f := range []string{"preferred", "source", "webp"} {
+		os.Setenv("IMGPROXY_DEFAULT_FORMAT", f)
+
+		require.Nil(s.T(), Configure())
+		require.Equal(s.T(), f, DefaultFormat)
+	}
+}
+
+func (s *ConfigTestSuite) TestDefaultFormatInvalid() {
+	os.Setenv("IMGPROXY_DEFAULT_FORMAT", "auto")
+	defer os.Unsetenv("IMGPROXY_DEFAULT_FORMAT")
+
+	require.EqualError(s.T(), Configure(), "Default format should be either preferred, source, or an image format, now - auto\n")
+}
+
+func TestConfig(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
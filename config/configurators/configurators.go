@@ -6,38 +6,113 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/imgproxy/imgproxy/v3/imagetype"
 )
 
+var (
+	fileValues map[string]string
+	requested  = make(map[string]struct{})
+)
+
+// SetFileValues sets the values loaded from the config file.
+// Environment variables take precedence over these values
+func SetFileValues(values map[string]string) {
+	fileValues = values
+}
+
+// FileValues returns the values loaded from the config file
+func FileValues() map[string]string {
+	return fileValues
+}
+
+// UnknownFileValues returns the sorted names of the config file values
+// that weren't requested by any configurator
+func UnknownFileValues() []string {
+	var names []string
+
+	for name := range fileValues {
+		if _, ok := requested[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// IsSet checks if the value is set either in the environment or in the config file
+func IsSet(name string) bool {
+	requested[name] = struct{}{}
+
+	if _, ok := os.LookupEnv(name); ok {
+		return true
+	}
+
+	_, ok := fileValues[name]
+	return ok
+}
+
+func getEnv(name string) string {
+	requested[name] = struct{}{}
+
+	if env := os.Getenv(name); len(env) > 0 {
+		return env
+	}
+
+	return fileValues[name]
+}
+
 func Int(i *int, name string) {
-	if env, err := strconv.Atoi(os.Getenv(name)); err == nil {
+	if env, err := strconv.Atoi(getEnv(name)); err == nil {
 		*i = env
 	}
 }
 
 func Float(i *float64, name string) {
-	if env, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+	if env, err := strconv.ParseFloat(getEnv(name), 64); err == nil {
 		*i = env
 	}
 }
 
+func FloatSlice(f *[]float64, name string) error {
+	if env := getEnv(name); len(env) > 0 {
+		parts := strings.Split(env, ",")
+
+		*f = make([]float64, 0, len(parts))
+
+		for _, p := range parts {
+			pf, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return fmt.Errorf("Invalid %s: %s", name, env)
+			}
+			*f = append(*f, pf)
+		}
+	}
+
+	return nil
+}
+
 func MegaInt(f *int, name string) {
-	if env, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+	if env, err := strconv.ParseFloat(getEnv(name), 64); err == nil {
 		*f = int(env * 1000000)
 	}
 }
 
 func String(s *string, name string) {
-	if env := os.Getenv(name); len(env) > 0 {
+	if env := getEnv(name); len(env) > 0 {
 		*s = env
 	}
 }
 
 func StringSlice(s *[]string, name string) {
-	if env := os.Getenv(name); len(env) > 0 {
+	if env := getEnv(name); len(env) > 0 {
 		parts := strings.Split(env, ",")
 
 		for i, p := range parts {
@@ -77,7 +152,7 @@ func StringSliceFile(s *[]string, filepath string) error {
 }
 
 func StringMap(m *map[string]string, name string) error {
-	if env := os.Getenv(name); len(env) > 0 {
+	if env := getEnv(name); len(env) > 0 {
 		mm := make(map[string]string)
 
 		keyvalues := strings.Split(env, ";")
@@ -97,13 +172,13 @@ func StringMap(m *map[string]string, name string) error {
 }
 
 func Bool(b *bool, name string) {
-	if env, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+	if env, err := strconv.ParseBool(getEnv(name)); err == nil {
 		*b = env
 	}
 }
 
 func ImageTypes(it *[]imagetype.Type, name string) error {
-	if env := os.Getenv(name); len(env) > 0 {
+	if env := getEnv(name); len(env) > 0 {
 		parts := strings.Split(env, ",")
 
 		*it = make([]imagetype.Type, 0, len(parts))
@@ -122,7 +197,7 @@ func ImageTypes(it *[]imagetype.Type, name string) error {
 }
 
 func ImageTypesQuality(m map[imagetype.Type]int, name string) error {
-	if env := os.Getenv(name); len(env) > 0 {
+	if env := getEnv(name); len(env) > 0 {
 		parts := strings.Split(env, ",")
 
 		for _, p := range parts {
@@ -135,7 +210,8 @@ func ImageTypesQuality(m map[imagetype.Type]int, name string) error {
 
 			imgtype, ok := imagetype.Types[imgtypeStr]
 			if !ok {
-				return fmt.Errorf("Invalid format: %s", p)
+				log.Warningf("Unknown format in %s: %s. Ignoring", name, imgtypeStr)
+				continue
 			}
 
 			q, err := strconv.Atoi(qStr)
@@ -153,7 +229,7 @@ func ImageTypesQuality(m map[imagetype.Type]int, name string) error {
 func Hex(b *[][]byte, name string) error {
 	var err error
 
-	if env := os.Getenv(name); len(env) > 0 {
+	if env := getEnv(name); len(env) > 0 {
 		parts := strings.Split(env, ",")
 
 		keys := make([][]byte, len(parts))
@@ -207,7 +283,7 @@ func HexFile(b *[][]byte, filepath string) error {
 }
 
 func Patterns(s *[]*regexp.Regexp, name string) {
-	if env := os.Getenv(name); len(env) > 0 {
+	if env := getEnv(name); len(env) > 0 {
 		parts := strings.Split(env, ",")
 		result := make([]*regexp.Regexp, len(parts))
 
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
+)
+
+// reloadableValues are the config values that can be changed with reload.
+// Changes of any other config file values require restart
+var reloadableValues = map[string]struct{}{
+	"IMGPROXY_ALLOWED_SOURCES": {},
+	"IMGPROXY_PRESETS":         {},
+	"IMGPROXY_LOG_LEVEL":       {},
+}
+
+var (
+	reloadMutex       sync.RWMutex
+	startupFileValues map[string]string
+)
+
+// ReloadableConfig holds the config values that are safe to change at runtime
+type ReloadableConfig struct {
+	AllowedSources []*regexp.Regexp
+	Presets        []string
+
+	// prevFileValues are the config file values replaced by ReadReloadable
+	prevFileValues map[string]string
+}
+
+// changedRestartRequiredValues returns the sorted names of the config file values
+// that can't be reloaded but differ from the ones the server was started with
+func changedRestartRequiredValues(values map[string]string) []string {
+	var changed []string
+
+	check := func(name string) {
+		if _, ok := reloadableValues[name]; ok {
+			return
+		}
+
+		value, ok := values[name]
+		startupValue, startupOk := startupFileValues[name]
+
+		if ok != startupOk || value != startupValue {
+			changed = append(changed, name)
+		}
+	}
+
+	for name := range values {
+		check(name)
+	}
+
+	for name := range startupFileValues {
+		if _, ok := values[name]; !ok {
+			check(name)
+		}
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
+
+// GetAllowedSources returns the allowed sources.
+// It's safe to call it concurrently with ApplyReloadable
+func GetAllowedSources() []*regexp.Regexp {
+	reloadMutex.RLock()
+	defer reloadMutex.RUnlock()
+
+	return AllowedSources
+}
+
+// ReadReloadable re-reads the config file and returns the values that are safe
+// to change at runtime. Environment variables can't be changed in a running process,
+// so only the config file and the presets file are actually re-read.
+// If any of the values that can't be reloaded is changed, the config file values
+// are left unchanged and an error is returned.
+// The returned values should be either applied with ApplyReloadable
+// or discarded with DiscardReloadable if they're invalid
+func ReadReloadable() (*ReloadableConfig, error) {
+	prevFileValues := configurators.FileValues()
+
+	if err := LoadFile(); err != nil {
+		return nil, err
+	}
+
+	if changed := changedRestartRequiredValues(configurators.FileValues()); len(changed) > 0 {
+		configurators.SetFileValues(prevFileValues)
+		return nil, fmt.Errorf("%s can't be changed without restart", strings.Join(changed, ", "))
+	}
+
+	rc := ReloadableConfig{
+		AllowedSources: make([]*regexp.Regexp, 0),
+		Presets:        make([]string, 0),
+		prevFileValues: prevFileValues,
+	}
+
+	configurators.Patterns(&rc.AllowedSources, "IMGPROXY_ALLOWED_SOURCES")
+
+	configurators.StringSlice(&rc.Presets, "IMGPROXY_PRESETS")
+	if err := configurators.StringSliceFile(&rc.Presets, presetsPath); err != nil {
+		configurators.SetFileValues(prevFileValues)
+		return nil, err
+	}
+
+	return &rc, nil
+}
+
+// ApplyReloadable atomically replaces the allowed sources. The apply functions
+// are called under the same lock, so the other reloadable values, like presets,
+// are replaced together with the allowed sources
+func ApplyReloadable(rc *ReloadableConfig, apply ...func()) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	AllowedSources = rc.AllowedSources
+
+	for _, f := range apply {
+		f()
+	}
+}
+
+// DiscardReloadable restores the config file values replaced by ReadReloadable.
+// It should be called if any of the reloaded values is invalid
+func DiscardReloadable(rc *ReloadableConfig) {
+	configurators.SetFileValues(rc.prevFileValues)
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
+)
+
+type ReloadTestSuite struct {
+	suite.Suite
+
+	path string
+}
+
+func (s *ReloadTestSuite) SetupTest() {
+	Reset()
+
+	s.path = filepath.Join(s.T().TempDir(), "imgproxy.yml")
+	os.Setenv("IMGPROXY_CONFIG_FILE", s.path)
+}
+
+func (s *ReloadTestSuite) TearDownTest() {
+	os.Unsetenv("IMGPROXY_CONFIG_FILE")
+
+	configurators.SetFileValues(nil)
+}
+
+func (s *ReloadTestSuite) writeConfigFile(content string) {
+	require.Nil(s.T(), os.WriteFile(s.path, []byte(content), 0644))
+}
+
+func (s *ReloadTestSuite) configure(content string) {
+	s.writeConfigFile(content)
+
+	require.Nil(s.T(), LoadFile())
+	require.Nil(s.T(), Configure())
+}
+
+func (s *ReloadTestSuite) allowedSources() []string {
+	sources := GetAllowedSources()
+
+	patterns := make([]string, len(sources))
+	for i, r := range sources {
+		patterns[i] = r.String()
+	}
+
+	return patterns
+}
+
+func (s *ReloadTestSuite) TestReload() {
+	s.configure(`
+bind: ":9090"
+allowed_sources: "http://images.dev/"
+presets: "small=rs:fit:4:4"
+`)
+
+	s.writeConfigFile(`
+bind: ":9090"
+allowed_sources: "http://images.dev/,http://images.test/"
+presets: "small=rs:fit:8:8,large=rs:fit:100:100"
+`)
+
+	rc, err := ReadReloadable()
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), []string{"small=rs:fit:8:8", "large=rs:fit:100:100"}, rc.Presets)
+
+	// Nothing is changed until the reloaded config is applied
+	require.Equal(s.T(), []string{`^http://images\.dev/`}, s.allowedSources())
+
+	applied := false
+
+	ApplyReloadable(rc, func() {
+		// Other values are applied together with the allowed sources
+		require.Equal(s.T(), rc.AllowedSources, AllowedSources)
+		applied = true
+	})
+
+	require.True(s.T(), applied)
+	require.Equal(s.T(), []string{`^http://images\.dev/`, `^http://images\.test/`}, s.allowedSources())
+}
+
+func (s *ReloadTestSuite) TestReloadDiscard() {
+	s.configure(`
+allowed_sources: "http://images.dev/"
+presets: "small=rs:fit:4:4"
+`)
+
+	s.writeConfigFile(`
+allowed_sources: "http://images.test/"
+presets: "small=rs:fit:-1:-1"
+`)
+
+	rc, err := ReadReloadable()
+	require.Nil(s.T(), err)
+
+	// The presets are invalid, so the reloaded values are discarded
+	DiscardReloadable(rc)
+
+	require.Equal(s.T(), "http://images.dev/", configurators.FileValues()["IMGPROXY_ALLOWED_SOURCES"])
+	require.Equal(s.T(), "small=rs:fit:4:4", configurators.FileValues()["IMGPROXY_PRESETS"])
+	require.Equal(s.T(), []string{`^http://images\.dev/`}, s.allowedSources())
+}
+
+func (s *ReloadTestSuite) TestReloadRestartRequired() {
+	s.configure(`
+bind: ":9090"
+allowed_sources: "http://images.dev/"
+`)
+
+	s.writeConfigFile(`
+bind: ":9091"
+allowed_sources: "http://images.test/"
+`)
+
+	_, err := ReadReloadable()
+	require.EqualError(s.T(), err, "IMGPROXY_BIND can't be changed without restart")
+
+	// The config file values are left unchanged
+	require.Equal(s.T(), ":9090", configurators.FileValues()["IMGPROXY_BIND"])
+	require.Equal(s.T(), []string{`^http://images\.dev/`}, s.allowedSources())
+}
+
+func (s *ReloadTestSuite) TestReloadNotReloadable() {
+	s.configure(`
+ttl: 100
+max_src_resolution: 20
+allowed_sources: "http://images.dev/"
+`)
+
+	// Removed values can't be reloaded either
+	s.writeConfigFile(`
+ttl: 200
+allowed_sources: "http://images.test/"
+`)
+
+	_, err := ReadReloadable()
+	require.EqualError(s.T(), err, "IMGPROXY_MAX_SRC_RESOLUTION, IMGPROXY_TTL can't be changed without restart")
+
+	require.Equal(s.T(), "100", configurators.FileValues()["IMGPROXY_TTL"])
+	require.Equal(s.T(), []string{`^http://images\.dev/`}, s.allowedSources())
+}
+
+func TestReload(t *testing.T) {
+	suite.Run(t, new(ReloadTestSuite))
+}
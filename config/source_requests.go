@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
+)
+
+// SourceRequest defines the method and the body of the requests
+// to the source image URLs that match the pattern
+type SourceRequest struct {
+	Name string
+
+	Pattern *regexp.Regexp
+
+	Method      string
+	Body        *template.Template
+	ContentType string
+}
+
+var sourceRequestMethods = map[string]struct{}{
+	http.MethodGet:  {},
+	http.MethodPost: {},
+	http.MethodPut:  {},
+}
+
+var sourceRequestBodyFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// NewSourceRequest validates the source request config and parses the body template
+func NewSourceRequest(name, pattern, method, body, contentType string) (SourceRequest, error) {
+	sr := SourceRequest{
+		Name:        name,
+		Method:      strings.ToUpper(method),
+		ContentType: contentType,
+	}
+
+	if len(pattern) == 0 {
+		return sr, fmt.Errorf("Pattern of the source request %s is not set", name)
+	}
+	sr.Pattern = configurators.RegexpFromPattern(pattern)
+
+	if len(sr.Method) == 0 {
+		sr.Method = http.MethodGet
+	}
+	if _, ok := sourceRequestMethods[sr.Method]; !ok {
+		return sr, fmt.Errorf("Invalid method of the source request %s: %s", name, method)
+	}
+
+	if len(body) > 0 {
+		tpl, err := template.New(name).Funcs(sourceRequestBodyFuncs).Parse(body)
+		if err != nil {
+			return sr, fmt.Errorf("Invalid body template of the source request %s: %s", name, err)
+		}
+		sr.Body = tpl
+	}
+
+	if len(sr.ContentType) == 0 {
+		sr.ContentType = "application/json"
+	}
+
+	return sr, nil
+}
+
+func configureSourceRequests() error {
+	var names []string
+	configurators.StringSlice(&names, "IMGPROXY_SOURCE_REQUESTS")
+
+	for _, name := range names {
+		if !tenantNameRe.MatchString(name) {
+			return fmt.Errorf("Invalid source request name: %s", name)
+		}
+
+		envPrefix := fmt.Sprintf("IMGPROXY_SOURCE_REQUEST_%s_", strings.ToUpper(name))
+
+		var pattern, method, body, contentType string
+
+		configurators.String(&pattern, envPrefix+"PATTERN")
+		configurators.String(&method, envPrefix+"METHOD")
+		configurators.String(&body, envPrefix+"BODY")
+		configurators.String(&contentType, envPrefix+"CONTENT_TYPE")
+
+		sr, err := NewSourceRequest(name, pattern, method, body, contentType)
+		if err != nil {
+			return err
+		}
+
+		SourceRequests = append(SourceRequests, sr)
+	}
+
+	return nil
+}
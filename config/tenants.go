@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
+)
+
+// Tenant is a named config profile that overrides the signature keys,
+// allowed sources, and presets for the requests that belong to it
+type Tenant struct {
+	Name       string
+	PathPrefix string
+
+	Keys  [][]byte
+	Salts [][]byte
+
+	AllowedSources []*regexp.Regexp
+
+	Presets []string
+}
+
+var tenantNameRe = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+func configureTenants() error {
+	var names []string
+	configurators.StringSlice(&names, "IMGPROXY_TENANTS")
+
+	for _, name := range names {
+		if !tenantNameRe.MatchString(name) {
+			return fmt.Errorf("Invalid tenant name: %s", name)
+		}
+
+		envPrefix := fmt.Sprintf("IMGPROXY_TENANT_%s_", strings.ToUpper(name))
+
+		t := Tenant{
+			Name:           name,
+			Keys:           make([][]byte, 0),
+			Salts:          make([][]byte, 0),
+			AllowedSources: make([]*regexp.Regexp, 0),
+			Presets:        make([]string, 0),
+		}
+
+		configurators.String(&t.PathPrefix, envPrefix+"PATH_PREFIX")
+
+		if err := configurators.Hex(&t.Keys, envPrefix+"KEY"); err != nil {
+			return err
+		}
+		if err := configurators.Hex(&t.Salts, envPrefix+"SALT"); err != nil {
+			return err
+		}
+
+		configurators.Patterns(&t.AllowedSources, envPrefix+"ALLOWED_SOURCES")
+		configurators.StringSlice(&t.Presets, envPrefix+"PRESETS")
+
+		if len(t.Keys) != len(t.Salts) {
+			return fmt.Errorf("Number of keys and number of salts of the tenant %s should be equal. Keys: %d, salts: %d", name, len(t.Keys), len(t.Salts))
+		}
+
+		if len(t.PathPrefix) > 0 && !strings.HasPrefix(t.PathPrefix, "/") {
+			return fmt.Errorf("Path prefix of the tenant %s should start with /, now - %s", name, t.PathPrefix)
+		}
+
+		Tenants = append(Tenants, t)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/ierrors"
+	"github.com/imgproxy/imgproxy/v3/imagedata"
+	"github.com/imgproxy/imgproxy/v3/imagemeta"
+	"github.com/imgproxy/imgproxy/v3/metrics"
+	"github.com/imgproxy/imgproxy/v3/metrics/stats"
+	"github.com/imgproxy/imgproxy/v3/processing"
+	"github.com/imgproxy/imgproxy/v3/router"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+type dryRunImage struct {
+	Format   string `json:"format"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Frames   int    `json:"frames"`
+	HasAlpha bool   `json:"has_alpha"`
+}
+
+type dryRunResponse struct {
+	Source        dryRunImage `json:"source"`
+	Result        dryRunImage `json:"result"`
+	Passthrough   bool        `json:"passthrough"`
+	EstimatedSize int         `json:"estimated_size"`
+}
+
+// dryRunSource describes the source image that is served as is.
+// The image isn't decoded, so we read its header with libvips if possible.
+// Otherwise, we know only its format and size
+func dryRunSource(originData *imagedata.ImageData) *processing.DryRunResult {
+	if vips.SupportsLoad(originData.Type) {
+		res, err := processing.DryRunSource(originData)
+		if err == nil {
+			return res
+		}
+
+		log.Debugf("Can't read the source image header: %s", err)
+	}
+
+	res := processing.DryRunResult{
+		SourceFormat:  originData.Type,
+		ResultFormat:  originData.Type,
+		Passthrough:   true,
+		EstimatedSize: len(originData.Data),
+	}
+
+	if meta, err := imagemeta.DecodeMeta(bytes.NewReader(originData.Data)); err == nil {
+		res.SourceWidth, res.SourceHeight = meta.Width(), meta.Height()
+		res.ResultWidth, res.ResultHeight = meta.Width(), meta.Height()
+	}
+
+	return &res
+}
+
+// handleDryRun processes the image the same way handleProcessing does
+// but responds with the processing metadata instead of the encoded image
+func handleDryRun(reqID string, rw http.ResponseWriter, r *http.Request) {
+	stats.IncRequestsInProgress()
+	defer stats.DecRequestsInProgress()
+
+	ctx := r.Context()
+
+	if queueSem != nil {
+		token, aquired := queueSem.TryAquire()
+		if !aquired {
+			panic(ierrors.New(429, "Too many requests", "Too many requests"))
+		}
+		defer token.Release()
+	}
+
+	_, queueSegmentCancel := metrics.StartQueueSegment(ctx)
+	defer queueSegmentCancel()
+
+	tenant, prefix := requestTenant(r, config.PathPrefix+"/dry-run")
+	path := verifiedPath(ctx, r, prefix, tenant)
+
+	po, imageURL := parseProcessingPath(ctx, r, tenant, path)
+
+	processingSemToken := acquireProcessingSem(ctx, queueSegmentCancel)
+	defer processingSemToken.Release()
+
+	var (
+		originData *imagedata.ImageData
+		err        error
+	)
+
+	if imagedata.IsColorSource(imageURL) {
+		originData, err = generateColorSource(imageURL, po)
+		checkErr(ctx, "path_parsing", err)
+	} else {
+		originData, err = downloadOrigin(ctx, r, imageURL, make(http.Header), po)
+		checkErr(ctx, errTypeDownloadOther, err)
+	}
+	defer originData.Close()
+
+	checkErr(ctx, "timeout", router.CheckTimeout(ctx))
+
+	originData = sanitizeOrigin(ctx, originData)
+
+	var res *processing.DryRunResult
+
+	if canPassthroughOrigin(po, originData) {
+		res = dryRunSource(originData)
+	} else {
+		checkProcessable(ctx, po, originData)
+
+		// The image isn't encoded, so we don't report the processing segment
+		res, err = processing.DryRun(ctx, originData, po)
+		if err != nil {
+			sendErrAndPanic(ctx, processingErrType(err), err)
+		}
+	}
+
+	resp := dryRunResponse{
+		Source: dryRunImage{
+			Format:   res.SourceFormat.String(),
+			Width:    res.SourceWidth,
+			Height:   res.SourceHeight,
+			Frames:   res.SourceFrames,
+			HasAlpha: res.SourceHasAlpha,
+		},
+		Result: dryRunImage{
+			Format:   res.ResultFormat.String(),
+			Width:    res.ResultWidth,
+			Height:   res.ResultHeight,
+			Frames:   res.ResultFrames,
+			HasAlpha: res.ResultHasAlpha,
+		},
+		Passthrough:   res.Passthrough,
+		EstimatedSize: res.EstimatedSize,
+	}
+
+	data, err := json.Marshal(resp)
+	checkErr(ctx, "dry_run", err)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(200)
+	rw.Write(data)
+
+	router.LogResponse(
+		reqID, r, 200, nil,
+		log.Fields{
+			"image_url":          imageURL,
+			"processing_options": po,
+		},
+	)
+}
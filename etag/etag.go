@@ -35,8 +35,9 @@ var eTagCalcPool = sync.Pool{
 type Handler struct {
 	poHashActual, poHashExpected string
 
-	imgEtagActual, imgEtagExpected string
-	imgHashActual, imgHashExpected string
+	imgEtagActual, imgEtagExpected       string
+	imgLastModActual, imgLastModExpected string
+	imgHashActual, imgHashExpected       string
 }
 
 func (h *Handler) ParseExpectedETag(etag string) {
@@ -74,6 +75,11 @@ func (h *Handler) ParseExpectedETag(etag string) {
 		if err == nil {
 			h.imgEtagExpected = string(imgPartDec)
 		}
+	case 'M':
+		imgPartDec, err := base64.RawURLEncoding.DecodeString(imgPart)
+		if err == nil {
+			h.imgLastModExpected = string(imgPartDec)
+		}
 	case 'D':
 		h.imgHashExpected = imgPart
 	default:
@@ -92,9 +98,15 @@ func (h *Handler) SetActualProcessingOptions(po *options.ProcessingOptions) bool
 	c := eTagCalcPool.Get().(*eTagCalc)
 	defer eTagCalcPool.Put(c)
 
+	// The list of the used presets depends on the options order in the URL
+	// while the presets are already applied to the options. Thus we don't include it
+	// into the hash, so equivalent URLs produce the same ETag
+	cpo := *po
+	cpo.UsedPresets = make([]string, 0)
+
 	c.hash.Reset()
 	c.hash.Write([]byte(config.ETagBuster))
-	c.enc.Encode(po)
+	c.enc.Encode(&cpo)
 
 	h.poHashActual = base64.RawURLEncoding.EncodeToString(c.hash.Sum(nil))
 
@@ -105,6 +117,10 @@ func (h *Handler) ImageEtagExpected() string {
 	return h.imgEtagExpected
 }
 
+func (h *Handler) ImageLastModifiedExpected() string {
+	return h.imgLastModExpected
+}
+
 func (h *Handler) SetActualImageData(imgdata *imagedata.ImageData) bool {
 	var haveActualImgETag bool
 	h.imgEtagActual, haveActualImgETag = imgdata.Headers["ETag"]
@@ -116,9 +132,20 @@ func (h *Handler) SetActualImageData(imgdata *imagedata.ImageData) bool {
 		return true
 	}
 
+	// Last-Modified is used only when the source has no ETag
+	haveActualImgLastMod := false
+	if !haveActualImgETag {
+		h.imgLastModActual = imgdata.Headers["Last-Modified"]
+		haveActualImgLastMod = len(h.imgLastModActual) > 0
+
+		if haveActualImgLastMod && h.imgLastModExpected == h.imgLastModActual {
+			return true
+		}
+	}
+
 	haveExpectedImgHash := len(h.imgHashExpected) != 0
 
-	if !haveActualImgETag || haveExpectedImgHash {
+	if (!haveActualImgETag && !haveActualImgLastMod) || haveExpectedImgHash {
 		c := eTagCalcPool.Get().(*eTagCalc)
 		defer eTagCalcPool.Put(c)
 
@@ -134,19 +161,24 @@ func (h *Handler) SetActualImageData(imgdata *imagedata.ImageData) bool {
 }
 
 func (h *Handler) GenerateActualETag() string {
-	return h.generate(h.poHashActual, h.imgEtagActual, h.imgHashActual)
+	return h.generate(h.poHashActual, h.imgEtagActual, h.imgLastModActual, h.imgHashActual)
 }
 
 func (h *Handler) GenerateExpectedETag() string {
-	return h.generate(h.poHashExpected, h.imgEtagExpected, h.imgHashExpected)
+	return h.generate(h.poHashExpected, h.imgEtagExpected, h.imgLastModExpected, h.imgHashExpected)
 }
 
-func (h *Handler) generate(poHash, imgEtag, imgHash string) string {
+func (h *Handler) generate(poHash, imgEtag, imgLastMod, imgHash string) string {
 	imgPartMark := 'D'
 	imgPart := imgHash
-	if len(imgEtag) != 0 {
+
+	switch {
+	case len(imgEtag) != 0:
 		imgPartMark = 'R'
 		imgPart = base64.RawURLEncoding.EncodeToString([]byte(imgEtag))
+	case len(imgLastMod) != 0:
+		imgPartMark = 'M'
+		imgPart = base64.RawURLEncoding.EncodeToString([]byte(imgLastMod))
 	}
 
 	return fmt.Sprintf(`"%s/%c%s"`, poHash, imgPartMark, imgPart)
@@ -2,6 +2,7 @@ package etag
 
 import (
 	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
@@ -24,6 +25,10 @@ var (
 	imgWithoutETag = imagedata.ImageData{
 		Data: []byte("Hello Test"),
 	}
+	imgWithLastModified = imagedata.ImageData{
+		Data:    []byte("Hello Test"),
+		Headers: map[string]string{"Last-Modified": "Wed, 21 Oct 2015 07:28:00 GMT"},
+	}
 
 	etagReq  = `"yj0WO6sFU4GCciYUBWjzvvfqrBh869doeOC2Pp5EI1Y/RImxvcmVtaXBzdW1kb2xvciI"`
 	etagData = `"yj0WO6sFU4GCciYUBWjzvvfqrBh869doeOC2Pp5EI1Y/DvyChhMNu_sFX7jrjoyrgQbnFwfoOVv7kzp_Fbs6hQBg"`
@@ -139,6 +144,63 @@ func (s *EtagTestSuite) TestETagBusterFailure() {
 	require.False(s.T(), s.h.SetActualImageData(&imgWithoutETag))
 }
 
+func (s *EtagTestSuite) TestGenerateActualLastModified() {
+	s.h.SetActualProcessingOptions(po)
+	s.h.SetActualImageData(&imgWithLastModified)
+
+	etag := s.h.GenerateActualETag()
+	require.Equal(s.T(), etagReq[:strings.Index(etagReq, "/")]+`/MV2VkLCAyMSBPY3QgMjAxNSAwNzoyODowMCBHTVQ"`, etag)
+
+	s.h = Handler{}
+	s.h.ParseExpectedETag(etag)
+	require.Equal(s.T(), etag, s.h.GenerateExpectedETag())
+	require.Equal(s.T(), imgWithLastModified.Headers["Last-Modified"], s.h.ImageLastModifiedExpected())
+	require.Empty(s.T(), s.h.ImageEtagExpected())
+}
+
+func (s *EtagTestSuite) TestImageDataCheckLastModifiedSuccess() {
+	s.h.SetActualProcessingOptions(po)
+	s.h.SetActualImageData(&imgWithLastModified)
+	etag := s.h.GenerateActualETag()
+
+	s.h = Handler{}
+	s.h.ParseExpectedETag(etag)
+	require.True(s.T(), s.h.SetActualImageData(&imgWithLastModified))
+}
+
+func (s *EtagTestSuite) TestImageDataCheckLastModifiedFailure() {
+	s.h.SetActualProcessingOptions(po)
+	s.h.SetActualImageData(&imgWithLastModified)
+	etag := s.h.GenerateActualETag()
+
+	modified := imagedata.ImageData{
+		Data:    imgWithLastModified.Data,
+		Headers: map[string]string{"Last-Modified": "Thu, 22 Oct 2015 07:28:00 GMT"},
+	}
+
+	s.h = Handler{}
+	s.h.ParseExpectedETag(etag)
+	require.False(s.T(), s.h.SetActualImageData(&modified))
+}
+
+func (s *EtagTestSuite) TestProcessingOptionsPresetsOrder() {
+	require.Nil(s.T(), options.ParsePresets([]string{"small=rs:fill:100:100", "blurry=bl:5"}))
+
+	po1, _, err := options.ParsePath("/pr:small:blurry/plain/http://images.dev/lorem/ipsum.jpg", make(http.Header))
+	require.Nil(s.T(), err)
+
+	po2, _, err := options.ParsePath("/pr:blurry:small/plain/http://images.dev/lorem/ipsum.jpg", make(http.Header))
+	require.Nil(s.T(), err)
+
+	s.h.SetActualProcessingOptions(po1)
+	s.h.SetActualImageData(&imgWithETag)
+	etag := s.h.GenerateActualETag()
+
+	s.h = Handler{}
+	s.h.ParseExpectedETag(etag)
+	require.True(s.T(), s.h.SetActualProcessingOptions(po2))
+}
+
 func TestEtag(t *testing.T) {
 	suite.Run(t, new(EtagTestSuite))
 }
@@ -13,6 +13,12 @@ import (
 )
 
 func healthcheck() int {
+	// The bind address and the path prefix may be set in the config file
+	if err := config.LoadFile(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
 	network := config.Network
 	bind := config.Bind
 	pathprefix := config.PathPrefix
@@ -11,6 +11,9 @@ type Error struct {
 	Message       string
 	PublicMessage string
 	Unexpected    bool
+	// Type is the error type reported to the metrics services.
+	// When empty, the type is defined by the handler
+	Type string
 
 	stack []uintptr
 }
@@ -2,7 +2,9 @@ package imagedata
 
 import (
 	"compress/gzip"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -12,11 +14,13 @@ import (
 
 	"github.com/imgproxy/imgproxy/v3/config"
 	"github.com/imgproxy/imgproxy/v3/ierrors"
+	"github.com/imgproxy/imgproxy/v3/metrics/stats"
 
 	azureTransport "github.com/imgproxy/imgproxy/v3/transport/azure"
 	fsTransport "github.com/imgproxy/imgproxy/v3/transport/fs"
 	gcsTransport "github.com/imgproxy/imgproxy/v3/transport/gcs"
 	s3Transport "github.com/imgproxy/imgproxy/v3/transport/s3"
+	sftpTransport "github.com/imgproxy/imgproxy/v3/transport/sftp"
 	swiftTransport "github.com/imgproxy/imgproxy/v3/transport/swift"
 )
 
@@ -32,13 +36,17 @@ var (
 		"Cache-Control",
 		"Expires",
 		"ETag",
+		"Last-Modified",
 	}
 
 	// For tests
 	redirectAllRequestsTo string
 )
 
-const msgSourceImageIsUnreachable = "Source image is unreachable"
+const (
+	msgSourceImageIsUnreachable = "Source image is unreachable"
+	msgSourceConnectionFailed   = "Can't connect to the source image server"
+)
 
 type ErrorNotModified struct {
 	Message string
@@ -76,6 +84,8 @@ func initDownloading() error {
 			return err
 		} else {
 			registerProtocol("s3", t)
+			// Backblaze B2 speaks the S3-compatible API
+			registerProtocol("b2", t)
 		}
 	}
 
@@ -103,6 +113,14 @@ func initDownloading() error {
 		}
 	}
 
+	if config.SFTPEnabled {
+		if t, err := sftpTransport.New(); err != nil {
+			return err
+		} else {
+			registerProtocol("sftp", t)
+		}
+	}
+
 	downloadClient = &http.Client{
 		Timeout:   time.Duration(config.DownloadTimeout) * time.Second,
 		Transport: transport,
@@ -118,6 +136,13 @@ func initDownloading() error {
 	return nil
 }
 
+// IsSourceConnectionError checks if the error is caused by the failed connection
+// to the source image server
+func IsSourceConnectionError(err error) bool {
+	ierr, ok := err.(*ierrors.Error)
+	return ok && ierr.Type == errTypeSourceConnection
+}
+
 func headersToStore(res *http.Response) map[string]string {
 	m := make(map[string]string)
 
@@ -131,7 +156,7 @@ func headersToStore(res *http.Response) map[string]string {
 }
 
 func requestImage(imageURL string, header http.Header, jar *cookiejar.Jar) (*http.Response, error) {
-	req, err := http.NewRequest("GET", imageURL, nil)
+	req, err := newSourceRequest(imageURL)
 	if err != nil {
 		return nil, ierrors.New(404, err.Error(), msgSourceImageIsUnreachable)
 	}
@@ -160,7 +185,20 @@ func requestImage(imageURL string, header http.Header, jar *cookiejar.Jar) (*htt
 
 	res, err := downloadClient.Do(req)
 	if err != nil {
-		return nil, ierrors.New(500, checkTimeoutErr(err).Error(), msgSourceImageIsUnreachable)
+		err = checkTimeoutErr(err)
+
+		var connErr *sftpTransport.ConnectionError
+		if errors.As(err, &connErr) {
+			ierr := ierrors.New(500, err.Error(), msgSourceConnectionFailed)
+			ierr.Type = errTypeSourceConnection
+
+			return nil, ierr
+		}
+
+		ierr := ierrors.New(500, err.Error(), msgSourceImageIsUnreachable)
+		ierr.Type = downloadErrorType(err)
+
+		return nil, ierr
 	}
 
 	if res.StatusCode == http.StatusNotModified {
@@ -177,18 +215,27 @@ func requestImage(imageURL string, header http.Header, jar *cookiejar.Jar) (*htt
 		}
 
 		msg := fmt.Sprintf("Status: %d; %s", res.StatusCode, string(body))
-		return nil, ierrors.New(status, msg, msgSourceImageIsUnreachable)
+		ierr := ierrors.New(status, msg, msgSourceImageIsUnreachable)
+
+		if res.StatusCode == http.StatusNotFound {
+			ierr.Type = errTypeDownloadNotFound
+		}
+
+		return nil, ierr
 	}
 
 	return res, nil
 }
 
-func download(imageURL string, header http.Header, jar *cookiejar.Jar) (*ImageData, error) {
+func download(ctx context.Context, imageURL string, header http.Header, jar *cookiejar.Jar, maxSrcResolution int, videoOpts VideoOptions) (*ImageData, error) {
 	// We use this for testing
 	if len(redirectAllRequestsTo) > 0 {
 		imageURL = redirectAllRequestsTo
 	}
 
+	stats.IncSourceConnections()
+	defer stats.DecSourceConnections()
+
 	res, err := requestImage(imageURL, header, jar)
 	if res != nil {
 		defer res.Body.Close()
@@ -212,9 +259,9 @@ func download(imageURL string, header http.Header, jar *cookiejar.Jar) (*ImageDa
 		contentLength = 0
 	}
 
-	imgdata, err := readAndCheckImage(body, contentLength)
+	imgdata, err := readAndCheckImage(ctx, body, contentLength, maxSrcResolution, videoOpts)
 	if err != nil {
-		return nil, ierrors.Wrap(err, 0)
+		return nil, wrapReadError(err)
 	}
 
 	imgdata.Headers = headersToStore(res)
@@ -0,0 +1,43 @@
+package imagedata
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/imgproxy/imgproxy/v3/ierrors"
+)
+
+const (
+	errTypeDownloadTimeout  = "download_timeout"
+	errTypeDownloadNotFound = "download_not_found"
+	errTypeDownloadRefused  = "download_refused"
+	errTypeSourceConnection = "source_connection"
+)
+
+// downloadErrorType returns the metrics error type of the error occurred
+// while downloading the source image. Empty type means that the error
+// can't be classified, and the caller should use the generic type
+func downloadErrorType(err error) string {
+	switch {
+	case errors.Is(err, errSourceRequestTimeout):
+		return errTypeDownloadTimeout
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return errTypeDownloadRefused
+	}
+
+	return ""
+}
+
+// wrapReadError wraps the error occurred while reading the source image body.
+// Errors that are already *ierrors.Error are returned as is since they
+// can be shared
+func wrapReadError(err error) *ierrors.Error {
+	if ierr, ok := err.(*ierrors.Error); ok {
+		return ierr
+	}
+
+	ierr := ierrors.Wrap(err, 1)
+	ierr.Type = downloadErrorType(err)
+
+	return ierr
+}
@@ -0,0 +1,50 @@
+package imagedata
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	"github.com/imgproxy/imgproxy/v3/ierrors"
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/imgproxy/imgproxy/v3/security"
+)
+
+// ColorSourcePrefix is the prefix of the source URLs that define a solid color
+// instead of the image location, e.g. color:ff0000
+const ColorSourcePrefix = "color:"
+
+func IsColorSource(imageURL string) bool {
+	return strings.HasPrefix(imageURL, ColorSourcePrefix)
+}
+
+// FromColor generates a PNG image of the provided size filled with the provided color
+func FromColor(c color.Color, width, height int) (*ImageData, error) {
+	if width <= 0 || height <= 0 {
+		return nil, ierrors.New(
+			422,
+			fmt.Sprintf("Invalid generated image size: %dx%d", width, height),
+			"Invalid source image",
+		)
+	}
+
+	if err := security.CheckDimensions(width, height); err != nil {
+		return nil, err
+	}
+
+	// Paletted image with a single color is the cheapest to encode
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{c})
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, ierrors.Wrap(err, 0)
+	}
+
+	return &ImageData{
+		Type: imagetype.PNG,
+		Data: buf.Bytes(),
+	}, nil
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/imgproxy/imgproxy/v3/config"
 	"github.com/imgproxy/imgproxy/v3/ierrors"
 	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/imgproxy/imgproxy/v3/video"
 )
 
 var (
@@ -43,6 +44,7 @@ func (d *ImageData) SetCancel(cancel context.CancelFunc) {
 
 func Init() error {
 	initRead()
+	video.Init()
 
 	if err := initDownloading(); err != nil {
 		return err
@@ -71,7 +73,7 @@ func loadWatermark() (err error) {
 	}
 
 	if len(config.WatermarkURL) > 0 {
-		Watermark, err = Download(config.WatermarkURL, "watermark", nil, nil)
+		Watermark, err = Download(context.Background(), config.WatermarkURL, "watermark", nil, nil, config.MaxSrcResolution, DefaultVideoOptions())
 		return
 	}
 
@@ -85,7 +87,7 @@ func loadFallbackImage() (err error) {
 	case len(config.FallbackImagePath) > 0:
 		FallbackImage, err = FromFile(config.FallbackImagePath, "fallback image")
 	case len(config.FallbackImageURL) > 0:
-		FallbackImage, err = Download(config.FallbackImageURL, "fallback image", nil, nil)
+		FallbackImage, err = Download(context.Background(), config.FallbackImageURL, "fallback image", nil, nil, config.MaxSrcResolution, DefaultVideoOptions())
 	default:
 		FallbackImage, err = nil, nil
 	}
@@ -104,7 +106,7 @@ func FromBase64(encoded, desc string) (*ImageData, error) {
 	dec := base64.NewDecoder(base64.StdEncoding, strings.NewReader(encoded))
 	size := 4 * (len(encoded)/3 + 1)
 
-	imgdata, err := readAndCheckImage(dec, size)
+	imgdata, err := readAndCheckImage(context.Background(), dec, size, config.MaxSrcResolution, DefaultVideoOptions())
 	if err != nil {
 		return nil, fmt.Errorf("Can't decode %s: %s", desc, err)
 	}
@@ -123,7 +125,7 @@ func FromFile(path, desc string) (*ImageData, error) {
 		return nil, fmt.Errorf("Can't read %s: %s", desc, err)
 	}
 
-	imgdata, err := readAndCheckImage(f, int(fi.Size()))
+	imgdata, err := readAndCheckImage(context.Background(), f, int(fi.Size()), config.MaxSrcResolution, DefaultVideoOptions())
 	if err != nil {
 		return nil, fmt.Errorf("Can't read %s: %s", desc, err)
 	}
@@ -131,8 +133,10 @@ func FromFile(path, desc string) (*ImageData, error) {
 	return imgdata, nil
 }
 
-func Download(imageURL, desc string, header http.Header, jar *cookiejar.Jar) (*ImageData, error) {
-	imgdata, err := download(imageURL, header, jar)
+// Download downloads the image and checks its resolution against maxSrcResolution.
+// Video sources are converted to images according to videoOpts
+func Download(ctx context.Context, imageURL, desc string, header http.Header, jar *cookiejar.Jar, maxSrcResolution int, videoOpts VideoOptions) (*ImageData, error) {
+	imgdata, err := download(ctx, imageURL, header, jar, maxSrcResolution, videoOpts)
 	if err != nil {
 		if nmErr, ok := err.(*ErrorNotModified); ok {
 			nmErr.Message = fmt.Sprintf("Can't download %s: %s", desc, nmErr.Message)
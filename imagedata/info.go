@@ -0,0 +1,148 @@
+package imagedata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+
+	"github.com/imgproxy/imgproxy/v3/bufreader"
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/ierrors"
+	"github.com/imgproxy/imgproxy/v3/imagemeta"
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/imgproxy/imgproxy/v3/imath"
+	"github.com/imgproxy/imgproxy/v3/metrics/stats"
+	"github.com/imgproxy/imgproxy/v3/security"
+)
+
+var ErrSourceAnimationTooBig = security.NewSourceResolutionError("Source animation resolution is too big")
+
+// Info describes a source image and the limits it violates
+type Info struct {
+	Type   imagetype.Type
+	Width  int
+	Height int
+	// Number of frames imgproxy would process. It never exceeds
+	// config.MaxAnimationFrames
+	Frames int
+	// Size of the source image file. 0 if unknown
+	Size int
+
+	Errors []error
+}
+
+func (i *Info) Processable() bool {
+	return len(i.Errors) == 0
+}
+
+func (i *Info) addError(err error) {
+	for _, e := range i.Errors {
+		if e == err {
+			return
+		}
+	}
+
+	i.Errors = append(i.Errors, err)
+}
+
+// DownloadInfo reads only as much of the source image as is needed to check it
+// against the configured limits. Limits violations are collected to Info.Errors
+// instead of being returned as an error.
+func DownloadInfo(imageURL string, header http.Header, jar *cookiejar.Jar) (*Info, error) {
+	// We use this for testing
+	if len(redirectAllRequestsTo) > 0 {
+		imageURL = redirectAllRequestsTo
+	}
+
+	stats.IncSourceConnections()
+	defer stats.DecSourceConnections()
+
+	res, err := requestImage(imageURL, header, jar)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader = res.Body
+	contentLength := int(res.ContentLength)
+
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		gzipBody, errGzip := gzip.NewReader(res.Body)
+		if gzipBody != nil {
+			defer gzipBody.Close()
+		}
+		if errGzip != nil {
+			return nil, ierrors.Wrap(errGzip, 0)
+		}
+		body = gzipBody
+		contentLength = 0
+	}
+
+	info := Info{Frames: 1}
+
+	if contentLength > 0 {
+		info.Size = contentLength
+	}
+
+	if config.MaxSrcFileSize > 0 {
+		if contentLength > config.MaxSrcFileSize {
+			info.addError(ErrSourceFileTooBig)
+		}
+
+		body = &hardLimitReader{r: body, left: config.MaxSrcFileSize}
+	}
+
+	var buf bytes.Buffer
+	br := bufreader.New(body, &buf)
+
+	meta, err := imagemeta.DecodeMeta(br)
+	if err == ErrSourceFileTooBig {
+		info.addError(err)
+		return &info, nil
+	}
+	if err == imagemeta.ErrFormat {
+		return nil, ErrSourceImageTypeNotSupported
+	}
+	if err != nil {
+		return nil, wrapReadError(checkTimeoutErr(err))
+	}
+
+	info.Type = meta.Format()
+	info.Width = meta.Width()
+	info.Height = meta.Height()
+
+	if err = security.CheckDimensions(info.Width, info.Height); err != nil {
+		info.addError(err)
+	}
+
+	// We don't need to count frames if the image is already not processable
+	// or if we're not going to process animations at all
+	if !info.Processable() || config.MaxAnimationFrames <= 1 {
+		return &info, nil
+	}
+
+	// bufreader keeps everything it has read in buf,
+	// so we can read the source from the beginning
+	r := io.MultiReader(bytes.NewReader(buf.Bytes()), body)
+
+	frames, err := imagemeta.CountFrames(r, info.Type, config.MaxAnimationFrames)
+	if err == ErrSourceFileTooBig {
+		info.addError(err)
+		return &info, nil
+	}
+	if err != nil {
+		return nil, wrapReadError(checkTimeoutErr(err))
+	}
+
+	info.Frames = imath.Max(frames, 1)
+
+	if info.Frames > 1 && security.CheckDimensions(info.Width, info.Height*info.Frames) != nil {
+		info.addError(ErrSourceAnimationTooBig)
+	}
+
+	return &info, nil
+}
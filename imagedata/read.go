@@ -1,6 +1,9 @@
 package imagedata
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
 
 	"github.com/imgproxy/imgproxy/v3/bufpool"
@@ -9,6 +12,7 @@ import (
 	"github.com/imgproxy/imgproxy/v3/ierrors"
 	"github.com/imgproxy/imgproxy/v3/imagemeta"
 	"github.com/imgproxy/imgproxy/v3/security"
+	"github.com/imgproxy/imgproxy/v3/video"
 )
 
 var (
@@ -18,6 +22,21 @@ var (
 
 var downloadBufPool *bufpool.Pool
 
+// VideoOptions define how video sources are converted to images
+type VideoOptions struct {
+	// Time is the time (in seconds) of the frame to use.
+	// If it's negative, the video is converted to an animation
+	Time float64
+	// MaxFrames is the maximum number of frames of the animation
+	MaxFrames int
+}
+
+// DefaultVideoOptions returns the options that convert videos to animations
+// with the configured max number of frames
+func DefaultVideoOptions() VideoOptions {
+	return VideoOptions{Time: -1, MaxFrames: config.MaxAnimationFrames}
+}
+
 func initRead() {
 	downloadBufPool = bufpool.New("download", config.Concurrency, config.DownloadBufferSize)
 }
@@ -39,7 +58,9 @@ func (lr *hardLimitReader) Read(p []byte) (n int, err error) {
 	return
 }
 
-func readAndCheckImage(r io.Reader, contentLength int) (*ImageData, error) {
+// readAndCheckImage reads the image and checks its resolution against maxSrcResolution.
+// Videos are converted to images according to videoOpts
+func readAndCheckImage(ctx context.Context, r io.Reader, contentLength, maxSrcResolution int, videoOpts VideoOptions) (*ImageData, error) {
 	if config.MaxSrcFileSize > 0 && contentLength > config.MaxSrcFileSize {
 		return nil, ErrSourceFileTooBig
 	}
@@ -53,6 +74,12 @@ func readAndCheckImage(r io.Reader, contentLength int) (*ImageData, error) {
 
 	br := bufreader.New(r, buf)
 
+	if video.Enabled() {
+		if header, err := br.Peek(12); err == nil && video.IsVideo(header) {
+			return readVideo(ctx, br, buf, cancel, maxSrcResolution, videoOpts)
+		}
+	}
+
 	meta, err := imagemeta.DecodeMeta(br)
 	if err != nil {
 		buf.Reset()
@@ -65,7 +92,7 @@ func readAndCheckImage(r io.Reader, contentLength int) (*ImageData, error) {
 		return nil, checkTimeoutErr(err)
 	}
 
-	if err = security.CheckDimensions(meta.Width(), meta.Height()); err != nil {
+	if err = security.CheckDimensionsLimit(meta.Width(), meta.Height(), maxSrcResolution); err != nil {
 		buf.Reset()
 		cancel()
 		return nil, err
@@ -86,3 +113,45 @@ func readAndCheckImage(r io.Reader, contentLength int) (*ImageData, error) {
 		cancel: cancel,
 	}, nil
 }
+
+// readVideo reads the whole video and converts it to an animation
+// or extracts the frame at videoOpts.Time
+func readVideo(ctx context.Context, br *bufreader.Reader, buf *bytes.Buffer, cancel func(), maxSrcResolution int, videoOpts VideoOptions) (*ImageData, error) {
+	defer cancel()
+
+	if err := br.Flush(); err != nil {
+		return nil, checkTimeoutErr(err)
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+
+	if videoOpts.Time >= 0 {
+		data, err = video.ExtractFrame(ctx, buf.Bytes(), videoOpts.Time, maxSrcResolution)
+	} else {
+		data, err = video.ToAnimation(ctx, buf.Bytes(), videoOpts.MaxFrames, maxSrcResolution)
+	}
+	// ffmpeg is limited with the download timeout, so we report it the same way
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, errSourceRequestTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := imagemeta.DecodeMeta(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrSourceImageTypeNotSupported
+	}
+
+	if err = security.CheckDimensionsLimit(meta.Width(), meta.Height(), maxSrcResolution); err != nil {
+		return nil, err
+	}
+
+	return &ImageData{
+		Data: data,
+		Type: meta.Format(),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package imagedata
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+)
+
+// sourceRequestData is the data available in the source request body templates.
+// It's built from the source image URL only, so the body is protected
+// by the URL signature as well as the URL itself
+type sourceRequestData struct {
+	URL    string
+	Scheme string
+	Host   string
+	Path   string
+	Query  url.Values
+}
+
+func findSourceRequest(imageURL string) *config.SourceRequest {
+	for i, sr := range config.SourceRequests {
+		if sr.Pattern.MatchString(imageURL) {
+			return &config.SourceRequests[i]
+		}
+	}
+
+	return nil
+}
+
+func newSourceRequest(imageURL string) (*http.Request, error) {
+	sr := findSourceRequest(imageURL)
+	if sr == nil {
+		return http.NewRequest(http.MethodGet, imageURL, nil)
+	}
+
+	var body io.Reader
+
+	if sr.Body != nil {
+		u, err := url.Parse(imageURL)
+		if err != nil {
+			return nil, err
+		}
+
+		data := sourceRequestData{
+			URL:    imageURL,
+			Scheme: u.Scheme,
+			Host:   u.Host,
+			Path:   u.Path,
+			Query:  u.Query(),
+		}
+
+		buf := new(bytes.Buffer)
+		if err := sr.Body.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("Can't render the body of the source request %s: %s", sr.Name, err)
+		}
+
+		body = buf
+	}
+
+	req, err := http.NewRequest(sr.Method, imageURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", sr.ContentType)
+	}
+
+	return req, nil
+}
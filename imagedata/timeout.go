@@ -2,13 +2,15 @@ package imagedata
 
 import "errors"
 
+var errSourceRequestTimeout = errors.New("The image request timed out")
+
 type httpError interface {
 	Timeout() bool
 }
 
 func checkTimeoutErr(err error) error {
 	if httpErr, ok := err.(httpError); ok && httpErr.Timeout() {
-		return errors.New("The image request timed out")
+		return errSourceRequestTimeout
 	}
 	return err
 }
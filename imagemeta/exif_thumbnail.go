@@ -0,0 +1,138 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	jpegApp1Marker = 0xe1
+
+	exifThumbnailOffset = 0x0201 // JPEGInterchangeFormat
+	exifThumbnailLength = 0x0202 // JPEGInterchangeFormatLength
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// JpegExifThumbnail returns the JPEG thumbnail embedded into the EXIF data
+// of the JPEG image. Returns nil if the image doesn't have the thumbnail
+// or the EXIF data is malformed
+func JpegExifThumbnail(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xff || data[1] != jpegSoiMarker {
+		return nil
+	}
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			return nil
+		}
+
+		marker := data[pos+1]
+
+		// Fill bytes
+		if marker == 0xff {
+			pos++
+			continue
+		}
+
+		// EXIF data is always placed before the image data
+		if marker == jpegSosMarker || marker == jpegEoiMarker {
+			return nil
+		}
+
+		segLen := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if segLen < 2 || pos+2+segLen > len(data) {
+			return nil
+		}
+
+		seg := data[pos+4 : pos+2+segLen]
+
+		if marker == jpegApp1Marker && bytes.HasPrefix(seg, exifHeader) {
+			return tiffThumbnail(seg[len(exifHeader):])
+		}
+
+		pos += 2 + segLen
+	}
+
+	return nil
+}
+
+func tiffThumbnail(tiff []byte) []byte {
+	if len(tiff) < 8 {
+		return nil
+	}
+
+	var byteOrder binary.ByteOrder
+
+	switch {
+	case bytes.Equal(tiffLeHeader, tiff[0:4]):
+		byteOrder = binary.LittleEndian
+	case bytes.Equal(tiffBeHeader, tiff[0:4]):
+		byteOrder = binary.BigEndian
+	default:
+		return nil
+	}
+
+	// The thumbnail is described by IFD1 that follows IFD0
+	ifd0 := int(byteOrder.Uint32(tiff[4:8]))
+
+	ifd1, ok := nextIFDOffset(tiff, ifd0, byteOrder)
+	if !ok || ifd1 == 0 || ifd1+2 > len(tiff) {
+		return nil
+	}
+
+	numItems := int(byteOrder.Uint16(tiff[ifd1 : ifd1+2]))
+
+	var offset, length int
+
+	for i := 0; i < numItems; i++ {
+		entry := ifd1 + 2 + i*12
+		if entry+12 > len(tiff) {
+			return nil
+		}
+
+		tag := byteOrder.Uint16(tiff[entry : entry+2])
+		if tag != exifThumbnailOffset && tag != exifThumbnailLength {
+			continue
+		}
+
+		if byteOrder.Uint16(tiff[entry+2:entry+4]) != tiffDtLong {
+			return nil
+		}
+
+		value := int(byteOrder.Uint32(tiff[entry+8 : entry+12]))
+
+		if tag == exifThumbnailOffset {
+			offset = value
+		} else {
+			length = value
+		}
+	}
+
+	if offset <= 0 || length <= 0 || offset+length > len(tiff) {
+		return nil
+	}
+
+	thumbnail := tiff[offset : offset+length]
+
+	if len(thumbnail) < 2 || thumbnail[0] != 0xff || thumbnail[1] != jpegSoiMarker {
+		return nil
+	}
+
+	return thumbnail
+}
+
+func nextIFDOffset(tiff []byte, ifd int, byteOrder binary.ByteOrder) (int, bool) {
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0, false
+	}
+
+	numItems := int(byteOrder.Uint16(tiff[ifd : ifd+2]))
+
+	next := ifd + 2 + numItems*12
+	if next+4 > len(tiff) {
+		return 0, false
+	}
+
+	return int(byteOrder.Uint32(tiff[next : next+4])), true
+}
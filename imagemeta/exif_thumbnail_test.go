@@ -0,0 +1,53 @@
+package imagemeta
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ExifThumbnailTestSuite struct {
+	suite.Suite
+}
+
+func (s *ExifThumbnailTestSuite) readFile(name string) []byte {
+	wd, err := os.Getwd()
+	require.Nil(s.T(), err)
+	data, err := os.ReadFile(filepath.Join(wd, "..", "testdata", name))
+	require.Nil(s.T(), err)
+	return data
+}
+
+func (s *ExifThumbnailTestSuite) TestJpegExifThumbnail() {
+	thumbnail := JpegExifThumbnail(s.readFile("test-exif-thumbnail.jpg"))
+	require.NotNil(s.T(), thumbnail)
+
+	meta, err := DecodeJpegMeta(bytes.NewReader(thumbnail))
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), imagetype.JPEG, meta.Format())
+	require.Equal(s.T(), 32, meta.Width())
+	require.Equal(s.T(), 24, meta.Height())
+}
+
+func (s *ExifThumbnailTestSuite) TestJpegExifThumbnailMissing() {
+	require.Nil(s.T(), JpegExifThumbnail(s.readFile("test-no-exif-thumbnail.jpg")))
+	require.Nil(s.T(), JpegExifThumbnail(s.readFile("test1.png")))
+}
+
+func (s *ExifThumbnailTestSuite) TestJpegExifThumbnailTruncated() {
+	data := s.readFile("test-exif-thumbnail.jpg")
+
+	for _, n := range []int{0, 4, 20, 60, 200} {
+		require.Nil(s.T(), JpegExifThumbnail(data[:n]))
+	}
+}
+
+func TestExifThumbnail(t *testing.T) {
+	suite.Run(t, new(ExifThumbnailTestSuite))
+}
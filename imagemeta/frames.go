@@ -0,0 +1,140 @@
+package imagemeta
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"golang.org/x/image/riff"
+)
+
+var ErrGifInvalidFormat = errors.New("gif: invalid format")
+
+var webpFccANMF = riff.FourCC{'A', 'N', 'M', 'F'}
+
+// CountFrames counts frames of an animated image without decoding them.
+// Counting stops as soon as limit frames are found, so the result never exceeds
+// the limit. If limit is 0, all the frames are counted.
+// Formats that don't support animation always have a single frame.
+func CountFrames(r io.Reader, format imagetype.Type, limit int) (int, error) {
+	switch format {
+	case imagetype.GIF:
+		return countGifFrames(bufio.NewReader(r), limit)
+	case imagetype.WEBP:
+		return countWebpFrames(r, limit)
+	default:
+		return 1, nil
+	}
+}
+
+func skipGifSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err = r.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+func countGifFrames(r *bufio.Reader, limit int) (int, error) {
+	var tmp [13]byte
+
+	// Header and logical screen descriptor
+	if _, err := io.ReadFull(r, tmp[:13]); err != nil {
+		return 0, err
+	}
+
+	if tmp[10]&0x80 != 0 {
+		if _, err := r.Discard(3 << ((tmp[10] & 0x07) + 1)); err != nil {
+			return 0, err
+		}
+	}
+
+	frames := 0
+
+	for limit <= 0 || frames < limit {
+		blockType, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch blockType {
+		case 0x21: // Extension
+			if _, err = r.ReadByte(); err != nil {
+				return 0, err
+			}
+			if err = skipGifSubBlocks(r); err != nil {
+				return 0, err
+			}
+
+		case 0x2C: // Image descriptor
+			if _, err = io.ReadFull(r, tmp[:9]); err != nil {
+				return 0, err
+			}
+
+			if tmp[8]&0x80 != 0 {
+				if _, err = r.Discard(3 << ((tmp[8] & 0x07) + 1)); err != nil {
+					return 0, err
+				}
+			}
+
+			// LZW minimum code size
+			if _, err = r.ReadByte(); err != nil {
+				return 0, err
+			}
+			if err = skipGifSubBlocks(r); err != nil {
+				return 0, err
+			}
+
+			frames++
+
+		case 0x3B: // Trailer
+			return frames, nil
+
+		default:
+			return 0, ErrGifInvalidFormat
+		}
+	}
+
+	return frames, nil
+}
+
+func countWebpFrames(r io.Reader, limit int) (int, error) {
+	formType, riffReader, err := riff.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	if formType != webpFccWEBP {
+		return 0, ErrWebpInvalidFormat
+	}
+
+	frames := 0
+
+	for limit <= 0 || frames < limit {
+		chunkID, _, _, err := riffReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if chunkID == webpFccANMF {
+			frames++
+		}
+	}
+
+	// Not animated WebP
+	if frames == 0 {
+		frames = 1
+	}
+
+	return frames, nil
+}
@@ -0,0 +1,58 @@
+package imagemeta
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type FramesTestSuite struct {
+	suite.Suite
+}
+
+func (s *FramesTestSuite) openFile(name string) *os.File {
+	wd, err := os.Getwd()
+	require.Nil(s.T(), err)
+	path := filepath.Join(wd, "..", "testdata", name)
+	f, err := os.Open(path)
+	require.Nil(s.T(), err)
+	return f
+}
+
+func (s *FramesTestSuite) TestCountGifFrames() {
+	f := s.openFile("test-animated.gif")
+	defer f.Close()
+
+	frames, err := CountFrames(f, imagetype.GIF, 0)
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 3, frames)
+}
+
+func (s *FramesTestSuite) TestCountGifFramesLimit() {
+	f := s.openFile("test-animated.gif")
+	defer f.Close()
+
+	frames, err := CountFrames(f, imagetype.GIF, 2)
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 2, frames)
+}
+
+func (s *FramesTestSuite) TestCountStaticFrames() {
+	f := s.openFile("test1.png")
+	defer f.Close()
+
+	frames, err := CountFrames(f, imagetype.PNG, 0)
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 1, frames)
+}
+
+func TestFrames(t *testing.T) {
+	suite.Run(t, new(FramesTestSuite))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/errorreport"
+	"github.com/imgproxy/imgproxy/v3/ierrors"
+	"github.com/imgproxy/imgproxy/v3/imath"
+	"github.com/imgproxy/imgproxy/v3/router"
+)
+
+// maxInfoBatchBodySize is the maximum size of the batch info request body
+const maxInfoBatchBodySize = 1 << 20
+
+type infoBatchRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// infoBatchItem contains either the image info or the error
+// occurred while collecting it
+type infoBatchItem struct {
+	*infoResponse
+
+	Error  string `json:"error,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func handleInfoBatch(reqID string, rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tenant, _ := requestTenant(r, config.PathPrefix+"/info/batch")
+
+	var req infoBatchRequest
+
+	body := http.MaxBytesReader(rw, r.Body, maxInfoBatchBodySize)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		sendErrAndPanic(ctx, "path_parsing", ierrors.New(
+			400, fmt.Sprintf("Invalid batch request: %s", err), "Invalid request",
+		))
+	}
+
+	if len(req.Paths) == 0 || len(req.Paths) > config.InfoBatchMaxSize {
+		sendErrAndPanic(ctx, "path_parsing", ierrors.New(
+			400,
+			fmt.Sprintf("Batch size should be between 1 and %d, now - %d", config.InfoBatchMaxSize, len(req.Paths)),
+			"Invalid request",
+		))
+	}
+
+	items := make([]infoBatchItem, len(req.Paths))
+
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := imath.Min(config.InfoBatchConcurrency, len(req.Paths)); w > 0; w-- {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				items[i] = collectBatchItem(ctx, r, tenant, req.Paths[i])
+			}
+		}()
+	}
+
+	for i := range req.Paths {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+
+	failed := 0
+	for _, item := range items {
+		if item.infoResponse == nil {
+			failed++
+		}
+	}
+
+	data, err := json.Marshal(items)
+	checkErr(ctx, "info", err)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(200)
+	rw.Write(data)
+
+	router.LogResponse(
+		reqID, r, 200, nil,
+		log.Fields{
+			"batch_size": len(items),
+			"failed":     failed,
+		},
+	)
+}
+
+// collectBatchItem collects the info of the source image referenced by the signed path.
+// Errors are reported to the metrics services and returned as a part of the item
+func collectBatchItem(ctx context.Context, r *http.Request, tenant *config.Tenant, signedPath string) (item infoBatchItem) {
+	// Items are collected in separate goroutines where the panic handler
+	// can't catch panics, so we turn them into the item errors
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			err, ok := rerr.(error)
+			if !ok {
+				err = fmt.Errorf("%v", rerr)
+			}
+
+			item = batchItemError(ctx, r, signedPath, "info", err)
+		}
+	}()
+
+	path, errType, err := verifySignedPath(signedPath, tenant)
+	if err == nil {
+		var resp *infoResponse
+
+		if resp, _, errType, err = collectInfo(ctx, r, tenant, path); err == nil {
+			return infoBatchItem{infoResponse: resp}
+		}
+	}
+
+	return batchItemError(ctx, r, signedPath, errType, err)
+}
+
+// batchItemError reports the error occurred while collecting the item
+// and returns the item containing it
+func batchItemError(ctx context.Context, r *http.Request, signedPath, errType string, err error) infoBatchItem {
+	sendErr(ctx, errType, err)
+
+	ierr := ierrors.Wrap(err, 0)
+	if ierr.Unexpected {
+		errorreport.Report(err, r)
+	}
+
+	log.Warningf("Can't collect image info for %s: %s", signedPath, ierr.Message)
+
+	item := infoBatchItem{
+		Error:  ierr.PublicMessage,
+		Status: ierr.StatusCode,
+	}
+
+	if config.DevelopmentErrorsMode {
+		item.Error = ierr.Message
+	}
+
+	return item
+}
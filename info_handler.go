@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/cookies"
+	"github.com/imgproxy/imgproxy/v3/ierrors"
+	"github.com/imgproxy/imgproxy/v3/imagedata"
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/imgproxy/imgproxy/v3/metrics"
+	"github.com/imgproxy/imgproxy/v3/metrics/stats"
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/processing"
+	"github.com/imgproxy/imgproxy/v3/router"
+	"github.com/imgproxy/imgproxy/v3/security"
+	"github.com/imgproxy/imgproxy/v3/semaphore"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+type infoResponse struct {
+	Format      string   `json:"format"`
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	Frames      int      `json:"frames"`
+	Size        int      `json:"size"`
+	Processable bool     `json:"processable"`
+	Reasons     []string `json:"reasons"`
+	Sharpness   *float64 `json:"sharpness,omitempty"`
+	IsBlank     *bool    `json:"is_blank,omitempty"`
+}
+
+func handleInfo(reqID string, rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tenant, prefix := requestTenant(r, config.PathPrefix+"/info")
+	path := verifiedPath(ctx, r, prefix, tenant)
+
+	resp, imageURL, errType, err := collectInfo(ctx, r, tenant, path)
+	checkErr(ctx, errType, err)
+
+	data, err := json.Marshal(resp)
+	checkErr(ctx, "info", err)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(200)
+	rw.Write(data)
+
+	router.LogResponse(
+		reqID, r, 200, nil,
+		log.Fields{
+			"image_url":   imageURL,
+			"processable": resp.Processable,
+		},
+	)
+}
+
+// collectInfo collects the info of the source image referenced by the verified path.
+// It returns the error type used for metrics along with the error
+func collectInfo(ctx context.Context, r *http.Request, tenant *config.Tenant, path string) (*infoResponse, string, string, error) {
+	po, imageURL, err := options.ParseTenantPath(tenant, path, r.Header)
+	if err != nil {
+		return nil, imageURL, "path_parsing", err
+	}
+
+	// Allowed sources policy can be selected only in signed URLs
+	policy := ""
+	if security.IsTenantSignatureEnabled(tenant) {
+		policy = po.AllowedSourcesPolicy
+	}
+
+	if !security.VerifyTenantSourceURL(tenant, policy, imageURL) {
+		return nil, imageURL, "security", ierrors.New(
+			404,
+			fmt.Sprintf("Source URL is not allowed: %s", imageURL),
+			"Invalid source",
+		)
+	}
+
+	info, err := func() (*imagedata.Info, error) {
+		_, cancel := metrics.StartDownloadingSegment(ctx)
+		defer cancel()
+
+		var cookieJar *cookiejar.Jar
+
+		if config.CookiePassthrough {
+			var err error
+			if cookieJar, err = cookies.JarFromRequest(r); err != nil {
+				return nil, err
+			}
+		}
+
+		return imagedata.DownloadInfo(imageURL, make(http.Header), cookieJar)
+	}()
+	if err != nil {
+		return nil, imageURL, errTypeDownloadOther, err
+	}
+
+	resp := infoResponse{
+		Format:  info.Type.String(),
+		Width:   info.Width,
+		Height:  info.Height,
+		Frames:  info.Frames,
+		Size:    info.Size,
+		Reasons: make([]string, 0, len(info.Errors)+1),
+	}
+
+	for _, e := range info.Errors {
+		resp.Reasons = append(resp.Reasons, e.Error())
+	}
+
+	// SVG is a special case, it can be passed through as is
+	if info.Type != imagetype.Unknown && info.Type != imagetype.SVG && !vips.SupportsLoad(info.Type) {
+		resp.Reasons = append(resp.Reasons, imagedata.ErrSourceImageTypeNotSupported.Error())
+	}
+
+	resp.Processable = len(resp.Reasons) == 0
+
+	// Image stats require the whole image to be downloaded and decoded
+	if (po.ReturnSharpness || po.DetectBlank) && resp.Processable && vips.SupportsLoad(info.Type) {
+		imgStats, errType, err := calcImageStats(ctx, r, imageURL)
+		if err != nil {
+			return nil, imageURL, errType, err
+		}
+
+		if po.ReturnSharpness {
+			resp.Sharpness = &imgStats.Sharpness
+		}
+
+		if po.DetectBlank {
+			isBlank := imgStats.Deviation <= po.BlankThreshold
+			resp.IsBlank = &isBlank
+		}
+	}
+
+	return &resp, imageURL, "", nil
+}
+
+// calcImageStats downloads and decodes the whole image to calculate its stats.
+// It returns the error type used for metrics along with the error
+func calcImageStats(ctx context.Context, r *http.Request, imageURL string) (*processing.ImageStats, string, error) {
+	// The heavy part start here, so we need to restrict concurrency
+	processingSemToken, err := func() (*semaphore.Token, error) {
+		ctx, cancel := metrics.StartWorkerSegment(ctx)
+		defer cancel()
+
+		stats.IncRequestsInQueue()
+		defer stats.DecRequestsInQueue()
+
+		token, aquired := processingSem.Aquire(ctx)
+		if !aquired {
+			if err := router.CheckTimeout(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		return token, nil
+	}()
+	if err != nil {
+		return nil, "queue", err
+	}
+	defer processingSemToken.Release()
+
+	imgdata, err := func() (*imagedata.ImageData, error) {
+		ctx, cancel := metrics.StartDownloadingSegment(ctx)
+		defer cancel()
+
+		var cookieJar *cookiejar.Jar
+
+		if config.CookiePassthrough {
+			var err error
+			if cookieJar, err = cookies.JarFromRequest(r); err != nil {
+				return nil, err
+			}
+		}
+
+		return imagedata.Download(ctx, imageURL, "source image", make(http.Header), cookieJar, config.MaxSrcResolution, imagedata.DefaultVideoOptions())
+	}()
+	if err != nil {
+		return nil, errTypeDownloadOther, err
+	}
+	defer imgdata.Close()
+
+	imgStats, err := func() (*processing.ImageStats, error) {
+		_, cancel := metrics.StartProcessingSegment(ctx)
+		defer cancel()
+
+		return processing.CalcImageStats(imgdata)
+	}()
+	if err != nil {
+		return nil, "processing", err
+	}
+
+	return imgStats, "", nil
+}
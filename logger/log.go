@@ -27,12 +27,7 @@ func Init() error {
 		logrus.SetFormatter(newPrettyFormatter())
 	}
 
-	levelLogLevel, err := logrus.ParseLevel(logLevel)
-	if err != nil {
-		levelLogLevel = logrus.InfoLevel
-	}
-
-	logrus.SetLevel(levelLogLevel)
+	setLevel(logLevel)
 
 	if isSyslogEnabled() {
 		slHook, err := newSyslogHook()
@@ -45,3 +40,20 @@ func Init() error {
 
 	return nil
 }
+
+// Reload sets the log level from the reloaded config
+func Reload() {
+	logLevel := "info"
+	configurators.String(&logLevel, "IMGPROXY_LOG_LEVEL")
+
+	setLevel(logLevel)
+}
+
+func setLevel(logLevel string) {
+	levelLogLevel, err := logrus.ParseLevel(logLevel)
+	if err != nil {
+		levelLogLevel = logrus.InfoLevel
+	}
+
+	logrus.SetLevel(levelLogLevel)
+}
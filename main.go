@@ -13,6 +13,7 @@ import (
 	"go.uber.org/automaxprocs/maxprocs"
 
 	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
 	"github.com/imgproxy/imgproxy/v3/errorreport"
 	"github.com/imgproxy/imgproxy/v3/gliblog"
 	"github.com/imgproxy/imgproxy/v3/imagedata"
@@ -27,6 +28,10 @@ import (
 )
 
 func initialize() error {
+	if err := config.LoadFile(); err != nil {
+		return err
+	}
+
 	if err := logger.Init(); err != nil {
 		return err
 	}
@@ -65,11 +70,44 @@ func initialize() error {
 		return err
 	}
 
+	for _, t := range config.Tenants {
+		if err := options.ParseTenantPresets(t.Name, t.Presets); err != nil {
+			vips.Shutdown()
+			return err
+		}
+	}
+
 	if err := options.ValidatePresets(); err != nil {
 		vips.Shutdown()
 		return err
 	}
 
+	if err := options.ValidateDefaultBackground(); err != nil {
+		vips.Shutdown()
+		return err
+	}
+
+	return nil
+}
+
+// reloadConfig reloads the config values that are safe to change at runtime:
+// allowed sources, presets, and log level
+func reloadConfig() error {
+	rc, err := config.ReadReloadable()
+	if err != nil {
+		return err
+	}
+
+	// Validate all the values before applying any of them,
+	// so an invalid value doesn't leave the config half-applied
+	applyPresets, err := options.PreparePresets(rc.Presets)
+	if err != nil {
+		config.DiscardReloadable(rc)
+		return err
+	}
+
+	config.ApplyReloadable(rc, applyPresets, logger.Reload)
+
 	return nil
 }
 
@@ -87,7 +125,9 @@ func run() error {
 	defer shutdown()
 
 	go func() {
-		var logMemStats = len(os.Getenv("IMGPROXY_LOG_MEM_STATS")) > 0
+		var logMemStatsStr string
+		configurators.String(&logMemStatsStr, "IMGPROXY_LOG_MEM_STATS")
+		logMemStats := len(logMemStatsStr) > 0
 
 		for range time.Tick(time.Duration(config.FreeMemoryInterval) * time.Second) {
 			memory.Free()
@@ -110,6 +150,19 @@ func run() error {
 	}
 	defer shutdownServer(s)
 
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	go func() {
+		for range reload {
+			if err := reloadConfig(); err != nil {
+				log.Errorf("Can't reload config: %s", err)
+			} else {
+				log.Info("Config reloaded")
+			}
+		}
+	}()
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
@@ -151,6 +151,12 @@ func ObserveBufferSize(t string, size int) {
 	}
 }
 
+func ObserveOutputSize(format string, size int) {
+	if enabledMetrics {
+		statsdClient.Histogram("imgproxy.output.size", float64(size), []string{"format:" + format}, 1)
+	}
+}
+
 func SetBufferDefaultSize(t string, size int) {
 	if enabledMetrics {
 		statsdClient.Gauge("imgproxy.buffer.default_size", float64(size), []string{"type:" + t}, 1)
@@ -180,6 +186,7 @@ func runMetricsCollector() {
 
 			statsdClient.Gauge("imgproxy.requests_in_progress", stats.RequestsInProgress(), nil, 1)
 			statsdClient.Gauge("imgproxy.images_in_progress", stats.ImagesInProgress(), nil, 1)
+			statsdClient.Gauge("imgproxy.source_connections", stats.SourceConnections(), nil, 1)
 		case <-statsdClientStop:
 			return
 		}
@@ -3,10 +3,13 @@ package metrics
 import (
 	"context"
 	"net/http"
+	"sync"
 
 	"github.com/imgproxy/imgproxy/v3/metrics/datadog"
 	"github.com/imgproxy/imgproxy/v3/metrics/newrelic"
+	"github.com/imgproxy/imgproxy/v3/metrics/otel"
 	"github.com/imgproxy/imgproxy/v3/metrics/prometheus"
+	"github.com/imgproxy/imgproxy/v3/metrics/statsd"
 )
 
 func Init() error {
@@ -18,96 +21,180 @@ func Init() error {
 
 	datadog.Init()
 
+	if err := otel.Init(); err != nil {
+		return err
+	}
+
+	if err := statsd.Init(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func Stop() {
 	newrelic.Stop()
 	datadog.Stop()
+	otel.Stop()
+	statsd.Stop()
 }
 
 func Enabled() bool {
 	return prometheus.Enabled() ||
 		newrelic.Enabled() ||
-		datadog.Enabled()
+		datadog.Enabled() ||
+		otel.Enabled() ||
+		statsd.Enabled()
 }
 
 func StartRequest(ctx context.Context, rw http.ResponseWriter, r *http.Request) (context.Context, context.CancelFunc, http.ResponseWriter) {
-	promCancel := prometheus.StartRequest()
+	ctx, promCancel := prometheus.StartRequest(ctx, r)
 	ctx, nrCancel, rw := newrelic.StartTransaction(ctx, rw, r)
 	ctx, ddCancel, rw := datadog.StartRootSpan(ctx, rw, r)
+	ctx, otelCancel := otel.StartRequest(ctx, r)
+	ctx, statsdCancel := statsd.StartRequest(ctx)
 
 	cancel := func() {
 		promCancel()
 		nrCancel()
 		ddCancel()
+		otelCancel()
+		statsdCancel()
 	}
 
 	return ctx, cancel, rw
 }
 
-func StartQueueSegment(ctx context.Context) context.CancelFunc {
-	promCancel := prometheus.StartQueueSegment()
+// StartQueueSegment starts the queue segment. The queue segment may be finished
+// before the request is done, so the returned cancel func can be called more than once
+func StartQueueSegment(ctx context.Context) (context.Context, context.CancelFunc) {
+	promCancel := prometheus.StartQueueSegment(ctx)
 	nrCancel := newrelic.StartSegment(ctx, "Queue")
 	ddCancel := datadog.StartSpan(ctx, "queue")
+	ctx, otelCancel := otel.StartQueueSegment(ctx)
+	statsdCancel := statsd.StartQueueSegment(ctx)
+
+	var once sync.Once
+
+	cancel := func() {
+		once.Do(func() {
+			promCancel()
+			nrCancel()
+			ddCancel()
+			otelCancel()
+			statsdCancel()
+		})
+	}
+
+	return ctx, cancel
+}
+
+func StartWorkerSegment(ctx context.Context) (context.Context, context.CancelFunc) {
+	promCancel := prometheus.StartWorkerSegment(ctx)
+	nrCancel := newrelic.StartSegment(ctx, "Waiting for worker")
+	ddCancel := datadog.StartSpan(ctx, "waiting_for_worker")
+	ctx, otelCancel := otel.StartWorkerSegment(ctx)
+	statsdCancel := statsd.StartWorkerSegment(ctx)
 
 	cancel := func() {
 		promCancel()
 		nrCancel()
 		ddCancel()
+		otelCancel()
+		statsdCancel()
 	}
 
-	return cancel
+	return ctx, cancel
 }
 
-func StartDownloadingSegment(ctx context.Context) context.CancelFunc {
-	promCancel := prometheus.StartDownloadingSegment()
+func StartDownloadingSegment(ctx context.Context) (context.Context, context.CancelFunc) {
+	promCancel := prometheus.StartDownloadingSegment(ctx)
 	nrCancel := newrelic.StartSegment(ctx, "Downloading image")
 	ddCancel := datadog.StartSpan(ctx, "downloading_image")
+	ctx, otelCancel := otel.StartDownloadingSegment(ctx)
+	statsdCancel := statsd.StartDownloadingSegment(ctx)
 
 	cancel := func() {
 		promCancel()
 		nrCancel()
 		ddCancel()
+		otelCancel()
+		statsdCancel()
 	}
 
-	return cancel
+	return ctx, cancel
 }
 
-func StartProcessingSegment(ctx context.Context) context.CancelFunc {
-	promCancel := prometheus.StartProcessingSegment()
+func StartProcessingSegment(ctx context.Context) (context.Context, context.CancelFunc) {
+	promCancel := prometheus.StartProcessingSegment(ctx)
 	nrCancel := newrelic.StartSegment(ctx, "Processing image")
 	ddCancel := datadog.StartSpan(ctx, "processing_image")
+	ctx, otelCancel := otel.StartProcessingSegment(ctx)
+	statsdCancel := statsd.StartProcessingSegment(ctx)
 
 	cancel := func() {
 		promCancel()
 		nrCancel()
 		ddCancel()
+		otelCancel()
+		statsdCancel()
 	}
 
-	return cancel
+	return ctx, cancel
 }
 
 func SendError(ctx context.Context, errType string, err error) {
 	prometheus.IncrementErrorsTotal(errType)
 	newrelic.SendError(ctx, errType, err)
 	datadog.SendError(ctx, errType, err)
+	otel.SendError(ctx, errType, err)
+	statsd.IncrementErrorsTotal(errType)
+}
+
+// IncrementErrorsTotal counts the error without reporting it to error tracking
+// services. It's used for the issues that don't fail the request
+func IncrementErrorsTotal(errType string) {
+	prometheus.IncrementErrorsTotal(errType)
+	statsd.IncrementErrorsTotal(errType)
+}
+
+// IncrementSmartCropTotal counts smart crops
+func IncrementSmartCropTotal() {
+	prometheus.IncrementSmartCropTotal()
+	statsd.IncrementSmartCropTotal()
+}
+
+// IncrementSmartCropFallbackTotal counts smart crops that fell back
+// to the center gravity because the smart crop area couldn't be detected
+func IncrementSmartCropFallbackTotal() {
+	prometheus.IncrementSmartCropFallbackTotal()
+	statsd.IncrementSmartCropFallbackTotal()
 }
 
 func ObserveBufferSize(t string, size int) {
 	prometheus.ObserveBufferSize(t, size)
 	newrelic.ObserveBufferSize(t, size)
 	datadog.ObserveBufferSize(t, size)
+	statsd.ObserveBufferSize(t, size)
+}
+
+func ObserveOutputSize(format string, size int) {
+	prometheus.ObserveOutputSize(format, size)
+	newrelic.ObserveOutputSize(format, size)
+	datadog.ObserveOutputSize(format, size)
+	statsd.ObserveOutputSize(format, size)
 }
 
 func SetBufferDefaultSize(t string, size int) {
 	prometheus.SetBufferDefaultSize(t, size)
 	newrelic.SetBufferDefaultSize(t, size)
 	datadog.SetBufferDefaultSize(t, size)
+	statsd.SetBufferDefaultSize(t, size)
 }
 
 func SetBufferMaxSize(t string, size int) {
 	prometheus.SetBufferMaxSize(t, size)
 	newrelic.SetBufferMaxSize(t, size)
 	datadog.SetBufferMaxSize(t, size)
+	statsd.SetBufferMaxSize(t, size)
 }
@@ -43,6 +43,9 @@ var (
 	bufferSummaries      = make(map[string]*telemetry.Summary)
 	bufferSummariesMutex sync.RWMutex
 
+	outputSizeSummaries      = make(map[string]*telemetry.Summary)
+	outputSizeSummariesMutex sync.RWMutex
+
 	interval = 10 * time.Second
 
 	licenseEuRegex = regexp.MustCompile(`(^eu.+?)x`)
@@ -179,12 +182,49 @@ func ObserveBufferSize(t string, size int) {
 			bufferSummaries[t] = summary
 		}
 
-		sizef := float64(size)
+		observeSummary(summary, float64(size))
+	}
+}
 
-		summary.Count += 1
-		summary.Sum += sizef
-		summary.Min = math.Min(summary.Min, sizef)
-		summary.Max = math.Max(summary.Max, sizef)
+func ObserveOutputSize(format string, size int) {
+	if enabledHarvester {
+		outputSizeSummariesMutex.Lock()
+		defer outputSizeSummariesMutex.Unlock()
+
+		summary, ok := outputSizeSummaries[format]
+		if !ok {
+			summary = &telemetry.Summary{
+				Name:       "imgproxy.output.size",
+				Attributes: map[string]interface{}{"format": format},
+				Timestamp:  time.Now(),
+			}
+			outputSizeSummaries[format] = summary
+		}
+
+		observeSummary(summary, float64(size))
+	}
+}
+
+func observeSummary(summary *telemetry.Summary, value float64) {
+	summary.Count += 1
+	summary.Sum += value
+	summary.Min = math.Min(summary.Min, value)
+	summary.Max = math.Max(summary.Max, value)
+}
+
+// recordSummaries records the summaries and resets them for the next interval
+func recordSummaries(summaries map[string]*telemetry.Summary) {
+	now := time.Now()
+
+	for _, summary := range summaries {
+		summary.Interval = now.Sub(summary.Timestamp)
+		harvester.RecordMetric(*summary)
+
+		summary.Timestamp = now
+		summary.Count = 0
+		summary.Sum = 0
+		summary.Min = 0
+		summary.Max = 0
 	}
 }
 
@@ -233,18 +273,14 @@ func runMetricsCollector() {
 				bufferSummariesMutex.RLock()
 				defer bufferSummariesMutex.RUnlock()
 
-				now := time.Now()
+				recordSummaries(bufferSummaries)
+			}()
 
-				for _, summary := range bufferSummaries {
-					summary.Interval = now.Sub(summary.Timestamp)
-					harvester.RecordMetric(*summary)
+			func() {
+				outputSizeSummariesMutex.RLock()
+				defer outputSizeSummariesMutex.RUnlock()
 
-					summary.Timestamp = now
-					summary.Count = 0
-					summary.Sum = 0
-					summary.Min = 0
-					summary.Max = 0
-				}
+				recordSummaries(outputSizeSummaries)
 			}()
 
 			harvester.RecordMetric(telemetry.Gauge{
@@ -259,6 +295,12 @@ func runMetricsCollector() {
 				Timestamp: time.Now(),
 			})
 
+			harvester.RecordMetric(telemetry.Gauge{
+				Name:      "imgproxy.source_connections",
+				Value:     stats.SourceConnections(),
+				Timestamp: time.Now(),
+			})
+
 			harvester.HarvestNow(harvesterCtx)
 		case <-harvesterCtx.Done():
 			return
@@ -0,0 +1,149 @@
+package otel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/metrics/errformat"
+	"github.com/imgproxy/imgproxy/v3/version"
+)
+
+var (
+	enabled bool
+
+	tracerProvider *sdktrace.TracerProvider
+	tracer         trace.Tracer
+
+	propagator = propagation.TraceContext{}
+)
+
+// Init sets up the OTLP/HTTP traces exporter. The exporter is configured with
+// the standard OTEL_EXPORTER_OTLP_* environment variables
+func Init() error {
+	if !config.OpenTelemetryEnable {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return fmt.Errorf("Can't initialize OpenTelemetry exporter: %s", err)
+	}
+
+	initTracer(sdktrace.WithBatcher(exporter))
+
+	return nil
+}
+
+func initTracer(opts ...sdktrace.TracerProviderOption) {
+	res, err := resource.Merge(
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("imgproxy"),
+			semconv.ServiceVersionKey.String(version.Version()),
+		),
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+		resource.Environment(),
+	)
+	if err != nil {
+		log.Warnf("Can't detect OpenTelemetry resource: %s", err)
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		append(opts, sdktrace.WithResource(res))...,
+	)
+	tracer = tracerProvider.Tracer("imgproxy")
+
+	enabled = true
+}
+
+func Stop() {
+	if !enabled {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Warnf("Can't stop OpenTelemetry tracer: %s", err)
+	}
+}
+
+func Enabled() bool {
+	return enabled
+}
+
+// StartRequest starts the root span of the request. If the request has
+// the traceparent header, the span continues the incoming trace
+func StartRequest(ctx context.Context, r *http.Request) (context.Context, context.CancelFunc) {
+	if !enabled {
+		return ctx, func() {}
+	}
+
+	ctx = propagator.Extract(ctx, propagation.HeaderCarrier(r.Header))
+
+	ctx, span := tracer.Start(
+		ctx, "request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(r.Method),
+			semconv.HTTPTargetKey.String(r.RequestURI),
+		),
+	)
+
+	return ctx, func() { span.End() }
+}
+
+// startSpan starts a child of the span stored in the context.
+// Spans are started only inside a request so they're never orphaned
+func startSpan(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	if !enabled || !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, func() {}
+	}
+
+	ctx, span := tracer.Start(ctx, name)
+
+	return ctx, func() { span.End() }
+}
+
+func StartQueueSegment(ctx context.Context) (context.Context, context.CancelFunc) {
+	return startSpan(ctx, "queue")
+}
+
+func StartWorkerSegment(ctx context.Context) (context.Context, context.CancelFunc) {
+	return startSpan(ctx, "waiting_for_worker")
+}
+
+func StartDownloadingSegment(ctx context.Context) (context.Context, context.CancelFunc) {
+	return startSpan(ctx, "downloading_image")
+}
+
+func StartProcessingSegment(ctx context.Context) (context.Context, context.CancelFunc) {
+	return startSpan(ctx, "processing_image")
+}
+
+func SendError(ctx context.Context, errType string, err error) {
+	if !enabled {
+		return
+	}
+
+	span := trace.SpanFromContext(ctx)
+	if !span.SpanContext().IsValid() {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, errformat.FormatErrType(errType, err))
+}
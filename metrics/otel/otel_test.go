@@ -0,0 +1,129 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+)
+
+type OtelTestSuite struct {
+	suite.Suite
+
+	exporter *tracetest.InMemoryExporter
+}
+
+func (s *OtelTestSuite) SetupTest() {
+	config.Reset()
+
+	s.exporter = tracetest.NewInMemoryExporter()
+	initTracer(sdktrace.WithSyncer(s.exporter))
+}
+
+func (s *OtelTestSuite) TearDownTest() {
+	enabled = false
+}
+
+func (s *OtelTestSuite) spanByName(name string) tracetest.SpanStub {
+	for _, span := range s.exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	require.Failf(s.T(), "Span not found", "span: %s", name)
+	return tracetest.SpanStub{}
+}
+
+func (s *OtelTestSuite) TestSegments() {
+	r := httptest.NewRequest(http.MethodGet, "/unsafe/plain/local:///test1.png", nil)
+
+	ctx, cancel := StartRequest(context.Background(), r)
+
+	_, queueCancel := StartQueueSegment(ctx)
+	_, downloadCancel := StartDownloadingSegment(ctx)
+	downloadCancel()
+	_, processingCancel := StartProcessingSegment(ctx)
+	processingCancel()
+	queueCancel()
+
+	cancel()
+
+	root := s.spanByName("request")
+	require.Equal(s.T(), trace.SpanKindServer, root.SpanKind)
+
+	for _, name := range []string{"queue", "downloading_image", "processing_image"} {
+		span := s.spanByName(name)
+		require.Equal(s.T(), root.SpanContext.TraceID(), span.SpanContext.TraceID(), name)
+		require.Equal(s.T(), root.SpanContext.SpanID(), span.Parent.SpanID(), name)
+	}
+}
+
+func (s *OtelTestSuite) TestTraceparent() {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	_, cancel := StartRequest(context.Background(), r)
+	cancel()
+
+	root := s.spanByName("request")
+	require.Equal(s.T(), "4bf92f3577b34da6a3ce929d0e0e4736", root.SpanContext.TraceID().String())
+	require.Equal(s.T(), "00f067aa0ba902b7", root.Parent.SpanID().String())
+}
+
+func (s *OtelTestSuite) TestSegmentWithoutRequest() {
+	_, cancel := StartProcessingSegment(context.Background())
+	cancel()
+
+	require.Empty(s.T(), s.exporter.GetSpans())
+}
+
+func (s *OtelTestSuite) TestSendError() {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	ctx, cancel := StartRequest(context.Background(), r)
+	SendError(ctx, "processing", errors.New("test error"))
+	cancel()
+
+	root := s.spanByName("request")
+	require.Equal(s.T(), codes.Error, root.Status.Code)
+	require.Len(s.T(), root.Events, 1)
+}
+
+func (s *OtelTestSuite) TestDisabled() {
+	enabled = false
+
+	require.Nil(s.T(), Init())
+	require.False(s.T(), Enabled())
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	ctx := context.Background()
+
+	reqCtx, cancel := StartRequest(ctx, r)
+	require.Equal(s.T(), ctx, reqCtx)
+
+	segmentCtx, segmentCancel := StartQueueSegment(reqCtx)
+	require.Equal(s.T(), ctx, segmentCtx)
+
+	SendError(reqCtx, "processing", errors.New("test error"))
+
+	segmentCancel()
+	cancel()
+
+	require.Empty(s.T(), s.exporter.GetSpans())
+}
+
+func TestOtel(t *testing.T) {
+	suite.Run(t, new(OtelTestSuite))
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -15,23 +17,43 @@ import (
 	"github.com/imgproxy/imgproxy/v3/reuseport"
 )
 
+type ctxKey string
+
+const (
+	nativeHistogramMaxBucketNumber  = 160
+	nativeHistogramMinResetDuration = time.Hour
+)
+
+const traceIDCtxKey = ctxKey("traceID")
+
 var (
 	enabled = false
 
+	traceparentRe = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+	// Guards the metrics that can be re-created by Reset
+	resettableMu sync.RWMutex
+
 	requestsTotal prometheus.Counter
 	errorsTotal   *prometheus.CounterVec
 
+	smartCropTotal         prometheus.Counter
+	smartCropFallbackTotal prometheus.Counter
+
 	requestDuration     prometheus.Histogram
 	requestSpanDuration *prometheus.HistogramVec
 	downloadDuration    prometheus.Histogram
 	processingDuration  prometheus.Histogram
 
 	bufferSize        *prometheus.HistogramVec
+	outputSize        *prometheus.HistogramVec
 	bufferDefaultSize *prometheus.GaugeVec
 	bufferMaxSize     *prometheus.GaugeVec
 
 	requestsInProgress prometheus.GaugeFunc
 	imagesInProgress   prometheus.GaugeFunc
+	sourceConnections  prometheus.GaugeFunc
+	requestsInQueue    prometheus.GaugeFunc
 )
 
 func Init() {
@@ -39,6 +61,60 @@ func Init() {
 		return
 	}
 
+	createResettableMetrics()
+
+	bufferDefaultSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: config.PrometheusNamespace,
+		Name:      "buffer_default_size_bytes",
+		Help:      "A gauge of the buffer default size in bytes.",
+	}, []string{"type"})
+
+	bufferMaxSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: config.PrometheusNamespace,
+		Name:      "buffer_max_size_bytes",
+		Help:      "A gauge of the buffer max size in bytes.",
+	}, []string{"type"})
+
+	requestsInProgress = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: config.PrometheusNamespace,
+		Name:      "requests_in_progress",
+		Help:      "A gauge of the number of requests currently being in progress.",
+	}, stats.RequestsInProgress)
+
+	imagesInProgress = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: config.PrometheusNamespace,
+		Name:      "images_in_progress",
+		Help:      "A gauge of the number of images currently being in progress.",
+	}, stats.ImagesInProgress)
+
+	sourceConnections = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: config.PrometheusNamespace,
+		Name:      "source_connections",
+		Help:      "A gauge of the number of currently open source image connections.",
+	}, stats.SourceConnections)
+
+	requestsInQueue = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: config.PrometheusNamespace,
+		Name:      "requests_in_queue",
+		Help:      "A gauge of the number of requests currently waiting for a free worker.",
+	}, stats.RequestsInQueue)
+
+	prometheus.MustRegister(resettableCollectors()...)
+	prometheus.MustRegister(
+		bufferDefaultSize,
+		bufferMaxSize,
+		requestsInProgress,
+		imagesInProgress,
+		sourceConnections,
+		requestsInQueue,
+	)
+
+	enabled = true
+}
+
+// createResettableMetrics creates counters and histograms.
+// Gauges reflect the current state, so they are not resettable
+func createResettableMetrics() {
 	requestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: config.PrometheusNamespace,
 		Name:      "requests_total",
@@ -51,29 +127,41 @@ func Init() {
 		Help:      "A counter of the occurred errors separated by type.",
 	}, []string{"type"})
 
-	requestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	smartCropTotal = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: config.PrometheusNamespace,
-		Name:      "request_duration_seconds",
-		Help:      "A histogram of the response latency.",
+		Name:      "smart_crop_total",
+		Help:      "A counter of the total number of smart crops.",
 	})
 
-	requestSpanDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: config.PrometheusNamespace,
-		Name:      "request_span_duration_seconds",
-		Help:      "A histogram of the queue latency.",
-	}, []string{"span"})
-
-	downloadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	smartCropFallbackTotal = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: config.PrometheusNamespace,
-		Name:      "download_duration_seconds",
-		Help:      "A histogram of the source image downloading latency.",
+		Name:      "smart_crop_fallback_total",
+		Help:      "A counter of the smart crops that fell back to the center gravity.",
 	})
 
-	processingDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Namespace: config.PrometheusNamespace,
-		Name:      "processing_duration_seconds",
-		Help:      "A histogram of the image processing latency.",
-	})
+	requestDuration = prometheus.NewHistogram(durationHistogramOpts(
+		"request_duration_seconds",
+		"A histogram of the response latency.",
+		config.PrometheusRequestDurationBuckets,
+	))
+
+	requestSpanDuration = prometheus.NewHistogramVec(durationHistogramOpts(
+		"request_span_duration_seconds",
+		"A histogram of the request latency separated by span.",
+		nil,
+	), []string{"span"})
+
+	downloadDuration = prometheus.NewHistogram(durationHistogramOpts(
+		"download_duration_seconds",
+		"A histogram of the source image downloading latency.",
+		config.PrometheusDownloadDurationBuckets,
+	))
+
+	processingDuration = prometheus.NewHistogram(durationHistogramOpts(
+		"processing_duration_seconds",
+		"A histogram of the image processing latency.",
+		config.PrometheusProcessingDurationBuckets,
+	))
 
 	bufferSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: config.PrometheusNamespace,
@@ -82,45 +170,74 @@ func Init() {
 		Buckets:   prometheus.ExponentialBuckets(1024, 2, 14),
 	}, []string{"type"})
 
-	bufferDefaultSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: config.PrometheusNamespace,
-		Name:      "buffer_default_size_bytes",
-		Help:      "A gauge of the buffer default size in bytes.",
-	}, []string{"type"})
-
-	bufferMaxSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: config.PrometheusNamespace,
-		Name:      "buffer_max_size_bytes",
-		Help:      "A gauge of the buffer max size in bytes.",
-	}, []string{"type"})
-
-	requestsInProgress = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	outputSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: config.PrometheusNamespace,
-		Name:      "requests_in_progress",
-		Help:      "A gauge of the number of requests currently being in progress.",
-	}, stats.RequestsInProgress)
-
-	imagesInProgress = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
-		Namespace: config.PrometheusNamespace,
-		Name:      "images_in_progress",
-		Help:      "A gauge of the number of images currently being in progress.",
-	}, stats.ImagesInProgress)
+		Name:      "output_size_bytes",
+		Help:      "A histogram of the output image size in bytes separated by format.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 2, 16),
+	}, []string{"format"})
+}
 
-	prometheus.MustRegister(
+func resettableCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
 		requestsTotal,
 		errorsTotal,
+		smartCropTotal,
+		smartCropFallbackTotal,
 		requestDuration,
 		requestSpanDuration,
 		downloadDuration,
 		processingDuration,
 		bufferSize,
-		bufferDefaultSize,
-		bufferMaxSize,
-		requestsInProgress,
-		imagesInProgress,
-	)
+		outputSize,
+	}
+}
 
-	enabled = true
+// Reset re-creates counters and histograms so they start from zero
+func Reset() {
+	if !enabled {
+		return
+	}
+
+	resettableMu.Lock()
+	defer resettableMu.Unlock()
+
+	for _, c := range resettableCollectors() {
+		prometheus.Unregister(c)
+	}
+
+	createResettableMetrics()
+
+	prometheus.MustRegister(resettableCollectors()...)
+}
+
+// durationHistogramOpts returns options of a duration histogram.
+// The histogram-specific buckets take precedence over the common ones.
+// If native histograms are enabled, classic buckets are kept only when
+// they are configured explicitly
+func durationHistogramOpts(name, help string, buckets []float64) prometheus.HistogramOpts {
+	opts := prometheus.HistogramOpts{
+		Namespace: config.PrometheusNamespace,
+		Name:      name,
+		Help:      help,
+	}
+
+	switch {
+	case len(buckets) > 0:
+		opts.Buckets = buckets
+	case len(config.PrometheusDurationBuckets) > 0:
+		opts.Buckets = config.PrometheusDurationBuckets
+	}
+
+	if config.PrometheusNativeHistogramBucketFactor > 1 {
+		opts.NativeHistogramBucketFactor = config.PrometheusNativeHistogramBucketFactor
+		opts.NativeHistogramMaxBucketNumber = nativeHistogramMaxBucketNumber
+		opts.NativeHistogramMinResetDuration = nativeHistogramMinResetDuration
+	} else if len(opts.Buckets) == 0 {
+		opts.Buckets = prometheus.DefBuckets
+	}
+
+	return opts
 }
 
 func Enabled() bool {
@@ -132,7 +249,7 @@ func StartServer(cancel context.CancelFunc) error {
 		return nil
 	}
 
-	s := http.Server{Handler: promhttp.Handler()}
+	s := http.Server{Handler: newHandler()}
 
 	l, err := reuseport.Listen("tcp", config.PrometheusBind)
 	if err != nil {
@@ -150,30 +267,84 @@ func StartServer(cancel context.CancelFunc) error {
 	return nil
 }
 
-func StartRequest() context.CancelFunc {
+func newHandler() http.Handler {
+	handler := promhttp.Handler()
+	if config.PrometheusExemplars {
+		// Exemplars are exposed only in the OpenMetrics format
+		handler = promhttp.InstrumentMetricHandler(
+			prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+				EnableOpenMetrics: true,
+			}),
+		)
+	}
+
+	if !config.PrometheusEnableReset {
+		return handler
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reset", handleReset)
+	mux.Handle("/", handler)
+
+	return mux
+}
+
+func handleReset(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	Reset()
+
+	log.Info("Prometheus metrics were reset")
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func StartRequest(ctx context.Context, r *http.Request) (context.Context, context.CancelFunc) {
 	if !enabled {
-		return func() {}
+		return ctx, func() {}
+	}
+
+	if config.PrometheusExemplars {
+		if m := traceparentRe.FindStringSubmatch(r.Header.Get("traceparent")); m != nil {
+			ctx = context.WithValue(ctx, traceIDCtxKey, m[1])
+		}
 	}
 
+	resettableMu.RLock()
 	requestsTotal.Inc()
-	return startDuration(requestDuration)
+	resettableMu.RUnlock()
+
+	return ctx, startDuration(ctx, func() prometheus.Observer { return requestDuration })
 }
 
-func StartQueueSegment() context.CancelFunc {
+func StartQueueSegment(ctx context.Context) context.CancelFunc {
 	if !enabled {
 		return func() {}
 	}
 
-	return startDuration(requestSpanDuration.With(prometheus.Labels{"span": "queue"}))
+	return startDuration(ctx, spanDuration("queue"))
 }
 
-func StartDownloadingSegment() context.CancelFunc {
+func StartWorkerSegment(ctx context.Context) context.CancelFunc {
 	if !enabled {
 		return func() {}
 	}
 
-	cancel := startDuration(requestSpanDuration.With(prometheus.Labels{"span": "downloading"}))
-	cancelLegacy := startDuration(downloadDuration)
+	return startDuration(ctx, spanDuration("worker"))
+}
+
+func StartDownloadingSegment(ctx context.Context) context.CancelFunc {
+	if !enabled {
+		return func() {}
+	}
+
+	cancel := startDuration(ctx, spanDuration("downloading"))
+	cancelLegacy := startDuration(ctx, func() prometheus.Observer { return downloadDuration })
 
 	return func() {
 		cancel()
@@ -181,13 +352,13 @@ func StartDownloadingSegment() context.CancelFunc {
 	}
 }
 
-func StartProcessingSegment() context.CancelFunc {
+func StartProcessingSegment(ctx context.Context) context.CancelFunc {
 	if !enabled {
 		return func() {}
 	}
 
-	cancel := startDuration(requestSpanDuration.With(prometheus.Labels{"span": "processing"}))
-	cancelLegacy := startDuration(processingDuration)
+	cancel := startDuration(ctx, spanDuration("processing"))
+	cancelLegacy := startDuration(ctx, func() prometheus.Observer { return processingDuration })
 
 	return func() {
 		cancel()
@@ -195,25 +366,83 @@ func StartProcessingSegment() context.CancelFunc {
 	}
 }
 
-func startDuration(m prometheus.Observer) context.CancelFunc {
+// spanDuration returns a getter of the request span duration observer
+func spanDuration(span string) func() prometheus.Observer {
+	return func() prometheus.Observer {
+		return requestSpanDuration.With(prometheus.Labels{"span": span})
+	}
+}
+
+// startDuration starts measuring a duration. If exemplars are enabled and
+// the request has a trace ID, the trace ID is attached to the observation as an exemplar.
+// The observer is got when the measurement is finished, so the metrics reset
+// while the request is in flight doesn't make it observe into the old histogram
+func startDuration(ctx context.Context, getObserver func() prometheus.Observer) context.CancelFunc {
 	t := time.Now()
 	return func() {
-		m.Observe(time.Since(t).Seconds())
+		d := time.Since(t).Seconds()
+
+		resettableMu.RLock()
+		defer resettableMu.RUnlock()
+
+		m := getObserver()
+
+		if traceID, ok := ctx.Value(traceIDCtxKey).(string); ok {
+			if em, ok := m.(prometheus.ExemplarObserver); ok {
+				em.ObserveWithExemplar(d, prometheus.Labels{"trace_id": traceID})
+				return
+			}
+		}
+
+		m.Observe(d)
 	}
 }
 
 func IncrementErrorsTotal(t string) {
 	if enabled {
+		resettableMu.RLock()
+		defer resettableMu.RUnlock()
+
 		errorsTotal.With(prometheus.Labels{"type": t}).Inc()
 	}
 }
 
+func IncrementSmartCropTotal() {
+	if enabled {
+		resettableMu.RLock()
+		defer resettableMu.RUnlock()
+
+		smartCropTotal.Inc()
+	}
+}
+
+func IncrementSmartCropFallbackTotal() {
+	if enabled {
+		resettableMu.RLock()
+		defer resettableMu.RUnlock()
+
+		smartCropFallbackTotal.Inc()
+	}
+}
+
 func ObserveBufferSize(t string, size int) {
 	if enabled {
+		resettableMu.RLock()
+		defer resettableMu.RUnlock()
+
 		bufferSize.With(prometheus.Labels{"type": t}).Observe(float64(size))
 	}
 }
 
+func ObserveOutputSize(format string, size int) {
+	if enabled {
+		resettableMu.RLock()
+		defer resettableMu.RUnlock()
+
+		outputSize.With(prometheus.Labels{"format": format}).Observe(float64(size))
+	}
+}
+
 func SetBufferDefaultSize(t string, size int) {
 	if enabled {
 		bufferDefaultSize.With(prometheus.Labels{"type": t}).Set(float64(size))
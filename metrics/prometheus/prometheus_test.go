@@ -0,0 +1,388 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/metrics/stats"
+)
+
+type PrometheusTestSuite struct {
+	suite.Suite
+}
+
+func (s *PrometheusTestSuite) SetupSuite() {
+	config.Reset()
+	config.PrometheusBind = "127.0.0.1:0"
+	config.PrometheusDurationBuckets = []float64{0.1, 0.5, 2}
+
+	Init()
+
+	require.True(s.T(), Enabled())
+}
+
+func (s *PrometheusTestSuite) SetupTest() {
+	config.PrometheusExemplars = false
+	config.PrometheusEnableReset = false
+
+	Reset()
+}
+
+func (s *PrometheusTestSuite) findMetrics(name string) []*dto.Metric {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.Nil(s.T(), err)
+
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()
+		}
+	}
+
+	return nil
+}
+
+func (s *PrometheusTestSuite) spanSamplesCount(span string) uint64 {
+	for _, m := range s.findMetrics("request_span_duration_seconds") {
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "span" && l.GetValue() == span {
+				return m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+
+	return 0
+}
+
+func (s *PrometheusTestSuite) requestExemplars() []*dto.Exemplar {
+	exemplars := make([]*dto.Exemplar, 0)
+
+	for _, m := range s.findMetrics("request_duration_seconds") {
+		for _, b := range m.GetHistogram().GetBucket() {
+			if e := b.GetExemplar(); e != nil {
+				exemplars = append(exemplars, e)
+			}
+		}
+	}
+
+	return exemplars
+}
+
+func (s *PrometheusTestSuite) TestQueueAndWorkerSegments() {
+	queueCancel := StartQueueSegment(context.Background())
+	workerCancel := StartWorkerSegment(context.Background())
+
+	workerCancel()
+	queueCancel()
+
+	require.Equal(s.T(), uint64(1), s.spanSamplesCount("queue"))
+	require.Equal(s.T(), uint64(1), s.spanSamplesCount("worker"))
+}
+
+func (s *PrometheusTestSuite) TestExemplars() {
+	config.PrometheusExemplars = true
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	_, cancel := StartRequest(context.Background(), r)
+	cancel()
+
+	exemplars := s.requestExemplars()
+	require.Len(s.T(), exemplars, 1)
+
+	labels := exemplars[0].GetLabel()
+	require.Len(s.T(), labels, 1)
+	require.Equal(s.T(), "trace_id", labels[0].GetName())
+	require.Equal(s.T(), "4bf92f3577b34da6a3ce929d0e0e4736", labels[0].GetValue())
+}
+
+func (s *PrometheusTestSuite) TestDurationBuckets() {
+	StartDownloadingSegment(context.Background())()
+	StartProcessingSegment(context.Background())()
+
+	for _, name := range []string{
+		"request_duration_seconds",
+		"request_span_duration_seconds",
+		"download_duration_seconds",
+		"processing_duration_seconds",
+	} {
+		metrics := s.findMetrics(name)
+		require.NotEmpty(s.T(), metrics, name)
+
+		bounds := make([]float64, 0)
+		for _, b := range metrics[0].GetHistogram().GetBucket() {
+			bounds = append(bounds, b.GetUpperBound())
+		}
+
+		require.Equal(s.T(), []float64{0.1, 0.5, 2}, bounds, name)
+	}
+}
+
+func (s *PrometheusTestSuite) TestHistogramDurationBuckets() {
+	config.PrometheusRequestDurationBuckets = []float64{0.01, 0.05}
+	config.PrometheusProcessingDurationBuckets = []float64{0.02}
+	defer func() {
+		config.PrometheusRequestDurationBuckets = nil
+		config.PrometheusProcessingDurationBuckets = nil
+		Reset()
+	}()
+
+	Reset()
+
+	_, cancel := StartRequest(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil))
+	cancel()
+	StartDownloadingSegment(context.Background())()
+	StartProcessingSegment(context.Background())()
+
+	for name, expected := range map[string][]float64{
+		"request_duration_seconds":    {0.01, 0.05},
+		"download_duration_seconds":   {0.1, 0.5, 2},
+		"processing_duration_seconds": {0.02},
+	} {
+		metrics := s.findMetrics(name)
+		require.NotEmpty(s.T(), metrics, name)
+
+		bounds := make([]float64, 0)
+		for _, b := range metrics[0].GetHistogram().GetBucket() {
+			bounds = append(bounds, b.GetUpperBound())
+		}
+
+		require.Equal(s.T(), expected, bounds, name)
+	}
+}
+
+func (s *PrometheusTestSuite) outputSizeHistogram(format string) *dto.Histogram {
+	for _, m := range s.findMetrics("output_size_bytes") {
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "format" && l.GetValue() == format {
+				return m.GetHistogram()
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *PrometheusTestSuite) TestOutputSize() {
+	ObserveOutputSize("png", 2000)
+	ObserveOutputSize("png", 500000)
+	ObserveOutputSize("jpeg", 3000)
+
+	png := s.outputSizeHistogram("png")
+	require.NotNil(s.T(), png)
+	require.Equal(s.T(), uint64(2), png.GetSampleCount())
+	require.Equal(s.T(), float64(502000), png.GetSampleSum())
+
+	jpeg := s.outputSizeHistogram("jpeg")
+	require.NotNil(s.T(), jpeg)
+	require.Equal(s.T(), uint64(1), jpeg.GetSampleCount())
+	require.Equal(s.T(), float64(3000), jpeg.GetSampleSum())
+
+	// Exponential buckets
+	buckets := jpeg.GetBucket()
+	require.Equal(s.T(), float64(1024), buckets[0].GetUpperBound())
+	require.Equal(s.T(), float64(2048), buckets[1].GetUpperBound())
+	require.Equal(s.T(), float64(4096), buckets[2].GetUpperBound())
+	require.Equal(s.T(), uint64(0), buckets[1].GetCumulativeCount())
+	require.Equal(s.T(), uint64(1), buckets[2].GetCumulativeCount())
+}
+
+func (s *PrometheusTestSuite) counterValue(name string) float64 {
+	metrics := s.findMetrics(name)
+	require.Len(s.T(), metrics, 1)
+
+	return metrics[0].GetCounter().GetValue()
+}
+
+func (s *PrometheusTestSuite) TestSmartCrop() {
+	IncrementSmartCropTotal()
+	IncrementSmartCropTotal()
+	IncrementSmartCropFallbackTotal()
+
+	require.Equal(s.T(), float64(2), s.counterValue("smart_crop_total"))
+	require.Equal(s.T(), float64(1), s.counterValue("smart_crop_fallback_total"))
+
+	Reset()
+
+	require.Equal(s.T(), float64(0), s.counterValue("smart_crop_total"))
+	require.Equal(s.T(), float64(0), s.counterValue("smart_crop_fallback_total"))
+}
+
+func (s *PrometheusTestSuite) TestSourceConnections() {
+	gauge := func() float64 {
+		metrics := s.findMetrics("source_connections")
+		require.Len(s.T(), metrics, 1)
+		return metrics[0].GetGauge().GetValue()
+	}
+
+	require.Equal(s.T(), float64(0), gauge())
+
+	stats.IncSourceConnections()
+	stats.IncSourceConnections()
+	require.Equal(s.T(), float64(2), gauge())
+
+	stats.DecSourceConnections()
+	stats.DecSourceConnections()
+	require.Equal(s.T(), float64(0), gauge())
+}
+
+func (s *PrometheusTestSuite) TestRequestsInQueue() {
+	gauge := func() float64 {
+		metrics := s.findMetrics("requests_in_queue")
+		require.Len(s.T(), metrics, 1)
+		return metrics[0].GetGauge().GetValue()
+	}
+
+	require.Equal(s.T(), float64(0), gauge())
+
+	stats.IncRequestsInQueue()
+	require.Equal(s.T(), float64(1), gauge())
+
+	stats.DecRequestsInQueue()
+	require.Equal(s.T(), float64(0), gauge())
+}
+
+func (s *PrometheusTestSuite) TestNativeHistograms() {
+	buckets := config.PrometheusDurationBuckets
+	defer func() {
+		config.PrometheusDurationBuckets = buckets
+		config.PrometheusNativeHistogramBucketFactor = 0
+	}()
+
+	opts := durationHistogramOpts("test_duration_seconds", "Test", nil)
+	require.Zero(s.T(), opts.NativeHistogramBucketFactor)
+	require.Equal(s.T(), buckets, opts.Buckets)
+
+	config.PrometheusNativeHistogramBucketFactor = 1.1
+	config.PrometheusDurationBuckets = nil
+
+	opts = durationHistogramOpts("test_duration_seconds", "Test", nil)
+	require.Equal(s.T(), 1.1, opts.NativeHistogramBucketFactor)
+	require.Empty(s.T(), opts.Buckets)
+
+	h := prometheus.NewHistogram(opts)
+	h.Observe(0.3)
+
+	var m dto.Metric
+	require.Nil(s.T(), h.Write(&m))
+
+	require.NotNil(s.T(), m.GetHistogram().Schema)
+	require.Empty(s.T(), m.GetHistogram().GetBucket())
+	require.NotEmpty(s.T(), m.GetHistogram().GetPositiveSpan())
+}
+
+func (s *PrometheusTestSuite) requestsTotal() float64 {
+	metrics := s.findMetrics("requests_total")
+	require.Len(s.T(), metrics, 1)
+
+	return metrics[0].GetCounter().GetValue()
+}
+
+func (s *PrometheusTestSuite) TestResetEndpoint() {
+	config.PrometheusEnableReset = true
+
+	handler := newHandler()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	for i := 0; i < 3; i++ {
+		_, cancel := StartRequest(context.Background(), r)
+		cancel()
+	}
+	StartQueueSegment(context.Background())()
+
+	require.Equal(s.T(), float64(3), s.requestsTotal())
+	require.Equal(s.T(), uint64(1), s.spanSamplesCount("queue"))
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/reset", nil))
+	require.Equal(s.T(), http.StatusMethodNotAllowed, rw.Code)
+	require.Equal(s.T(), float64(3), s.requestsTotal())
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/reset", nil))
+	require.Equal(s.T(), http.StatusOK, rw.Code)
+
+	require.Equal(s.T(), float64(0), s.requestsTotal())
+	require.Equal(s.T(), uint64(0), s.spanSamplesCount("queue"))
+
+	_, cancel := StartRequest(context.Background(), r)
+	cancel()
+
+	require.Equal(s.T(), float64(1), s.requestsTotal())
+}
+
+func (s *PrometheusTestSuite) TestResetDisabled() {
+	handler := newHandler()
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/reset", nil))
+
+	// Without reset enabled, any path serves metrics
+	require.Equal(s.T(), http.StatusOK, rw.Code)
+	require.Contains(s.T(), rw.Body.String(), "requests_total")
+}
+
+func (s *PrometheusTestSuite) TestResetConcurrent() {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				ctx, cancel := StartRequest(context.Background(), r)
+				StartWorkerSegment(ctx)()
+				IncrementErrorsTotal("test")
+				ObserveBufferSize("test", 1024)
+				cancel()
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		Reset()
+	}
+
+	wg.Wait()
+
+	require.LessOrEqual(s.T(), s.requestsTotal(), float64(400))
+}
+
+func (s *PrometheusTestSuite) TestResetInFlight() {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	_, cancel := StartRequest(context.Background(), r)
+	cancelProcessing := StartProcessingSegment(context.Background())
+
+	Reset()
+
+	cancelProcessing()
+	cancel()
+
+	// The in-flight request is observed into the new histograms
+	metrics := s.findMetrics("request_duration_seconds")
+	require.Len(s.T(), metrics, 1)
+	require.Equal(s.T(), uint64(1), metrics[0].GetHistogram().GetSampleCount())
+
+	metrics = s.findMetrics("processing_duration_seconds")
+	require.Len(s.T(), metrics, 1)
+	require.Equal(s.T(), uint64(1), metrics[0].GetHistogram().GetSampleCount())
+
+	require.Equal(s.T(), uint64(1), s.spanSamplesCount("processing"))
+}
+
+func TestPrometheus(t *testing.T) {
+	suite.Run(t, new(PrometheusTestSuite))
+}
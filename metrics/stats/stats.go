@@ -5,6 +5,8 @@ import "sync/atomic"
 var (
 	requestsInProgress int64
 	imagesInProgress   int64
+	sourceConnections  int64
+	requestsInQueue    int64
 )
 
 func RequestsInProgress() float64 {
@@ -30,3 +32,28 @@ func IncImagesInProgress() {
 func DecImagesInProgress() {
 	atomic.AddInt64(&imagesInProgress, -1)
 }
+
+func SourceConnections() float64 {
+	return float64(atomic.LoadInt64(&sourceConnections))
+}
+
+func IncSourceConnections() {
+	atomic.AddInt64(&sourceConnections, 1)
+}
+
+func DecSourceConnections() {
+	atomic.AddInt64(&sourceConnections, -1)
+}
+
+// RequestsInQueue returns the number of requests waiting for a free worker
+func RequestsInQueue() float64 {
+	return float64(atomic.LoadInt64(&requestsInQueue))
+}
+
+func IncRequestsInQueue() {
+	atomic.AddInt64(&requestsInQueue, 1)
+}
+
+func DecRequestsInQueue() {
+	atomic.AddInt64(&requestsInQueue, -1)
+}
@@ -0,0 +1,200 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/metrics/stats"
+)
+
+const metricsPrefix = "imgproxy."
+
+type GaugeFunc func() float64
+
+var (
+	enabled = false
+
+	conn     net.Conn
+	stopChan chan struct{}
+
+	flushInterval = 10 * time.Second
+
+	gaugeFuncs      = make(map[string]GaugeFunc)
+	gaugeFuncsMutex sync.RWMutex
+)
+
+func Init() error {
+	if len(config.StatsdAddr) == 0 {
+		return nil
+	}
+
+	var err error
+
+	// UDP is connectionless, so dialing just resolves the address
+	conn, err = net.Dial("udp", config.StatsdAddr)
+	if err != nil {
+		return fmt.Errorf("Can't initialize StatsD client: %s", err)
+	}
+
+	stopChan = make(chan struct{})
+	enabled = true
+
+	go runMetricsCollector(conn, stopChan, flushInterval)
+
+	return nil
+}
+
+func Stop() {
+	if enabled {
+		close(stopChan)
+		conn.Close()
+		enabled = false
+	}
+}
+
+func Enabled() bool {
+	return enabled
+}
+
+func StartRequest(ctx context.Context) (context.Context, context.CancelFunc) {
+	if !enabled {
+		return ctx, func() {}
+	}
+
+	send("requests_total", "1", "c")
+
+	return ctx, startTiming("request_duration")
+}
+
+func StartQueueSegment(ctx context.Context) context.CancelFunc {
+	if !enabled {
+		return func() {}
+	}
+
+	return startTiming("queue_duration")
+}
+
+func StartWorkerSegment(ctx context.Context) context.CancelFunc {
+	if !enabled {
+		return func() {}
+	}
+
+	return startTiming("worker_duration")
+}
+
+func StartDownloadingSegment(ctx context.Context) context.CancelFunc {
+	if !enabled {
+		return func() {}
+	}
+
+	return startTiming("download_duration")
+}
+
+func StartProcessingSegment(ctx context.Context) context.CancelFunc {
+	if !enabled {
+		return func() {}
+	}
+
+	return startTiming("processing_duration")
+}
+
+func IncrementErrorsTotal(t string) {
+	if enabled {
+		send("errors_total."+t, "1", "c")
+	}
+}
+
+func IncrementSmartCropTotal() {
+	if enabled {
+		send("smart_crop_total", "1", "c")
+	}
+}
+
+func IncrementSmartCropFallbackTotal() {
+	if enabled {
+		send("smart_crop_fallback_total", "1", "c")
+	}
+}
+
+func ObserveBufferSize(t string, size int) {
+	if enabled {
+		send("buffer.size."+t, strconv.Itoa(size), "h")
+	}
+}
+
+func ObserveOutputSize(format string, size int) {
+	if enabled {
+		send("output.size."+format, strconv.Itoa(size), "h")
+	}
+}
+
+func SetBufferDefaultSize(t string, size int) {
+	if enabled {
+		send("buffer.default_size."+t, strconv.Itoa(size), "g")
+	}
+}
+
+func SetBufferMaxSize(t string, size int) {
+	if enabled {
+		send("buffer.max_size."+t, strconv.Itoa(size), "g")
+	}
+}
+
+func AddGaugeFunc(name string, f GaugeFunc) {
+	gaugeFuncsMutex.Lock()
+	defer gaugeFuncsMutex.Unlock()
+
+	gaugeFuncs[name] = f
+}
+
+func startTiming(name string) context.CancelFunc {
+	t := time.Now()
+	return func() {
+		ms := float64(time.Since(t)) / float64(time.Millisecond)
+		send(name, strconv.FormatFloat(ms, 'f', 3, 64), "ms")
+	}
+}
+
+func send(name, value, metricType string) {
+	write(conn, name, value, metricType)
+}
+
+// write writes a single metric packet. StatsD is a fire-and-forget protocol,
+// so write errors are ignored
+func write(c net.Conn, name, value, metricType string) {
+	c.Write([]byte(metricsPrefix + name + ":" + value + "|" + metricType))
+}
+
+func runMetricsCollector(c net.Conn, stop chan struct{}, interval time.Duration) {
+	sendGauge := func(name string, value float64) {
+		write(c, name, strconv.FormatFloat(value, 'f', -1, 64), "g")
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			func() {
+				gaugeFuncsMutex.RLock()
+				defer gaugeFuncsMutex.RUnlock()
+
+				for name, f := range gaugeFuncs {
+					sendGauge(name, f())
+				}
+			}()
+
+			sendGauge("requests_in_progress", stats.RequestsInProgress())
+			sendGauge("images_in_progress", stats.ImagesInProgress())
+			sendGauge("source_connections", stats.SourceConnections())
+			sendGauge("requests_in_queue", stats.RequestsInQueue())
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+)
+
+type StatsdTestSuite struct {
+	suite.Suite
+
+	listener net.PacketConn
+}
+
+func (s *StatsdTestSuite) SetupTest() {
+	config.Reset()
+
+	var err error
+	s.listener, err = net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(s.T(), err)
+
+	config.StatsdAddr = s.listener.LocalAddr().String()
+}
+
+func (s *StatsdTestSuite) TearDownTest() {
+	Stop()
+	s.listener.Close()
+}
+
+func (s *StatsdTestSuite) readPacket() string {
+	buf := make([]byte, 1024)
+
+	s.listener.SetReadDeadline(time.Now().Add(time.Second))
+
+	n, _, err := s.listener.ReadFrom(buf)
+	require.Nil(s.T(), err)
+
+	return string(buf[:n])
+}
+
+func (s *StatsdTestSuite) TestDisabled() {
+	config.StatsdAddr = ""
+
+	require.Nil(s.T(), Init())
+	require.False(s.T(), Enabled())
+
+	// Shouldn't panic when disabled
+	_, cancel := StartRequest(context.Background())
+	cancel()
+	IncrementErrorsTotal("processing")
+	ObserveOutputSize("png", 100)
+}
+
+func (s *StatsdTestSuite) TestRequest() {
+	require.Nil(s.T(), Init())
+	require.True(s.T(), Enabled())
+
+	ctx, cancel := StartRequest(context.Background())
+	require.Equal(s.T(), "imgproxy.requests_total:1|c", s.readPacket())
+
+	StartDownloadingSegment(ctx)()
+	require.Regexp(s.T(), regexp.MustCompile(`^imgproxy\.download_duration:\d+\.\d{3}\|ms$`), s.readPacket())
+
+	StartProcessingSegment(ctx)()
+	require.Regexp(s.T(), regexp.MustCompile(`^imgproxy\.processing_duration:\d+\.\d{3}\|ms$`), s.readPacket())
+
+	cancel()
+	require.Regexp(s.T(), regexp.MustCompile(`^imgproxy\.request_duration:\d+\.\d{3}\|ms$`), s.readPacket())
+}
+
+func (s *StatsdTestSuite) TestCounters() {
+	require.Nil(s.T(), Init())
+
+	IncrementErrorsTotal("downloading")
+	require.Equal(s.T(), "imgproxy.errors_total.downloading:1|c", s.readPacket())
+
+	ObserveOutputSize("png", 1234)
+	require.Equal(s.T(), "imgproxy.output.size.png:1234|h", s.readPacket())
+
+	SetBufferMaxSize("download", 4096)
+	require.Equal(s.T(), "imgproxy.buffer.max_size.download:4096|g", s.readPacket())
+
+	IncrementSmartCropTotal()
+	require.Equal(s.T(), "imgproxy.smart_crop_total:1|c", s.readPacket())
+
+	IncrementSmartCropFallbackTotal()
+	require.Equal(s.T(), "imgproxy.smart_crop_fallback_total:1|c", s.readPacket())
+}
+
+func (s *StatsdTestSuite) TestGauges() {
+	defer func(d time.Duration) { flushInterval = d }(flushInterval)
+	flushInterval = 10 * time.Millisecond
+
+	require.Nil(s.T(), Init())
+
+	packets := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		packets[s.readPacket()] = true
+	}
+
+	require.True(s.T(), packets["imgproxy.requests_in_progress:0|g"])
+	require.True(s.T(), packets["imgproxy.images_in_progress:0|g"])
+	require.True(s.T(), packets["imgproxy.source_connections:0|g"])
+	require.True(s.T(), packets["imgproxy.requests_in_queue:0|g"])
+}
+
+func TestStatsd(t *testing.T) {
+	suite.Run(t, new(StatsdTestSuite))
+}
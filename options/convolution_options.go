@@ -0,0 +1,40 @@
+package options
+
+type ConvolutionOptions struct {
+	Matrix []float64
+	Scale  float64
+	Offset float64
+}
+
+func (co ConvolutionOptions) Enabled() bool {
+	return len(co.Matrix) > 0
+}
+
+var allowedConvolutionSizes = []int{3, 5}
+
+var convolutionKernels = map[string]ConvolutionOptions{
+	"emboss": {
+		Matrix: []float64{
+			-2, -1, 0,
+			-1, 1, 1,
+			0, 1, 2,
+		},
+		Scale: 1,
+	},
+	"sharpen-strong": {
+		Matrix: []float64{
+			-1, -1, -1,
+			-1, 9, -1,
+			-1, -1, -1,
+		},
+		Scale: 1,
+	},
+	"blur-soft": {
+		Matrix: []float64{
+			1, 2, 1,
+			2, 4, 2,
+			1, 2, 1,
+		},
+		Scale: 16,
+	},
+}
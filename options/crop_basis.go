@@ -0,0 +1,34 @@
+package options
+
+import "fmt"
+
+type CropBasis int
+
+const (
+	CropBasisUnknown CropBasis = iota
+	CropBasisSource
+	CropBasisOutput
+)
+
+var cropBases = map[string]CropBasis{
+	"source": CropBasisSource,
+	"output": CropBasisOutput,
+}
+
+func (cb CropBasis) String() string {
+	for k, v := range cropBases {
+		if v == cb {
+			return k
+		}
+	}
+	return ""
+}
+
+func (cb CropBasis) MarshalJSON() ([]byte, error) {
+	for k, v := range cropBases {
+		if v == cb {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
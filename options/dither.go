@@ -0,0 +1,47 @@
+package options
+
+import (
+	"fmt"
+
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+type Dither int
+
+const (
+	DitherDefault Dither = iota
+	DitherNone
+	DitherFloydSteinberg
+	DitherOrdered
+)
+
+var dithers = map[string]Dither{
+	"none":            DitherNone,
+	"floyd_steinberg": DitherFloydSteinberg,
+	"ordered":         DitherOrdered,
+}
+
+var vipsDithers = map[Dither]vips.Dither{
+	DitherDefault:        vips.DitherDefault,
+	DitherNone:           vips.DitherNone,
+	DitherFloydSteinberg: vips.DitherFloydSteinberg,
+	DitherOrdered:        vips.DitherOrdered,
+}
+
+func (d Dither) String() string {
+	for k, v := range dithers {
+		if v == d {
+			return k
+		}
+	}
+	return ""
+}
+
+func (d Dither) MarshalJSON() ([]byte, error) {
+	for k, v := range dithers {
+		if v == d {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
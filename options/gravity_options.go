@@ -19,20 +19,37 @@ const (
 	GravitySouthEast
 	GravitySmart
 	GravityFocusPoint
+	GravityAlpha
+	GravityObject
 )
 
 var gravityTypes = map[string]GravityType{
-	"ce":   GravityCenter,
-	"no":   GravityNorth,
-	"ea":   GravityEast,
-	"so":   GravitySouth,
-	"we":   GravityWest,
-	"nowe": GravityNorthWest,
-	"noea": GravityNorthEast,
-	"sowe": GravitySouthWest,
-	"soea": GravitySouthEast,
-	"sm":   GravitySmart,
-	"fp":   GravityFocusPoint,
+	"ce":    GravityCenter,
+	"no":    GravityNorth,
+	"ea":    GravityEast,
+	"so":    GravitySouth,
+	"we":    GravityWest,
+	"nowe":  GravityNorthWest,
+	"noea":  GravityNorthEast,
+	"sowe":  GravitySouthWest,
+	"soea":  GravitySouthEast,
+	"sm":    GravitySmart,
+	"fp":    GravityFocusPoint,
+	"alpha": GravityAlpha,
+	"obj":   GravityObject,
+}
+
+type SmartCropStrategy int
+
+const (
+	SmartCropStrategyUnknown SmartCropStrategy = iota
+	SmartCropStrategyAttention
+	SmartCropStrategyEntropy
+)
+
+var smartCropStrategies = map[string]SmartCropStrategy{
+	"attention": SmartCropStrategyAttention,
+	"entropy":   SmartCropStrategyEntropy,
 }
 
 var gravityTypesRotationMap = map[int]map[GravityType]GravityType{
@@ -95,9 +112,39 @@ func (gt GravityType) MarshalJSON() ([]byte, error) {
 	return []byte("null"), nil
 }
 
+func (s SmartCropStrategy) String() string {
+	for k, v := range smartCropStrategies {
+		if v == s {
+			return k
+		}
+	}
+	return ""
+}
+
+func (s SmartCropStrategy) MarshalJSON() ([]byte, error) {
+	for k, v := range smartCropStrategies {
+		if v == s {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
 type GravityOptions struct {
 	Type GravityType
 	X, Y float64
+
+	// Strategy is used by the smart gravity only. When it's not set,
+	// the smart crop area is detected before processing
+	Strategy SmartCropStrategy
+
+	// Fallback is used by the smart gravity only. It's the gravity used
+	// when the smart crop area can't be detected. Unknown means center
+	Fallback GravityType
+
+	// Classes are the classes of the objects (like "face" or "text")
+	// the object gravity is guided by. Empty means all the detected objects
+	Classes []string
 }
 
 func (g *GravityOptions) RotateAndFlip(angle int, flip bool) {
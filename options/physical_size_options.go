@@ -0,0 +1,71 @@
+package options
+
+import (
+	"fmt"
+	"math"
+)
+
+type PhysicalUnit int
+
+const (
+	PhysicalUnitCm PhysicalUnit = iota
+	PhysicalUnitMm
+	PhysicalUnitIn
+)
+
+var physicalUnits = map[string]PhysicalUnit{
+	"cm": PhysicalUnitCm,
+	"mm": PhysicalUnitMm,
+	"in": PhysicalUnitIn,
+}
+
+// unitsPerInch is the number of units in an inch
+var unitsPerInch = map[PhysicalUnit]float64{
+	PhysicalUnitCm: 2.54,
+	PhysicalUnitMm: 25.4,
+	PhysicalUnitIn: 1,
+}
+
+func (u PhysicalUnit) String() string {
+	for k, v := range physicalUnits {
+		if v == u {
+			return k
+		}
+	}
+	return ""
+}
+
+func (u PhysicalUnit) MarshalJSON() ([]byte, error) {
+	for k, v := range physicalUnits {
+		if v == u {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
+type PhysicalSizeOptions struct {
+	Width  float64
+	Height float64
+	Unit   PhysicalUnit
+	Dpi    float64
+}
+
+func (ps PhysicalSizeOptions) Enabled() bool {
+	return ps.Dpi > 0
+}
+
+// maxPhysicalSizePixels is the maximum image dimension libvips can handle.
+// Large physical sizes and DPIs may overflow int, so we limit the pixel sizes with it
+const maxPhysicalSizePixels = 10000000
+
+// Pixels converts the physical size to pixels at the set DPI
+func (ps PhysicalSizeOptions) Pixels() (int, int) {
+	scale := ps.Dpi / unitsPerInch[ps.Unit]
+
+	toPixels := func(size float64) int {
+		return int(math.Min(math.Round(size*scale), maxPhysicalSizePixels))
+	}
+
+	return toPixels(ps.Width), toPixels(ps.Height)
+}
@@ -3,9 +3,17 @@ package options
 import (
 	"fmt"
 	"strings"
+	"sync"
 )
 
-var presets map[string]urlOptions
+var (
+	presets       map[string]urlOptions
+	tenantPresets map[string]map[string]urlOptions
+
+	// presetsMutex protects the global presets from being replaced on reload
+	// while they're read
+	presetsMutex sync.RWMutex
+)
 
 func ParsePresets(presetStrs []string) error {
 	for _, presetStr := range presetStrs {
@@ -17,7 +25,35 @@ func ParsePresets(presetStrs []string) error {
 	return nil
 }
 
+// ParseTenantPresets parses presets that are available only to the tenant's requests.
+// Tenant presets override global presets with the same names
+func ParseTenantPresets(tenant string, presetStrs []string) error {
+	if tenantPresets == nil {
+		tenantPresets = make(map[string]map[string]urlOptions)
+	}
+
+	if tenantPresets[tenant] == nil {
+		tenantPresets[tenant] = make(map[string]urlOptions)
+	}
+
+	for _, presetStr := range presetStrs {
+		if err := parsePresetTo(tenantPresets[tenant], presetStr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func parsePreset(presetStr string) error {
+	if presets == nil {
+		presets = make(map[string]urlOptions)
+	}
+
+	return parsePresetTo(presets, presetStr)
+}
+
+func parsePresetTo(m map[string]urlOptions, presetStr string) error {
 	presetStr = strings.Trim(presetStr, " ")
 
 	if len(presetStr) == 0 || strings.HasPrefix(presetStr, "#") {
@@ -48,14 +84,38 @@ func parsePreset(presetStr string) error {
 		return fmt.Errorf("Invalid preset value: %s", presetStr)
 	}
 
-	if presets == nil {
-		presets = make(map[string]urlOptions)
-	}
-	presets[name] = opts
+	m[name] = opts
 
 	return nil
 }
 
+// PreparePresets parses and validates the presets without replacing the global ones.
+// The returned function replaces the global presets with the parsed ones
+func PreparePresets(presetStrs []string) (func(), error) {
+	m := make(map[string]urlOptions)
+
+	for _, presetStr := range presetStrs {
+		if err := parsePresetTo(m, presetStr); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, opts := range m {
+		po := NewProcessingOptions()
+		po.presets = m
+		if err := applyURLOptions(po, opts); err != nil {
+			return nil, fmt.Errorf("Error in preset `%s`: %s", name, err)
+		}
+	}
+
+	return func() {
+		presetsMutex.Lock()
+		defer presetsMutex.Unlock()
+
+		presets = m
+	}, nil
+}
+
 func ValidatePresets() error {
 	for name, opts := range presets {
 		po := NewProcessingOptions()
@@ -64,5 +124,15 @@ func ValidatePresets() error {
 		}
 	}
 
+	for tenant, tp := range tenantPresets {
+		for name, opts := range tp {
+			po := NewProcessingOptions()
+			po.presets = tp
+			if err := applyURLOptions(po, opts); err != nil {
+				return fmt.Errorf("Error in preset `%s` of the tenant %s: %s", name, tenant, err)
+			}
+		}
+	}
+
 	return nil
 }
@@ -100,6 +100,47 @@ func (s *PresetsTestSuite) TestValidatePresetsInvalid() {
 	require.Error(s.T(), err)
 }
 
+func (s *PresetsTestSuite) TestPreparePresets() {
+	presets = map[string]urlOptions{
+		"test": urlOptions{
+			urlOption{Name: "resize", Args: []string{"fit", "100", "200"}},
+		},
+	}
+
+	apply, err := PreparePresets([]string{"small=resize:fit:4:4", "thumb=preset:small/sharpen:2"})
+
+	require.Nil(s.T(), err)
+
+	// Nothing is changed until the presets are applied
+	require.Contains(s.T(), presets, "test")
+
+	apply()
+
+	require.Equal(s.T(), map[string]urlOptions{
+		"small": urlOptions{
+			urlOption{Name: "resize", Args: []string{"fit", "4", "4"}},
+		},
+		"thumb": urlOptions{
+			urlOption{Name: "preset", Args: []string{"small"}},
+			urlOption{Name: "sharpen", Args: []string{"2"}},
+		},
+	}, presets)
+}
+
+func (s *PresetsTestSuite) TestPreparePresetsInvalid() {
+	current := map[string]urlOptions{
+		"test": urlOptions{
+			urlOption{Name: "resize", Args: []string{"fit", "100", "200"}},
+		},
+	}
+	presets = current
+
+	_, err := PreparePresets([]string{"test=resize:fit:-1:-2"})
+
+	require.Error(s.T(), err)
+	require.Equal(s.T(), current, presets)
+}
+
 func TestPresets(t *testing.T) {
 	suite.Run(t, new(PresetsTestSuite))
 }
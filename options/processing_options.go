@@ -3,6 +3,7 @@ package options
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -18,8 +19,6 @@ import (
 	"github.com/imgproxy/imgproxy/v3/vips"
 )
 
-const maxClientHintDPR = 8
-
 var errExpiredURL = errors.New("Expired URL")
 
 type ExtendOptions struct {
@@ -41,29 +40,96 @@ type PaddingOptions struct {
 	Left    int
 }
 
+type CanvasOptions struct {
+	Enabled bool
+	Width   int
+	Height  int
+	Margin  int
+	Fill    bool
+	Color   vips.Color
+	Gravity GravityOptions
+}
+
+type TileOptions struct {
+	Enabled bool
+	Width   int
+	Height  int
+}
+
 type TrimOptions struct {
 	Enabled   bool
 	Threshold float64
 	Smart     bool
 	Color     vips.Color
+	Alpha     bool
 	EqualHor  bool
 	EqualVer  bool
 }
 
+type EdgesOptions struct {
+	Strength  float64
+	Grayscale bool
+}
+
+type NormalizeOptions struct {
+	Enabled bool
+	Clip    float64
+}
+
+type VignetteOptions struct {
+	Strength float64
+	Color    vips.Color
+}
+
+type FrameOptions struct {
+	Enabled bool
+	Best    bool
+	Index   int
+}
+
+type FrameStepOptions struct {
+	Step             int
+	PreserveDuration bool
+}
+
+type GrainOptions struct {
+	Amount float64
+	Seeded bool
+	Seed   int
+}
+
+type WatermarkRegion struct {
+	Left   float64
+	Top    float64
+	Width  float64
+	Height float64
+}
+
 type WatermarkOptions struct {
 	Enabled   bool
 	Opacity   float64
 	Replicate bool
 	Gravity   GravityOptions
 	Scale     float64
+	ScaleMode WatermarkScaleMode
+	Region    WatermarkRegion
+	Rotate    float64
+	Tint      bool
+	TintColor vips.Color
 }
 
 type ProcessingOptions struct {
 	ResizingType      ResizeType
+	ResizingTolerance float64
+	PhysicalSize      PhysicalSizeOptions
+	ResizingAlgorithm ResizingAlgorithm
+	EnlargeAlgorithm  ResizingAlgorithm
 	Width             int
 	Height            int
 	MinWidth          int
 	MinHeight         int
+	SourceWidth       int
+	SourceHeight      int
 	ZoomWidth         float64
 	ZoomHeight        float64
 	Dpr               float64
@@ -71,30 +137,73 @@ type ProcessingOptions struct {
 	Enlarge           bool
 	Extend            ExtendOptions
 	Crop              CropOptions
+	CropAfterResize   bool
+	CropBasis         CropBasis
+	CropOverflow      bool
+	SkipResultCrop    bool
+	SmartCropMargin   int
 	Padding           PaddingOptions
+	Canvas            CanvasOptions
+	Tile              TileOptions
 	Trim              TrimOptions
 	Rotate            int
+	Flip              bool
+	Flop              bool
 	Format            imagetype.Type
 	Quality           int
 	FormatQuality     map[imagetype.Type]int
+	AlphaQuality      int
 	MaxBytes          int
 	Flatten           bool
 	Background        vips.Color
 	Blur              float32
+	BlurMinAmpl       float32
 	Sharpen           float32
+	SharpenFlat       float32
+	// DefaultSharpen is true when Sharpen is set by IMGPROXY_SHARPENING
+	// and wasn't overridden with the sharpen option. We can't just compare
+	// Sharpen with the config value since the requested sigma may be the same
+	DefaultSharpen    bool
+	SharpenJagged     float32
 	Pixelate          int
+	Edges             EdgesOptions
+	Convolution       ConvolutionOptions
+	AutoWhiteBalance  bool
+	Normalize         NormalizeOptions
+	Vignette          VignetteOptions
+	Grain             GrainOptions
+	AlphaMask         bool
 	StripMetadata     bool
 	KeepCopyright     bool
 	StripColorProfile bool
 	AutoRotate        bool
 	EnforceThumbnail  bool
+	Frame             FrameOptions
+	FrameStep         FrameStepOptions
+	VideoTime         float64
 	ReturnAttachment  bool
+	PngInterlaced     bool
+	Reproducible      bool
+	ProcessingBudget  int
+	MaxSrcResolution  int
+	Dither            Dither
+
+	AllowedSourcesPolicy string
+
+	MaxAnimationFrames int
+
+	// ReturnSharpness, DetectBlank, and BlankThreshold are used by the info endpoint only
+	ReturnSharpness bool
+	DetectBlank     bool
+	BlankThreshold  float64
 
 	SkipProcessingFormats []imagetype.Type
 
 	CacheBuster string
 
 	Watermark WatermarkOptions
+	// ExtraWatermarks are applied after Watermark in the same order
+	ExtraWatermarks []WatermarkOptions
 
 	PreferWebP  bool
 	EnforceWebP bool
@@ -106,11 +215,18 @@ type ProcessingOptions struct {
 	UsedPresets []string
 
 	defaultQuality int
+
+	// Tenant presets. Global presets are used if a preset is not found here
+	presets map[string]urlOptions
+
+	// Non-fatal warnings collected during parsing and processing
+	warnings []string
 }
 
 func NewProcessingOptions() *ProcessingOptions {
 	po := ProcessingOptions{
 		ResizingType:      ResizeFit,
+		ResizingTolerance: DefaultResizingTolerance,
 		Width:             0,
 		Height:            0,
 		ZoomWidth:         1,
@@ -118,6 +234,7 @@ func NewProcessingOptions() *ProcessingOptions {
 		Gravity:           GravityOptions{Type: GravityCenter},
 		Enlarge:           false,
 		Extend:            ExtendOptions{Enabled: false, Gravity: GravityOptions{Type: GravityCenter}},
+		Canvas:            CanvasOptions{Enabled: false, Gravity: GravityOptions{Type: GravityCenter}},
 		Padding:           PaddingOptions{Enabled: false},
 		Trim:              TrimOptions{Enabled: false, Threshold: 10, Smart: true},
 		Rotate:            0,
@@ -126,15 +243,29 @@ func NewProcessingOptions() *ProcessingOptions {
 		Format:            imagetype.Unknown,
 		Background:        vips.Color{R: 255, G: 255, B: 255},
 		Blur:              0,
-		Sharpen:           0,
+		BlurMinAmpl:       0.2,
+		Sharpen:           float32(config.Sharpening),
+		DefaultSharpen:    true,
+		SharpenFlat:       0,
+		SharpenJagged:     3,
+		Edges:             EdgesOptions{Strength: 0, Grayscale: true},
 		Dpr:               1,
+		FrameStep:         FrameStepOptions{Step: 1, PreserveDuration: true},
+		VideoTime:         -1,
 		Watermark:         WatermarkOptions{Opacity: 1, Replicate: false, Gravity: GravityOptions{Type: GravityCenter}},
 		StripMetadata:     config.StripMetadata,
 		KeepCopyright:     config.KeepCopyright,
 		StripColorProfile: config.StripColorProfile,
 		AutoRotate:        config.AutoRotate,
 		EnforceThumbnail:  config.EnforceThumbnail,
+		PngInterlaced:     config.PngInterlaced,
 		ReturnAttachment:  config.ReturnAttachment,
+		Reproducible:      config.Reproducible,
+		ProcessingBudget:  config.ProcessingBudget,
+		MaxSrcResolution:  config.MaxSrcResolution,
+		BlankThreshold:    config.BlankThreshold,
+
+		MaxAnimationFrames: config.MaxAnimationFrames,
 
 		SkipProcessingFormats: append([]imagetype.Type(nil), config.SkipProcessingFormats...),
 		UsedPresets:           make([]string, 0, len(config.Presets)),
@@ -148,9 +279,39 @@ func NewProcessingOptions() *ProcessingOptions {
 		po.FormatQuality[k] = v
 	}
 
+	// IMGPROXY_BACKGROUND is validated on start, so we can safely ignore the error
+	applyDefaultBackground(&po)
+
 	return &po
 }
 
+func applyDefaultBackground(po *ProcessingOptions) error {
+	if len(config.Background) == 0 {
+		return nil
+	}
+
+	return applyBackgroundOption(po, strings.Split(config.Background, ":"))
+}
+
+// ValidateDefaultBackground checks if IMGPROXY_BACKGROUND is a valid color
+func ValidateDefaultBackground() error {
+	if err := applyDefaultBackground(&ProcessingOptions{}); err != nil {
+		return fmt.Errorf("Invalid IMGPROXY_BACKGROUND: %s", err)
+	}
+
+	return nil
+}
+
+// TargetSize returns the requested size of the result.
+// The physical size overrides the width and the height
+func (po *ProcessingOptions) TargetSize() (int, int) {
+	if po.PhysicalSize.Enabled() {
+		return po.PhysicalSize.Pixels()
+	}
+
+	return po.Width, po.Height
+}
+
 func (po *ProcessingOptions) GetQuality() int {
 	q := po.Quality
 
@@ -165,6 +326,87 @@ func (po *ProcessingOptions) GetQuality() int {
 	return q
 }
 
+func (po *ProcessingOptions) SaveOptions() vips.SaveOptions {
+	return vips.SaveOptions{
+		PngInterlaced:    po.PngInterlaced,
+		Dither:           vipsDithers[po.Dither],
+		WebpAlphaQuality: po.AlphaQuality,
+		Reproducible:     po.Reproducible,
+	}
+}
+
+// AddWarning adds a non-fatal processing warning. Duplicate warnings are ignored
+func (po *ProcessingOptions) AddWarning(w string) {
+	for _, ew := range po.warnings {
+		if ew == w {
+			return
+		}
+	}
+
+	po.warnings = append(po.warnings, w)
+}
+
+// Warnings returns the non-fatal warnings collected during parsing and processing
+func (po *ProcessingOptions) Warnings() []string {
+	return po.warnings
+}
+
+// Clone returns a copy of the options that can be modified independently
+// of the original ones
+func (po *ProcessingOptions) Clone() *ProcessingOptions {
+	c := *po
+
+	c.FormatQuality = make(map[imagetype.Type]int, len(po.FormatQuality))
+	for k, v := range po.FormatQuality {
+		c.FormatQuality[k] = v
+	}
+
+	c.SkipProcessingFormats = append([]imagetype.Type(nil), po.SkipProcessingFormats...)
+	c.UsedPresets = append([]string(nil), po.UsedPresets...)
+	c.ExtraWatermarks = append([]WatermarkOptions(nil), po.ExtraWatermarks...)
+	c.warnings = append([]string(nil), po.warnings...)
+
+	return &c
+}
+
+// Watermarks returns the enabled watermarks in the order they should be applied
+func (po *ProcessingOptions) Watermarks() []WatermarkOptions {
+	wms := make([]WatermarkOptions, 0, len(po.ExtraWatermarks)+1)
+
+	for _, wm := range append([]WatermarkOptions{po.Watermark}, po.ExtraWatermarks...) {
+		if wm.Enabled {
+			wms = append(wms, wm)
+		}
+	}
+
+	return wms
+}
+
+// syncExtraWatermarks copies the options shared by all the watermarks
+// from the first watermark to the extra ones
+func (po *ProcessingOptions) syncExtraWatermarks() {
+	for i := range po.ExtraWatermarks {
+		wm := &po.ExtraWatermarks[i]
+
+		wm.Region = po.Watermark.Region
+		wm.Rotate = po.Watermark.Rotate
+		wm.Tint = po.Watermark.Tint
+		wm.TintColor = po.Watermark.TintColor
+	}
+}
+
+func (po *ProcessingOptions) getPreset(name string) (urlOptions, bool) {
+	if p, ok := po.presets[name]; ok {
+		return p, true
+	}
+
+	presetsMutex.RLock()
+	defer presetsMutex.RUnlock()
+
+	p, ok := presets[name]
+	return p, ok
+}
+
 func (po *ProcessingOptions) isPresetUsed(name string) bool {
 	for _, usedName := range po.UsedPresets {
 		if usedName == name {
@@ -210,20 +452,63 @@ func isGravityOffcetValid(gravity GravityType, offset float64) bool {
 	return gravity != GravityFocusPoint || (offset >= 0 && offset <= 1)
 }
 
+func isSmartCropFallbackValid(gt GravityType) bool {
+	return gt != GravitySmart && gt != GravityFocusPoint && gt != GravityAlpha && gt != GravityObject
+}
+
 func parseGravity(g *GravityOptions, args []string) error {
 	nArgs := len(args)
 
-	if nArgs > 3 {
+	// Object gravity accepts only the object classes
+	if args[0] == "obj" {
+		for _, c := range args[1:] {
+			if len(c) == 0 {
+				return fmt.Errorf("Invalid gravity arguments: %v", args)
+			}
+		}
+
+		g.Type = GravityObject
+		g.Strategy = SmartCropStrategyUnknown
+		g.Fallback = GravityUnknown
+		g.Classes = args[1:]
+
+		return nil
+	}
+
+	if nArgs > 3 && !(nArgs == 4 && args[0] == "sm") {
 		return fmt.Errorf("Invalid gravity arguments: %v", args)
 	}
 
 	if t, ok := gravityTypes[args[0]]; ok {
 		g.Type = t
+		g.Strategy = SmartCropStrategyUnknown
+		g.Fallback = GravityUnknown
+		g.Classes = nil
 	} else {
 		return fmt.Errorf("Invalid gravity: %s", args[0])
 	}
 
+	// Smart gravity without the strategy accepts the fallback gravity
+	if g.Type == GravitySmart && nArgs == 2 {
+		if f, ok := gravityTypes[args[1]]; ok && isSmartCropFallbackValid(f) {
+			g.Fallback = f
+			return nil
+		}
+	}
+
+	// Smart gravity accepts offsets only along with the strategy
 	if g.Type == GravitySmart && nArgs > 1 {
+		if s, ok := smartCropStrategies[args[1]]; ok {
+			g.Strategy = s
+		} else {
+			return fmt.Errorf("Invalid smart crop strategy: %s", args[1])
+		}
+
+		args = args[1:]
+		nArgs--
+	}
+
+	if g.Type == GravityAlpha && nArgs > 1 {
 		return fmt.Errorf("Invalid gravity arguments: %v", args)
 	} else if g.Type == GravityFocusPoint && nArgs != 3 {
 		return fmt.Errorf("Invalid gravity arguments: %v", args)
@@ -280,6 +565,45 @@ func applyMinHeightOption(po *ProcessingOptions, args []string) error {
 	return parseDimension(&po.MinHeight, " min height", args[0])
 }
 
+func applySourceWidthOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid source width arguments: %v", args)
+	}
+
+	return parseDimension(&po.SourceWidth, "source width", args[0])
+}
+
+func applySourceHeightOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid source height arguments: %v", args)
+	}
+
+	return parseDimension(&po.SourceHeight, "source height", args[0])
+}
+
+func applyResizingAlgorithmOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid resizing algorithm arguments: %v", args)
+	}
+
+	if ra, ok := resizingAlgorithms[args[0]]; ok {
+		po.ResizingAlgorithm = ra
+		po.EnlargeAlgorithm = ra
+	} else {
+		return fmt.Errorf("Invalid resizing algorithm: %s", args[0])
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if ra, ok := resizingAlgorithms[args[1]]; ok {
+			po.EnlargeAlgorithm = ra
+		} else {
+			return fmt.Errorf("Invalid enlarge resizing algorithm: %s", args[1])
+		}
+	}
+
+	return nil
+}
+
 func applyEnlargeOption(po *ProcessingOptions, args []string) error {
 	if len(args) > 1 {
 		return fmt.Errorf("Invalid enlarge arguments: %v", args)
@@ -305,6 +629,14 @@ func applyExtendOption(po *ProcessingOptions, args []string) error {
 		if po.Extend.Gravity.Type == GravitySmart {
 			return errors.New("extend doesn't support smart gravity")
 		}
+
+		if po.Extend.Gravity.Type == GravityAlpha {
+			return errors.New("extend doesn't support alpha gravity")
+		}
+
+		if po.Extend.Gravity.Type == GravityObject {
+			return errors.New("extend doesn't support object gravity")
+		}
 	}
 
 	return nil
@@ -343,7 +675,7 @@ func applySizeOption(po *ProcessingOptions, args []string) (err error) {
 }
 
 func applyResizingTypeOption(po *ProcessingOptions, args []string) error {
-	if len(args) > 1 {
+	if len(args) > 2 {
 		return fmt.Errorf("Invalid resizing type arguments: %v", args)
 	}
 
@@ -353,6 +685,18 @@ func applyResizingTypeOption(po *ProcessingOptions, args []string) error {
 		return fmt.Errorf("Invalid resize type: %s", args[0])
 	}
 
+	if len(args) > 1 && len(args[1]) > 0 {
+		if po.ResizingType != ResizeSmart {
+			return fmt.Errorf("Resizing tolerance is supported only by the smart resizing type")
+		}
+
+		if t, err := strconv.ParseFloat(args[1], 64); err == nil && t >= 0 {
+			po.ResizingTolerance = t
+		} else {
+			return fmt.Errorf("Invalid resizing tolerance: %s", args[1])
+		}
+	}
+
 	return nil
 }
 
@@ -376,6 +720,42 @@ func applyResizeOption(po *ProcessingOptions, args []string) error {
 	return nil
 }
 
+func applyPhysicalSizeOption(po *ProcessingOptions, args []string) error {
+	if len(args) != 4 {
+		return fmt.Errorf("Invalid physical size arguments: %v", args)
+	}
+
+	var ps PhysicalSizeOptions
+
+	for i, dst := range []*float64{&ps.Width, &ps.Height} {
+		if len(args[i]) == 0 {
+			continue
+		}
+
+		if v, err := strconv.ParseFloat(args[i], 64); err == nil && v >= 0 {
+			*dst = v
+		} else {
+			return fmt.Errorf("Invalid physical size: %s", args[i])
+		}
+	}
+
+	if u, ok := physicalUnits[args[2]]; ok {
+		ps.Unit = u
+	} else {
+		return fmt.Errorf("Invalid physical size unit: %s", args[2])
+	}
+
+	if d, err := strconv.ParseFloat(args[3], 64); err == nil && d > 0 {
+		ps.Dpi = d
+	} else {
+		return fmt.Errorf("Invalid physical size DPI: %s", args[3])
+	}
+
+	po.PhysicalSize = ps
+
+	return nil
+}
+
 func applyZoomOption(po *ProcessingOptions, args []string) error {
 	nArgs := len(args)
 
@@ -406,8 +786,12 @@ func applyDprOption(po *ProcessingOptions, args []string) error {
 		return fmt.Errorf("Invalid dpr arguments: %v", args)
 	}
 
-	if d, err := strconv.ParseFloat(args[0], 64); err == nil && d > 0 {
-		po.Dpr = d
+	if d, err := strconv.ParseFloat(args[0], 64); err == nil && d >= 1 {
+		po.Dpr = math.Min(d, config.MaxDpr)
+
+		if d > config.MaxDpr {
+			po.AddWarning(fmt.Sprintf("DPR is limited to %g", config.MaxDpr))
+		}
 	} else {
 		return fmt.Errorf("Invalid dpr: %s", args[0])
 	}
@@ -445,6 +829,66 @@ func applyCropOption(po *ProcessingOptions, args []string) error {
 	return nil
 }
 
+func applyCropAfterResizeOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid crop after resize arguments: %v", args)
+	}
+
+	po.CropAfterResize = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyCropOverflowOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid crop overflow arguments: %v", args)
+	}
+
+	po.CropOverflow = parseBoolOption(args[0])
+
+	return nil
+}
+
+// applyCropBasisOption declares whether the crop dimensions and offsets
+// are set in the source image pixels or in the resized image pixels
+func applyCropBasisOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid crop basis arguments: %v", args)
+	}
+
+	if cb, ok := cropBases[args[0]]; ok {
+		po.CropBasis = cb
+	} else {
+		return fmt.Errorf("Invalid crop basis: %s", args[0])
+	}
+
+	return nil
+}
+
+func applySkipResultCropOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid skip result crop arguments: %v", args)
+	}
+
+	po.SkipResultCrop = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applySmartCropMarginOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid smart crop margin arguments: %v", args)
+	}
+
+	if m, err := strconv.Atoi(args[0]); err == nil && m >= 0 {
+		po.SmartCropMargin = m
+	} else {
+		return fmt.Errorf("Invalid smart crop margin: %s", args[0])
+	}
+
+	return nil
+}
+
 func applyPaddingOption(po *ProcessingOptions, args []string) error {
 	nArgs := len(args)
 
@@ -489,6 +933,93 @@ func applyPaddingOption(po *ProcessingOptions, args []string) error {
 	return nil
 }
 
+func applyCanvasOption(po *ProcessingOptions, args []string) error {
+	nArgs := len(args)
+
+	if nArgs < 2 || nArgs > 7 {
+		return fmt.Errorf("Invalid canvas arguments: %v", args)
+	}
+
+	if err := parseDimension(&po.Canvas.Width, "canvas width", args[0]); err != nil {
+		return err
+	}
+
+	if err := parseDimension(&po.Canvas.Height, "canvas height", args[1]); err != nil {
+		return err
+	}
+
+	if po.Canvas.Width == 0 || po.Canvas.Height == 0 {
+		po.Canvas.Enabled = false
+		return nil
+	}
+
+	po.Canvas.Margin = 0
+
+	if nArgs > 2 && len(args[2]) > 0 {
+		if err := parseDimension(&po.Canvas.Margin, "canvas margin", args[2]); err != nil {
+			return err
+		}
+	}
+
+	if po.Canvas.Margin*2 >= po.Canvas.Width || po.Canvas.Margin*2 >= po.Canvas.Height {
+		return fmt.Errorf("Canvas margin is too big: %d", po.Canvas.Margin)
+	}
+
+	po.Canvas.Fill = false
+
+	if nArgs > 3 && len(args[3]) > 0 {
+		c, err := vips.ColorFromHex(args[3])
+		if err != nil {
+			return fmt.Errorf("Invalid canvas color: %s", args[3])
+		}
+
+		po.Canvas.Fill = true
+		po.Canvas.Color = c
+	}
+
+	po.Canvas.Gravity = GravityOptions{Type: GravityCenter}
+
+	if nArgs > 4 && len(args[4]) > 0 {
+		if err := parseGravity(&po.Canvas.Gravity, args[4:]); err != nil {
+			return err
+		}
+
+		if po.Canvas.Gravity.Type == GravitySmart {
+			return errors.New("canvas doesn't support smart gravity")
+		}
+
+		if po.Canvas.Gravity.Type == GravityAlpha {
+			return errors.New("canvas doesn't support alpha gravity")
+		}
+
+		if po.Canvas.Gravity.Type == GravityObject {
+			return errors.New("canvas doesn't support object gravity")
+		}
+	}
+
+	po.Canvas.Enabled = true
+
+	return nil
+}
+
+func applyTileOption(po *ProcessingOptions, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Invalid tile arguments: %v", args)
+	}
+
+	if err := parseDimension(&po.Tile.Width, "tile width", args[0]); err != nil {
+		return err
+	}
+
+	if err := parseDimension(&po.Tile.Height, "tile height", args[1]); err != nil {
+		return err
+	}
+
+	po.Tile.Enabled = po.Tile.Width > 0 && po.Tile.Height > 0
+
+	return nil
+}
+
 func applyTrimOption(po *ProcessingOptions, args []string) error {
 	nArgs := len(args)
 
@@ -496,14 +1027,27 @@ func applyTrimOption(po *ProcessingOptions, args []string) error {
 		return fmt.Errorf("Invalid trim arguments: %v", args)
 	}
 
-	if t, err := strconv.ParseFloat(args[0], 64); err == nil && t >= 0 {
+	if args[0] == "alpha" {
+		po.Trim.Enabled = true
+		po.Trim.Alpha = true
+		po.Trim.Threshold = 0
+
+		if nArgs > 1 && len(args[1]) > 0 {
+			if t, err := strconv.ParseFloat(args[1], 64); err == nil && t >= 0 && t < 255 {
+				po.Trim.Threshold = t
+			} else {
+				return fmt.Errorf("Invalid trim threshold: %s", args[1])
+			}
+		}
+	} else if t, err := strconv.ParseFloat(args[0], 64); err == nil && t >= 0 {
 		po.Trim.Enabled = true
+		po.Trim.Alpha = false
 		po.Trim.Threshold = t
 	} else {
 		return fmt.Errorf("Invalid trim threshold: %s", args[0])
 	}
 
-	if nArgs > 1 && len(args[1]) > 0 {
+	if nArgs > 1 && len(args[1]) > 0 && !po.Trim.Alpha {
 		if c, err := vips.ColorFromHex(args[1]); err == nil {
 			po.Trim.Color = c
 			po.Trim.Smart = false
@@ -529,7 +1073,9 @@ func applyRotateOption(po *ProcessingOptions, args []string) error {
 	}
 
 	if r, err := strconv.Atoi(args[0]); err == nil && r%90 == 0 {
-		po.Rotate = r
+		// Normalize the angle to [0, 360) so negative and large angles
+		// are handled the same way as their equivalents
+		po.Rotate = (r%360 + 360) % 360
 	} else {
 		return fmt.Errorf("Invalid rotation angle: %s", args[0])
 	}
@@ -537,27 +1083,61 @@ func applyRotateOption(po *ProcessingOptions, args []string) error {
 	return nil
 }
 
-func applyQualityOption(po *ProcessingOptions, args []string) error {
+func applyFlipOption(po *ProcessingOptions, args []string) error {
 	if len(args) > 1 {
-		return fmt.Errorf("Invalid quality arguments: %v", args)
+		return fmt.Errorf("Invalid flip arguments: %v", args)
 	}
 
-	if q, err := strconv.Atoi(args[0]); err == nil && q >= 0 && q <= 100 {
-		po.Quality = q
-	} else {
-		return fmt.Errorf("Invalid quality: %s", args[0])
-	}
+	po.Flip = parseBoolOption(args[0])
 
 	return nil
 }
 
-func applyFormatQualityOption(po *ProcessingOptions, args []string) error {
-	argsLen := len(args)
-	if len(args)%2 != 0 {
-		return fmt.Errorf("Missing quality for: %s", args[argsLen-1])
+func applyFlopOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid flop arguments: %v", args)
 	}
 
-	for i := 0; i < argsLen; i += 2 {
+	po.Flop = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyQualityOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid quality arguments: %v", args)
+	}
+
+	if q, err := strconv.Atoi(args[0]); err == nil && q >= 0 && q <= 100 {
+		po.Quality = q
+	} else {
+		return fmt.Errorf("Invalid quality: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyAlphaQualityOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid alpha quality arguments: %v", args)
+	}
+
+	if q, err := strconv.Atoi(args[0]); err == nil && q >= 0 && q <= 100 {
+		po.AlphaQuality = q
+	} else {
+		return fmt.Errorf("Invalid alpha quality: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyFormatQualityOption(po *ProcessingOptions, args []string) error {
+	argsLen := len(args)
+	if len(args)%2 != 0 {
+		return fmt.Errorf("Missing quality for: %s", args[argsLen-1])
+	}
+
+	for i := 0; i < argsLen; i += 2 {
 		f, ok := imagetype.Types[args[i]]
 		if !ok {
 			return fmt.Errorf("Invalid image format: %s", args[i])
@@ -592,7 +1172,7 @@ func applyBackgroundOption(po *ProcessingOptions, args []string) error {
 	case 1:
 		if len(args[0]) == 0 {
 			po.Flatten = false
-		} else if c, err := vips.ColorFromHex(args[0]); err == nil {
+		} else if c, err := vips.ColorFromString(args[0]); err == nil {
 			po.Flatten = true
 			po.Background = c
 		} else {
@@ -627,29 +1207,63 @@ func applyBackgroundOption(po *ProcessingOptions, args []string) error {
 	return nil
 }
 
+const minBlurMinAmpl = 0.01
+
 func applyBlurOption(po *ProcessingOptions, args []string) error {
-	if len(args) > 1 {
+	if len(args) > 2 {
 		return fmt.Errorf("Invalid blur arguments: %v", args)
 	}
 
 	if b, err := strconv.ParseFloat(args[0], 32); err == nil && b >= 0 {
+		// Huge sigmas make the gaussian mask huge too, which costs a lot of CPU
+		if b > config.MaxBlurSigma {
+			return fmt.Errorf("Blur is too large: %s; max allowed: %g", args[0], config.MaxBlurSigma)
+		}
 		po.Blur = float32(b)
 	} else {
 		return fmt.Errorf("Invalid blur: %s", args[0])
 	}
 
+	if len(args) > 1 && len(args[1]) > 0 {
+		// Lower amplitudes make the gaussian mask larger, so we limit them too
+		if a, err := strconv.ParseFloat(args[1], 32); err == nil && float32(a) >= minBlurMinAmpl && a < 1 {
+			po.BlurMinAmpl = float32(a)
+		} else {
+			return fmt.Errorf("Invalid blur min amplitude: %s", args[1])
+		}
+	}
+
 	return nil
 }
 
 func applySharpenOption(po *ProcessingOptions, args []string) error {
-	if len(args) > 1 {
+	if len(args) > 3 {
 		return fmt.Errorf("Invalid sharpen arguments: %v", args)
 	}
 
-	if s, err := strconv.ParseFloat(args[0], 32); err == nil && s >= 0 {
-		po.Sharpen = float32(s)
-	} else {
-		return fmt.Errorf("Invalid sharpen: %s", args[0])
+	if len(args[0]) > 0 {
+		if s, err := strconv.ParseFloat(args[0], 32); err == nil && s >= 0 {
+			po.Sharpen = float32(s)
+			po.DefaultSharpen = false
+		} else {
+			return fmt.Errorf("Invalid sharpen: %s", args[0])
+		}
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if f, err := strconv.ParseFloat(args[1], 32); err == nil && f >= 0 {
+			po.SharpenFlat = float32(f)
+		} else {
+			return fmt.Errorf("Invalid sharpen flat: %s", args[1])
+		}
+	}
+
+	if len(args) > 2 && len(args[2]) > 0 {
+		if j, err := strconv.ParseFloat(args[2], 32); err == nil && j >= 0 {
+			po.SharpenJagged = float32(j)
+		} else {
+			return fmt.Errorf("Invalid sharpen jagged: %s", args[2])
+		}
 	}
 
 	return nil
@@ -669,9 +1283,194 @@ func applyPixelateOption(po *ProcessingOptions, args []string) error {
 	return nil
 }
 
+func applyEdgesOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid edges arguments: %v", args)
+	}
+
+	if e, err := strconv.ParseFloat(args[0], 64); err == nil && e >= 0 {
+		po.Edges.Strength = e
+	} else {
+		return fmt.Errorf("Invalid edges strength: %s", args[0])
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		po.Edges.Grayscale = parseBoolOption(args[1])
+	}
+
+	return nil
+}
+
+func applyAutoWhiteBalanceOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid auto white balance arguments: %v", args)
+	}
+
+	po.AutoWhiteBalance = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyNormalizeOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid normalize arguments: %v", args)
+	}
+
+	po.Normalize.Enabled = parseBoolOption(args[0])
+	po.Normalize.Clip = 0
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if c, err := strconv.ParseFloat(args[1], 64); err == nil && c >= 0 && c < 50 {
+			po.Normalize.Clip = c
+		} else {
+			return fmt.Errorf("Invalid normalize clip: %s", args[1])
+		}
+	}
+
+	return nil
+}
+
+func applyVignetteOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid vignette arguments: %v", args)
+	}
+
+	if v, err := strconv.ParseFloat(args[0], 64); err == nil && v >= 0 && v <= 1 {
+		po.Vignette.Strength = v
+	} else {
+		return fmt.Errorf("Invalid vignette strength: %s", args[0])
+	}
+
+	po.Vignette.Color = vips.Color{}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if c, err := vips.ColorFromHex(args[1]); err == nil {
+			po.Vignette.Color = c
+		} else {
+			return fmt.Errorf("Invalid vignette color: %s", args[1])
+		}
+	}
+
+	return nil
+}
+
+func applyGrainOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid grain arguments: %v", args)
+	}
+
+	if a, err := strconv.ParseFloat(args[0], 64); err == nil && a >= 0 && a <= 1 {
+		po.Grain.Amount = a
+	} else {
+		return fmt.Errorf("Invalid grain amount: %s", args[0])
+	}
+
+	po.Grain.Seeded = false
+	po.Grain.Seed = 0
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if seed, err := strconv.ParseInt(args[1], 10, 32); err == nil {
+			po.Grain.Seeded = true
+			po.Grain.Seed = int(seed)
+		} else {
+			return fmt.Errorf("Invalid grain seed: %s", args[1])
+		}
+	}
+
+	return nil
+}
+
+func applyKernelOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid kernel arguments: %v", args)
+	}
+
+	if k, ok := convolutionKernels[args[0]]; ok {
+		po.Convolution = k
+	} else {
+		return fmt.Errorf("Invalid kernel: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyConvOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 3 {
+		return fmt.Errorf("Invalid conv arguments: %v", args)
+	}
+
+	values := strings.Split(args[0], ",")
+
+	size := 0
+	for _, sz := range allowedConvolutionSizes {
+		if len(values) == sz*sz {
+			size = sz
+			break
+		}
+	}
+
+	if size == 0 {
+		return fmt.Errorf("Invalid conv matrix size: %d", len(values))
+	}
+
+	matrix := make([]float64, len(values))
+	sum := 0.0
+
+	for i, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid conv matrix value: %s", v)
+		}
+
+		matrix[i] = f
+		sum += f
+	}
+
+	scale := sum
+	if scale == 0 {
+		scale = 1
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if sc, err := strconv.ParseFloat(args[1], 64); err == nil && sc != 0 {
+			scale = sc
+		} else {
+			return fmt.Errorf("Invalid conv scale: %s", args[1])
+		}
+	}
+
+	offset := 0.0
+
+	if len(args) > 2 && len(args[2]) > 0 {
+		if o, err := strconv.ParseFloat(args[2], 64); err == nil {
+			offset = o
+		} else {
+			return fmt.Errorf("Invalid conv offset: %s", args[2])
+		}
+	}
+
+	po.Convolution = ConvolutionOptions{
+		Matrix: matrix,
+		Scale:  scale,
+		Offset: offset,
+	}
+
+	return nil
+}
+
+func applyAlphaMaskOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid alpha mask arguments: %v", args)
+	}
+
+	po.AlphaMask = parseBoolOption(args[0])
+
+	return nil
+}
+
 func applyPresetOption(po *ProcessingOptions, args []string) error {
 	for _, preset := range args {
-		if p, ok := presets[preset]; ok {
+		if p, ok := po.getPreset(preset); ok {
 			if po.isPresetUsed(preset) {
 				log.Warningf("Recursive preset usage is detected: %s", preset)
 				continue
@@ -695,18 +1494,60 @@ func applyWatermarkOption(po *ProcessingOptions, args []string) error {
 		return fmt.Errorf("Invalid watermark arguments: %v", args)
 	}
 
+	// Each argument is a comma-separated list of values, one for each watermark.
+	// The number of watermarks is defined by the number of opacity values
+	wmArgs := make([][]string, len(strings.Split(args[0], ",")))
+
+	for _, arg := range args {
+		values := strings.Split(arg, ",")
+		if len(values) > len(wmArgs) {
+			return fmt.Errorf("Invalid watermark arguments: %v", args)
+		}
+
+		for i := range wmArgs {
+			v := ""
+			if i < len(values) {
+				v = values[i]
+			}
+
+			wmArgs[i] = append(wmArgs[i], v)
+		}
+	}
+
+	if err := parseWatermark(&po.Watermark, wmArgs[0]); err != nil {
+		return err
+	}
+
+	po.ExtraWatermarks = nil
+
+	// Extra watermarks inherit the values they don't set from the first one
+	for _, a := range wmArgs[1:] {
+		wm := po.Watermark
+
+		if err := parseWatermark(&wm, a); err != nil {
+			return err
+		}
+
+		po.ExtraWatermarks = append(po.ExtraWatermarks, wm)
+	}
+
+	return nil
+}
+
+func parseWatermark(wm *WatermarkOptions, args []string) error {
 	if o, err := strconv.ParseFloat(args[0], 64); err == nil && o >= 0 && o <= 1 {
-		po.Watermark.Enabled = o > 0
-		po.Watermark.Opacity = o
+		wm.Enabled = o > 0
+		wm.Opacity = o
 	} else {
 		return fmt.Errorf("Invalid watermark opacity: %s", args[0])
 	}
 
 	if len(args) > 1 && len(args[1]) > 0 {
 		if args[1] == "re" {
-			po.Watermark.Replicate = true
-		} else if g, ok := gravityTypes[args[1]]; ok && g != GravityFocusPoint && g != GravitySmart {
-			po.Watermark.Gravity.Type = g
+			wm.Replicate = true
+		} else if g, ok := gravityTypes[args[1]]; ok && g != GravityFocusPoint && g != GravitySmart && g != GravityAlpha && g != GravityObject {
+			wm.Replicate = false
+			wm.Gravity.Type = g
 		} else {
 			return fmt.Errorf("Invalid watermark position: %s", args[1])
 		}
@@ -714,7 +1555,7 @@ func applyWatermarkOption(po *ProcessingOptions, args []string) error {
 
 	if len(args) > 2 && len(args[2]) > 0 {
 		if x, err := strconv.Atoi(args[2]); err == nil {
-			po.Watermark.Gravity.X = float64(x)
+			wm.Gravity.X = float64(x)
 		} else {
 			return fmt.Errorf("Invalid watermark X offset: %s", args[2])
 		}
@@ -722,7 +1563,7 @@ func applyWatermarkOption(po *ProcessingOptions, args []string) error {
 
 	if len(args) > 3 && len(args[3]) > 0 {
 		if y, err := strconv.Atoi(args[3]); err == nil {
-			po.Watermark.Gravity.Y = float64(y)
+			wm.Gravity.Y = float64(y)
 		} else {
 			return fmt.Errorf("Invalid watermark Y offset: %s", args[3])
 		}
@@ -730,12 +1571,101 @@ func applyWatermarkOption(po *ProcessingOptions, args []string) error {
 
 	if len(args) > 4 && len(args[4]) > 0 {
 		if s, err := strconv.ParseFloat(args[4], 64); err == nil && s >= 0 {
-			po.Watermark.Scale = s
+			wm.Scale = s
 		} else {
 			return fmt.Errorf("Invalid watermark scale: %s", args[4])
 		}
 	}
 
+	if len(args) > 5 && len(args[5]) > 0 {
+		if sm, ok := watermarkScaleModes[args[5]]; ok {
+			wm.ScaleMode = sm
+		} else {
+			return fmt.Errorf("Invalid watermark scale mode: %s", args[5])
+		}
+	}
+
+	return nil
+}
+
+func applyWatermarkRegionOption(po *ProcessingOptions, args []string) error {
+	if len(args) != 4 {
+		return fmt.Errorf("Invalid watermark region arguments: %v", args)
+	}
+
+	for i, v := range []*float64{
+		&po.Watermark.Region.Left,
+		&po.Watermark.Region.Top,
+		&po.Watermark.Region.Width,
+		&po.Watermark.Region.Height,
+	} {
+		if f, err := strconv.ParseFloat(args[i], 64); err == nil && f >= 0 {
+			*v = f
+		} else {
+			return fmt.Errorf("Invalid watermark region: %s", args[i])
+		}
+	}
+
+	po.syncExtraWatermarks()
+
+	return nil
+}
+
+func applyWatermarkRotateOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid watermark rotate arguments: %v", args)
+	}
+
+	if r, err := strconv.ParseFloat(args[0], 64); err == nil {
+		po.Watermark.Rotate = r
+	} else {
+		return fmt.Errorf("Invalid watermark rotation angle: %s", args[0])
+	}
+
+	po.syncExtraWatermarks()
+
+	return nil
+}
+
+func applyWatermarkTintOption(po *ProcessingOptions, args []string) error {
+	switch len(args) {
+	case 1:
+		if len(args[0]) == 0 {
+			po.Watermark.Tint = false
+		} else if c, err := vips.ColorFromHex(args[0]); err == nil {
+			po.Watermark.Tint = true
+			po.Watermark.TintColor = c
+		} else {
+			return fmt.Errorf("Invalid watermark tint argument: %s", err)
+		}
+
+	case 3:
+		po.Watermark.Tint = true
+
+		if r, err := strconv.ParseUint(args[0], 10, 8); err == nil && r <= 255 {
+			po.Watermark.TintColor.R = uint8(r)
+		} else {
+			return fmt.Errorf("Invalid watermark tint red channel: %s", args[0])
+		}
+
+		if g, err := strconv.ParseUint(args[1], 10, 8); err == nil && g <= 255 {
+			po.Watermark.TintColor.G = uint8(g)
+		} else {
+			return fmt.Errorf("Invalid watermark tint green channel: %s", args[1])
+		}
+
+		if b, err := strconv.ParseUint(args[2], 10, 8); err == nil && b <= 255 {
+			po.Watermark.TintColor.B = uint8(b)
+		} else {
+			return fmt.Errorf("Invalid watermark tint blue channel: %s", args[2])
+		}
+
+	default:
+		return fmt.Errorf("Invalid watermark tint arguments: %v", args)
+	}
+
+	po.syncExtraWatermarks()
+
 	return nil
 }
 
@@ -852,6 +1782,67 @@ func applyEnforceThumbnailOption(po *ProcessingOptions, args []string) error {
 	return nil
 }
 
+func applyFrameOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid frame arguments: %v", args)
+	}
+
+	if args[0] == "best" {
+		po.Frame = FrameOptions{Enabled: true, Best: true}
+		return nil
+	}
+
+	if i, err := strconv.Atoi(args[0]); err == nil && i >= 0 {
+		po.Frame = FrameOptions{Enabled: true, Index: i}
+	} else {
+		return fmt.Errorf("Invalid frame: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyFrameStepOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid frame step arguments: %v", args)
+	}
+
+	if step, err := strconv.Atoi(args[0]); err == nil && step > 0 {
+		po.FrameStep.Step = step
+	} else {
+		return fmt.Errorf("Invalid frame step: %s", args[0])
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		po.FrameStep.PreserveDuration = parseBoolOption(args[1])
+	}
+
+	return nil
+}
+
+func applyVideoTimeOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid time arguments: %v", args)
+	}
+
+	if t, err := strconv.ParseFloat(args[0], 64); err == nil && t >= 0 {
+		po.VideoTime = t
+	} else {
+		return fmt.Errorf("Invalid time: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyPngInterlacedOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid png interlaced arguments: %v", args)
+	}
+
+	po.PngInterlaced = parseBoolOption(args[0])
+
+	return nil
+}
+
 func applyReturnAttachmentOption(po *ProcessingOptions, args []string) error {
 	if len(args) > 1 {
 		return fmt.Errorf("Invalid return_attachment arguments: %v", args)
@@ -862,6 +1853,130 @@ func applyReturnAttachmentOption(po *ProcessingOptions, args []string) error {
 	return nil
 }
 
+func applyDitherOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid dither arguments: %v", args)
+	}
+
+	if d, ok := dithers[args[0]]; ok {
+		po.Dither = d
+	} else {
+		return fmt.Errorf("Invalid dither: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyReproducibleOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid reproducible arguments: %v", args)
+	}
+
+	po.Reproducible = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyProcessingBudgetOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid processing budget arguments: %v", args)
+	}
+
+	if b, err := strconv.Atoi(args[0]); err == nil && b >= 0 {
+		po.ProcessingBudget = b
+	} else {
+		return fmt.Errorf("Invalid processing budget: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyAllowedSourcesPolicyOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid allowed sources policy arguments: %v", args)
+	}
+
+	if _, ok := config.AllowedSourcesPolicies[args[0]]; !ok {
+		return fmt.Errorf("Unknown allowed sources policy: %s", args[0])
+	}
+
+	po.AllowedSourcesPolicy = args[0]
+
+	return nil
+}
+
+func applySharpnessOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid sharpness arguments: %v", args)
+	}
+
+	po.ReturnSharpness = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyDetectBlankOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Invalid detect blank arguments: %v", args)
+	}
+
+	po.DetectBlank = parseBoolOption(args[0])
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if t, err := strconv.ParseFloat(args[1], 64); err == nil && t >= 0 {
+			po.BlankThreshold = t
+		} else {
+			return fmt.Errorf("Invalid detect blank threshold: %s", args[1])
+		}
+	}
+
+	return nil
+}
+
+func applyMaxSrcResolutionOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid max src resolution arguments: %v", args)
+	}
+
+	mp, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || mp <= 0 {
+		return fmt.Errorf("Invalid max src resolution: %s", args[0])
+	}
+
+	// The option can't raise the limit above the absolute server maximum
+	limit := imath.Max(config.MaxSrcResolutionLimit, config.MaxSrcResolution)
+
+	po.MaxSrcResolution = int(mp * 1000000)
+
+	if po.MaxSrcResolution > limit {
+		po.MaxSrcResolution = limit
+		po.AddWarning(fmt.Sprintf("Max src resolution is limited to %g megapixels", float64(limit)/1000000))
+	}
+
+	return nil
+}
+
+func applyMaxAnimationFramesOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid max animation frames arguments: %v", args)
+	}
+
+	frames, err := strconv.Atoi(args[0])
+	if err != nil || frames <= 0 {
+		return fmt.Errorf("Invalid max animation frames: %s", args[0])
+	}
+
+	// The option can only lower the server limit
+	if frames > config.MaxAnimationFrames {
+		frames = config.MaxAnimationFrames
+		po.AddWarning(fmt.Sprintf("Max animation frames is limited to %d", frames))
+	}
+
+	po.MaxAnimationFrames = frames
+
+	return nil
+}
+
 func applyURLOption(po *ProcessingOptions, name string, args []string) error {
 	switch name {
 	case "resize", "rs":
@@ -878,10 +1993,18 @@ func applyURLOption(po *ProcessingOptions, name string, args []string) error {
 		return applyMinWidthOption(po, args)
 	case "min-height", "mh":
 		return applyMinHeightOption(po, args)
+	case "source_width", "srcw":
+		return applySourceWidthOption(po, args)
+	case "source_height", "srch":
+		return applySourceHeightOption(po, args)
+	case "physical_size", "ps":
+		return applyPhysicalSizeOption(po, args)
 	case "zoom", "z":
 		return applyZoomOption(po, args)
 	case "dpr":
 		return applyDprOption(po, args)
+	case "resizing_algorithm", "ra":
+		return applyResizingAlgorithmOption(po, args)
 	case "enlarge", "el":
 		return applyEnlargeOption(po, args)
 	case "extend", "ex":
@@ -890,14 +2013,32 @@ func applyURLOption(po *ProcessingOptions, name string, args []string) error {
 		return applyGravityOption(po, args)
 	case "crop", "c":
 		return applyCropOption(po, args)
+	case "crop_after_resize", "car":
+		return applyCropAfterResizeOption(po, args)
+	case "crop_basis", "crb":
+		return applyCropBasisOption(po, args)
+	case "crop_overflow", "cof":
+		return applyCropOverflowOption(po, args)
+	case "skip_result_crop", "skrc":
+		return applySkipResultCropOption(po, args)
+	case "smart_crop_margin", "scm":
+		return applySmartCropMarginOption(po, args)
 	case "trim", "t":
 		return applyTrimOption(po, args)
 	case "padding", "pd":
 		return applyPaddingOption(po, args)
+	case "canvas", "cnv":
+		return applyCanvasOption(po, args)
+	case "tile", "tl":
+		return applyTileOption(po, args)
 	case "auto_rotate", "ar":
 		return applyAutoRotateOption(po, args)
 	case "rotate", "rot":
 		return applyRotateOption(po, args)
+	case "flip":
+		return applyFlipOption(po, args)
+	case "flop":
+		return applyFlopOption(po, args)
 	case "background", "bg":
 		return applyBackgroundOption(po, args)
 	case "blur", "bl":
@@ -906,8 +2047,30 @@ func applyURLOption(po *ProcessingOptions, name string, args []string) error {
 		return applySharpenOption(po, args)
 	case "pixelate", "pix":
 		return applyPixelateOption(po, args)
+	case "edges", "ed":
+		return applyEdgesOption(po, args)
+	case "auto_wb", "awb":
+		return applyAutoWhiteBalanceOption(po, args)
+	case "normalize", "nm":
+		return applyNormalizeOption(po, args)
+	case "vignette", "vg":
+		return applyVignetteOption(po, args)
+	case "grain", "gr":
+		return applyGrainOption(po, args)
+	case "kernel", "kn":
+		return applyKernelOption(po, args)
+	case "conv":
+		return applyConvOption(po, args)
+	case "alpha_mask", "am":
+		return applyAlphaMaskOption(po, args)
 	case "watermark", "wm":
 		return applyWatermarkOption(po, args)
+	case "watermark_region", "wmr":
+		return applyWatermarkRegionOption(po, args)
+	case "watermark_rotate", "wmrt":
+		return applyWatermarkRotateOption(po, args)
+	case "watermark_tint", "wmtn":
+		return applyWatermarkTintOption(po, args)
 	case "strip_metadata", "sm":
 		return applyStripMetadataOption(po, args)
 	case "keep_copyright", "kcr":
@@ -916,13 +2079,35 @@ func applyURLOption(po *ProcessingOptions, name string, args []string) error {
 		return applyStripColorProfileOption(po, args)
 	case "enforce_thumbnail", "eth":
 		return applyEnforceThumbnailOption(po, args)
+	case "frame", "fr":
+		return applyFrameOption(po, args)
+	case "frame_step", "fs":
+		return applyFrameStepOption(po, args)
+	case "time", "tm":
+		return applyVideoTimeOption(po, args)
+	case "png_interlaced", "pngi":
+		return applyPngInterlacedOption(po, args)
 	case "return_attachment", "att":
 		return applyReturnAttachmentOption(po, args)
+	case "reproducible", "rpr":
+		return applyReproducibleOption(po, args)
+	case "processing_budget", "pb":
+		return applyProcessingBudgetOption(po, args)
+	case "max_src_resolution", "msr":
+		return applyMaxSrcResolutionOption(po, args)
+	case "max_animation_frames", "maf":
+		return applyMaxAnimationFramesOption(po, args)
+	case "dither", "dt":
+		return applyDitherOption(po, args)
+	case "allowed_sources_policy", "asp":
+		return applyAllowedSourcesPolicyOption(po, args)
 	// Saving options
 	case "quality", "q":
 		return applyQualityOption(po, args)
 	case "format_quality", "fq":
 		return applyFormatQualityOption(po, args)
+	case "alpha_quality", "aq":
+		return applyAlphaQualityOption(po, args)
 	case "max_bytes", "mb":
 		return applyMaxBytesOption(po, args)
 	case "format", "f", "ext":
@@ -936,6 +2121,10 @@ func applyURLOption(po *ProcessingOptions, name string, args []string) error {
 		return applyExpiresOption(po, args)
 	case "filename", "fn":
 		return applyFilenameOption(po, args)
+	case "sharpness", "shp":
+		return applySharpnessOption(po, args)
+	case "detect_blank", "db":
+		return applyDetectBlankOption(po, args)
 	// Presets
 	case "preset", "pr":
 		return applyPresetOption(po, args)
@@ -944,8 +2133,29 @@ func applyURLOption(po *ProcessingOptions, name string, args []string) error {
 	return fmt.Errorf("Unknown processing option: %s", name)
 }
 
+func isPresetOption(name string) bool {
+	return name == "preset" || name == "pr"
+}
+
+// applyURLOptions applies presets first and other options after them,
+// so explicitly specified options always override the ones from presets
+// regardless of their position in the URL
 func applyURLOptions(po *ProcessingOptions, options urlOptions) error {
 	for _, opt := range options {
+		if !isPresetOption(opt.Name) {
+			continue
+		}
+
+		if err := applyURLOption(po, opt.Name, opt.Args); err != nil {
+			return err
+		}
+	}
+
+	for _, opt := range options {
+		if isPresetOption(opt.Name) {
+			continue
+		}
+
 		if err := applyURLOption(po, opt.Name, opt.Args); err != nil {
 			return err
 		}
@@ -954,9 +2164,13 @@ func applyURLOptions(po *ProcessingOptions, options urlOptions) error {
 	return nil
 }
 
-func defaultProcessingOptions(headers http.Header) (*ProcessingOptions, error) {
+func defaultProcessingOptions(headers http.Header, tenant *config.Tenant) (*ProcessingOptions, error) {
 	po := NewProcessingOptions()
 
+	if tenant != nil {
+		po.presets = tenantPresets[tenant.Name]
+	}
+
 	headerAccept := headers.Get("Accept")
 
 	if strings.Contains(headerAccept, "image/webp") {
@@ -971,8 +2185,10 @@ func defaultProcessingOptions(headers http.Header) (*ProcessingOptions, error) {
 
 	if config.EnableClientHints {
 		if headerDPR := headers.Get("DPR"); len(headerDPR) > 0 {
-			if dpr, err := strconv.ParseFloat(headerDPR, 64); err == nil && (dpr > 0 && dpr <= maxClientHintDPR) {
+			if dpr, err := strconv.ParseFloat(headerDPR, 64); err == nil && (dpr > 0 && dpr <= config.MaxDpr) {
 				po.Dpr = dpr
+			} else if err == nil && dpr > config.MaxDpr {
+				po.AddWarning(fmt.Sprintf("DPR header value is greater than %g and is ignored", config.MaxDpr))
 			}
 		}
 		if headerViewportWidth := headers.Get("Viewport-Width"); len(headerViewportWidth) > 0 {
@@ -987,7 +2203,7 @@ func defaultProcessingOptions(headers http.Header) (*ProcessingOptions, error) {
 		}
 	}
 
-	if _, ok := presets["default"]; ok {
+	if _, ok := po.getPreset("default"); ok {
 		if err := applyPresetOption(po, []string{"default"}); err != nil {
 			return po, err
 		}
@@ -996,7 +2212,17 @@ func defaultProcessingOptions(headers http.Header) (*ProcessingOptions, error) {
 	return po, nil
 }
 
-func parsePathOptions(parts []string, headers http.Header) (*ProcessingOptions, string, error) {
+// checkOptionsCount limits the number of options in the URL so long
+// option chains can't be used to overload imgproxy
+func checkOptionsCount(count int) error {
+	if config.MaxProcessingOptions > 0 && count > config.MaxProcessingOptions {
+		return fmt.Errorf("Too many processing options: %d. The maximum is %d", count, config.MaxProcessingOptions)
+	}
+
+	return nil
+}
+
+func parsePathOptions(parts []string, headers http.Header, tenant *config.Tenant) (*ProcessingOptions, string, error) {
 	if _, ok := resizeTypes[parts[0]]; ok {
 		return nil, "", ierrors.New(
 			404,
@@ -1005,13 +2231,17 @@ func parsePathOptions(parts []string, headers http.Header) (*ProcessingOptions,
 		)
 	}
 
-	po, err := defaultProcessingOptions(headers)
+	po, err := defaultProcessingOptions(headers, tenant)
 	if err != nil {
 		return nil, "", err
 	}
 
 	options, urlParts := parseURLOptions(parts)
 
+	if err = checkOptionsCount(len(options)); err != nil {
+		return nil, "", err
+	}
+
 	if err = applyURLOptions(po, options); err != nil {
 		return nil, "", err
 	}
@@ -1030,8 +2260,8 @@ func parsePathOptions(parts []string, headers http.Header) (*ProcessingOptions,
 	return po, url, nil
 }
 
-func parsePathPresets(parts []string, headers http.Header) (*ProcessingOptions, string, error) {
-	po, err := defaultProcessingOptions(headers)
+func parsePathPresets(parts []string, headers http.Header, tenant *config.Tenant) (*ProcessingOptions, string, error) {
+	po, err := defaultProcessingOptions(headers, tenant)
 	if err != nil {
 		return nil, "", err
 	}
@@ -1039,6 +2269,10 @@ func parsePathPresets(parts []string, headers http.Header) (*ProcessingOptions,
 	presets := strings.Split(parts[0], ":")
 	urlParts := parts[1:]
 
+	if err = checkOptionsCount(len(presets)); err != nil {
+		return nil, "", err
+	}
+
 	if err = applyPresetOption(po, presets); err != nil {
 		return nil, "", err
 	}
@@ -1058,6 +2292,13 @@ func parsePathPresets(parts []string, headers http.Header) (*ProcessingOptions,
 }
 
 func ParsePath(path string, headers http.Header) (*ProcessingOptions, string, error) {
+	return ParseTenantPath(nil, path, headers)
+}
+
+// ParseTenantPath parses the path the same way as ParsePath does
+// but makes the tenant's presets available. If tenant is nil, only global
+// presets are available
+func ParseTenantPath(tenant *config.Tenant, path string, headers http.Header) (*ProcessingOptions, string, error) {
 	if path == "" || path == "/" {
 		return nil, "", ierrors.New(404, fmt.Sprintf("Invalid path: %s", path), "Invalid URL")
 	}
@@ -1071,9 +2312,9 @@ func ParsePath(path string, headers http.Header) (*ProcessingOptions, string, er
 	)
 
 	if config.OnlyPresets {
-		po, imageURL, err = parsePathPresets(parts, headers)
+		po, imageURL, err = parsePathPresets(parts, headers, tenant)
 	} else {
-		po, imageURL, err = parsePathOptions(parts, headers)
+		po, imageURL, err = parsePathOptions(parts, headers, tenant)
 	}
 
 	if err != nil {
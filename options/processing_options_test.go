@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/imgproxy/imgproxy/v3/config"
 	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/imgproxy/imgproxy/v3/vips"
 )
 
 type ProcessingOptionsTestSuite struct{ suite.Suite }
@@ -20,6 +22,7 @@ func (s *ProcessingOptionsTestSuite) SetupTest() {
 	config.Reset()
 	// Reset presets
 	presets = make(map[string]urlOptions)
+	tenantPresets = make(map[string]map[string]urlOptions)
 }
 
 func (s *ProcessingOptionsTestSuite) TestParseBase64URL() {
@@ -108,202 +111,1657 @@ func (s *ProcessingOptionsTestSuite) TestParsePlainURLEscapedWithBase() {
 	require.Equal(s.T(), imagetype.PNG, po.Format)
 }
 
+func (s *ProcessingOptionsTestSuite) TestParsePlainColorSourceWithBase() {
+	config.BaseURL = "http://images.dev/"
+
+	path := "/size:100:100/plain/color:ff0000@png"
+	po, imageURL, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), "color:ff0000", imageURL)
+	require.Equal(s.T(), imagetype.PNG, po.Format)
+}
+
 // func (s *ProcessingOptionsTestSuite) TestParseURLAllowedSource() {
 // 	config.AllowedSources = []string{"local://", "http://images.dev/"}
 
 // 	path := "/plain/http://images.dev/lorem/ipsum.jpg"
 // 	_, _, err := ParsePath(path, make(http.Header))
 
-// 	require.Nil(s.T(), err)
-// }
+// 	require.Nil(s.T(), err)
+// }
+
+// func (s *ProcessingOptionsTestSuite) TestParseURLNotAllowedSource() {
+// 	config.AllowedSources = []string{"local://", "http://images.dev/"}
+
+// 	path := "/plain/s3://images/lorem/ipsum.jpg"
+// 	_, _, err := ParsePath(path, make(http.Header))
+
+// 	require.Error(s.T(), err)
+// }
+
+func (s *ProcessingOptionsTestSuite) TestParsePathFormat() {
+	path := "/format:webp/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), imagetype.WEBP, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathResize() {
+	path := "/resize:fill:100:200:1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), ResizeFill, po.ResizingType)
+	require.Equal(s.T(), 100, po.Width)
+	require.Equal(s.T(), 200, po.Height)
+	require.True(s.T(), po.Enlarge)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathResizingType() {
+	path := "/resizing_type:fill/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), ResizeFill, po.ResizingType)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathResizingTypeSmart() {
+	path := "/rs:smart:100:200/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), ResizeSmart, po.ResizingType)
+	require.Equal(s.T(), DefaultResizingTolerance, po.ResizingTolerance)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathResizingTypeSmartTolerance() {
+	path := "/rt:smart:0.25/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), ResizeSmart, po.ResizingType)
+	require.Equal(s.T(), 0.25, po.ResizingTolerance)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathResizingTypeSmartInvalidTolerance() {
+	path := "/rt:smart:-1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathResizingTypeToleranceNotSmart() {
+	path := "/rt:fill:0.25/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSize() {
+	path := "/size:100:200:1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 100, po.Width)
+	require.Equal(s.T(), 200, po.Height)
+	require.True(s.T(), po.Enlarge)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWidth() {
+	path := "/width:100/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 100, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathHeight() {
+	path := "/height:100/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 100, po.Height)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathEnlarge() {
+	path := "/enlarge:1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Enlarge)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathExtend() {
+	path := "/extend:1:so:10:20/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), true, po.Extend.Enabled)
+	require.Equal(s.T(), GravitySouth, po.Extend.Gravity.Type)
+	require.Equal(s.T(), 10.0, po.Extend.Gravity.X)
+	require.Equal(s.T(), 20.0, po.Extend.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravity() {
+	path := "/gravity:soea/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravitySouthEast, po.Gravity.Type)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityFocuspoint() {
+	path := "/gravity:fp:0.5:0.75/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravityFocusPoint, po.Gravity.Type)
+	require.Equal(s.T(), 0.5, po.Gravity.X)
+	require.Equal(s.T(), 0.75, po.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityFocuspointOutOfRange() {
+	for _, args := range []string{"1.5:0.5", "0.5:-0.1", "-1:1", "0.5:2"} {
+		path := fmt.Sprintf("/gravity:fp:%s/plain/http://images.dev/lorem/ipsum.jpg", args)
+		_, _, err := ParsePath(path, make(http.Header))
+
+		require.Error(s.T(), err, args)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityFocuspointBounds() {
+	path := "/gravity:fp:0:1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravityFocusPoint, po.Gravity.Type)
+	require.Equal(s.T(), 0.0, po.Gravity.X)
+	require.Equal(s.T(), 1.0, po.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityAlpha() {
+	path := "/gravity:alpha/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravityAlpha, po.Gravity.Type)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityAlphaWithOffsets() {
+	path := "/gravity:alpha:10:20/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityObject() {
+	path := "/g:obj:face/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravityObject, po.Gravity.Type)
+	require.Equal(s.T(), []string{"face"}, po.Gravity.Classes)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityObjectMultipleClasses() {
+	path := "/g:obj:face:cat:dog/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravityObject, po.Gravity.Type)
+	require.Equal(s.T(), []string{"face", "cat", "dog"}, po.Gravity.Classes)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityObjectAllClasses() {
+	path := "/g:obj/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravityObject, po.Gravity.Type)
+	require.Empty(s.T(), po.Gravity.Classes)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityObjectOverride() {
+	path := "/g:obj:face/g:sm/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravitySmart, po.Gravity.Type)
+	require.Empty(s.T(), po.Gravity.Classes)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravityObjectInvalid() {
+	for _, path := range []string{
+		"/g:obj:/plain/http://images.dev/lorem/ipsum.jpg",
+		"/g:obj:face::dog/plain/http://images.dev/lorem/ipsum.jpg",
+		"/ex:1:obj:face/plain/http://images.dev/lorem/ipsum.jpg",
+		"/cnv:100:100:10:fff:obj:face/plain/http://images.dev/lorem/ipsum.jpg",
+		"/wm:1:obj/plain/http://images.dev/lorem/ipsum.jpg",
+	} {
+		_, _, err := ParsePath(path, make(http.Header))
+
+		require.Error(s.T(), err, path)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCropGravityObject() {
+	path := "/c:100:100:obj:text/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravityObject, po.Crop.Gravity.Type)
+	require.Equal(s.T(), []string{"text"}, po.Crop.Gravity.Classes)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravitySmartStrategy() {
+	path := "/gravity:sm:entropy/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravitySmart, po.Gravity.Type)
+	require.Equal(s.T(), SmartCropStrategyEntropy, po.Gravity.Strategy)
+	require.Equal(s.T(), 0.0, po.Gravity.X)
+	require.Equal(s.T(), 0.0, po.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravitySmartStrategyWithOffsets() {
+	path := "/gravity:sm:attention:10:-20/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravitySmart, po.Gravity.Type)
+	require.Equal(s.T(), SmartCropStrategyAttention, po.Gravity.Strategy)
+	require.Equal(s.T(), 10.0, po.Gravity.X)
+	require.Equal(s.T(), -20.0, po.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravitySmartOffsetsWithoutStrategy() {
+	path := "/gravity:sm:10:20/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravitySmartFallback() {
+	path := "/gravity:sm:no/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravitySmart, po.Gravity.Type)
+	require.Equal(s.T(), SmartCropStrategyUnknown, po.Gravity.Strategy)
+	require.Equal(s.T(), GravityNorth, po.Gravity.Fallback)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravitySmartFallbackOverride() {
+	path := "/gravity:sm:no/gravity:sm/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), GravitySmart, po.Gravity.Type)
+	require.Equal(s.T(), GravityUnknown, po.Gravity.Fallback)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGravitySmartFallbackInvalid() {
+	for _, g := range []string{"sm:sm", "sm:fp", "sm:alpha", "sm:obj", "sm:attention:no", "sm:no:10"} {
+		path := fmt.Sprintf("/gravity:%s/plain/http://images.dev/lorem/ipsum.jpg", g)
+		_, _, err := ParsePath(path, make(http.Header))
+
+		require.Error(s.T(), err, g)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathTrim() {
+	path := "/trim:20:FF00FF:1:0/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Trim.Enabled)
+	require.False(s.T(), po.Trim.Alpha)
+	require.False(s.T(), po.Trim.Smart)
+	require.Equal(s.T(), 20.0, po.Trim.Threshold)
+	require.Equal(s.T(), vips.Color{R: 255, G: 0, B: 255}, po.Trim.Color)
+	require.True(s.T(), po.Trim.EqualHor)
+	require.False(s.T(), po.Trim.EqualVer)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathTrimAlpha() {
+	path := "/trim:alpha/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Trim.Enabled)
+	require.True(s.T(), po.Trim.Alpha)
+	require.Equal(s.T(), 0.0, po.Trim.Threshold)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathTrimAlphaThreshold() {
+	path := "/trim:alpha:100:1:1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Trim.Enabled)
+	require.True(s.T(), po.Trim.Alpha)
+	require.Equal(s.T(), 100.0, po.Trim.Threshold)
+	require.True(s.T(), po.Trim.EqualHor)
+	require.True(s.T(), po.Trim.EqualVer)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathTrimAlphaInvalidThreshold() {
+	path := "/trim:alpha:FF00FF/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathEdges() {
+	path := "/edges:0.5/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 0.5, po.Edges.Strength)
+	require.True(s.T(), po.Edges.Grayscale)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathEdgesColor() {
+	path := "/edges:2:0/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 2.0, po.Edges.Strength)
+	require.False(s.T(), po.Edges.Grayscale)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathKernel() {
+	path := "/kernel:sharpen-strong/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Convolution.Enabled())
+	require.Equal(s.T(), convolutionKernels["sharpen-strong"], po.Convolution)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathKernelInvalid() {
+	path := "/kernel:unknown/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathConv() {
+	path := "/conv:0,-1,0,-1,5,-1,0,-1,0/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), []float64{0, -1, 0, -1, 5, -1, 0, -1, 0}, po.Convolution.Matrix)
+	require.Equal(s.T(), 1.0, po.Convolution.Scale)
+	require.Equal(s.T(), 0.0, po.Convolution.Offset)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathConvScaleOffset() {
+	path := "/conv:1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1,1:5:10/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Len(s.T(), po.Convolution.Matrix, 25)
+	require.Equal(s.T(), 5.0, po.Convolution.Scale)
+	require.Equal(s.T(), 10.0, po.Convolution.Offset)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathConvZeroSumScale() {
+	path := "/conv:-1,0,1,-2,0,2,-1,0,1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 1.0, po.Convolution.Scale)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathConvInvalidSize() {
+	path := "/conv:1,1,1,1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathConvInvalidValue() {
+	path := "/conv:0,0,0,0,a,0,0,0,0/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathConvZeroScale() {
+	path := "/conv:0,0,0,0,1,0,0,0,0:0/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAlphaMask() {
+	path := "/alpha_mask:1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.AlphaMask)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathQuality() {
+	path := "/quality:55/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 55, po.Quality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBackground() {
+	path := "/background:128:129:130/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Flatten)
+	require.Equal(s.T(), uint8(128), po.Background.R)
+	require.Equal(s.T(), uint8(129), po.Background.G)
+	require.Equal(s.T(), uint8(130), po.Background.B)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBackgroundHex() {
+	path := "/background:ffddee/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Flatten)
+	require.Equal(s.T(), uint8(0xff), po.Background.R)
+	require.Equal(s.T(), uint8(0xdd), po.Background.G)
+	require.Equal(s.T(), uint8(0xee), po.Background.B)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBackgroundDisable() {
+	path := "/background:fff/background:/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.False(s.T(), po.Flatten)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBackgroundNamed() {
+	path := "/bg:Orange/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Flatten)
+	require.Equal(s.T(), vips.Color{R: 255, G: 165, B: 0}, po.Background)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBackgroundInvalid() {
+	path := "/bg:notacolor/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBackgroundDefault() {
+	testCases := []struct {
+		background string
+		expected   vips.Color
+	}{
+		{"ffddee", vips.Color{R: 0xff, G: 0xdd, B: 0xee}},
+		{"128:129:130", vips.Color{R: 128, G: 129, B: 130}},
+		{"navy", vips.Color{R: 0, G: 0, B: 128}},
+	}
+
+	for _, tc := range testCases {
+		config.Background = tc.background
+
+		po, _, err := ParsePath("/plain/http://images.dev/lorem/ipsum.jpg", make(http.Header))
+
+		require.Nil(s.T(), err)
+
+		require.True(s.T(), po.Flatten, tc.background)
+		require.Equal(s.T(), tc.expected, po.Background, tc.background)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBackgroundDefaultOverride() {
+	config.Background = "navy"
+
+	po, _, err := ParsePath("/bg:fff/plain/http://images.dev/lorem/ipsum.jpg", make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Flatten)
+	require.Equal(s.T(), vips.Color{R: 255, G: 255, B: 255}, po.Background)
+
+	po, _, err = ParsePath("/bg:/plain/http://images.dev/lorem/ipsum.jpg", make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.False(s.T(), po.Flatten)
+}
+
+func (s *ProcessingOptionsTestSuite) TestValidateDefaultBackground() {
+	config.Background = "navy"
+	require.Nil(s.T(), ValidateDefaultBackground())
+
+	config.Background = "300:0:0"
+	require.Error(s.T(), ValidateDefaultBackground())
+
+	config.Background = "notacolor"
+	require.Error(s.T(), ValidateDefaultBackground())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBlur() {
+	path := "/blur:0.2/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.2), po.Blur)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBlurMinAmpl() {
+	path := "/blur:5:0.05/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(5), po.Blur)
+	require.Equal(s.T(), float32(0.05), po.BlurMinAmpl)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBlurMinAmplLowest() {
+	path := "/blur:5:0.01/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.01), po.BlurMinAmpl)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBlurMinAmplDefault() {
+	path := "/blur:5/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.2), po.BlurMinAmpl)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBlurInvalid() {
+	for _, args := range []string{"-1", "5:0", "5:0.001", "5:1", "5:abc"} {
+		path := fmt.Sprintf("/blur:%s/plain/http://images.dev/lorem/ipsum.jpg", args)
+		_, _, err := ParsePath(path, make(http.Header))
+
+		require.Error(s.T(), err, args)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBlurTooLarge() {
+	config.MaxBlurSigma = 10
+
+	path := "/blur:10/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	path = "/blur:10.5/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err = ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSharpen() {
+	path := "/sharpen:0.2/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.2), po.Sharpen)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSharpenFlatJagged() {
+	path := "/sharpen:0.5:1:2.5/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.5), po.Sharpen)
+	require.Equal(s.T(), float32(1), po.SharpenFlat)
+	require.Equal(s.T(), float32(2.5), po.SharpenJagged)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSharpenDefault() {
+	config.Sharpening = 0.7
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.7), po.Sharpen)
+	require.Equal(s.T(), float32(0), po.SharpenFlat)
+	require.Equal(s.T(), float32(3), po.SharpenJagged)
+	require.True(s.T(), po.DefaultSharpen)
+
+	// Empty sigma keeps the default one
+	path = "/sharpen::1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err = ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.7), po.Sharpen)
+	require.Equal(s.T(), float32(1), po.SharpenFlat)
+	require.True(s.T(), po.DefaultSharpen)
+
+	// Explicit sigma overrides the default one even if it's the same
+	path = "/sharpen:0.7/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err = ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.7), po.Sharpen)
+	require.False(s.T(), po.DefaultSharpen)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSharpenInvalid() {
+	for _, args := range []string{"-1", "1:-1", "1:1:-1", "1:1:1:1", "1:a"} {
+		path := fmt.Sprintf("/sharpen:%s/plain/http://images.dev/lorem/ipsum.jpg", args)
+		_, _, err := ParsePath(path, make(http.Header))
+
+		require.Error(s.T(), err, args)
+	}
+}
+func (s *ProcessingOptionsTestSuite) TestParsePathDpr() {
+	path := "/dpr:2/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 2.0, po.Dpr)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDprLessThanOne() {
+	path := "/dpr:0.5/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDprMax() {
+	config.MaxDpr = 3
+
+	path := "/dpr:3/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 3.0, po.Dpr)
+
+	path = "/dpr:100/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err = ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 3.0, po.Dpr)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDprMaxWarning() {
+	config.MaxDpr = 3
+
+	path := "/dpr:3/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Empty(s.T(), po.Warnings())
+
+	path = "/dpr:100/dpr:50/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err = ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), []string{"DPR is limited to 3"}, po.Warnings())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathResizingAlgorithm() {
+	path := "/resizing_algorithm:lanczos3/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), ResizingAlgorithmLanczos3, po.ResizingAlgorithm)
+	require.Equal(s.T(), ResizingAlgorithmLanczos3, po.EnlargeAlgorithm)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathResizingAlgorithmEnlarge() {
+	path := "/resizing_algorithm:lanczos3:linear/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), ResizingAlgorithmLanczos3, po.ResizingAlgorithm)
+	require.Equal(s.T(), ResizingAlgorithmLinear, po.EnlargeAlgorithm)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathResizingAlgorithmInvalid() {
+	path := "/resizing_algorithm:bicubic/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathRotate() {
+	testCases := map[string]int{
+		"0":    0,
+		"90":   90,
+		"-90":  270,
+		"360":  0,
+		"450":  90,
+		"-540": 180,
+	}
+
+	for arg, expected := range testCases {
+		path := fmt.Sprintf("/rotate:%s/plain/http://images.dev/lorem/ipsum.jpg", arg)
+		po, _, err := ParsePath(path, make(http.Header))
+
+		require.Nil(s.T(), err)
+		require.Equal(s.T(), expected, po.Rotate, "rotate:%s", arg)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathRotateInvalid() {
+	path := "/rotate:45/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathFlipFlop() {
+	path := "/flip:1/flop:true/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Flip)
+	require.True(s.T(), po.Flop)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermark() {
+	path := "/watermark:0.5:soea:10:20:0.6/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Watermark.Enabled)
+	require.Equal(s.T(), GravitySouthEast, po.Watermark.Gravity.Type)
+	require.Equal(s.T(), 10.0, po.Watermark.Gravity.X)
+	require.Equal(s.T(), 20.0, po.Watermark.Gravity.Y)
+	require.Equal(s.T(), 0.6, po.Watermark.Scale)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkScaleMode() {
+	path := "/watermark:0.5:soea:10:20:0.6:cover/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 0.6, po.Watermark.Scale)
+	require.Equal(s.T(), WatermarkScaleCover, po.Watermark.ScaleMode)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkScaleModeLongest() {
+	path := "/watermark:0.5:soea:10:20:0.2:longest/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 0.2, po.Watermark.Scale)
+	require.Equal(s.T(), WatermarkScaleLongest, po.Watermark.ScaleMode)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkInvalidScaleMode() {
+	path := "/watermark:0.5:soea:10:20:0.6:stretch/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkList() {
+	path := "/watermark_rotate:45/watermark:1,0.5:nowe,so:10,0:10:0.2,1:,abs/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Len(s.T(), po.ExtraWatermarks, 1)
+
+	first, second := po.Watermark, po.ExtraWatermarks[0]
+
+	require.Equal(s.T(), 1.0, first.Opacity)
+	require.Equal(s.T(), GravityNorthWest, first.Gravity.Type)
+	require.Equal(s.T(), 10.0, first.Gravity.X)
+	require.Equal(s.T(), 10.0, first.Gravity.Y)
+	require.Equal(s.T(), 0.2, first.Scale)
+	require.Equal(s.T(), WatermarkScaleContain, first.ScaleMode)
+	require.Equal(s.T(), 45.0, first.Rotate)
+
+	require.Equal(s.T(), 0.5, second.Opacity)
+	require.Equal(s.T(), GravitySouth, second.Gravity.Type)
+	require.Equal(s.T(), 0.0, second.Gravity.X)
+	// Y offset isn't set for the second watermark, so it's inherited from the first one
+	require.Equal(s.T(), 10.0, second.Gravity.Y)
+	require.Equal(s.T(), 1.0, second.Scale)
+	require.Equal(s.T(), WatermarkScaleAbsolute, second.ScaleMode)
+	require.Equal(s.T(), 45.0, second.Rotate)
+
+	require.Equal(s.T(), []WatermarkOptions{first, second}, po.Watermarks())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkListSharedOptions() {
+	path := "/watermark:1,1:nowe,soea/watermark_region:10:0.8:0:0.2/watermark_tint:ff0000/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Len(s.T(), po.ExtraWatermarks, 1)
+
+	for _, wm := range po.Watermarks() {
+		require.Equal(s.T(), WatermarkRegion{Left: 10, Top: 0.8, Width: 0, Height: 0.2}, wm.Region)
+		require.True(s.T(), wm.Tint)
+		require.Equal(s.T(), vips.Color{R: 255, G: 0, B: 0}, wm.TintColor)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkListDisabled() {
+	path := "/watermark:0,1:nowe,re/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	watermarks := po.Watermarks()
+	require.Len(s.T(), watermarks, 1)
+	require.True(s.T(), watermarks[0].Replicate)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkListOverride() {
+	presets["test1"] = urlOptions{
+		urlOption{Name: "watermark", Args: []string{"1,1", "nowe,soea"}},
+	}
+
+	path := "/preset:test1/watermark:0.5:re/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Empty(s.T(), po.ExtraWatermarks)
+	require.Equal(s.T(), 0.5, po.Watermark.Opacity)
+	require.True(s.T(), po.Watermark.Replicate)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkListInvalid() {
+	path := "/watermark:1:nowe,soea/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkRegion() {
+	path := "/watermark_region:10:0.8:0:0.2/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), WatermarkRegion{Left: 10, Top: 0.8, Width: 0, Height: 0.2}, po.Watermark.Region)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkRotate() {
+	path := "/watermark_rotate:-22.5/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), -22.5, po.Watermark.Rotate)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkTint() {
+	path := "/watermark_tint:ffddee/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Watermark.Tint)
+	require.Equal(s.T(), vips.Color{R: 0xff, G: 0xdd, B: 0xee}, po.Watermark.TintColor)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWatermarkTintRGB() {
+	path := "/watermark_tint:10:20:30/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Watermark.Tint)
+	require.Equal(s.T(), vips.Color{R: 10, G: 20, B: 30}, po.Watermark.TintColor)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSourceSize() {
+	path := "/source_width:1000/srch:500/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 1000, po.SourceWidth)
+	require.Equal(s.T(), 500, po.SourceHeight)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCanvas() {
+	path := "/canvas:200:100:10/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Canvas.Enabled)
+	require.Equal(s.T(), 200, po.Canvas.Width)
+	require.Equal(s.T(), 100, po.Canvas.Height)
+	require.Equal(s.T(), 10, po.Canvas.Margin)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCanvasNoMargin() {
+	path := "/canvas:200:100/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Canvas.Enabled)
+	require.Equal(s.T(), 0, po.Canvas.Margin)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCanvasTooBigMargin() {
+	path := "/canvas:200:100:50/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCanvasColorAndGravity() {
+	path := "/canvas:200:100:10:ff0000:soea:5:6/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Canvas.Enabled)
+	require.True(s.T(), po.Canvas.Fill)
+	require.Equal(s.T(), vips.Color{R: 255, G: 0, B: 0}, po.Canvas.Color)
+	require.Equal(s.T(), GravitySouthEast, po.Canvas.Gravity.Type)
+	require.Equal(s.T(), 5.0, po.Canvas.Gravity.X)
+	require.Equal(s.T(), 6.0, po.Canvas.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCanvasDefaultGravity() {
+	path := "/canvas:200:100:10/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.False(s.T(), po.Canvas.Fill)
+	require.Equal(s.T(), GravityCenter, po.Canvas.Gravity.Type)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCanvasInvalid() {
+	paths := []string{
+		"/canvas:200:100:10:zzz/plain/http://images.dev/lorem/ipsum.jpg",
+		"/canvas:200:100:10::sm/plain/http://images.dev/lorem/ipsum.jpg",
+		"/canvas:200:100:10::alpha/plain/http://images.dev/lorem/ipsum.jpg",
+	}
+
+	for _, path := range paths {
+		_, _, err := ParsePath(path, make(http.Header))
+		require.Error(s.T(), err, path)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathTile() {
+	path := "/tile:300:200/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Tile.Enabled)
+	require.Equal(s.T(), 300, po.Tile.Width)
+	require.Equal(s.T(), 200, po.Tile.Height)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathTileDisabled() {
+	path := "/tile:300:0/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.False(s.T(), po.Tile.Enabled)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathTileInvalid() {
+	path := "/tile:300/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathVignette() {
+	path := "/vignette:0.5:ff0000/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 0.5, po.Vignette.Strength)
+	require.Equal(s.T(), vips.Color{R: 255, G: 0, B: 0}, po.Vignette.Color)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathVignetteInvalid() {
+	paths := []string{
+		"/vignette:1.5/plain/http://images.dev/lorem/ipsum.jpg",
+		"/vignette:0.5:zzz/plain/http://images.dev/lorem/ipsum.jpg",
+	}
+
+	for _, path := range paths {
+		_, _, err := ParsePath(path, make(http.Header))
+		require.Error(s.T(), err, path)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGrain() {
+	path := "/grain:0.3:42/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 0.3, po.Grain.Amount)
+	require.True(s.T(), po.Grain.Seeded)
+	require.Equal(s.T(), 42, po.Grain.Seed)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGrainNoSeed() {
+	path := "/grain:0.3/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 0.3, po.Grain.Amount)
+	require.False(s.T(), po.Grain.Seeded)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathGrainInvalid() {
+	paths := []string{
+		"/grain:2/plain/http://images.dev/lorem/ipsum.jpg",
+		"/grain:0.3:abc/plain/http://images.dev/lorem/ipsum.jpg",
+	}
+
+	for _, path := range paths {
+		_, _, err := ParsePath(path, make(http.Header))
+		require.Error(s.T(), err, path)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDither() {
+	path := "/dither:ordered/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), DitherOrdered, po.Dither)
+	require.Equal(s.T(), vips.DitherOrdered, po.SaveOptions().Dither)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDitherDefault() {
+	path := "/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), DitherDefault, po.Dither)
+	require.Equal(s.T(), vips.DitherDefault, po.SaveOptions().Dither)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDitherInvalid() {
+	path := "/dither:halftone/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAutoWhiteBalance() {
+	path := "/auto_wb:1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.AutoWhiteBalance)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathNormalize() {
+	path := "/normalize:1:0.5/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.True(s.T(), po.Normalize.Enabled)
+	require.Equal(s.T(), 0.5, po.Normalize.Clip)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathNormalizeInvalidClip() {
+	path := "/normalize:1:50/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathProcessingBudget() {
+	path := "/processing_budget:250/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 250, po.ProcessingBudget)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathProcessingBudgetDefault() {
+	config.ProcessingBudget = 500
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 500, po.ProcessingBudget)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathProcessingBudgetInvalid() {
+	path := "/processing_budget:-1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathFrame() {
+	path := "/frame:best/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), FrameOptions{Enabled: true, Best: true}, po.Frame)
+
+	path = "/fr:3/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err = ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), FrameOptions{Enabled: true, Index: 3}, po.Frame)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathFrameInvalid() {
+	path := "/frame:-1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+
+	path = "/frame:sharpest/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err = ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathFrameStep() {
+	path := "/frame_step:2/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), FrameStepOptions{Step: 2, PreserveDuration: true}, po.FrameStep)
+
+	path = "/fs:3:0/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err = ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), FrameStepOptions{Step: 3, PreserveDuration: false}, po.FrameStep)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathFrameStepInvalid() {
+	for _, path := range []string{
+		"/fs:0/plain/http://images.dev/lorem/ipsum.jpg",
+		"/fs:-2/plain/http://images.dev/lorem/ipsum.jpg",
+		"/fs:two/plain/http://images.dev/lorem/ipsum.jpg",
+		"/fs:2:1:1/plain/http://images.dev/lorem/ipsum.jpg",
+	} {
+		_, _, err := ParsePath(path, make(http.Header))
+
+		require.Error(s.T(), err, path)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathVideoTime() {
+	po, _, err := ParsePath("/plain/http://images.dev/lorem/ipsum.mp4", make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), -1.0, po.VideoTime)
+
+	po, _, err = ParsePath("/time:2.5/plain/http://images.dev/lorem/ipsum.mp4", make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 2.5, po.VideoTime)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathVideoTimeInvalid() {
+	for _, path := range []string{
+		"/tm:-1/plain/http://images.dev/lorem/ipsum.mp4",
+		"/tm:soon/plain/http://images.dev/lorem/ipsum.mp4",
+		"/tm:1:2/plain/http://images.dev/lorem/ipsum.mp4",
+	} {
+		_, _, err := ParsePath(path, make(http.Header))
+
+		require.Error(s.T(), err, path)
+	}
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAlphaQuality() {
+	path := "/aq:90/plain/http://images.dev/lorem/ipsum.jpg@webp"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 90, po.AlphaQuality)
+	require.Equal(s.T(), 90, po.SaveOptions().WebpAlphaQuality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAlphaQualityInvalid() {
+	path := "/aq:101/plain/http://images.dev/lorem/ipsum.jpg@webp"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathMaxSrcResolution() {
+	config.MaxSrcResolution = 10000000
+	config.MaxSrcResolutionLimit = 50000000
+
+	path := "/msr:30/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 30000000, po.MaxSrcResolution)
+	require.Empty(s.T(), po.Warnings())
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathMaxSrcResolutionCapped() {
+	config.MaxSrcResolution = 10000000
+	config.MaxSrcResolutionLimit = 50000000
+
+	path := "/msr:100/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 50000000, po.MaxSrcResolution)
+	require.Equal(s.T(), []string{"Max src resolution is limited to 50 megapixels"}, po.Warnings())
+
+	config.MaxSrcResolutionLimit = 0
+
+	po, _, err = ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 10000000, po.MaxSrcResolution)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathMaxAnimationFrames() {
+	config.MaxAnimationFrames = 10
+
+	po, _, err := ParsePath("/plain/http://images.dev/lorem/ipsum.jpg", make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 10, po.MaxAnimationFrames)
 
-// func (s *ProcessingOptionsTestSuite) TestParseURLNotAllowedSource() {
-// 	config.AllowedSources = []string{"local://", "http://images.dev/"}
+	po, _, err = ParsePath("/maf:3/plain/http://images.dev/lorem/ipsum.jpg", make(http.Header))
 
-// 	path := "/plain/s3://images/lorem/ipsum.jpg"
-// 	_, _, err := ParsePath(path, make(http.Header))
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 3, po.MaxAnimationFrames)
+	require.Empty(s.T(), po.Warnings())
+}
 
-// 	require.Error(s.T(), err)
-// }
+func (s *ProcessingOptionsTestSuite) TestParsePathMaxAnimationFramesCapped() {
+	config.MaxAnimationFrames = 10
 
-func (s *ProcessingOptionsTestSuite) TestParsePathFormat() {
-	path := "/format:webp/plain/http://images.dev/lorem/ipsum.jpg"
+	path := "/max_animation_frames:100/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
+	require.Equal(s.T(), 10, po.MaxAnimationFrames)
+	require.Equal(s.T(), []string{"Max animation frames is limited to 10"}, po.Warnings())
+}
 
-	require.Equal(s.T(), imagetype.WEBP, po.Format)
+func (s *ProcessingOptionsTestSuite) TestParsePathMaxAnimationFramesInvalid() {
+	for _, path := range []string{
+		"/maf:0/plain/http://images.dev/lorem/ipsum.jpg",
+		"/maf:-1/plain/http://images.dev/lorem/ipsum.jpg",
+		"/maf:many/plain/http://images.dev/lorem/ipsum.jpg",
+	} {
+		_, _, err := ParsePath(path, make(http.Header))
+
+		require.Error(s.T(), err, path)
+	}
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathResize() {
-	path := "/resize:fill:100:200:1/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathAllowedSourcesPolicy() {
+	config.AllowedSourcesPolicies = map[string][]*regexp.Regexp{
+		"images": {regexp.MustCompile("^http://images.dev/")},
+	}
+
+	path := "/asp:images/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
+	require.Equal(s.T(), "images", po.AllowedSourcesPolicy)
+}
 
-	require.Equal(s.T(), ResizeFill, po.ResizingType)
-	require.Equal(s.T(), 100, po.Width)
-	require.Equal(s.T(), 200, po.Height)
-	require.True(s.T(), po.Enlarge)
+func (s *ProcessingOptionsTestSuite) TestParsePathAllowedSourcesPolicyUnknown() {
+	path := "/asp:images/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathResizingType() {
-	path := "/resizing_type:fill/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathSharpness() {
+	path := "/shp:1/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.Equal(s.T(), ResizeFill, po.ResizingType)
+	require.True(s.T(), po.ReturnSharpness)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathSize() {
-	path := "/size:100:200:1/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathDetectBlank() {
+	config.BlankThreshold = 3
+
+	path := "/db:1/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.Equal(s.T(), 100, po.Width)
-	require.Equal(s.T(), 200, po.Height)
-	require.True(s.T(), po.Enlarge)
+	require.True(s.T(), po.DetectBlank)
+	require.Equal(s.T(), 3.0, po.BlankThreshold)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathWidth() {
-	path := "/width:100/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathDetectBlankThreshold() {
+	path := "/detect_blank:1:5.5/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.Equal(s.T(), 100, po.Width)
+	require.True(s.T(), po.DetectBlank)
+	require.Equal(s.T(), 5.5, po.BlankThreshold)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathHeight() {
-	path := "/height:100/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathDetectBlankInvalidThreshold() {
+	path := "/db:1:-1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathMaxProcessingOptions() {
+	config.MaxProcessingOptions = 3
+
+	path := "/w:100/h:100/q:50/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
+	require.Equal(s.T(), 50, po.Quality)
+}
 
-	require.Equal(s.T(), 100, po.Height)
+func (s *ProcessingOptionsTestSuite) TestParsePathTooManyProcessingOptions() {
+	config.MaxProcessingOptions = 3
+
+	path := "/w:100/h:100/q:50/bl:2/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.EqualError(s.T(), err, "Too many processing options: 4. The maximum is 3")
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathEnlarge() {
-	path := "/enlarge:1/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathTooManyPresets() {
+	config.OnlyPresets = true
+	config.MaxProcessingOptions = 1
+	presets["test1"] = urlOptions{
+		urlOption{Name: "blur", Args: []string{"0.2"}},
+	}
+
+	path := "/test1:test1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.EqualError(s.T(), err, "Too many processing options: 2. The maximum is 1")
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathPhysicalSize() {
+	path := "/physical_size:10:5.5:cm:300/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.True(s.T(), po.Enlarge)
+	require.True(s.T(), po.PhysicalSize.Enabled())
+	require.InDelta(s.T(), 10, po.PhysicalSize.Width, 0.0001)
+	require.InDelta(s.T(), 5.5, po.PhysicalSize.Height, 0.0001)
+	require.Equal(s.T(), PhysicalUnitCm, po.PhysicalSize.Unit)
+	require.InDelta(s.T(), 300, po.PhysicalSize.Dpi, 0.0001)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathExtend() {
-	path := "/extend:1:so:10:20/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathPhysicalSizeInvalidUnit() {
+	path := "/physical_size:10:10:ft:300/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathPhysicalSizeInvalidDpi() {
+	path := "/physical_size:10:10:cm:0/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestPhysicalSizePixels() {
+	w, h := PhysicalSizeOptions{Width: 10, Height: 10, Unit: PhysicalUnitCm, Dpi: 300}.Pixels()
+	require.Equal(s.T(), 1181, w)
+	require.Equal(s.T(), 1181, h)
+
+	w, h = PhysicalSizeOptions{Width: 100, Height: 0, Unit: PhysicalUnitMm, Dpi: 300}.Pixels()
+	require.Equal(s.T(), 1181, w)
+	require.Equal(s.T(), 0, h)
+
+	w, h = PhysicalSizeOptions{Width: 4, Height: 6, Unit: PhysicalUnitIn, Dpi: 300}.Pixels()
+	require.Equal(s.T(), 1200, w)
+	require.Equal(s.T(), 1800, h)
+
+	w, h = PhysicalSizeOptions{Width: 1e300, Height: 10, Unit: PhysicalUnitIn, Dpi: 1e300}.Pixels()
+	require.Equal(s.T(), maxPhysicalSizePixels, w)
+	require.Equal(s.T(), maxPhysicalSizePixels, h)
+}
+
+func (s *ProcessingOptionsTestSuite) TestPhysicalSizeTargetSize() {
+	path := "/w:100/h:200/physical_size:4:6:in:300/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.Equal(s.T(), true, po.Extend.Enabled)
-	require.Equal(s.T(), GravitySouth, po.Extend.Gravity.Type)
-	require.Equal(s.T(), 10.0, po.Extend.Gravity.X)
-	require.Equal(s.T(), 20.0, po.Extend.Gravity.Y)
+	w, h := po.TargetSize()
+	require.Equal(s.T(), 1200, w)
+	require.Equal(s.T(), 1800, h)
+
+	// The requested size is kept as is
+	require.Equal(s.T(), 100, po.Width)
+	require.Equal(s.T(), 200, po.Height)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathGravity() {
-	path := "/gravity:soea/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathAutoRotate() {
+	path := "/ar:0/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
+	require.False(s.T(), po.AutoRotate)
+}
 
-	require.Equal(s.T(), GravitySouthEast, po.Gravity.Type)
+func (s *ProcessingOptionsTestSuite) TestParsePathAutoRotateDefault() {
+	config.AutoRotate = false
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+	require.Nil(s.T(), err)
+	require.False(s.T(), po.AutoRotate)
+
+	path = "/auto_rotate:1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err = ParsePath(path, make(http.Header))
+	require.Nil(s.T(), err)
+	require.True(s.T(), po.AutoRotate)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathGravityFocuspoint() {
-	path := "/gravity:fp:0.5:0.75/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathSmartCropMargin() {
+	path := "/smart_crop_margin:10/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
+	require.Equal(s.T(), 10, po.SmartCropMargin)
+}
 
-	require.Equal(s.T(), GravityFocusPoint, po.Gravity.Type)
-	require.Equal(s.T(), 0.5, po.Gravity.X)
-	require.Equal(s.T(), 0.75, po.Gravity.Y)
+func (s *ProcessingOptionsTestSuite) TestParsePathSmartCropMarginInvalid() {
+	path := "/scm:-1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathQuality() {
-	path := "/quality:55/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathCropAfterResize() {
+	path := "/crop:100:100/crop_after_resize:1/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.Equal(s.T(), 55, po.Quality)
+	require.True(s.T(), po.CropAfterResize)
+	require.Equal(s.T(), 100.0, po.Crop.Width)
+	require.Equal(s.T(), 100.0, po.Crop.Height)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathBackground() {
-	path := "/background:128:129:130/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathCropOverflow() {
+	path := "/crop:100:100:nowe:-10:-20/crop_overflow:1/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.True(s.T(), po.Flatten)
-	require.Equal(s.T(), uint8(128), po.Background.R)
-	require.Equal(s.T(), uint8(129), po.Background.G)
-	require.Equal(s.T(), uint8(130), po.Background.B)
+	require.True(s.T(), po.CropOverflow)
+	require.Equal(s.T(), -10.0, po.Crop.Gravity.X)
+	require.Equal(s.T(), -20.0, po.Crop.Gravity.Y)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathBackgroundHex() {
-	path := "/background:ffddee/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathCropBasis() {
+	path := "/crop:100:100/crop_basis:output/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
+	require.Equal(s.T(), CropBasisOutput, po.CropBasis)
 
-	require.True(s.T(), po.Flatten)
-	require.Equal(s.T(), uint8(0xff), po.Background.R)
-	require.Equal(s.T(), uint8(0xdd), po.Background.G)
-	require.Equal(s.T(), uint8(0xee), po.Background.B)
+	path = "/crop:100:100/car:1/crb:source/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err = ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), CropBasisSource, po.CropBasis)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathBackgroundDisable() {
-	path := "/background:fff/background:/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathCropBasisInvalid() {
+	path := "/crop:100:100/crop_basis:result/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathSkipResultCrop() {
+	path := "/rs:fill:100:50/skrc:1/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.False(s.T(), po.Flatten)
+	require.True(s.T(), po.SkipResultCrop)
+	require.Equal(s.T(), ResizeFill, po.ResizingType)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathBlur() {
-	path := "/blur:0.2/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathSkipResultCropInvalid() {
+	path := "/skip_result_crop:1:1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := ParsePath(path, make(http.Header))
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathPngInterlaced() {
+	path := "/png_interlaced:1/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.Equal(s.T(), float32(0.2), po.Blur)
+	require.True(s.T(), po.PngInterlaced)
+	require.True(s.T(), po.SaveOptions().PngInterlaced)
 }
 
-func (s *ProcessingOptionsTestSuite) TestParsePathSharpen() {
-	path := "/sharpen:0.2/plain/http://images.dev/lorem/ipsum.jpg"
+func (s *ProcessingOptionsTestSuite) TestParsePathPngInterlacedDefault() {
+	config.PngInterlaced = true
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.Equal(s.T(), float32(0.2), po.Sharpen)
+	require.True(s.T(), po.PngInterlaced)
 }
-func (s *ProcessingOptionsTestSuite) TestParsePathDpr() {
-	path := "/dpr:2/plain/http://images.dev/lorem/ipsum.jpg"
+
+func (s *ProcessingOptionsTestSuite) TestParsePathReproducible() {
+	path := "/reproducible:1/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.Equal(s.T(), 2.0, po.Dpr)
+	require.True(s.T(), po.Reproducible)
+	require.True(s.T(), po.SaveOptions().Reproducible)
 }
-func (s *ProcessingOptionsTestSuite) TestParsePathWatermark() {
-	path := "/watermark:0.5:soea:10:20:0.6/plain/http://images.dev/lorem/ipsum.jpg"
+
+func (s *ProcessingOptionsTestSuite) TestParsePathReproducibleDefault() {
+	config.Reproducible = true
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg"
 	po, _, err := ParsePath(path, make(http.Header))
 
 	require.Nil(s.T(), err)
 
-	require.True(s.T(), po.Watermark.Enabled)
-	require.Equal(s.T(), GravitySouthEast, po.Watermark.Gravity.Type)
-	require.Equal(s.T(), 10.0, po.Watermark.Gravity.X)
-	require.Equal(s.T(), 20.0, po.Watermark.Gravity.Y)
-	require.Equal(s.T(), 0.6, po.Watermark.Scale)
+	require.True(s.T(), po.Reproducible)
 }
 
 func (s *ProcessingOptionsTestSuite) TestParsePathPreset() {
@@ -326,6 +1784,39 @@ func (s *ProcessingOptionsTestSuite) TestParsePathPreset() {
 	require.Equal(s.T(), 50, po.Quality)
 }
 
+func (s *ProcessingOptionsTestSuite) TestParseTenantPathPreset() {
+	presets["test1"] = urlOptions{
+		urlOption{Name: "resizing_type", Args: []string{"fill"}},
+	}
+	presets["test2"] = urlOptions{
+		urlOption{Name: "blur", Args: []string{"0.2"}},
+	}
+
+	tenantPresets["tenant"] = map[string]urlOptions{
+		"test2": urlOptions{
+			urlOption{Name: "quality", Args: []string{"50"}},
+		},
+	}
+
+	tenant := &config.Tenant{Name: "tenant"}
+
+	path := "/preset:test1:test2/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParseTenantPath(tenant, path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), ResizeFill, po.ResizingType)
+	require.Equal(s.T(), float32(0), po.Blur)
+	require.Equal(s.T(), 50, po.Quality)
+
+	po, _, err = ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.2), po.Blur)
+	require.Equal(s.T(), 0, po.Quality)
+}
+
 func (s *ProcessingOptionsTestSuite) TestParsePathPresetDefault() {
 	presets["default"] = urlOptions{
 		urlOption{Name: "resizing_type", Args: []string{"fill"}},
@@ -343,6 +1834,82 @@ func (s *ProcessingOptionsTestSuite) TestParsePathPresetDefault() {
 	require.Equal(s.T(), 70, po.Quality)
 }
 
+func (s *ProcessingOptionsTestSuite) TestParsePathPresetExplicitOverride() {
+	presets["test1"] = urlOptions{
+		urlOption{Name: "resizing_type", Args: []string{"fill"}},
+		urlOption{Name: "quality", Args: []string{"50"}},
+	}
+
+	path := "/quality:70/preset:test1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), ResizeFill, po.ResizingType)
+	require.Equal(s.T(), 70, po.Quality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathPresetOverridesDefault() {
+	presets["default"] = urlOptions{
+		urlOption{Name: "blur", Args: []string{"0.2"}},
+		urlOption{Name: "quality", Args: []string{"50"}},
+	}
+
+	presets["test1"] = urlOptions{
+		urlOption{Name: "quality", Args: []string{"60"}},
+	}
+
+	path := "/preset:test1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.2), po.Blur)
+	require.Equal(s.T(), 60, po.Quality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathPresetPrecedence() {
+	presets["default"] = urlOptions{
+		urlOption{Name: "blur", Args: []string{"0.2"}},
+		urlOption{Name: "sharpen", Args: []string{"0.3"}},
+		urlOption{Name: "quality", Args: []string{"50"}},
+	}
+
+	presets["test1"] = urlOptions{
+		urlOption{Name: "sharpen", Args: []string{"0.5"}},
+		urlOption{Name: "quality", Args: []string{"60"}},
+	}
+
+	path := "/q:70/pr:test1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.2), po.Blur)
+	require.Equal(s.T(), float32(0.5), po.Sharpen)
+	require.Equal(s.T(), 70, po.Quality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathNestedPresetOverride() {
+	presets["test1"] = urlOptions{
+		urlOption{Name: "quality", Args: []string{"70"}},
+		urlOption{Name: "preset", Args: []string{"test2"}},
+	}
+
+	presets["test2"] = urlOptions{
+		urlOption{Name: "blur", Args: []string{"0.2"}},
+		urlOption{Name: "quality", Args: []string{"50"}},
+	}
+
+	path := "/preset:test1/plain/http://images.dev/lorem/ipsum.jpg"
+	po, _, err := ParsePath(path, make(http.Header))
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), float32(0.2), po.Blur)
+	require.Equal(s.T(), 70, po.Quality)
+}
+
 func (s *ProcessingOptionsTestSuite) TestParsePathPresetLoopDetection() {
 	presets["test1"] = urlOptions{
 		urlOption{Name: "resizing_type", Args: []string{"fill"}},
@@ -485,6 +2052,19 @@ func (s *ProcessingOptionsTestSuite) TestParsePathDprHeader() {
 	require.Equal(s.T(), 2.0, po.Dpr)
 }
 
+func (s *ProcessingOptionsTestSuite) TestParsePathDprHeaderMax() {
+	config.EnableClientHints = true
+	config.MaxDpr = 3
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg@png"
+	headers := http.Header{"Dpr": []string{"4"}}
+	po, _, err := ParsePath(path, headers)
+
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), 1.0, po.Dpr)
+}
+
 func (s *ProcessingOptionsTestSuite) TestParsePathDprHeaderDisabled() {
 	path := "/plain/http://images.dev/lorem/ipsum.jpg@png"
 	headers := http.Header{"Dpr": []string{"2"}}
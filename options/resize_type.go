@@ -10,14 +10,19 @@ const (
 	ResizeFillDown
 	ResizeForce
 	ResizeAuto
+	ResizeSmart
 )
 
+// DefaultResizingTolerance is the default aspect ratio tolerance of the smart resizing type
+const DefaultResizingTolerance = 0.1
+
 var resizeTypes = map[string]ResizeType{
 	"fit":       ResizeFit,
 	"fill":      ResizeFill,
 	"fill-down": ResizeFillDown,
 	"force":     ResizeForce,
 	"auto":      ResizeAuto,
+	"smart":     ResizeSmart,
 }
 
 func (rt ResizeType) String() string {
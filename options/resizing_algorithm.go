@@ -0,0 +1,41 @@
+package options
+
+import "fmt"
+
+type ResizingAlgorithm int
+
+const (
+	ResizingAlgorithmCubic ResizingAlgorithm = iota
+	ResizingAlgorithmNearest
+	ResizingAlgorithmLinear
+	ResizingAlgorithmMitchell
+	ResizingAlgorithmLanczos2
+	ResizingAlgorithmLanczos3
+)
+
+var resizingAlgorithms = map[string]ResizingAlgorithm{
+	"cubic":    ResizingAlgorithmCubic,
+	"nearest":  ResizingAlgorithmNearest,
+	"linear":   ResizingAlgorithmLinear,
+	"mitchell": ResizingAlgorithmMitchell,
+	"lanczos2": ResizingAlgorithmLanczos2,
+	"lanczos3": ResizingAlgorithmLanczos3,
+}
+
+func (ra ResizingAlgorithm) String() string {
+	for k, v := range resizingAlgorithms {
+		if v == ra {
+			return k
+		}
+	}
+	return ""
+}
+
+func (ra ResizingAlgorithm) MarshalJSON() ([]byte, error) {
+	for k, v := range resizingAlgorithms {
+		if v == ra {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
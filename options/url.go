@@ -8,12 +8,13 @@ import (
 	"strings"
 
 	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/imagedata"
 )
 
 const urlTokenPlain = "plain"
 
 func addBaseURL(u string) string {
-	if len(config.BaseURL) == 0 || strings.HasPrefix(u, config.BaseURL) {
+	if len(config.BaseURL) == 0 || strings.HasPrefix(u, config.BaseURL) || imagedata.IsColorSource(u) {
 		return u
 	}
 
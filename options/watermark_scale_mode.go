@@ -0,0 +1,37 @@
+package options
+
+import "fmt"
+
+type WatermarkScaleMode int
+
+const (
+	WatermarkScaleContain WatermarkScaleMode = iota
+	WatermarkScaleCover
+	WatermarkScaleAbsolute
+	WatermarkScaleLongest
+)
+
+var watermarkScaleModes = map[string]WatermarkScaleMode{
+	"contain": WatermarkScaleContain,
+	"cover":   WatermarkScaleCover,
+	"abs":     WatermarkScaleAbsolute,
+	"longest": WatermarkScaleLongest,
+}
+
+func (sm WatermarkScaleMode) String() string {
+	for k, v := range watermarkScaleModes {
+		if v == sm {
+			return k
+		}
+	}
+	return ""
+}
+
+func (sm WatermarkScaleMode) MarshalJSON() ([]byte, error) {
+	for k, v := range watermarkScaleModes {
+		if v == sm {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
@@ -0,0 +1,136 @@
+package processing
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/imath"
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/semaphore"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+var workersSem *semaphore.Semaphore
+
+// SetWorkersSemaphore sets the semaphore that limits the number of requests
+// processed at the same time. Additional frame workers take its free tokens
+func SetWorkersSemaphore(sem *semaphore.Semaphore) {
+	workersSem = sem
+}
+
+// acquireFrameWorkers acquires tokens for up to n additional frame workers.
+// Additional workers take the tokens of the workers semaphore, so animations
+// can't occupy more threads than IMGPROXY_CONCURRENCY together with the other requests.
+// Acquiring doesn't block, fewer tokens are returned when all the workers are busy
+func acquireFrameWorkers(n int) []*semaphore.Token {
+	if workersSem == nil {
+		return nil
+	}
+
+	tokens := make([]*semaphore.Token, 0, n)
+
+	for ; n > 0; n-- {
+		token, ok := workersSem.TryAquire()
+		if !ok {
+			break
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens
+}
+
+// processFrames runs the main pipeline for each of the animation frames.
+//
+// The first frame is processed first, so the changes the pipeline makes to the processing
+// options (like the detected smart crop area) are applied to the rest of the frames.
+// The rest of the frames are processed by up to IMGPROXY_ANIMATION_CONCURRENCY workers,
+// each frame with its own copy of the processing options
+func processFrames(ctx context.Context, frames []*vips.Image, po *options.ProcessingOptions) error {
+	if err := mainPipeline.Run(ctx, frames[0], po, nil); err != nil {
+		return err
+	}
+
+	if len(frames) == 1 {
+		return nil
+	}
+
+	// The crop report is filled by the first frame.
+	// Other frames have the same crops, so we hide the report from them
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, cropReportCtxKey{}, (*cropReport)(nil)))
+	defer cancel()
+
+	indexes := make(chan int, len(frames)-1)
+	for i := 1; i < len(frames); i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	var (
+		firstErr     error
+		firstErrOnce sync.Once
+	)
+
+	work := func() {
+		for i := range indexes {
+			if err := mainPipeline.Run(ctx, frames[i], po.Clone(), nil); err != nil {
+				firstErrOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	// The current goroutine is a worker too
+	tokens := acquireFrameWorkers(imath.Min(config.AnimationConcurrency, len(frames)-1) - 1)
+
+	for _, token := range tokens {
+		wg.Add(1)
+
+		go func(token *semaphore.Token) {
+			defer wg.Done()
+			defer token.Release()
+
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+
+			defer vips.Cleanup()
+
+			work()
+		}(token)
+	}
+
+	work()
+
+	wg.Wait()
+
+	return firstErr
+}
+
+// sampleDelays returns the delays of the frames sampled with the step.
+// When preserveDuration is true, each of the sampled frames lasts as long as
+// all the frames it replaces, so the animation duration doesn't change
+func sampleDelays(delay []int, step int, preserveDuration bool) []int {
+	sampled := make([]int, 0, (len(delay)+step-1)/step)
+
+	for i := 0; i < len(delay); i += step {
+		d := delay[i]
+
+		if preserveDuration {
+			for j := i + 1; j < imath.Min(i+step, len(delay)); j++ {
+				d += delay[j]
+			}
+		}
+
+		sampled = append(sampled, d)
+	}
+
+	return sampled
+}
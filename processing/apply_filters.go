@@ -1,13 +1,22 @@
 package processing
 
 import (
+	"fmt"
+
+	"github.com/imgproxy/imgproxy/v3/config"
 	"github.com/imgproxy/imgproxy/v3/imagedata"
 	"github.com/imgproxy/imgproxy/v3/options"
 	"github.com/imgproxy/imgproxy/v3/vips"
 )
 
+// maxGrainSigma is the standard deviation of the grain noise when its amount is 1
+const maxGrainSigma = 64.0
+
 func applyFilters(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
-	if po.Blur == 0 && po.Sharpen == 0 && po.Pixelate <= 1 {
+	if po.Blur == 0 && po.Sharpen == 0 && po.Pixelate <= 1 &&
+		po.Edges.Strength == 0 && !po.Convolution.Enabled() &&
+		!po.AutoWhiteBalance && !po.Normalize.Enabled &&
+		po.Vignette.Strength == 0 && po.Grain.Amount == 0 {
 		return nil
 	}
 
@@ -19,9 +28,78 @@ func applyFilters(pctx *pipelineContext, img *vips.Image, po *options.Processing
 		return err
 	}
 
-	if err := img.ApplyFilters(po.Blur, po.Sharpen, po.Pixelate); err != nil {
-		return err
+	if po.AutoWhiteBalance {
+		if err := img.AutoWhiteBalance(); err != nil {
+			return err
+		}
+	}
+
+	if po.Normalize.Enabled {
+		if err := img.Normalize(po.Normalize.Clip); err != nil {
+			return err
+		}
+	}
+
+	sharpen := po.Sharpen
+	if sharpen > 0 && skipOptionalFilter(pctx, "sharpen") {
+		sharpen = 0
+	}
+
+	// Blur sigma is set for the CSS pixels, so it should match the resulting image density.
+	// The density may make the sigma larger than the URL allows, so we limit it here too
+	blur := po.Blur * float32(po.Dpr)
+	if blur > float32(config.MaxBlurSigma) {
+		blur = float32(config.MaxBlurSigma)
+		po.AddWarning(fmt.Sprintf("Blur sigma is limited to %g", config.MaxBlurSigma))
+	}
+
+	if blur > 0 || sharpen > 0 || po.Pixelate > 1 {
+		// vips_sharpen works in LabS, so converting the image to linear colorspace beforehand
+		// doesn't make any difference. We sharpen in linear light separately instead
+		if err := img.ApplyFilters(blur, po.BlurMinAmpl, sharpen, po.SharpenFlat, po.SharpenJagged, config.UseLinearColorspace, po.Pixelate); err != nil {
+			return err
+		}
+	}
+
+	if po.Edges.Strength > 0 {
+		if err := img.Edges(po.Edges.Strength, po.Edges.Grayscale); err != nil {
+			return err
+		}
+	}
+
+	if po.Convolution.Enabled() {
+		if err := img.Conv(po.Convolution.Matrix, po.Convolution.Scale, po.Convolution.Offset); err != nil {
+			return err
+		}
+	}
+
+	if po.Vignette.Strength > 0 && !skipOptionalFilter(pctx, "vignette") {
+		if err := img.Vignette(po.Vignette.Strength, po.Vignette.Color); err != nil {
+			return err
+		}
+	}
+
+	if po.Grain.Amount > 0 && !skipOptionalFilter(pctx, "grain") {
+		// Reproducible results require the noise to be reproducible too
+		seeded := po.Grain.Seeded || po.Reproducible
+
+		if err := img.Grain(po.Grain.Amount*maxGrainSigma, seeded, po.Grain.Seed); err != nil {
+			return err
+		}
 	}
 
 	return img.CopyMemory()
 }
+
+// skipOptionalFilter checks if the optional filter should be skipped
+// because the processing budget is nearly spent
+func skipOptionalFilter(pctx *pipelineContext, name string) bool {
+	budget := budgetFromContext(pctx.ctx)
+	if !budget.nearlySpent(pctx.ctx) {
+		return false
+	}
+
+	budget.degrade(name)
+
+	return true
+}
@@ -0,0 +1,73 @@
+package processing
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/router"
+)
+
+// The budget is considered nearly spent when this share of it has elapsed
+const budgetDegradationThreshold = 0.75
+
+type budgetCtxKey struct{}
+
+// processingBudget tracks the time budget of the request. When the budget is nearly spent,
+// imgproxy skips optional stages and lowers the quality to respond in time
+type processingBudget struct {
+	threshold time.Duration
+
+	degraded      []string
+	degradedMutex sync.Mutex
+}
+
+func withBudget(ctx context.Context, po *options.ProcessingOptions) (context.Context, *processingBudget) {
+	if po.ProcessingBudget <= 0 {
+		return ctx, nil
+	}
+
+	b := &processingBudget{
+		threshold: time.Duration(float64(po.ProcessingBudget) * budgetDegradationThreshold * float64(time.Millisecond)),
+	}
+
+	return context.WithValue(ctx, budgetCtxKey{}, b), b
+}
+
+func budgetFromContext(ctx context.Context) *processingBudget {
+	b, _ := ctx.Value(budgetCtxKey{}).(*processingBudget)
+	return b
+}
+
+// nearlySpent checks if the budget is nearly spent. The time is counted from the request start
+func (b *processingBudget) nearlySpent(ctx context.Context) bool {
+	return b != nil && router.RequestTime(ctx) >= b.threshold
+}
+
+// degrade records that the stage was degraded to fit the budget
+func (b *processingBudget) degrade(stage string) {
+	b.degradedMutex.Lock()
+	defer b.degradedMutex.Unlock()
+
+	for _, s := range b.degraded {
+		if s == stage {
+			return
+		}
+	}
+
+	b.degraded = append(b.degraded, stage)
+}
+
+// degradedStages returns the comma-separated list of the degraded stages
+func (b *processingBudget) degradedStages() string {
+	if b == nil {
+		return ""
+	}
+
+	b.degradedMutex.Lock()
+	defer b.degradedMutex.Unlock()
+
+	return strings.Join(b.degraded, ", ")
+}
@@ -0,0 +1,54 @@
+package processing
+
+import (
+	"math"
+
+	"github.com/imgproxy/imgproxy/v3/imagedata"
+	"github.com/imgproxy/imgproxy/v3/imath"
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+func canvas(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
+	if !po.Canvas.Enabled {
+		return nil
+	}
+
+	canvasWidth := imath.Scale(po.Canvas.Width, po.Dpr)
+	canvasHeight := imath.Scale(po.Canvas.Height, po.Dpr)
+	margin := imath.Scale(po.Canvas.Margin, po.Dpr)
+
+	innerWidth := imath.Max(1, canvasWidth-2*margin)
+	innerHeight := imath.Max(1, canvasHeight-2*margin)
+
+	// Scale the image to fit the canvas minus margins
+	scale := math.Min(
+		float64(innerWidth)/float64(img.Width()),
+		float64(innerHeight)/float64(img.Height()),
+	)
+
+	if scale != 1 {
+		if err := img.Resize(scale, scale, resizingKernel(po, scale, scale)); err != nil {
+			return err
+		}
+	}
+
+	gravity := po.Canvas.Gravity
+	if gravity.Type != options.GravityFocusPoint {
+		gravity.X *= po.Dpr
+		gravity.Y *= po.Dpr
+	}
+
+	innerLeft, innerTop := calcPosition(innerWidth, innerHeight, img.Width(), img.Height(), &gravity, false)
+
+	if err := img.Embed(canvasWidth, canvasHeight, margin+innerLeft, margin+innerTop); err != nil {
+		return err
+	}
+
+	if po.Canvas.Fill {
+		// Composite the image over the canvas of the requested color
+		return img.Flatten(po.Canvas.Color)
+	}
+
+	return nil
+}
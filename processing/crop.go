@@ -1,15 +1,22 @@
 package processing
 
 import (
+	"image"
+
 	"github.com/imgproxy/imgproxy/v3/imagedata"
 	"github.com/imgproxy/imgproxy/v3/imath"
+	"github.com/imgproxy/imgproxy/v3/metrics"
 	"github.com/imgproxy/imgproxy/v3/options"
 	"github.com/imgproxy/imgproxy/v3/vips"
 )
 
-func cropImage(img *vips.Image, cropWidth, cropHeight int, gravity *options.GravityOptions) error {
+// cropImage crops the image to the provided size using the gravity
+// and returns the cropped area. The area is empty if nothing was cropped.
+// When overflow is true, gravity offsets can move the crop area partly
+// outside of the image, the uncovered area becomes transparent
+func cropImage(img *vips.Image, cropWidth, cropHeight int, gravity *options.GravityOptions, overflow bool) (image.Rectangle, error) {
 	if cropWidth == 0 && cropHeight == 0 {
-		return nil
+		return image.Rectangle{}, nil
 	}
 
 	imgWidth, imgHeight := img.Width(), img.Height()
@@ -17,46 +24,202 @@ func cropImage(img *vips.Image, cropWidth, cropHeight int, gravity *options.Grav
 	cropWidth = imath.MinNonZero(cropWidth, imgWidth)
 	cropHeight = imath.MinNonZero(cropHeight, imgHeight)
 
-	if cropWidth >= imgWidth && cropHeight >= imgHeight {
+	if cropWidth >= imgWidth && cropHeight >= imgHeight && !overflow {
+		return image.Rectangle{}, nil
+	}
+
+	if gravity.Type == options.GravitySmart && gravity.Strategy != options.SmartCropStrategyUnknown {
+		return smartCropImage(img, cropWidth, cropHeight, gravity)
+	}
+
+	if gravity.Type == options.GravitySmart && int(gravity.X) + cropWidth <= imgWidth && int(gravity.Y) + cropHeight <= imgHeight {
+		rect := image.Rect(int(gravity.X), int(gravity.Y), int(gravity.X)+cropWidth, int(gravity.Y)+cropHeight)
+		return rect, img.Crop(rect.Min.X, rect.Min.Y, cropWidth, cropHeight)
+	}
+
+	if gravity.Type == options.GravityAlpha {
+		alphaGravity, err := calcAlphaGravity(img)
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		gravity = &alphaGravity
+	}
+
+	if gravity.Type == options.GravityObject {
+		objGravity, ok, err := calcObjectGravity(img, gravity.Classes)
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+
+		// Fall back to the smart crop if no objects were detected
+		if !ok {
+			return smartCropImage(img, cropWidth, cropHeight, &options.GravityOptions{
+				Type:     options.GravitySmart,
+				Strategy: options.SmartCropStrategyAttention,
+			})
+		}
+
+		gravity = &objGravity
+	}
+
+	left, top := calcPosition(imgWidth, imgHeight, cropWidth, cropHeight, gravity, overflow)
+	rect := image.Rect(left, top, left+cropWidth, top+cropHeight)
+
+	if overflow {
+		return rect, overflowCropImage(img, left, top, cropWidth, cropHeight)
+	}
+
+	return rect, img.Crop(left, top, cropWidth, cropHeight)
+}
+
+// overflowCropImage crops the area that can be partly outside of the image.
+// It crops the part of the area inside the image and embeds it into the area
+func overflowCropImage(img *vips.Image, left, top, cropWidth, cropHeight int) error {
+	imgWidth, imgHeight := img.Width(), img.Height()
+
+	if left == 0 && top == 0 && cropWidth == imgWidth && cropHeight == imgHeight {
 		return nil
 	}
 
-	// if gravity.Type == options.GravitySmart {
-	// 	if err := img.CopyMemory(); err != nil {
-	// 		return err
-	// 	}
-	// 	if err := img.SmartCrop(cropWidth, cropHeight, imgdata); err != nil {
-	// 		return err
-	// 	}
-	// 	// Applying additional modifications after smart crop causes SIGSEGV on Alpine
-	// 	// so we have to copy memory after it
-	// 	return img.CopyMemory()
-	// }
+	innerLeft, innerTop := imath.Max(left, 0), imath.Max(top, 0)
+	innerRight := imath.Min(left+cropWidth, imgWidth)
+	innerBottom := imath.Min(top+cropHeight, imgHeight)
 
+	if err := img.Crop(innerLeft, innerTop, innerRight-innerLeft, innerBottom-innerTop); err != nil {
+		return err
+	}
 
-	if gravity.Type == options.GravitySmart && int(gravity.X) + cropWidth <= imgWidth && int(gravity.Y) + cropHeight <= imgHeight {
-		return img.Crop(int(gravity.X), int(gravity.Y), cropWidth, cropHeight)
+	if innerLeft == left && innerTop == top && innerRight-innerLeft == cropWidth && innerBottom-innerTop == cropHeight {
+		return nil
+	}
+
+	return img.Embed(cropWidth, cropHeight, innerLeft-left, innerTop-top)
+}
+
+var smartCropStrategies = map[options.SmartCropStrategy]vips.Interesting{
+	options.SmartCropStrategyAttention: vips.InterestingAttention,
+	options.SmartCropStrategyEntropy:   vips.InterestingEntropy,
+}
+
+// smartCropImage crops the most interesting area of the image detected by libvips
+// and returns the cropped area. Gravity offsets nudge the detected area
+func smartCropImage(img *vips.Image, cropWidth, cropHeight int, gravity *options.GravityOptions) (image.Rectangle, error) {
+	metrics.IncrementSmartCropTotal()
+
+	// Detection reads the image, so we need to be able to read it once again
+	if err := img.CopyMemory(); err != nil {
+		return image.Rectangle{}, err
+	}
+
+	left, top, err := img.SmartCropPosition(cropWidth, cropHeight, smartCropStrategies[gravity.Strategy])
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	left = imath.Max(0, imath.Min(left+int(gravity.X), img.Width()-cropWidth))
+	top = imath.Max(0, imath.Min(top+int(gravity.Y), img.Height()-cropHeight))
+
+	if err = img.Crop(left, top, cropWidth, cropHeight); err != nil {
+		return image.Rectangle{}, err
+	}
+
+	// Applying additional modifications after smart crop causes SIGSEGV on Alpine
+	// so we have to copy memory after it
+	return image.Rect(left, top, left+cropWidth, top+cropHeight), img.CopyMemory()
+}
+
+// smartCropFallback returns the gravity used when the smart crop area
+// can't be detected. The center gravity is used by default
+func smartCropFallback(gravity *options.GravityOptions) options.GravityOptions {
+	if gravity.Fallback == options.GravityUnknown {
+		return options.GravityOptions{Type: options.GravityCenter}
+	}
+
+	return options.GravityOptions{Type: gravity.Fallback}
+}
+
+func calcAlphaGravity(img *vips.Image) (options.GravityOptions, error) {
+	x, y, ok, err := img.AlphaCentroid()
+	if err != nil {
+		return options.GravityOptions{}, err
+	}
+
+	// Images without alpha or with uniform alpha fall back to the center
+	if !ok {
+		return options.GravityOptions{Type: options.GravityCenter}, nil
+	}
+
+	return options.GravityOptions{Type: options.GravityFocusPoint, X: x, Y: y}, nil
+}
+
+// overflowingCrop crops the image allowing the crop overflow if requested.
+// The area outside of the image is transparent. If the image had no alpha,
+// we fill the area with the background color instead
+func overflowingCrop(pctx *pipelineContext, img *vips.Image, width, height int, gravity *options.GravityOptions, po *options.ProcessingOptions) error {
+	hadAlpha := img.HasAlpha()
+
+	rect, err := cropImage(img, width, height, gravity, po.CropOverflow)
+	if err != nil {
+		return err
+	}
+
+	if r := cropReportFromContext(pctx.ctx); r != nil && !rect.Empty() {
+		r.crop = rect
+	}
+
+	if po.CropOverflow && !hadAlpha && img.HasAlpha() {
+		return img.Flatten(po.Background)
 	}
 
-	left, top := calcPosition(imgWidth, imgHeight, cropWidth, cropHeight, gravity, false)
-	return img.Crop(left, top, cropWidth, cropHeight)
+	return nil
 }
 
 func crop(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
 	width, height := pctx.cropWidth, pctx.cropHeight
 
 	opts := pctx.cropGravity
-	opts.RotateAndFlip(pctx.angle, pctx.flip)
-	opts.RotateAndFlip(po.Rotate, false)
+	rotateAndFlipGravity(&opts, pctx, po)
 
 	if (pctx.angle+po.Rotate)%180 == 90 {
 		width, height = height, width
 	}
 
-	return cropImage(img, width, height, &opts)
+	return overflowingCrop(pctx, img, width, height, &opts, po)
+}
+
+func cropAfterScale(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
+	if !pctx.cropAfterScale {
+		return nil
+	}
+
+	// The image is not rotated yet, so we need to swap its dimensions
+	// to calculate the crop size in the resulting orientation
+	rotated := (pctx.angle+po.Rotate)%180 == 90
+
+	imgWidth, imgHeight := img.Width(), img.Height()
+	if rotated {
+		imgWidth, imgHeight = imgHeight, imgWidth
+	}
+
+	width := calcCropSize(imgWidth, po.Crop.Width)
+	height := calcCropSize(imgHeight, po.Crop.Height)
+
+	// The crop gravity is resolved the same way as for the crop before scaling
+	opts := pctx.resultCropGravity
+	rotateAndFlipGravity(&opts, pctx, po)
+
+	if rotated {
+		width, height = height, width
+	}
+
+	return overflowingCrop(pctx, img, width, height, &opts, po)
 }
 
 func cropToResult(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
+	if po.SkipResultCrop {
+		return nil
+	}
+
 	// Crop image to the result size
 	resultWidth, resultHeight := resultSize(po)
 
@@ -72,5 +235,10 @@ func cropToResult(pctx *pipelineContext, img *vips.Image, po *options.Processing
 		}
 	}
 
-	return cropImage(img, resultWidth, resultHeight, &po.Gravity)
+	rect, err := cropImage(img, resultWidth, resultHeight, &po.Gravity, false)
+	if r := cropReportFromContext(pctx.ctx); r != nil && err == nil && !rect.Empty() {
+		r.resultCrop = rect
+	}
+
+	return err
 }
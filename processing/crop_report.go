@@ -0,0 +1,50 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/imgproxy/imgproxy/v3/imagedata"
+)
+
+type cropReportCtxKey struct{}
+
+// cropReport holds the areas cut from the image during processing.
+// Each area is set in the coordinates of the intermediate image it was cut from.
+// These aren't mapped back to the source image since trimming, scale-on-load,
+// and orientation are applied by then
+type cropReport struct {
+	// crop is the area cut by the crop processing option
+	crop image.Rectangle
+	// resultCrop is the area cut to fit the result size
+	resultCrop image.Rectangle
+}
+
+func withCropReport(ctx context.Context) (context.Context, *cropReport) {
+	r := new(cropReport)
+	return context.WithValue(ctx, cropReportCtxKey{}, r), r
+}
+
+func cropReportFromContext(ctx context.Context) *cropReport {
+	r, _ := ctx.Value(cropReportCtxKey{}).(*cropReport)
+	return r
+}
+
+func formatCropRect(rect image.Rectangle) string {
+	return fmt.Sprintf("%d,%d,%d,%d", rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+}
+
+func setCropHeaders(imgdata *imagedata.ImageData, r *cropReport) {
+	if r == nil {
+		return
+	}
+
+	if !r.crop.Empty() {
+		imgdata.Headers["X-Crop-Rectangle"] = formatCropRect(r.crop)
+	}
+
+	if !r.resultCrop.Empty() {
+		imgdata.Headers["X-Result-Crop-Rectangle"] = formatCropRect(r.resultCrop)
+	}
+}
@@ -0,0 +1,25 @@
+package processing
+
+import (
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/imagedata"
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+// decode decodes the source image to memory within the decode timeout.
+// libvips loads images lazily, so without this the decoding happens during
+// the following steps and is limited only by the request timeout
+func decode(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
+	if config.DecodeTimeout <= 0 || pctx.decoded {
+		return nil
+	}
+
+	if err := img.Decode(config.DecodeTimeout); err != nil {
+		return err
+	}
+
+	pctx.decoded = true
+
+	return nil
+}
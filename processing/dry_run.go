@@ -0,0 +1,183 @@
+package processing
+
+import (
+	"context"
+	"math"
+	"runtime"
+
+	"github.com/imgproxy/imgproxy/v3/imagedata"
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+// DryRunResult describes the source image and the result of its processing
+type DryRunResult struct {
+	SourceFormat   imagetype.Type
+	SourceWidth    int
+	SourceHeight   int
+	SourceFrames   int
+	SourceHasAlpha bool
+
+	// ResultWidth and ResultHeight are the size of a single frame of the result
+	ResultFormat   imagetype.Type
+	ResultWidth    int
+	ResultHeight   int
+	ResultFrames   int
+	ResultHasAlpha bool
+
+	// Passthrough is true when the source image would be served as is
+	Passthrough bool
+
+	// EstimatedSize is the rough estimate of the result size in bytes.
+	// It's the exact size of the source image when it's served as is
+	EstimatedSize int
+}
+
+// estimatedBitsPerPixel is the rough number of bits per pixel of an encoded photo.
+// The values for the lossy formats are for the quality of 80
+var estimatedBitsPerPixel = map[imagetype.Type]float64{
+	imagetype.JPEG: 2,
+	imagetype.WEBP: 1.4,
+	imagetype.AVIF: 0.9,
+	imagetype.HEIC: 1,
+	imagetype.PNG:  14,
+	imagetype.GIF:  5,
+	imagetype.ICO:  32,
+	imagetype.BMP:  24,
+	imagetype.TIFF: 24,
+}
+
+// estimateSize roughly estimates the size of the encoded result in bytes
+// using the bits per pixel for the format and the quality
+func estimateSize(format imagetype.Type, quality, width, height, frames int, hasAlpha bool) int {
+	bpp, ok := estimatedBitsPerPixel[format]
+	if !ok {
+		return 0
+	}
+
+	switch format {
+	case imagetype.JPEG, imagetype.WEBP, imagetype.AVIF, imagetype.HEIC:
+		bpp *= float64(quality) / 80
+		if hasAlpha && format != imagetype.JPEG {
+			bpp *= 1.25
+		}
+	case imagetype.PNG, imagetype.BMP, imagetype.TIFF:
+		if hasAlpha {
+			bpp *= 4.0 / 3.0
+		}
+	}
+
+	return int(math.Round(bpp * float64(width) * float64(height) * float64(frames) / 8))
+}
+
+// DryRunSource reads the source image info from its header without decoding it.
+// It's used when the source image would be served as is
+func DryRunSource(imgdata *imagedata.ImageData) (*DryRunResult, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer vips.Cleanup()
+
+	img := new(vips.Image)
+	defer img.Clear()
+
+	if err := img.Load(imgdata, 1, 1.0, 1); err != nil {
+		return nil, err
+	}
+
+	width, height := getImageSize(img)
+	frames, _ := img.GetIntDefault("n-pages", 1)
+
+	return &DryRunResult{
+		SourceFormat:   imgdata.Type,
+		SourceWidth:    width,
+		SourceHeight:   height,
+		SourceFrames:   frames,
+		SourceHasAlpha: img.HasAlpha(),
+
+		ResultFormat:   imgdata.Type,
+		ResultWidth:    width,
+		ResultHeight:   height,
+		ResultFrames:   frames,
+		ResultHasAlpha: img.HasAlpha(),
+
+		Passthrough:   true,
+		EstimatedSize: len(imgdata.Data),
+	}, nil
+}
+
+// DryRun processes the image the same way ProcessImage does but doesn't encode the result.
+// Passthrough of the source image that is smaller than the result can't be detected
+// without encoding, so it's not reported
+func DryRun(ctx context.Context, imgdata *imagedata.ImageData, po *options.ProcessingOptions) (*DryRunResult, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer vips.Cleanup()
+
+	ctx, _ = withBudget(ctx, po)
+
+	img := new(vips.Image)
+	defer img.Clear()
+
+	thumbnail, err := loadImage(img, imgdata, po)
+	if err != nil {
+		return nil, err
+	}
+
+	originWidth, originHeight := getImageSize(img)
+	originPages, _ := img.GetIntDefault("n-pages", 1)
+	originHasProfile := img.HasColourProfile()
+
+	res := DryRunResult{
+		SourceFormat:   imgdata.Type,
+		SourceWidth:    originWidth,
+		SourceHeight:   originHeight,
+		SourceFrames:   originPages,
+		SourceHasAlpha: img.HasAlpha(),
+	}
+
+	if err := selectFormat(img, imgdata, po); err != nil {
+		return nil, err
+	}
+
+	if canSkipProcessing(po, imgdata, img, originWidth, originHeight, thumbnail) {
+		res.ResultFormat = imgdata.Type
+		res.ResultWidth = originWidth
+		res.ResultHeight = originHeight
+		res.ResultFrames = 1
+		res.ResultHasAlpha = res.SourceHasAlpha
+		res.Passthrough = true
+		res.EstimatedSize = len(imgdata.Data)
+
+		return &res, nil
+	}
+
+	if err := transformImage(ctx, img, imgdata, po); err != nil {
+		return nil, err
+	}
+
+	res.ResultFrames = 1
+	if img.IsAnimated() {
+		res.ResultFrames, _ = img.GetIntDefault("n-pages", 1)
+	}
+
+	res.ResultFormat = po.Format
+	res.ResultWidth = img.Width()
+	res.ResultHeight = img.Height() / res.ResultFrames
+	res.ResultHasAlpha = img.HasAlpha()
+	res.Passthrough = canPassthroughGif(po, imgdata, img, originWidth, originHeight, originPages, originHasProfile)
+
+	if res.Passthrough {
+		res.ResultFormat = imgdata.Type
+		res.EstimatedSize = len(imgdata.Data)
+	} else {
+		res.EstimatedSize = estimateSize(
+			res.ResultFormat, po.GetQuality(),
+			res.ResultWidth, res.ResultHeight, res.ResultFrames, res.ResultHasAlpha,
+		)
+	}
+
+	return &res, nil
+}
@@ -83,19 +83,25 @@ func stripXMP(img *vips.Image) []byte {
 }
 
 func finalize(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
-	if po.StripMetadata {
+	if po.StripMetadata || po.Reproducible {
 		var iptcData, xmpData []byte
 
-		if po.KeepCopyright {
+		// Metadata may contain timestamps, so we strip it completely
+		// in the reproducible mode
+		keepCopyright := po.KeepCopyright && !po.Reproducible
+
+		if keepCopyright {
 			iptcData = stripIPTC(img)
 			xmpData = stripXMP(img)
 		}
 
-		if err := img.Strip(po.KeepCopyright); err != nil {
+		// The EXIF orientation is kept when auto-rotation is disabled,
+		// otherwise the image would be displayed with the wrong orientation
+		if err := img.Strip(keepCopyright, !po.AutoRotate); err != nil {
 			return err
 		}
 
-		if po.KeepCopyright {
+		if keepCopyright {
 			if len(iptcData) > 0 {
 				img.SetBlob("iptc-data", iptcData)
 			}
@@ -106,5 +112,11 @@ func finalize(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOpti
 		}
 	}
 
+	if po.PhysicalSize.Enabled() {
+		if err := img.SetResolution(po.PhysicalSize.Dpi); err != nil {
+			return err
+		}
+	}
+
 	return img.CopyMemory()
 }
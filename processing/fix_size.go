@@ -1,6 +1,7 @@
 package processing
 
 import (
+	"fmt"
 	"math"
 
 	"github.com/imgproxy/imgproxy/v3/imagedata"
@@ -18,7 +19,7 @@ const (
 	icoMaxDimension  = 256.0
 )
 
-func fixWebpSize(img *vips.Image) error {
+func fixWebpSize(img *vips.Image, po *options.ProcessingOptions) error {
 	webpLimitShrink := float64(imath.Max(img.Width(), img.Height())) / webpMaxDimension
 
 	if webpLimitShrink <= 1.0 {
@@ -26,16 +27,18 @@ func fixWebpSize(img *vips.Image) error {
 	}
 
 	scale := 1.0 / webpLimitShrink
-	if err := img.Resize(scale, scale); err != nil {
+	if err := img.Resize(scale, scale, vips.KernelCubic); err != nil {
 		return err
 	}
 
-	log.Warningf("WebP dimension size is limited to %d. The image is rescaled to %dx%d", int(webpMaxDimension), img.Width(), img.Height())
+	w := fmt.Sprintf("WebP dimension size is limited to %d. The image is rescaled to %dx%d", int(webpMaxDimension), img.Width(), img.Height())
+	log.Warning(w)
+	po.AddWarning(w)
 
 	return img.CopyMemory()
 }
 
-func fixGifSize(img *vips.Image) error {
+func fixGifSize(img *vips.Image, po *options.ProcessingOptions) error {
 	gifMaxResolution := float64(vips.GifResolutionLimit())
 	gifResLimitShrink := float64(img.Width()*img.Height()) / gifMaxResolution
 	gifDimLimitShrink := float64(imath.Max(img.Width(), img.Height())) / gifMaxDimension
@@ -47,16 +50,18 @@ func fixGifSize(img *vips.Image) error {
 	}
 
 	scale := math.Sqrt(1.0 / gifLimitShrink)
-	if err := img.Resize(scale, scale); err != nil {
+	if err := img.Resize(scale, scale, vips.KernelCubic); err != nil {
 		return err
 	}
 
-	log.Warningf("GIF resolution is limited to %d and dimension size is limited to %d. The image is rescaled to %dx%d", int(gifMaxResolution), int(gifMaxDimension), img.Width(), img.Height())
+	w := fmt.Sprintf("GIF resolution is limited to %d and dimension size is limited to %d. The image is rescaled to %dx%d", int(gifMaxResolution), int(gifMaxDimension), img.Width(), img.Height())
+	log.Warning(w)
+	po.AddWarning(w)
 
 	return img.CopyMemory()
 }
 
-func fixIcoSize(img *vips.Image) error {
+func fixIcoSize(img *vips.Image, po *options.ProcessingOptions) error {
 	icoLimitShrink := float64(imath.Max(img.Width(), img.Height())) / icoMaxDimension
 
 	if icoLimitShrink <= 1.0 {
@@ -64,11 +69,13 @@ func fixIcoSize(img *vips.Image) error {
 	}
 
 	scale := 1.0 / icoLimitShrink
-	if err := img.Resize(scale, scale); err != nil {
+	if err := img.Resize(scale, scale, vips.KernelCubic); err != nil {
 		return err
 	}
 
-	log.Warningf("ICO dimension size is limited to %d. The image is rescaled to %dx%d", int(icoMaxDimension), img.Width(), img.Height())
+	w := fmt.Sprintf("ICO dimension size is limited to %d. The image is rescaled to %dx%d", int(icoMaxDimension), img.Width(), img.Height())
+	log.Warning(w)
+	po.AddWarning(w)
 
 	return img.CopyMemory()
 }
@@ -76,11 +83,11 @@ func fixIcoSize(img *vips.Image) error {
 func fixSize(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
 	switch po.Format {
 	case imagetype.WEBP:
-		return fixWebpSize(img)
+		return fixWebpSize(img, po)
 	case imagetype.GIF:
-		return fixGifSize(img)
+		return fixGifSize(img, po)
 	case imagetype.ICO:
-		return fixIcoSize(img)
+		return fixIcoSize(img, po)
 	}
 
 	return nil
@@ -7,7 +7,8 @@ import (
 )
 
 func flatten(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
-	if !po.Flatten && po.Format.SupportsAlpha() {
+	// Alpha mask requires alpha to be preserved
+	if po.AlphaMask || (!po.Flatten && po.Format.SupportsAlpha()) {
 		return nil
 	}
 
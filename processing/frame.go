@@ -0,0 +1,90 @@
+package processing
+
+import (
+	"fmt"
+
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/security"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+// selectFrame replaces the animated image with a single frame of it
+func selectFrame(img *vips.Image, po *options.ProcessingOptions) error {
+	imgWidth := img.Width()
+
+	frameHeight, err := img.GetInt("page-height")
+	if err != nil {
+		return err
+	}
+
+	// All the frames are loaded to select from, so they are limited
+	// by the source resolution only, not by the max animation frames
+	framesCount := img.Height() / frameHeight
+
+	if err = security.CheckDimensionsLimit(imgWidth, frameHeight*framesCount, po.MaxSrcResolution); err != nil {
+		return err
+	}
+
+	// Animated images are loaded sequentially, but we may need to read
+	// the frames more than once
+	if err = img.CopyMemory(); err != nil {
+		return err
+	}
+
+	index := po.Frame.Index
+
+	if po.Frame.Best {
+		if index, err = findSharpestFrame(img, framesCount, frameHeight); err != nil {
+			return err
+		}
+	} else if index >= framesCount {
+		index = framesCount - 1
+		po.AddWarning(fmt.Sprintf("The image has only %d frames. The last frame is used", framesCount))
+	}
+
+	frame := new(vips.Image)
+	defer frame.Clear()
+
+	if err = img.Extract(frame, 0, index*frameHeight, imgWidth, frameHeight); err != nil {
+		return err
+	}
+
+	img.Swap(frame)
+
+	img.SetInt("page-height", frameHeight)
+	img.SetInt("n-pages", 1)
+
+	return nil
+}
+
+// findSharpestFrame returns the index of the frame with the greatest sharpness.
+// The first frame wins in case of a tie
+func findSharpestFrame(img *vips.Image, framesCount, frameHeight int) (int, error) {
+	best := 0
+	bestSharpness := -1.0
+
+	for i := 0; i < framesCount; i++ {
+		sharpness, err := frameSharpness(img, i, frameHeight)
+		if err != nil {
+			return 0, err
+		}
+
+		if sharpness > bestSharpness {
+			best = i
+			bestSharpness = sharpness
+		}
+	}
+
+	return best, nil
+}
+
+func frameSharpness(img *vips.Image, index, frameHeight int) (float64, error) {
+	frame := new(vips.Image)
+	defer frame.Clear()
+
+	if err := img.Extract(frame, 0, index*frameHeight, img.Width(), frameHeight); err != nil {
+		return 0, err
+	}
+
+	return frame.Sharpness()
+}
@@ -0,0 +1,53 @@
+package processing
+
+import (
+	"runtime"
+
+	"github.com/imgproxy/imgproxy/v3/imagedata"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+// ImageStats contains the statistics of the image luminance
+type ImageStats struct {
+	// Sharpness is the variance of the Laplacian. Blurry images have low values
+	Sharpness float64
+	// Deviation is the standard deviation. Images that are mostly one color
+	// have values close to zero
+	Deviation float64
+}
+
+// CalcImageStats calculates the statistics of the image luminance.
+// Only the first frame of animated images is used.
+// The image is converted to 8-bit sRGB first, so stats of different images are comparable
+func CalcImageStats(imgdata *imagedata.ImageData) (*ImageStats, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer vips.Cleanup()
+
+	img := new(vips.Image)
+	defer img.Clear()
+
+	if err := img.Load(imgdata, 1, 1.0, 1); err != nil {
+		return nil, err
+	}
+
+	if err := img.RgbColourspace(); err != nil {
+		return nil, err
+	}
+
+	var (
+		stats ImageStats
+		err   error
+	)
+
+	if stats.Sharpness, err = img.LaplacianVariance(); err != nil {
+		return nil, err
+	}
+
+	if stats.Deviation, err = img.LuminanceDeviation(); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
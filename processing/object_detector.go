@@ -0,0 +1,82 @@
+package processing
+
+import (
+	"image"
+	"sync"
+
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+// ObjectDetector detects objects of the given classes on the image.
+// When classes are empty, objects of all the known classes should be detected.
+// Detect returns the bounding boxes of the detected objects
+// in the coordinates of the provided image
+type ObjectDetector interface {
+	Detect(img *vips.Image, classes []string) ([]image.Rectangle, error)
+}
+
+// noopObjectDetector doesn't detect anything, so the object gravity
+// falls back to the smart crop
+type noopObjectDetector struct{}
+
+func (noopObjectDetector) Detect(img *vips.Image, classes []string) ([]image.Rectangle, error) {
+	return nil, nil
+}
+
+var (
+	objectDetector      ObjectDetector = noopObjectDetector{}
+	objectDetectorMutex sync.RWMutex
+)
+
+// SetObjectDetector sets the detector used by the object gravity.
+// Passing nil restores the default detector that doesn't detect anything
+func SetObjectDetector(d ObjectDetector) {
+	objectDetectorMutex.Lock()
+	defer objectDetectorMutex.Unlock()
+
+	if d == nil {
+		d = noopObjectDetector{}
+	}
+
+	objectDetector = d
+}
+
+func getObjectDetector() ObjectDetector {
+	objectDetectorMutex.RLock()
+	defer objectDetectorMutex.RUnlock()
+
+	return objectDetector
+}
+
+// calcObjectGravity returns the focus point gravity pointing to the center
+// of the area containing all the detected objects.
+// ok is false when no objects were detected
+func calcObjectGravity(img *vips.Image, classes []string) (gravity options.GravityOptions, ok bool, err error) {
+	// Detection reads the image, so we need to be able to read it once again
+	if err = img.CopyMemory(); err != nil {
+		return
+	}
+
+	rects, err := getObjectDetector().Detect(img, classes)
+	if err != nil {
+		return
+	}
+
+	bounds := image.Rect(0, 0, img.Width(), img.Height())
+
+	var area image.Rectangle
+	for _, r := range rects {
+		area = area.Union(r.Intersect(bounds))
+	}
+
+	if area.Empty() {
+		return
+	}
+
+	return options.GravityOptions{
+		Type: options.GravityFocusPoint,
+		X:    float64(area.Min.X+area.Max.X) / 2 / float64(bounds.Dx()),
+		Y:    float64(area.Min.Y+area.Max.Y) / 2 / float64(bounds.Dy()),
+	}, true, nil
+}
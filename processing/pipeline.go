@@ -16,15 +16,20 @@ type pipelineContext struct {
 	imgtype imagetype.Type
 
 	trimmed bool
+	decoded bool
 
 	srcWidth  int
 	srcHeight int
 	angle     int
 	flip      bool
 
-	cropWidth   int
-	cropHeight  int
-	cropGravity options.GravityOptions
+	cropWidth      int
+	cropHeight     int
+	cropGravity    options.GravityOptions
+	cropAfterScale bool
+	// resultCropGravity is the crop gravity used after scaling.
+	// Unlike cropGravity, its offsets aren't scaled on load
+	resultCropGravity options.GravityOptions
 
 	wscale float64
 	hscale float64
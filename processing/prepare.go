@@ -1,6 +1,7 @@
 package processing
 
 import (
+	"fmt"
 	"math"
 	"image"
 	"bytes"
@@ -8,6 +9,7 @@ import (
 	"github.com/imgproxy/imgproxy/v3/imagedata"
 	"github.com/imgproxy/imgproxy/v3/imagetype"
 	"github.com/imgproxy/imgproxy/v3/imath"
+	"github.com/imgproxy/imgproxy/v3/metrics"
 	"github.com/imgproxy/imgproxy/v3/options"
 	"github.com/imgproxy/imgproxy/v3/vips"
 	"github.com/muesli/smartcrop"
@@ -45,13 +47,28 @@ func extractMeta(img *vips.Image, baseAngle int, useOrientation bool) (int, int,
 	return width, height, angle, flip
 }
 
+// smartResizeType returns fit when the source and the resulting aspect ratios
+// differ by no more than the tolerance, and fill otherwise
+func smartResizeType(srcW, srcH, dstW, dstH, tolerance float64) options.ResizeType {
+	srcRatio := srcW / srcH
+	dstRatio := dstW / dstH
+
+	if math.Max(srcRatio, dstRatio)/math.Min(srcRatio, dstRatio)-1 <= tolerance {
+		return options.ResizeFit
+	}
+
+	return options.ResizeFill
+}
+
 func calcScale(width, height int, po *options.ProcessingOptions, imgtype imagetype.Type) (float64, float64) {
 	var wshrink, hshrink float64
 
+	targetWidth, targetHeight := po.TargetSize()
+
 	srcW, srcH := float64(width), float64(height)
-	dstW, dstH := float64(po.Width), float64(po.Height)
+	dstW, dstH := float64(targetWidth), float64(targetHeight)
 
-	if po.Width == 0 {
+	if targetWidth == 0 {
 		dstW = srcW
 	}
 
@@ -61,7 +78,7 @@ func calcScale(width, height int, po *options.ProcessingOptions, imgtype imagety
 		wshrink = srcW / dstW
 	}
 
-	if po.Height == 0 {
+	if targetHeight == 0 {
 		dstH = srcH
 	}
 
@@ -88,10 +105,14 @@ func calcScale(width, height int, po *options.ProcessingOptions, imgtype imagety
 			}
 		}
 
+		if rt == options.ResizeSmart {
+			rt = smartResizeType(srcW, srcH, dstW, dstH, po.ResizingTolerance)
+		}
+
 		switch {
-		case po.Width == 0 && rt != options.ResizeForce:
+		case targetWidth == 0 && rt != options.ResizeForce:
 			wshrink = hshrink
-		case po.Height == 0 && rt != options.ResizeForce:
+		case targetHeight == 0 && rt != options.ResizeForce:
 			hshrink = wshrink
 		case rt == options.ResizeFit:
 			wshrink = math.Max(wshrink, hshrink)
@@ -152,36 +173,124 @@ func calcCropSize(orig int, crop float64) int {
 	}
 }
 
+// analyzeSmartCrop returns true if the smart crop area should be detected
+// before processing. Smart gravity with a strategy is handled by libvips while cropping
+func analyzeSmartCrop(g *options.GravityOptions) bool {
+	return g.Type == options.GravitySmart && g.Strategy == options.SmartCropStrategyUnknown
+}
+
+// detectSmartCrop detects the most interesting area of the source image
+// and sets it as the crop area. Returns false if the area can't be detected
+func detectSmartCrop(pctx *pipelineContext, po *options.ProcessingOptions, imgdata *imagedata.ImageData) bool {
+	if imgdata == nil {
+		return false
+	}
+
+	reader := bytes.NewReader(imgdata.Data)
+	img_decoded, _, err := image.Decode(reader)
+	if err != nil {
+		return false
+	}
+
+	analyzer := smartcrop.NewAnalyzer(nfnt.NewDefaultResizer())
+	targetWidth, targetHeight := po.TargetSize()
+	topCrop, err := analyzer.FindBestCrop(img_decoded, targetWidth, targetHeight)
+	if err != nil {
+		return false
+	}
+
+	// Pad the detected area with the margin but keep it inside the image
+	minX := imath.Max(0, topCrop.Min.X-po.SmartCropMargin)
+	minY := imath.Max(0, topCrop.Min.Y-po.SmartCropMargin)
+	maxX := imath.MinNonZero(img_decoded.Bounds().Dx(), topCrop.Max.X+po.SmartCropMargin)
+	maxY := imath.MinNonZero(img_decoded.Bounds().Dy(), topCrop.Max.Y+po.SmartCropMargin)
+	po.Gravity.X = float64(minX)
+	po.Gravity.Y = float64(minY)
+	po.Crop.Width = float64(maxX - minX)
+	po.Crop.Height = float64(maxY - minY)
+	pctx.cropGravity = po.Gravity
+
+	return true
+}
+
 func prepare(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
 	pctx.imgtype = imagetype.Unknown
 	if imgdata != nil {
 		pctx.imgtype = imgdata.Type
 	}
 
-	if po.Gravity.Type == options.GravitySmart {
-		reader := bytes.NewReader(imgdata.Data)
-		img_decoded, _, _ := image.Decode(reader)
-		analyzer := smartcrop.NewAnalyzer(nfnt.NewDefaultResizer())
-		topCrop, _ := analyzer.FindBestCrop(img_decoded, po.Width, po.Height)
+	if analyzeSmartCrop(&po.Gravity) {
+		metrics.IncrementSmartCropTotal()
+
+		if !detectSmartCrop(pctx, po, imgdata) {
+			// The smart crop area can't be detected, so we fall back to the requested gravity
+			metrics.IncrementSmartCropFallbackTotal()
 
-		maxX := imath.MinNonZero(img_decoded.Bounds().Dx(), topCrop.Max.X)
-		maxY := imath.MinNonZero(img_decoded.Bounds().Dy(), topCrop.Max.Y)
-		po.Gravity.X = float64(topCrop.Min.X)
-		po.Gravity.Y = float64(topCrop.Min.Y)
-		po.Crop.Width = float64(maxX - topCrop.Min.X)
-		po.Crop.Height = float64(maxY - topCrop.Min.Y)
-		pctx.cropGravity = po.Gravity
+			if analyzeSmartCrop(&pctx.cropGravity) {
+				pctx.cropGravity = smartCropFallback(&pctx.cropGravity)
+			}
+			po.Gravity = smartCropFallback(&po.Gravity)
+		}
 	}
 
 	pctx.srcWidth, pctx.srcHeight, pctx.angle, pctx.flip = extractMeta(img, po.Rotate, po.AutoRotate)
 
-	pctx.cropWidth = calcCropSize(pctx.srcWidth, po.Crop.Width)
-	pctx.cropHeight = calcCropSize(pctx.srcHeight, po.Crop.Height)
+	// Source size hints are used to reject too big images before downloading them.
+	// The image header is already loaded here, so the probed size is always used.
+	// We only report the wrong hints since they may reject images by mistake
+	if !pctx.trimmed && po.SourceWidth > 0 && po.SourceHeight > 0 &&
+		(po.SourceWidth != img.Width() || po.SourceHeight != img.Height()) {
+		po.AddWarning(fmt.Sprintf(
+			"Source size hint %dx%d doesn't match the source image size %dx%d",
+			po.SourceWidth, po.SourceHeight, img.Width(), img.Height(),
+		))
+	}
+
+	// Smart crop area is calculated for the source image,
+	// so we can't apply it after scaling or treat it as set in the output pixels
+	smartCrop := analyzeSmartCrop(&po.Gravity)
+	cropInOutput := po.CropBasis == options.CropBasisOutput && !smartCrop
+
+	pctx.cropAfterScale = po.CropAfterResize && po.CropBasis == options.CropBasisUnknown && !smartCrop
+
+	if pctx.cropAfterScale {
+		pctx.resultCropGravity = pctx.cropGravity
+	} else if !cropInOutput {
+		pctx.cropWidth = calcCropSize(pctx.srcWidth, po.Crop.Width)
+		pctx.cropHeight = calcCropSize(pctx.srcHeight, po.Crop.Height)
+	}
 
 	widthToScale := imath.MinNonZero(pctx.cropWidth, pctx.srcWidth)
 	heightToScale := imath.MinNonZero(pctx.cropHeight, pctx.srcHeight)
 
 	pctx.wscale, pctx.hscale = calcScale(widthToScale, heightToScale, po, pctx.imgtype)
 
+	if cropInOutput {
+		convertOutputCrop(pctx, po)
+	}
+
 	return nil
 }
+
+// convertOutputCrop converts the crop set in the resized image pixels
+// to the source image pixels. The scale is calculated for the whole image,
+// so cropping the converted area before resizing gives the same result
+// as cropping the resized image. Both the source size and the scale are set
+// for the resulting orientation, the same as the crop
+func convertOutputCrop(pctx *pipelineContext, po *options.ProcessingOptions) {
+	outWidth := imath.Scale(pctx.srcWidth, pctx.wscale)
+	outHeight := imath.Scale(pctx.srcHeight, pctx.hscale)
+
+	if w := calcCropSize(outWidth, po.Crop.Width); w > 0 {
+		pctx.cropWidth = imath.Max(1, imath.Shrink(w, pctx.wscale))
+	}
+	if h := calcCropSize(outHeight, po.Crop.Height); h > 0 {
+		pctx.cropHeight = imath.Max(1, imath.Shrink(h, pctx.hscale))
+	}
+
+	// Focus point is relative to the image size, other gravities have offsets in pixels
+	if pctx.cropGravity.Type != options.GravityFocusPoint {
+		pctx.cropGravity.X /= pctx.wscale
+		pctx.cropGravity.Y /= pctx.hscale
+	}
+}
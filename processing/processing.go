@@ -13,6 +13,7 @@ import (
 	"github.com/imgproxy/imgproxy/v3/imagedata"
 	"github.com/imgproxy/imgproxy/v3/imagetype"
 	"github.com/imgproxy/imgproxy/v3/imath"
+	"github.com/imgproxy/imgproxy/v3/metrics"
 	"github.com/imgproxy/imgproxy/v3/options"
 	"github.com/imgproxy/imgproxy/v3/router"
 	"github.com/imgproxy/imgproxy/v3/security"
@@ -23,18 +24,23 @@ var mainPipeline = pipeline{
 	trim,
 	prepare,
 	scaleOnLoad,
+	decode,
 	importColorProfile,
 	crop,
 	scale,
+	cropAfterScale,
 	rotateAndFlip,
 	cropToResult,
 	applyFilters,
+	tile,
 	extend,
 	padding,
+	canvas,
 	fixSize,
 	flatten,
 	watermark,
 	exportColorProfile,
+	alphaMask,
 	finalize,
 }
 
@@ -64,6 +70,25 @@ func findBestFormat(srcType imagetype.Type, animated, expectAlpha bool) imagetyp
 	return config.PreferredFormats[0]
 }
 
+// defaultFormat returns the resulting format used when it's neither specified
+// in the URL nor negotiated by the Accept header
+func defaultFormat(srcType imagetype.Type, animated, expectAlpha bool) imagetype.Type {
+	if config.DefaultFormat == "source" && vips.SupportsSave(srcType) {
+		return srcType
+	}
+
+	// If the default format can't be saved, we fall back to the preferred formats
+	if t, ok := imagetype.Types[config.DefaultFormat]; ok && vips.SupportsSave(t) {
+		return t
+	}
+
+	if isImageTypePreferred(srcType) {
+		return srcType
+	}
+
+	return findBestFormat(srcType, animated, expectAlpha)
+}
+
 func ValidatePreferredFormats() error {
 	filtered := config.PreferredFormats[:0]
 
@@ -106,6 +131,7 @@ func getImageSize(img *vips.Image) (int, int) {
 func transformAnimated(ctx context.Context, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
 	if po.Trim.Enabled {
 		log.Warning("Trim is not supported for animated images")
+		po.AddWarning("Trim is not supported for animated images")
 		po.Trim.Enabled = false
 	}
 
@@ -116,10 +142,10 @@ func transformAnimated(ctx context.Context, img *vips.Image, po *options.Process
 		return err
 	}
 
-	framesCount := imath.Min(img.Height()/frameHeight, config.MaxAnimationFrames)
+	framesCount := imath.Min(img.Height()/frameHeight, po.MaxAnimationFrames)
 
 	// Double check dimensions because animated image has many frames
-	if err = security.CheckDimensions(imgWidth, frameHeight*framesCount); err != nil {
+	if err = security.CheckDimensionsLimit(imgWidth, frameHeight*framesCount, po.MaxSrcResolution); err != nil {
 		return err
 	}
 
@@ -141,9 +167,12 @@ func transformAnimated(ctx context.Context, img *vips.Image, po *options.Process
 		return err
 	}
 
-	watermarkEnabled := po.Watermark.Enabled
+	// Watermarks are applied to the whole animation after the frames are joined
+	watermarks := po.Watermarks()
+	watermarkOpts, extraWatermarks := po.Watermark, po.ExtraWatermarks
 	po.Watermark.Enabled = false
-	defer func() { po.Watermark.Enabled = watermarkEnabled }()
+	po.ExtraWatermarks = nil
+	defer func() { po.Watermark, po.ExtraWatermarks = watermarkOpts, extraWatermarks }()
 
 	frames := make([]*vips.Image, 0, framesCount)
 	defer func() {
@@ -154,7 +183,7 @@ func transformAnimated(ctx context.Context, img *vips.Image, po *options.Process
 		}
 	}()
 
-	for i := 0; i < framesCount; i++ {
+	for i := 0; i < framesCount; i += po.FrameStep.Step {
 		frame := new(vips.Image)
 
 		if err = img.Extract(frame, 0, i*frameHeight, imgWidth, frameHeight); err != nil {
@@ -162,18 +191,18 @@ func transformAnimated(ctx context.Context, img *vips.Image, po *options.Process
 		}
 
 		frames = append(frames, frame)
+	}
 
-		if err = mainPipeline.Run(ctx, frame, po, nil); err != nil {
-			return err
-		}
+	if err = processFrames(ctx, frames, po); err != nil {
+		return err
 	}
 
 	if err = img.Arrayjoin(frames); err != nil {
 		return err
 	}
 
-	if watermarkEnabled && imagedata.Watermark != nil {
-		if err = applyWatermark(img, imagedata.Watermark, &po.Watermark, framesCount); err != nil {
+	if len(watermarks) > 0 && imagedata.Watermark != nil {
+		if err = applyWatermarks(img, imagedata.Watermark, watermarks, po, len(frames)); err != nil {
 			return err
 		}
 	}
@@ -195,10 +224,14 @@ func transformAnimated(ctx context.Context, img *vips.Image, po *options.Process
 		delay = delay[:framesCount]
 	}
 
+	if po.FrameStep.Step > 1 {
+		delay = sampleDelays(delay, po.FrameStep.Step, po.FrameStep.PreserveDuration)
+	}
+
 	img.SetInt("page-height", frames[0].Height())
 	img.SetIntSlice("delay", delay)
 	img.SetInt("loop", loop)
-	img.SetInt("n-pages", framesCount)
+	img.SetInt("n-pages", len(frames))
 
 	return nil
 }
@@ -208,7 +241,7 @@ func saveImageToFitBytes(ctx context.Context, po *options.ProcessingOptions, img
 	quality := po.GetQuality()
 
 	for {
-		imgdata, err := img.Save(po.Format, quality)
+		imgdata, err := img.Save(po.Format, quality, po.SaveOptions())
 		if len(imgdata.Data) <= po.MaxBytes || quality <= 10 || err != nil {
 			return imgdata, err
 		}
@@ -228,84 +261,238 @@ func saveImageToFitBytes(ctx context.Context, po *options.ProcessingOptions, img
 			diff = 0.75
 		}
 		quality = int(float64(quality) * diff)
+
+		// Jump to the lowest quality to finish in time
+		if budget := budgetFromContext(ctx); quality > 10 && budget.nearlySpent(ctx) {
+			quality = 10
+			budget.degrade("max_bytes")
+		}
 	}
 }
 
-func ProcessImage(ctx context.Context, imgdata *imagedata.ImageData, po *options.ProcessingOptions) (*imagedata.ImageData, error) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+// canPassthroughSource checks if the source image can be served instead of the result.
+// This is possible only when the result has the same format and dimensions as the source
+// and no options that change pixels or metadata were applied.
+// The image may be already processed, so the source color profile presence is provided separately
+func canPassthroughSource(po *options.ProcessingOptions, imgdata *imagedata.ImageData, img *vips.Image, originWidth, originHeight int, originHasProfile bool) bool {
+	if po.Format != imgdata.Type {
+		return false
+	}
 
-	defer vips.Cleanup()
+	// The source image is served with all its metadata,
+	// so we can't serve it if the metadata should be stripped or changed
+	if po.StripMetadata ||
+		po.Reproducible ||
+		po.PhysicalSize.Enabled() ||
+		(po.StripColorProfile && originHasProfile) {
+		return false
+	}
+
+	if img.Width() != originWidth || img.Height() != originHeight {
+		return false
+	}
 
+	return po.Rotate == 0 &&
+		!po.Flip &&
+		!po.Flop &&
+		!po.Flatten &&
+		po.Blur == 0 &&
+		// The default sharpening compensates the softness of resizing,
+		// so it doesn't prevent passthrough of images that weren't resized
+		(po.Sharpen == 0 || po.DefaultSharpen) &&
+		po.Pixelate <= 1 &&
+		po.Edges.Strength == 0 &&
+		!po.Convolution.Enabled() &&
+		!po.AutoWhiteBalance &&
+		!po.Normalize.Enabled &&
+		po.Vignette.Strength == 0 &&
+		po.Grain.Amount == 0 &&
+		!po.AlphaMask &&
+		!po.Frame.Enabled &&
+		len(po.Watermarks()) == 0
+}
+
+// checkAnimationFramesLimit counts the animations that have more frames than we can process.
+// Such animations are truncated to the first frames. A limit of 1 means that animation
+// processing is disabled and animations are intentionally processed as still images,
+// so we don't count them. The frame option uses all the frames, so we don't count them either
+func checkAnimationFramesLimit(img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) {
+	if !imgdata.Type.SupportsAnimation() || po.MaxAnimationFrames <= 1 || po.Frame.Enabled {
+		return
+	}
+
+	if nPages, _ := img.GetIntDefault("n-pages", 1); nPages > po.MaxAnimationFrames {
+		log.Debugf("The animation has %d frames and is truncated to %d", nPages, po.MaxAnimationFrames)
+		metrics.IncrementErrorsTotal("animation_truncated")
+	}
+}
+
+// canSkipProcessing checks if the source image can be served as is without
+// decoding and encoding it. This is possible only when the result has the same
+// format as the source and no geometric or color transformations are needed
+func canSkipProcessing(po *options.ProcessingOptions, imgdata *imagedata.ImageData, img *vips.Image, originWidth, originHeight int, thumbnail bool) bool {
+	// The loaded thumbnail doesn't describe the source image we'd respond with
+	if !config.AllowPassthrough || img.IsAnimated() || thumbnail {
+		return false
+	}
+
+	// Changing quality requires re-encoding
+	if po.Quality > 0 ||
+		po.AlphaQuality > 0 ||
+		po.PngInterlaced ||
+		po.Dither != options.DitherDefault ||
+		(po.MaxBytes > 0 && len(imgdata.Data) > po.MaxBytes) {
+		return false
+	}
+
+	if !img.IsSRGB() {
+		return false
+	}
+
+	if po.AutoRotate && img.Orientation() > 1 {
+		return false
+	}
+
+	if po.Crop.Width != 0 ||
+		po.Crop.Height != 0 ||
+		po.Trim.Enabled ||
+		po.Extend.Enabled ||
+		po.Padding.Enabled ||
+		po.Canvas.Enabled ||
+		po.Tile.Enabled ||
+		po.ResizingType == options.ResizeFillDown {
+		return false
+	}
+
+	if wscale, hscale := calcScale(originWidth, originHeight, po, imgdata.Type); wscale != 1 || hscale != 1 {
+		return false
+	}
+
+	// The image is not scaled but it still can be cropped to the result size
+	if resultWidth, resultHeight := resultSize(po); (resultWidth > 0 && resultWidth < originWidth) ||
+		(resultHeight > 0 && resultHeight < originHeight) {
+		return false
+	}
+
+	return canPassthroughSource(po, imgdata, img, originWidth, originHeight, img.HasColourProfile())
+}
+
+// canPassthroughGif checks if the source GIF can be served instead of the result
+// losslessly. Animated GIFs can be served only if all of their frames were processed
+func canPassthroughGif(po *options.ProcessingOptions, imgdata *imagedata.ImageData, img *vips.Image, originWidth, originHeight, originPages int, originHasProfile bool) bool {
+	if !config.PassthroughGif || imgdata.Type != imagetype.GIF {
+		return false
+	}
+
+	pages := 1
+	if img.IsAnimated() {
+		pages, _ = img.GetIntDefault("n-pages", 1)
+	}
+
+	if pages != originPages {
+		return false
+	}
+
+	if po.MaxBytes > 0 && len(imgdata.Data) > po.MaxBytes {
+		return false
+	}
+
+	return po.Dither == options.DitherDefault &&
+		canPassthroughSource(po, imgdata, img, originWidth, originHeight, originHasProfile)
+}
+
+// loadImage loads the source image. Animated images are loaded with all their frames
+// when the resulting format may support animation.
+// Returns true if the embedded thumbnail was loaded instead of the image itself
+func loadImage(img *vips.Image, imgdata *imagedata.ImageData, po *options.ProcessingOptions) (bool, error) {
 	animationSupport :=
-		config.MaxAnimationFrames > 1 &&
+		po.MaxAnimationFrames > 1 &&
 			imgdata.Type.SupportsAnimation() &&
 			(po.Format == imagetype.Unknown || po.Format.SupportsAnimation())
 
 	pages := 1
-	if animationSupport {
+	if animationSupport || (po.Frame.Enabled && imgdata.Type.SupportsAnimation()) {
 		pages = -1
 	}
 
-	img := new(vips.Image)
-	defer img.Clear()
+	thumbnail := false
 
 	if po.EnforceThumbnail && imgdata.Type.SupportsThumbnail() {
 		if err := img.LoadThumbnail(imgdata); err != nil {
 			log.Debugf("Can't load thumbnail: %s", err)
 			// Failed to load thumbnail, rollback to the full image
 			if err := img.Load(imgdata, 1, 1.0, pages); err != nil {
-				return nil, err
+				return false, err
 			}
+		} else {
+			thumbnail = true
 		}
 	} else {
 		if err := img.Load(imgdata, 1, 1.0, pages); err != nil {
-			return nil, err
+			return false, err
 		}
 	}
 
-	originWidth, originHeight := getImageSize(img)
+	checkAnimationFramesLimit(img, po, imgdata)
+
+	if po.Frame.Enabled && img.IsAnimated() {
+		if err := selectFrame(img, po); err != nil {
+			return false, err
+		}
+	}
 
+	return thumbnail, nil
+}
+
+// selectFormat sets the resulting format if it's not set yet or should be overridden
+func selectFormat(img *vips.Image, imgdata *imagedata.ImageData, po *options.ProcessingOptions) error {
 	animated := img.IsAnimated()
-	expectAlpha := !po.Flatten && (img.HasAlpha() || po.Padding.Enabled || po.Extend.Enabled)
+	expectAlpha := !po.Flatten && (img.HasAlpha() || po.Padding.Enabled || po.Extend.Enabled || po.Canvas.Enabled)
 
+	// Formats negotiated by the Accept header are used only if we can save them.
+	// Otherwise, we fall back to the format we would use without negotiation
 	switch {
 	case po.Format == imagetype.Unknown:
 		switch {
-		case po.PreferAvif && !animated:
+		case po.PreferAvif && !animated && vips.SupportsSave(imagetype.AVIF):
 			po.Format = imagetype.AVIF
-		case po.PreferWebP:
+		case po.PreferWebP && vips.SupportsSave(imagetype.WEBP):
 			po.Format = imagetype.WEBP
-		case isImageTypePreferred(imgdata.Type):
-			po.Format = imgdata.Type
 		default:
-			po.Format = findBestFormat(imgdata.Type, animated, expectAlpha)
+			po.Format = defaultFormat(imgdata.Type, animated, expectAlpha)
 		}
-	case po.EnforceAvif && !animated:
+	case po.EnforceAvif && !animated && vips.SupportsSave(imagetype.AVIF):
 		po.Format = imagetype.AVIF
-	case po.EnforceWebP:
+	case po.EnforceWebP && vips.SupportsSave(imagetype.WEBP):
 		po.Format = imagetype.WEBP
 	}
 
 	if !vips.SupportsSave(po.Format) {
-		return nil, fmt.Errorf("Can't save %s, probably not supported by your libvips", po.Format)
+		return fmt.Errorf("Can't save %s, probably not supported by your libvips", po.Format)
 	}
 
+	return nil
+}
+
+// transformImage runs the processing pipeline for the loaded image
+func transformImage(ctx context.Context, img *vips.Image, imgdata *imagedata.ImageData, po *options.ProcessingOptions) error {
+	animated := img.IsAnimated()
+
 	if po.Format.SupportsAnimation() && animated {
 		if err := transformAnimated(ctx, img, po, imgdata); err != nil {
-			return nil, err
+			return err
 		}
 	} else {
 		if animated {
 			// We loaded animated image but the resulting format doesn't support
 			// animations, so we need to reload image as not animated
 			if err := img.Load(imgdata, 1, 1.0, 1); err != nil {
-				return nil, err
+				return err
 			}
 		}
 
 		if err := mainPipeline.Run(ctx, img, po, imgdata); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -316,32 +503,134 @@ func ProcessImage(ctx context.Context, imgdata *imagedata.ImageData, po *options
 			po.Format = imagetype.JPEG
 		}
 
-		log.Warningf(
+		w := fmt.Sprintf(
 			"Minimal dimension of AVIF is 16, current image size is %dx%d. Image will be saved as %s",
 			img.Width(), img.Height(), po.Format,
 		)
+		log.Warning(w)
+		po.AddWarning(w)
 	}
 
-	var (
-		outData *imagedata.ImageData
-		err     error
-	)
+	return nil
+}
+
+func ProcessImage(ctx context.Context, imgdata *imagedata.ImageData, po *options.ProcessingOptions) (*imagedata.ImageData, error) {
+	return processImage(ctx, imgdata, po, nil)
+}
+
+// ProcessImageStream works like ProcessImage but writes the encoded result
+// to the stream when possible. In this case, it returns nil result data
+func ProcessImageStream(ctx context.Context, imgdata *imagedata.ImageData, po *options.ProcessingOptions, stream ResultStream) (*imagedata.ImageData, error) {
+	return processImage(ctx, imgdata, po, stream)
+}
+
+func processImage(ctx context.Context, imgdata *imagedata.ImageData, po *options.ProcessingOptions, stream ResultStream) (*imagedata.ImageData, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer vips.Cleanup()
+
+	ctx, budget := withBudget(ctx, po)
+	ctx, crops := withCropReport(ctx)
+
+	img := new(vips.Image)
+	defer img.Clear()
+
+	thumbnail, err := loadImage(img, imgdata, po)
+	if err != nil {
+		return nil, err
+	}
 
-	if po.MaxBytes > 0 && canFitToBytes(po.Format) {
+	originWidth, originHeight := getImageSize(img)
+	originPages, _ := img.GetIntDefault("n-pages", 1)
+	originHasProfile := img.HasColourProfile()
+
+	animated := img.IsAnimated()
+
+	if err := selectFormat(img, imgdata, po); err != nil {
+		return nil, err
+	}
+
+	if canSkipProcessing(po, imgdata, img, originWidth, originHeight, thumbnail) {
+		log.Debug("No transformations are needed, responding with the source image")
+
+		outData := &imagedata.ImageData{
+			Type: imgdata.Type,
+			Data: imgdata.Data,
+		}
+
+		metrics.ObserveOutputSize(outData.Type.String(), len(outData.Data))
+		setResultSizeHeaders(outData, originWidth, originHeight, originWidth, originHeight)
+
+		return outData, nil
+	}
+
+	if err := transformImage(ctx, img, imgdata, po); err != nil {
+		return nil, err
+	}
+
+	if stream != nil && canStreamResult(po) {
+		return nil, streamResult(stream, img, po, originWidth, originHeight, budget, crops)
+	}
+
+	var outData *imagedata.ImageData
+
+	switch {
+	case canPassthroughGif(po, imgdata, img, originWidth, originHeight, originPages, originHasProfile):
+		// Re-encoding requantizes the palette, so we respond with the source GIF as is
+		log.Debug("No pixels were changed, responding with the source GIF")
+
+		outData = &imagedata.ImageData{
+			Type: imgdata.Type,
+			Data: imgdata.Data,
+		}
+	case po.MaxBytes > 0 && canFitToBytes(po.Format):
 		outData, err = saveImageToFitBytes(ctx, po, img)
-	} else {
-		outData, err = img.Save(po.Format, po.GetQuality())
+	default:
+		outData, err = img.Save(po.Format, po.GetQuality(), po.SaveOptions())
 	}
 
-	if err == nil {
-		if outData.Headers == nil {
-			outData.Headers = make(map[string]string)
+	if err == nil && config.PassthroughSmallerSource && !animated &&
+		len(outData.Data) >= len(imgdata.Data) &&
+		canPassthroughSource(po, imgdata, img, originWidth, originHeight, originHasProfile) {
+
+		log.Debugf(
+			"Result is larger than the source (%d >= %d bytes), responding with the source image",
+			len(outData.Data), len(imgdata.Data),
+		)
+
+		outData.Close()
+		outData = &imagedata.ImageData{
+			Type: imgdata.Type,
+			Data: imgdata.Data,
 		}
-		outData.Headers["X-Origin-Width"] = strconv.Itoa(originWidth)
-		outData.Headers["X-Origin-Height"] = strconv.Itoa(originHeight)
-		outData.Headers["X-Result-Width"] = strconv.Itoa(img.Width())
-		outData.Headers["X-Result-Height"] = strconv.Itoa(img.Height())
+	}
+
+	if err == nil {
+		metrics.ObserveOutputSize(outData.Type.String(), len(outData.Data))
+
+		setResultSizeHeaders(outData, originWidth, originHeight, img.Width(), img.Height())
+		setDegradedHeader(outData, po, budget)
+		setCropHeaders(outData, crops)
 	}
 
 	return outData, err
 }
+
+func setResultSizeHeaders(imgdata *imagedata.ImageData, originWidth, originHeight, resultWidth, resultHeight int) {
+	if imgdata.Headers == nil {
+		imgdata.Headers = make(map[string]string)
+	}
+	imgdata.Headers["X-Origin-Width"] = strconv.Itoa(originWidth)
+	imgdata.Headers["X-Origin-Height"] = strconv.Itoa(originHeight)
+	imgdata.Headers["X-Result-Width"] = strconv.Itoa(resultWidth)
+	imgdata.Headers["X-Result-Height"] = strconv.Itoa(resultHeight)
+}
+
+func setDegradedHeader(imgdata *imagedata.ImageData, po *options.ProcessingOptions, budget *processingBudget) {
+	if degraded := budget.degradedStages(); len(degraded) > 0 {
+		log.Warningf("Processing budget is nearly spent, degraded: %s", degraded)
+		po.AddWarning("Processing budget is nearly spent, degraded: " + degraded)
+		imgdata.Headers["X-Imgproxy-Degraded"] = degraded
+	}
+}
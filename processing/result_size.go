@@ -6,8 +6,10 @@ import (
 )
 
 func resultSize(po *options.ProcessingOptions) (int, int) {
-	resultWidth := imath.Scale(po.Width, po.Dpr*po.ZoomWidth)
-	resultHeight := imath.Scale(po.Height, po.Dpr*po.ZoomHeight)
+	width, height := po.TargetSize()
+
+	resultWidth := imath.Scale(width, po.Dpr*po.ZoomWidth)
+	resultHeight := imath.Scale(height, po.Dpr*po.ZoomHeight)
 
 	return resultWidth, resultHeight
 }
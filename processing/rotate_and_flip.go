@@ -7,15 +7,57 @@ import (
 )
 
 func rotateAndFlip(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
-	if err := img.Rotate(pctx.angle); err != nil {
+	// When auto-rotation is disabled, pctx.angle and pctx.flip are not set
+	// and the EXIF orientation is kept, so viewers apply it on top of the explicit rotation
+	if po.AutoRotate {
+		if err := img.Rotate(pctx.angle); err != nil {
+			return err
+		}
+
+		if pctx.flip {
+			if err := img.Flip(); err != nil {
+				return err
+			}
+		}
+
+		if err := img.RemoveOrientation(); err != nil {
+			return err
+		}
+	}
+
+	if err := img.Rotate(po.Rotate); err != nil {
 		return err
 	}
 
-	if pctx.flip {
+	if po.Flop {
 		if err := img.Flip(); err != nil {
 			return err
 		}
 	}
 
-	return img.Rotate(po.Rotate)
+	if po.Flip {
+		if err := img.FlipVertical(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotateAndFlipGravity converts the gravity of the resulting image
+// to the gravity of the image before rotation and flipping.
+// The transformations are reverted in the reverse order of rotateAndFlip.
+// The order matters since flipping doesn't commute with rotation by 90 and 270 degrees
+func rotateAndFlipGravity(g *options.GravityOptions, pctx *pipelineContext, po *options.ProcessingOptions) {
+	if po.Flip {
+		// Vertical flip is a horizontal flip combined with rotation by 180 degrees
+		g.RotateAndFlip(180, true)
+	}
+
+	if po.Flop {
+		g.RotateAndFlip(0, true)
+	}
+
+	g.RotateAndFlip(po.Rotate, false)
+	g.RotateAndFlip(pctx.angle, pctx.flip)
 }
@@ -1,11 +1,32 @@
 package processing
 
 import (
+	"math"
+
 	"github.com/imgproxy/imgproxy/v3/imagedata"
 	"github.com/imgproxy/imgproxy/v3/options"
 	"github.com/imgproxy/imgproxy/v3/vips"
 )
 
+var resizingKernels = map[options.ResizingAlgorithm]vips.Kernel{
+	options.ResizingAlgorithmNearest:  vips.KernelNearest,
+	options.ResizingAlgorithmLinear:   vips.KernelLinear,
+	options.ResizingAlgorithmCubic:    vips.KernelCubic,
+	options.ResizingAlgorithmMitchell: vips.KernelMitchell,
+	options.ResizingAlgorithmLanczos2: vips.KernelLanczos2,
+	options.ResizingAlgorithmLanczos3: vips.KernelLanczos3,
+}
+
+// resizingKernel returns the kernel that should be used for resizing with the provided scales.
+// The enlarge algorithm is used if the image is enlarged along any of the axes
+func resizingKernel(po *options.ProcessingOptions, wscale, hscale float64) vips.Kernel {
+	if wscale > 1 || hscale > 1 {
+		return resizingKernels[po.EnlargeAlgorithm]
+	}
+
+	return resizingKernels[po.ResizingAlgorithm]
+}
+
 func scale(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
 	if pctx.wscale != 1 || pctx.hscale != 1 {
 		wscale, hscale := pctx.wscale, pctx.hscale
@@ -13,7 +34,12 @@ func scale(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions
 			wscale, hscale = hscale, wscale
 		}
 
-		if err := img.Resize(wscale, hscale); err != nil {
+		// Don't let the result be smaller than 1px in any dimension.
+		// libvips fails when the resulting dimension is rounded to zero
+		wscale = math.Max(wscale, 1/float64(img.Width()))
+		hscale = math.Max(hscale, 1/float64(img.Height()))
+
+		if err := img.Resize(wscale, hscale, resizingKernel(po, wscale, hscale)); err != nil {
 			return err
 		}
 	}
@@ -48,7 +48,7 @@ func calcJpegShink(scale float64, imgtype imagetype.Type) int {
 }
 
 func scaleOnLoad(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
-	if po.Gravity.Type == options.GravitySmart {
+	if analyzeSmartCrop(&po.Gravity) {
 		return nil
 	}
 
@@ -79,6 +79,8 @@ func scaleOnLoad(pctx *pipelineContext, img *vips.Image, po *options.ProcessingO
 		img.Swap(thumbnail)
 		pctx.angle = angle
 		pctx.flip = flip
+	} else if w, h, ok := scaleOnLoadExifThumbnail(pctx, img, po, imgdata, prescale); ok {
+		newWidth, newHeight = w, h
 	} else {
 		jpegShrink := calcJpegShink(prescale, pctx.imgtype)
 
@@ -120,3 +122,39 @@ func scaleOnLoad(pctx *pipelineContext, img *vips.Image, po *options.ProcessingO
 
 	return nil
 }
+
+// scaleOnLoadExifThumbnail replaces the JPEG image with its embedded EXIF thumbnail
+// when the thumbnail is enforced and is large enough to get the result
+func scaleOnLoadExifThumbnail(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData, prescale float64) (int, int, bool) {
+	if !po.EnforceThumbnail || imgdata.Type != imagetype.JPEG {
+		return 0, 0, false
+	}
+
+	thumbnail := new(vips.Image)
+	defer thumbnail.Clear()
+
+	if err := thumbnail.LoadThumbnail(imgdata); err != nil {
+		log.Debugf("Can't load thumbnail: %s", err)
+		return 0, 0, false
+	}
+
+	// EXIF thumbnail doesn't have its own orientation, it inherits the one of the image
+	thumbnail.SetInt("orientation", int(img.Orientation()))
+
+	newWidth, newHeight, _, _ := extractMeta(thumbnail, po.Rotate, po.AutoRotate)
+
+	if newWidth >= pctx.srcWidth || float64(newWidth)/float64(pctx.srcWidth) < prescale {
+		return 0, 0, false
+	}
+
+	// Some cameras add black bars to thumbnails to fit them into a fixed aspect ratio.
+	// Such thumbnails can't replace the image
+	expectedHeight := float64(pctx.srcHeight) * float64(newWidth) / float64(pctx.srcWidth)
+	if math.Abs(expectedHeight-float64(newHeight)) > 1 {
+		return 0, 0, false
+	}
+
+	img.Swap(thumbnail)
+
+	return newWidth, newHeight, true
+}
@@ -0,0 +1,59 @@
+package processing
+
+import (
+	"io"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/imagedata"
+	"github.com/imgproxy/imgproxy/v3/metrics"
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+// ResultStream receives the encoded result as the encoder produces it.
+// Start is called before the first write with the result data that has
+// the type and the headers set but doesn't contain the image data
+type ResultStream interface {
+	io.Writer
+	Start(resultData *imagedata.ImageData)
+}
+
+type countingWriter struct {
+	io.Writer
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += n
+	return n, err
+}
+
+// canStreamResult checks if the result can be streamed. The result can't be streamed
+// if we need to know its size before responding
+func canStreamResult(po *options.ProcessingOptions) bool {
+	return config.StreamResult &&
+		vips.CanSaveTo(po.Format) &&
+		po.MaxBytes == 0 &&
+		!config.PassthroughSmallerSource
+}
+
+func streamResult(stream ResultStream, img *vips.Image, po *options.ProcessingOptions, originWidth, originHeight int, budget *processingBudget, crops *cropReport) error {
+	resultData := &imagedata.ImageData{Type: po.Format}
+
+	setResultSizeHeaders(resultData, originWidth, originHeight, img.Width(), img.Height())
+	setDegradedHeader(resultData, po, budget)
+	setCropHeaders(resultData, crops)
+
+	stream.Start(resultData)
+
+	w := countingWriter{Writer: stream}
+
+	if err := img.SaveTo(&w, po.Format, po.GetQuality(), po.SaveOptions()); err != nil {
+		return err
+	}
+
+	metrics.ObserveOutputSize(po.Format.String(), w.n)
+
+	return nil
+}
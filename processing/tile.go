@@ -0,0 +1,38 @@
+package processing
+
+import (
+	"fmt"
+
+	"github.com/imgproxy/imgproxy/v3/ierrors"
+	"github.com/imgproxy/imgproxy/v3/imagedata"
+	"github.com/imgproxy/imgproxy/v3/imath"
+	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/vips"
+)
+
+func tile(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
+	if !po.Tile.Enabled {
+		return nil
+	}
+
+	width := imath.Scale(po.Tile.Width, po.Dpr)
+	height := imath.Scale(po.Tile.Height, po.Dpr)
+
+	if width == img.Width() && height == img.Height() {
+		return nil
+	}
+
+	// The tiled canvas is allocated in full, so we limit it the same way as the source image.
+	// Dimensions are multiplied as floats to avoid int overflow
+	if float64(width)*float64(height) > float64(po.MaxSrcResolution) {
+		return ierrors.New(
+			422,
+			fmt.Sprintf("Tiled image resolution is too big: %dx%d", width, height),
+			"Invalid tile size",
+		)
+	}
+
+	// Replicate repeats the image starting from the top-left corner
+	// and crops the result to the requested size
+	return img.Replicate(width, height)
+}
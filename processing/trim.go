@@ -11,13 +11,24 @@ func trim(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions,
 		return nil
 	}
 
+	// Trim reads the whole image, so we need to decode it within the decode timeout
+	if err := decode(pctx, img, po, imgdata); err != nil {
+		return err
+	}
+
 	// We need to import color profile before trim
 	if err := importColorProfile(pctx, img, po, imgdata); err != nil {
 		return err
 	}
 
-	if err := img.Trim(po.Trim.Threshold, po.Trim.Smart, po.Trim.Color, po.Trim.EqualHor, po.Trim.EqualVer); err != nil {
-		return err
+	if po.Trim.Alpha {
+		if err := img.TrimAlpha(po.Trim.Threshold, po.Trim.EqualHor, po.Trim.EqualVer); err != nil {
+			return err
+		}
+	} else {
+		if err := img.Trim(po.Trim.Threshold, po.Trim.Smart, po.Trim.Color, po.Trim.EqualHor, po.Trim.EqualVer); err != nil {
+			return err
+		}
 	}
 	if err := img.CopyMemory(); err != nil {
 		return err
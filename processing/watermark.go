@@ -2,8 +2,10 @@ package processing
 
 import (
 	"context"
+	"math"
 
 	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/ierrors"
 	"github.com/imgproxy/imgproxy/v3/imagedata"
 	"github.com/imgproxy/imgproxy/v3/imath"
 	"github.com/imgproxy/imgproxy/v3/options"
@@ -16,48 +18,137 @@ var watermarkPipeline = pipeline{
 	importColorProfile,
 	scale,
 	rotateAndFlip,
+	cropToResult,
 	padding,
 	finalize,
 }
 
-func prepareWatermark(wm *vips.Image, wmData *imagedata.ImageData, opts *options.WatermarkOptions, imgWidth, imgHeight int) error {
+// calcWatermarkRegion calculates the area of the image the watermark is confined to.
+// Region values less than 1 are relative to the image size
+func calcWatermarkRegion(imgWidth, imgHeight int, region *options.WatermarkRegion) (left, top, width, height int) {
+	left = imath.Min(calcCropSize(imgWidth, region.Left), imgWidth-1)
+	top = imath.Min(calcCropSize(imgHeight, region.Top), imgHeight-1)
+
+	width = calcCropSize(imgWidth, region.Width)
+	if width == 0 || left+width > imgWidth {
+		width = imgWidth - left
+	}
+
+	height = calcCropSize(imgHeight, region.Height)
+	if height == 0 || top+height > imgHeight {
+		height = imgHeight - top
+	}
+
+	return
+}
+
+// prepareWatermark loads the watermark and places it to the transparent image of the provided size.
+// The watermark size and offsets that aren't relative to the image are multiplied by dpr.
+// The watermark is resized with the same algorithms as the main image
+func prepareWatermark(wm *vips.Image, wmData *imagedata.ImageData, opts *options.WatermarkOptions, po *options.ProcessingOptions, imgWidth, imgHeight int) error {
 	if err := wm.Load(wmData, 1, 1.0, 1); err != nil {
-		return err
+		// The watermark isn't the source image, so it's not an unprocessable image
+		ierr := ierrors.WrapWithPrefix(err, 1, "Can't load watermark")
+		ierr.Type = ""
+		return ierr
 	}
 
-	po := options.NewProcessingOptions()
-	po.ResizingType = options.ResizeFit
-	po.Dpr = 1
-	po.Enlarge = true
-	po.Format = wmData.Type
+	dpr := po.Dpr
+
+	regionLeft, regionTop, regionWidth, regionHeight := calcWatermarkRegion(imgWidth, imgHeight, &opts.Region)
+
+	wmPo := options.NewProcessingOptions()
+	wmPo.ResizingType = options.ResizeFit
+	wmPo.ResizingAlgorithm = po.ResizingAlgorithm
+	wmPo.EnlargeAlgorithm = po.EnlargeAlgorithm
+	wmPo.Dpr = 1
+	wmPo.Enlarge = true
+	wmPo.Format = wmData.Type
+
+	// The watermark's own size is set in CSS pixels, so it's multiplied by dpr
+	zoom := dpr
 
 	if opts.Scale > 0 {
-		po.Width = imath.Max(imath.Scale(imgWidth, opts.Scale), 1)
-		po.Height = imath.Max(imath.Scale(imgHeight, opts.Scale), 1)
+		switch opts.ScaleMode {
+		case options.WatermarkScaleAbsolute:
+			// Scale the watermark relative to its own size
+			zoom *= opts.Scale
+		case options.WatermarkScaleLongest:
+			// The longest side of the watermark is relative to the longest side of the image.
+			// The watermark is clamped to the image so it never overflows
+			side := imath.Max(imath.Scale(imath.Max(regionWidth, regionHeight), opts.Scale), 1)
+			wmPo.Width = imath.Min(side, regionWidth)
+			wmPo.Height = imath.Min(side, regionHeight)
+			zoom = 1
+		case options.WatermarkScaleCover:
+			wmPo.ResizingType = options.ResizeFill
+			fallthrough
+		default:
+			wmPo.Width = imath.Max(imath.Scale(regionWidth, opts.Scale), 1)
+			wmPo.Height = imath.Max(imath.Scale(regionHeight, opts.Scale), 1)
+			zoom = 1
+		}
+	}
+
+	wmPo.ZoomWidth = zoom
+	wmPo.ZoomHeight = zoom
+
+	gravity := opts.Gravity
+	if gravity.Type != options.GravityFocusPoint {
+		gravity.X *= dpr
+		gravity.Y *= dpr
 	}
 
 	if opts.Replicate {
-		po.Padding.Enabled = true
-		po.Padding.Left = int(opts.Gravity.X / 2)
-		po.Padding.Right = int(opts.Gravity.X) - po.Padding.Left
-		po.Padding.Top = int(opts.Gravity.Y / 2)
-		po.Padding.Bottom = int(opts.Gravity.Y) - po.Padding.Top
+		wmPo.Padding.Enabled = true
+		wmPo.Padding.Left = int(gravity.X / 2)
+		wmPo.Padding.Right = int(gravity.X) - wmPo.Padding.Left
+		wmPo.Padding.Top = int(gravity.Y / 2)
+		wmPo.Padding.Bottom = int(gravity.Y) - wmPo.Padding.Top
 	}
 
-	if err := watermarkPipeline.Run(context.Background(), wm, po, wmData); err != nil {
+	if err := watermarkPipeline.Run(context.Background(), wm, wmPo, wmData); err != nil {
 		return err
 	}
 
+	if opts.Tint {
+		if err := wm.RgbColourspace(); err != nil {
+			return err
+		}
+
+		if err := wm.Tint(opts.TintColor); err != nil {
+			return err
+		}
+	}
+
+	if math.Mod(opts.Rotate, 360) != 0 {
+		if err := wm.RotateAngle(opts.Rotate); err != nil {
+			return err
+		}
+	}
+
 	if opts.Replicate {
-		return wm.Replicate(imgWidth, imgHeight)
+		if err := wm.Replicate(regionWidth, regionHeight); err != nil {
+			return err
+		}
+	} else {
+		left, top := calcPosition(regionWidth, regionHeight, wm.Width(), wm.Height(), &gravity, true)
+
+		if err := wm.Embed(regionWidth, regionHeight, left, top); err != nil {
+			return err
+		}
 	}
 
-	left, top := calcPosition(imgWidth, imgHeight, wm.Width(), wm.Height(), &opts.Gravity, true)
+	if regionWidth == imgWidth && regionHeight == imgHeight {
+		return nil
+	}
 
-	return wm.Embed(imgWidth, imgHeight, left, top)
+	// Place the watermark to the region. Everything outside the region is transparent
+	return wm.Embed(imgWidth, imgHeight, regionLeft, regionTop)
 }
 
-func applyWatermark(img *vips.Image, wmData *imagedata.ImageData, opts *options.WatermarkOptions, framesCount int) error {
+// applyWatermarks composites the watermarks over the image in the provided order
+func applyWatermarks(img *vips.Image, wmData *imagedata.ImageData, watermarks []options.WatermarkOptions, po *options.ProcessingOptions, framesCount int) error {
 	if err := img.RgbColourspace(); err != nil {
 		return err
 	}
@@ -66,13 +157,23 @@ func applyWatermark(img *vips.Image, wmData *imagedata.ImageData, opts *options.
 		return err
 	}
 
+	for i := range watermarks {
+		if err := applyWatermark(img, wmData, &watermarks[i], po, framesCount); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyWatermark(img *vips.Image, wmData *imagedata.ImageData, opts *options.WatermarkOptions, po *options.ProcessingOptions, framesCount int) error {
 	wm := new(vips.Image)
 	defer wm.Clear()
 
 	width := img.Width()
 	height := img.Height()
 
-	if err := prepareWatermark(wm, wmData, opts, width, height/framesCount); err != nil {
+	if err := prepareWatermark(wm, wmData, opts, po, width, height/framesCount); err != nil {
 		return err
 	}
 
@@ -88,9 +189,10 @@ func applyWatermark(img *vips.Image, wmData *imagedata.ImageData, opts *options.
 }
 
 func watermark(pctx *pipelineContext, img *vips.Image, po *options.ProcessingOptions, imgdata *imagedata.ImageData) error {
-	if !po.Watermark.Enabled || imagedata.Watermark == nil {
+	watermarks := po.Watermarks()
+	if len(watermarks) == 0 || imagedata.Watermark == nil {
 		return nil
 	}
 
-	return applyWatermark(img, imagedata.Watermark, &po.Watermark, 1)
+	return applyWatermarks(img, imagedata.Watermark, watermarks, po, 1)
 }
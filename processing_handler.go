@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"image/color"
 	"net/http"
 	"net/http/cookiejar"
 	"strconv"
@@ -18,6 +19,7 @@ import (
 	"github.com/imgproxy/imgproxy/v3/ierrors"
 	"github.com/imgproxy/imgproxy/v3/imagedata"
 	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/imgproxy/imgproxy/v3/imath"
 	"github.com/imgproxy/imgproxy/v3/metrics"
 	"github.com/imgproxy/imgproxy/v3/metrics/stats"
 	"github.com/imgproxy/imgproxy/v3/options"
@@ -29,6 +31,10 @@ import (
 	"github.com/imgproxy/imgproxy/v3/vips"
 )
 
+// errTypeDownloadOther is the metrics error type of the downloading errors
+// that aren't classified more specifically by imagedata
+const errTypeDownloadOther = "download_other"
+
 var (
 	queueSem      *semaphore.Semaphore
 	processingSem *semaphore.Semaphore
@@ -42,6 +48,7 @@ func initProcessingHandler() {
 	}
 
 	processingSem = semaphore.New(config.Concurrency)
+	processing.SetWorkersSemaphore(processingSem)
 
 	vary := make([]string, 0)
 
@@ -53,6 +60,10 @@ func initProcessingHandler() {
 		vary = append(vary, "DPR", "Viewport-Width", "Width")
 	}
 
+	if len(config.TenantHeader) > 0 && len(config.Tenants) > 0 {
+		vary = append(vary, config.TenantHeader)
+	}
+
 	headerVaryValue = strings.Join(vary, ", ")
 }
 
@@ -92,7 +103,7 @@ func setVary(rw http.ResponseWriter) {
 	}
 }
 
-func respondWithImage(reqID string, r *http.Request, rw http.ResponseWriter, statusCode int, resultData *imagedata.ImageData, po *options.ProcessingOptions, originURL string, originData *imagedata.ImageData) {
+func setImageResponseHeaders(rw http.ResponseWriter, resultData *imagedata.ImageData, po *options.ProcessingOptions, originURL string, originData *imagedata.ImageData) {
 	var contentDisposition string
 	if len(po.Filename) > 0 {
 		contentDisposition = resultData.Type.ContentDisposition(po.Filename, po.ReturnAttachment)
@@ -114,16 +125,50 @@ func respondWithImage(reqID string, r *http.Request, rw http.ResponseWriter, sta
 		}
 	}
 
+	if config.SetFormatHeader {
+		rw.Header().Set("X-Imgproxy-Format", resultData.Type.String())
+	}
+
+	if config.EnableWarningsHeader {
+		if warnings := po.Warnings(); len(warnings) > 0 {
+			rw.Header().Set("X-Imgproxy-Warnings", strings.Join(warnings, "; "))
+		}
+	}
+
 	setCacheControl(rw, originData.Headers)
 	setVary(rw)
 
+	if degraded, ok := resultData.Headers["X-Imgproxy-Degraded"]; ok {
+		rw.Header().Set("X-Imgproxy-Degraded", degraded)
+
+		// The degraded result shouldn't be cached, so the next request
+		// has a chance to get the fully processed image
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Header().Del("Expires")
+	}
+
 	if config.EnableDebugHeaders {
 		rw.Header().Set("X-Origin-Content-Length", strconv.Itoa(len(originData.Data)))
 		rw.Header().Set("X-Origin-Width", resultData.Headers["X-Origin-Width"])
 		rw.Header().Set("X-Origin-Height", resultData.Headers["X-Origin-Height"])
 		rw.Header().Set("X-Result-Width", resultData.Headers["X-Result-Width"])
 		rw.Header().Set("X-Result-Height", resultData.Headers["X-Result-Height"])
+
+		if rect, ok := resultData.Headers["X-Crop-Rectangle"]; ok {
+			rw.Header().Set("X-Crop-Rectangle", rect)
+		}
+		if rect, ok := resultData.Headers["X-Result-Crop-Rectangle"]; ok {
+			rw.Header().Set("X-Result-Crop-Rectangle", rect)
+		}
+
+		if poJSON, err := po.MarshalJSON(); err == nil {
+			rw.Header().Set("X-Processing-Options", string(poJSON))
+		}
 	}
+}
+
+func respondWithImage(reqID string, r *http.Request, rw http.ResponseWriter, statusCode int, resultData *imagedata.ImageData, po *options.ProcessingOptions, originURL string, originData *imagedata.ImageData) {
+	setImageResponseHeaders(rw, resultData, po, originURL, originData)
 
 	rw.Header().Set("Content-Length", strconv.Itoa(len(resultData.Data)))
 	rw.WriteHeader(statusCode)
@@ -138,6 +183,43 @@ func respondWithImage(reqID string, r *http.Request, rw http.ResponseWriter, sta
 	)
 }
 
+// resultStream writes the result to the response as the encoder produces it.
+// Since the result size is unknown, the response is sent without Content-Length.
+// The result is sent asynchronously, so the encoder doesn't wait for the client
+// and the worker can be released as soon as the result is encoded
+type resultStream struct {
+	rw         http.ResponseWriter
+	statusCode int
+	po         *options.ProcessingOptions
+	originURL  string
+	originData *imagedata.ImageData
+
+	started bool
+	writer  *asyncWriter
+}
+
+func (s *resultStream) Start(resultData *imagedata.ImageData) {
+	setImageResponseHeaders(s.rw, resultData, s.po, s.originURL, s.originData)
+	s.rw.WriteHeader(s.statusCode)
+
+	s.writer = newAsyncWriter(s.rw)
+	s.started = true
+}
+
+func (s *resultStream) Write(p []byte) (int, error) {
+	return s.writer.Write(p)
+}
+
+// Finish waits until the streamed result is sent to the client.
+// When discard is true, the part of the result that isn't sent yet is dropped
+func (s *resultStream) Finish(discard bool) error {
+	if s.writer == nil {
+		return nil
+	}
+
+	return s.writer.Close(discard)
+}
+
 func respondWithNotModified(reqID string, r *http.Request, rw http.ResponseWriter, po *options.ProcessingOptions, originURL string, originHeaders map[string]string) {
 	setCacheControl(rw, originHeaders)
 	setVary(rw)
@@ -153,9 +235,20 @@ func respondWithNotModified(reqID string, r *http.Request, rw http.ResponseWrite
 }
 
 func sendErrAndPanic(ctx context.Context, errType string, err error) {
+	sendErr(ctx, errType, err)
+	panic(err)
+}
+
+// sendErr sends the error to the metrics services. Some error types
+// are overridden depending on the error
+func sendErr(ctx context.Context, errType string, err error) {
 	send := true
 
 	if ierr, ok := err.(*ierrors.Error); ok {
+		if len(ierr.Type) > 0 {
+			errType = ierr.Type
+		}
+
 		switch ierr.StatusCode {
 		case http.StatusServiceUnavailable:
 			errType = "timeout"
@@ -163,13 +256,17 @@ func sendErrAndPanic(ctx context.Context, errType string, err error) {
 			// Don't need to send a "request cancelled" error
 			send = false
 		}
+
+		if security.IsSourceResolutionError(ierr) {
+			errType = "source_resolution"
+		} else if imagedata.IsSourceConnectionError(ierr) {
+			errType = "source_connection"
+		}
 	}
 
 	if send {
 		metrics.SendError(ctx, errType, err)
 	}
-
-	panic(err)
 }
 
 func checkErr(ctx context.Context, errType string, err error) {
@@ -179,29 +276,29 @@ func checkErr(ctx context.Context, errType string, err error) {
 	sendErrAndPanic(ctx, errType, err)
 }
 
-func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
-	stats.IncRequestsInProgress()
-	defer stats.DecRequestsInProgress()
-
-	ctx := r.Context()
-
-	if queueSem != nil {
-		token, aquired := queueSem.TryAquire()
-		if !aquired {
-			panic(ierrors.New(429, "Too many requests", "Too many requests"))
-		}
-		defer token.Release()
-	}
-
+// verifiedPath cuts the prefix and the signature from the request path,
+// verifies the signature with the tenant's keys, and returns the rest of the path
+func verifiedPath(ctx context.Context, r *http.Request, prefix string, tenant *config.Tenant) string {
 	path := r.RequestURI
 	if queryStart := strings.IndexByte(path, '?'); queryStart >= 0 {
 		path = path[:queryStart]
 	}
 
-	if len(config.PathPrefix) > 0 {
-		path = strings.TrimPrefix(path, config.PathPrefix)
+	if len(prefix) > 0 {
+		path = strings.TrimPrefix(path, prefix)
+	}
+
+	path, errType, err := verifySignedPath(path, tenant)
+	if err != nil {
+		sendErrAndPanic(ctx, errType, err)
 	}
 
+	return path
+}
+
+// verifySignedPath cuts the signature from the path and verifies it with the tenant's keys.
+// It returns the error type used for metrics along with the error
+func verifySignedPath(path string, tenant *config.Tenant) (string, string, error) {
 	path = strings.TrimPrefix(path, "/")
 	signature := ""
 
@@ -209,19 +306,65 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 		signature = path[:signatureEnd]
 		path = path[signatureEnd:]
 	} else {
-		sendErrAndPanic(ctx, "path_parsing", ierrors.New(
+		return "", "path_parsing", ierrors.New(
 			404, fmt.Sprintf("Invalid path: %s", path), "Invalid URL",
-		))
+		)
 	}
 
-	if err := security.VerifySignature(signature, path); err != nil {
-		sendErrAndPanic(ctx, "security", ierrors.New(403, err.Error(), "Forbidden"))
+	if err := security.VerifyTenantSignature(tenant, signature, path); err != nil {
+		return "", "security", ierrors.New(403, err.Error(), "Forbidden")
 	}
 
-	po, imageURL, err := options.ParsePath(path, r.Header)
+	return path, "", nil
+}
+
+// generateColorSource generates a solid-color source image for color:<hex> source URLs.
+// The generated image has the size of the requested result
+func generateColorSource(imageURL string, po *options.ProcessingOptions) (*imagedata.ImageData, error) {
+	c, err := vips.ColorFromHex(strings.TrimPrefix(imageURL, imagedata.ColorSourcePrefix))
+	if err != nil {
+		return nil, ierrors.New(422, err.Error(), "Invalid URL")
+	}
+
+	width, height := po.TargetSize()
+
+	if width == 0 || height == 0 {
+		return nil, ierrors.New(
+			422,
+			"Both width and height are required for color sources",
+			"Invalid URL",
+		)
+	}
+
+	width = imath.Scale(width, po.Dpr)
+	height = imath.Scale(height, po.Dpr)
+
+	return imagedata.FromColor(color.RGBA{c.R, c.G, c.B, 255}, width, height)
+}
+
+// parseProcessingPath parses the verified path and checks if the source URL
+// and the resulting format are allowed
+func parseProcessingPath(ctx context.Context, r *http.Request, tenant *config.Tenant, path string) (*options.ProcessingOptions, string) {
+	po, imageURL, err := options.ParseTenantPath(tenant, path, r.Header)
 	checkErr(ctx, "path_parsing", err)
 
-	if !security.VerifySourceURL(imageURL) {
+	// Source size hints affect sizing, the max source resolution affects
+	// the resources usage, and the allowed sources policy bypasses the allowed sources,
+	// so we trust them only in signed URLs
+	if !security.IsTenantSignatureEnabled(tenant) {
+		po.SourceWidth, po.SourceHeight = 0, 0
+		po.MaxSrcResolution = config.MaxSrcResolution
+		po.AllowedSourcesPolicy = ""
+	}
+
+	// Trusted source size hints let us reject too big images before downloading them
+	if po.SourceWidth > 0 && po.SourceHeight > 0 {
+		checkErr(ctx, security.ErrTypeSourceResolution, security.CheckDimensionsLimit(
+			po.SourceWidth, po.SourceHeight, po.MaxSrcResolution,
+		))
+	}
+
+	if !security.VerifyTenantSourceURL(tenant, po.AllowedSourcesPolicy, imageURL) {
 		sendErrAndPanic(ctx, "security", ierrors.New(
 			404,
 			fmt.Sprintf("Source URL is not allowed: %s", imageURL),
@@ -238,6 +381,144 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 		))
 	}
 
+	return po, imageURL
+}
+
+// acquireProcessingSem waits for a free worker. The queue segment is finished
+// as soon as the worker is acquired
+func acquireProcessingSem(ctx context.Context, queueSegmentCancel context.CancelFunc) *semaphore.Token {
+	defer queueSegmentCancel()
+
+	workerCtx, workerSegmentCancel := metrics.StartWorkerSegment(ctx)
+	defer workerSegmentCancel()
+
+	stats.IncRequestsInQueue()
+	defer stats.DecRequestsInQueue()
+
+	token, aquired := processingSem.Aquire(workerCtx)
+	if !aquired {
+		// We don't actually need to check timeout here,
+		// but it's an easy way to check if this is an actual timeout
+		// or the request was cancelled
+		checkErr(ctx, "queue", router.CheckTimeout(ctx))
+	}
+
+	return token
+}
+
+func downloadOrigin(ctx context.Context, r *http.Request, imageURL string, header http.Header, po *options.ProcessingOptions) (*imagedata.ImageData, error) {
+	downloadCtx, downloadSegmentCancel := metrics.StartDownloadingSegment(ctx)
+	defer downloadSegmentCancel()
+
+	var cookieJar *cookiejar.Jar
+
+	if config.CookiePassthrough {
+		var err error
+		cookieJar, err = cookies.JarFromRequest(r)
+		checkErr(ctx, errTypeDownloadOther, err)
+	}
+
+	return imagedata.Download(
+		downloadCtx, imageURL, "source image", header, cookieJar, po.MaxSrcResolution,
+		imagedata.VideoOptions{Time: po.VideoTime, MaxFrames: po.MaxAnimationFrames},
+	)
+}
+
+// sanitizeOrigin sanitizes SVG before both serving and rasterizing it
+func sanitizeOrigin(ctx context.Context, originData *imagedata.ImageData) *imagedata.ImageData {
+	if originData.Type != imagetype.SVG || !config.SanitizeSvg {
+		return originData
+	}
+
+	sanitized, err := svg.Satitize(originData.Data)
+	checkErr(ctx, "svg_processing", err)
+
+	// Since we'll replace origin data, it's better to close it to return
+	// it's buffer to the pool
+	originData.Close()
+
+	return &imagedata.ImageData{
+		Data:    sanitized,
+		Type:    imagetype.SVG,
+		Headers: originData.Headers,
+	}
+}
+
+// canPassthroughOrigin checks if the source image should be served as is without processing
+func canPassthroughOrigin(po *options.ProcessingOptions, originData *imagedata.ImageData) bool {
+	if originData.Type == po.Format || po.Format == imagetype.Unknown {
+		// Don't process SVG
+		if originData.Type == imagetype.SVG {
+			return true
+		}
+
+		for _, f := range po.SkipProcessingFormats {
+			if f == originData.Type {
+				return true
+			}
+		}
+	}
+
+	return !vips.SupportsLoad(originData.Type) && config.PassthroughUnsupportedFormats
+}
+
+// checkProcessable checks if the source image can be processed to the resulting format
+func checkProcessable(ctx context.Context, po *options.ProcessingOptions, originData *imagedata.ImageData) {
+	if !vips.SupportsLoad(originData.Type) {
+		sendErrAndPanic(ctx, "processing", ierrors.New(
+			422,
+			fmt.Sprintf("Source image format is not supported: %s", originData.Type),
+			"Invalid URL",
+		))
+	}
+
+	// At this point we can't allow requested format to be SVG as we can't save SVGs
+	if po.Format == imagetype.SVG {
+		sendErrAndPanic(ctx, "processing", ierrors.New(
+			422, "Resulting image format is not supported: svg", "Invalid URL",
+		))
+	}
+}
+
+// processingErrType returns the error type used for metrics for the processing error
+func processingErrType(err error) string {
+	if ierr, ok := err.(*ierrors.Error); ok && len(ierr.Type) > 0 {
+		return ierr.Type
+	}
+
+	if err == vips.ErrDecodeTimeout {
+		return "decode_timeout"
+	}
+
+	return "processing"
+}
+
+func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
+	stats.IncRequestsInProgress()
+	defer stats.DecRequestsInProgress()
+
+	ctx := r.Context()
+
+	if queueSem != nil {
+		token, aquired := queueSem.TryAquire()
+		if !aquired {
+			panic(ierrors.New(429, "Too many requests", "Too many requests"))
+		}
+		defer token.Release()
+	}
+
+	// The queue segment lasts until the request gets a worker.
+	// If the request fails before that, the segment is finished here.
+	// The rest of the request isn't a part of the queue segment,
+	// so we don't use its context
+	_, queueSegmentCancel := metrics.StartQueueSegment(ctx)
+	defer queueSegmentCancel()
+
+	tenant, prefix := requestTenant(r, config.PathPrefix)
+	path := verifiedPath(ctx, r, prefix, tenant)
+
+	po, imageURL := parseProcessingPath(ctx, r, tenant, path)
+
 	imgRequestHeader := make(http.Header)
 
 	var etagHandler etag.Handler
@@ -248,24 +529,14 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 		if etagHandler.SetActualProcessingOptions(po) {
 			if imgEtag := etagHandler.ImageEtagExpected(); len(imgEtag) != 0 {
 				imgRequestHeader.Set("If-None-Match", imgEtag)
+			} else if imgLastMod := etagHandler.ImageLastModifiedExpected(); len(imgLastMod) != 0 {
+				imgRequestHeader.Set("If-Modified-Since", imgLastMod)
 			}
 		}
 	}
 
 	// The heavy part start here, so we need to restrict concurrency
-	var processingSemToken *semaphore.Token
-	func() {
-		defer metrics.StartQueueSegment(ctx)()
-
-		var aquired bool
-		processingSemToken, aquired = processingSem.Aquire(ctx)
-		if !aquired {
-			// We don't actually need to check timeout here,
-			// but it's an easy way to check if this is an actual timeout
-			// or the request was cancelled
-			checkErr(ctx, "queue", router.CheckTimeout(ctx))
-		}
-	}()
+	processingSemToken := acquireProcessingSem(ctx, queueSegmentCancel)
 	defer processingSemToken.Release()
 
 	stats.IncImagesInProgress()
@@ -273,18 +544,17 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 
 	statusCode := http.StatusOK
 
-	originData, err := func() (*imagedata.ImageData, error) {
-		defer metrics.StartDownloadingSegment(ctx)()
-
-		var cookieJar *cookiejar.Jar
-
-		if config.CookiePassthrough {
-			cookieJar, err = cookies.JarFromRequest(r)
-			checkErr(ctx, "download", err)
-		}
+	var (
+		originData *imagedata.ImageData
+		err        error
+	)
 
-		return imagedata.Download(imageURL, "source image", imgRequestHeader, cookieJar)
-	}()
+	if imagedata.IsColorSource(imageURL) {
+		originData, err = generateColorSource(imageURL, po)
+		checkErr(ctx, "path_parsing", err)
+	} else {
+		originData, err = downloadOrigin(ctx, r, imageURL, imgRequestHeader, po)
+	}
 
 	if err == nil {
 		defer originData.Close()
@@ -301,7 +571,7 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 			errorreport.Report(err, r)
 		}
 
-		metrics.SendError(ctx, "download", err)
+		sendErr(ctx, errTypeDownloadOther, err)
 
 		if imagedata.FallbackImage == nil {
 			panic(err)
@@ -330,57 +600,68 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 
 	checkErr(ctx, "timeout", router.CheckTimeout(ctx))
 
-	if originData.Type == po.Format || po.Format == imagetype.Unknown {
-		// Don't process SVG
-		if originData.Type == imagetype.SVG {
-			if config.SanitizeSvg {
-				sanitized, svgErr := svg.Satitize(originData.Data)
-				checkErr(ctx, "svg_processing", svgErr)
-
-				// Since we'll replace origin data, it's better to close it to return
-				// it's buffer to the pool
-				originData.Close()
-
-				originData = &imagedata.ImageData{
-					Data: sanitized,
-					Type: imagetype.SVG,
-				}
-			}
-
-			respondWithImage(reqID, r, rw, statusCode, originData, po, imageURL, originData)
-			return
-		}
+	originData = sanitizeOrigin(ctx, originData)
 
-		if len(po.SkipProcessingFormats) > 0 {
-			for _, f := range po.SkipProcessingFormats {
-				if f == originData.Type {
-					respondWithImage(reqID, r, rw, statusCode, originData, po, imageURL, originData)
-					return
-				}
-			}
-		}
+	if canPassthroughOrigin(po, originData) {
+		respondWithImage(reqID, r, rw, statusCode, originData, po, imageURL, originData)
+		return
 	}
 
-	if !vips.SupportsLoad(originData.Type) {
-		sendErrAndPanic(ctx, "processing", ierrors.New(
-			422,
-			fmt.Sprintf("Source image format is not supported: %s", originData.Type),
-			"Invalid URL",
-		))
-	}
+	checkProcessable(ctx, po, originData)
 
-	// At this point we can't allow requested format to be SVG as we can't save SVGs
-	if po.Format == imagetype.SVG {
-		sendErrAndPanic(ctx, "processing", ierrors.New(
-			422, "Resulting image format is not supported: svg", "Invalid URL",
-		))
+	stream := &resultStream{
+		rw:         rw,
+		statusCode: statusCode,
+		po:         po,
+		originURL:  imageURL,
+		originData: originData,
 	}
 
+	// The response writer can't be used after the handler returns,
+	// so we need to stop sending the result in case of panic
+	defer stream.Finish(true)
+
 	resultData, err := func() (*imagedata.ImageData, error) {
-		defer metrics.StartProcessingSegment(ctx)()
-		return processing.ProcessImage(ctx, originData, po)
+		ctx, cancel := metrics.StartProcessingSegment(ctx)
+		defer cancel()
+
+		return processing.ProcessImageStream(ctx, originData, po, stream)
 	}()
-	checkErr(ctx, "processing", err)
+
+	// The result is encoded, so we don't need the worker anymore.
+	// Sending the result to a slow client shouldn't hold it
+	processingSemToken.Release()
+
+	if err != nil {
+		errType := processingErrType(err)
+
+		if stream.started {
+			// We've already sent the response headers and a part of the result,
+			// so the only thing we can do is to abort the response
+			stream.Finish(true)
+			metrics.SendError(ctx, errType, err)
+			log.Errorf("Streaming of %s failed: %s", imageURL, err)
+			panic(http.ErrAbortHandler)
+		}
+
+		sendErrAndPanic(ctx, errType, err)
+	}
+
+	// The result was streamed to the response
+	if resultData == nil {
+		if err = stream.Finish(false); err != nil {
+			log.Debugf("Can't send the streamed result of %s: %s", imageURL, err)
+		}
+
+		router.LogResponse(
+			reqID, r, statusCode, nil,
+			log.Fields{
+				"image_url":          imageURL,
+				"processing_options": po,
+			},
+		)
+		return
+	}
 
 	defer resultData.Close()
 
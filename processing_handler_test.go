@@ -2,15 +2,25 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/imgproxy/imgproxy/v3/config"
 	"github.com/imgproxy/imgproxy/v3/config/configurators"
@@ -18,13 +28,18 @@ import (
 	"github.com/imgproxy/imgproxy/v3/imagedata"
 	"github.com/imgproxy/imgproxy/v3/imagemeta"
 	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/imgproxy/imgproxy/v3/metrics/stats"
+	"github.com/imgproxy/imgproxy/v3/metrics/statsd"
 	"github.com/imgproxy/imgproxy/v3/options"
+	"github.com/imgproxy/imgproxy/v3/processing"
 	"github.com/imgproxy/imgproxy/v3/router"
+	"github.com/imgproxy/imgproxy/v3/semaphore"
 	"github.com/imgproxy/imgproxy/v3/svg"
 	"github.com/imgproxy/imgproxy/v3/vips"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"golang.org/x/image/webp"
 )
 
 type ProcessingHandlerTestSuite struct {
@@ -89,6 +104,142 @@ func (s *ProcessingHandlerTestSuite) readBody(res *http.Response) []byte {
 	return data
 }
 
+// requireGolden checks that the response contains a PNG image that matches
+// the golden image from testdata/golden with the provided per-channel tolerance
+func (s *ProcessingHandlerTestSuite) requireGolden(res *http.Response, name string, tolerance int) {
+	actual, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	expected, err := png.Decode(bytes.NewReader(s.readTestFile(filepath.Join("golden", name))))
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), expected.Bounds(), actual.Bounds())
+
+	bounds := expected.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			er, eg, eb, ea := expected.At(x, y).RGBA()
+			ar, ag, ab, aa := actual.At(x, y).RGBA()
+
+			for i, c := range [][2]uint32{{er, ar}, {eg, ag}, {eb, ab}, {ea, aa}} {
+				diff := int(c[0]>>8) - int(c[1]>>8)
+				if diff < 0 {
+					diff = -diff
+				}
+
+				require.LessOrEqual(s.T(), diff, tolerance, "Pixel %d:%d channel %d doesn't match the golden image %s", x, y, i, name)
+			}
+		}
+	}
+}
+
+// setWatermark sets the watermark image from testdata for the current test
+func (s *ProcessingHandlerTestSuite) setWatermark(name string) {
+	wm, err := imagedata.FromFile(filepath.Join("testdata", name), "watermark")
+	require.Nil(s.T(), err)
+
+	prev := imagedata.Watermark
+	imagedata.Watermark = wm
+
+	s.T().Cleanup(func() { imagedata.Watermark = prev })
+}
+
+// redBounds returns the bounds of the red area of the PNG image from the response
+func (s *ProcessingHandlerTestSuite) redBounds(res *http.Response) image.Rectangle {
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	bounds := image.Rectangle{}
+	imgBounds := img.Bounds()
+
+	for y := imgBounds.Min.Y; y < imgBounds.Max.Y; y++ {
+		for x := imgBounds.Min.X; x < imgBounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if r>>8 > 200 && g>>8 < 100 && b>>8 < 100 {
+				bounds = bounds.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	return bounds
+}
+
+// requirePixels checks that the response contains a PNG image with the provided pixels
+func (s *ProcessingHandlerTestSuite) requirePixels(res *http.Response, expected [][][3]uint8) {
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), image.Rect(0, 0, len(expected[0]), len(expected)), img.Bounds())
+
+	for y, row := range expected {
+		for x, c := range row {
+			r, g, b, _ := img.At(x, y).RGBA()
+			require.Equal(s.T(), c, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}, "Pixel %d:%d", x, y)
+		}
+	}
+}
+
+type pixelPoint struct {
+	x, y  int
+	color [3]uint8
+}
+
+// requirePoints checks that the image has the provided colors at the provided points
+func (s *ProcessingHandlerTestSuite) requirePoints(img image.Image, points []pixelPoint) {
+	for _, p := range points {
+		r, g, b, _ := img.At(p.x, p.y).RGBA()
+		require.Equal(s.T(), p.color, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}, "Pixel %d:%d", p.x, p.y)
+	}
+}
+
+// onlyColors checks if the response contains a PNG image that consists of the provided colors only
+func (s *ProcessingHandlerTestSuite) onlyColors(res *http.Response, colors ...[3]uint8) bool {
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			c := [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+
+			found := false
+			for _, cc := range colors {
+				if c == cc {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (s *ProcessingHandlerTestSuite) imagesDiffer(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return true
+	}
+
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ar, ag, ab, aa := a.At(x, y).RGBA()
+			br, bg, bb, ba := b.At(x, y).RGBA()
+
+			if ar != br || ag != bg || ab != bb || aa != ba {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (s *ProcessingHandlerTestSuite) sampleETagData(imgETag string) (string, *imagedata.ImageData, string) {
 	poStr := "rs:fill:4:4"
 
@@ -148,6 +299,70 @@ func (s *ProcessingHandlerTestSuite) TestSignatureValidationSuccess() {
 	require.Equal(s.T(), 200, res.StatusCode)
 }
 
+func (s *ProcessingHandlerTestSuite) TestSourceSizeHints() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+	config.EnableDebugHeaders = true
+
+	config.EnableWarningsHeader = true
+
+	rw := s.send("/PG-ijgDQ37_43ebKHpJj2InDEyCivRIYJ9eqHuy2Oa8/srcw:10/srch:10/rs:fit:4:4/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Height"))
+	require.Empty(s.T(), res.Header.Get("X-Imgproxy-Warnings"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceSizeHintsMismatch() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+	config.EnableDebugHeaders = true
+	config.EnableWarningsHeader = true
+
+	// test1.png is 10x10, but the hints say it's 20x20, so the hints are ignored
+	rw := s.send("/87U1WC9QO-Md4mb20OklXOdhJfqvVXqO4aeo64Jj0mg/srcw:20/srch:20/rs:fit:4:4/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Height"))
+	require.Equal(s.T(), "Source size hint 20x20 doesn't match the source image size 10x10", res.Header.Get("X-Imgproxy-Warnings"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceSizeHintsResolutionLimit() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+
+	rw := s.send("/C6yoSLo9rc3JNhbtnS7Xsx8TW9-oQkRIP5GV82odslU/srcw:5000/srch:5000/rs:fit:4:4/plain/local:///test1.png")
+	require.Equal(s.T(), 422, rw.Result().StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceSizeProbed() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/bZZ_Y0_C0atdT1VYIDQMa5i93LO-o7gedngjyCpK0Ik/rs:fit:4:4/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceSizeHintsUnsigned() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/srcw:20/srch:20/rs:fit:4:4/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Height"))
+}
+
 func (s *ProcessingHandlerTestSuite) TestSourceValidation() {
 	imagedata.RedirectAllRequestsTo("local:///test1.png")
 	defer imagedata.StopRedirectingRequests()
@@ -220,6 +435,36 @@ func (s *ProcessingHandlerTestSuite) TestSourceFormatNotSupported() {
 	require.Equal(s.T(), 422, res.StatusCode)
 }
 
+func (s *ProcessingHandlerTestSuite) TestSourceFormatNotSupportedPassthrough() {
+	config.PassthroughUnsupportedFormats = true
+
+	vips.DisableLoadSupport(imagetype.PNG)
+	defer vips.ResetLoadSupport()
+
+	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png@jpg")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "image/png", res.Header.Get("Content-Type"))
+
+	actual := s.readBody(res)
+	expected := s.readTestFile("test1.png")
+
+	require.True(s.T(), bytes.Equal(expected, actual))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceFormatNotSupportedPassthroughDisabled() {
+	config.PassthroughUnsupportedFormats = false
+
+	vips.DisableLoadSupport(imagetype.PNG)
+	defer vips.ResetLoadSupport()
+
+	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png@jpg")
+	res := rw.Result()
+
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
 func (s *ProcessingHandlerTestSuite) TestResultingFormatNotSupported() {
 	vips.DisableSaveSupport(imagetype.PNG)
 	defer vips.ResetSaveSupport()
@@ -298,165 +543,3240 @@ func (s *ProcessingHandlerTestSuite) TestSkipProcessingSVG() {
 	require.True(s.T(), bytes.Equal(expected, actual))
 }
 
-func (s *ProcessingHandlerTestSuite) TestNotSkipProcessingSVGToJPG() {
-	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.svg@jpg")
+func (s *ProcessingHandlerTestSuite) TestPassthroughSmallerSource() {
+	config.PassthroughSmallerSource = true
+	config.StripMetadata = false
+
+	rw := s.send("/unsafe/q:100/plain/local:///test-lowq.jpg")
 	res := rw.Result()
 
 	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "image/jpeg", res.Header.Get("Content-Type"))
 
 	actual := s.readBody(res)
-	expected := s.readTestFile("test1.svg")
+	expected := s.readTestFile("test-lowq.jpg")
 
-	require.False(s.T(), bytes.Equal(expected, actual))
+	require.True(s.T(), bytes.Equal(expected, actual))
 }
 
-func (s *ProcessingHandlerTestSuite) TestErrorSavingToSVG() {
-	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png@svg")
-	res := rw.Result()
-
-	require.Equal(s.T(), 422, res.StatusCode)
-}
+func (s *ProcessingHandlerTestSuite) TestPassthroughSmallerSourceResultSmaller() {
+	config.PassthroughSmallerSource = true
 
-func (s *ProcessingHandlerTestSuite) TestCacheControlPassthrough() {
-	config.CacheControlPassthrough = true
+	rw := s.send("/unsafe/q:10/plain/local:///test1.jpg")
+	res := rw.Result()
 
-	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Set("Cache-Control", "fake-cache-control")
-		rw.Header().Set("Expires", "fake-expires")
-		rw.WriteHeader(200)
-		rw.Write(s.readTestFile("test1.png"))
-	}))
-	defer ts.Close()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "image/jpeg", res.Header.Get("Content-Type"))
 
-	rw := s.send("/unsafe/rs:fill:4:4/plain/" + ts.URL)
-	res := rw.Result()
+	actual := s.readBody(res)
+	source := s.readTestFile("test1.jpg")
 
-	require.Equal(s.T(), "fake-cache-control", res.Header.Get("Cache-Control"))
-	require.Equal(s.T(), "fake-expires", res.Header.Get("Expires"))
+	require.Less(s.T(), len(actual), len(source))
 }
 
-func (s *ProcessingHandlerTestSuite) TestCacheControlPassthroughDisabled() {
-	config.CacheControlPassthrough = false
-
-	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Set("Cache-Control", "fake-cache-control")
-		rw.Header().Set("Expires", "fake-expires")
-		rw.WriteHeader(200)
-		rw.Write(s.readTestFile("test1.png"))
-	}))
-	defer ts.Close()
+func (s *ProcessingHandlerTestSuite) TestPassthroughSmallerSourceFiltersApplied() {
+	config.PassthroughSmallerSource = true
 
-	rw := s.send("/unsafe/rs:fill:4:4/plain/" + ts.URL)
+	rw := s.send("/unsafe/q:100/bl:2/plain/local:///test-lowq.jpg")
 	res := rw.Result()
 
-	require.NotEqual(s.T(), "fake-cache-control", res.Header.Get("Cache-Control"))
-	require.NotEqual(s.T(), "fake-expires", res.Header.Get("Expires"))
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	actual := s.readBody(res)
+	expected := s.readTestFile("test-lowq.jpg")
+
+	require.False(s.T(), bytes.Equal(expected, actual))
 }
 
-func (s *ProcessingHandlerTestSuite) TestETagDisabled() {
-	config.ETagEnabled = false
+func (s *ProcessingHandlerTestSuite) TestPassthroughSmallerSourceStripMetadata() {
+	config.PassthroughSmallerSource = true
+	config.StripMetadata = true
 
-	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png")
+	// test-orientation-1.jpg has EXIF that should be stripped from the result
+	rw := s.send("/unsafe/q:100/plain/local:///test-orientation-1.jpg")
 	res := rw.Result()
 
 	require.Equal(s.T(), 200, res.StatusCode)
-	require.Empty(s.T(), res.Header.Get("ETag"))
+
+	actual := s.readBody(res)
+
+	require.False(s.T(), bytes.Equal(s.readTestFile("test-orientation-1.jpg"), actual))
+	require.False(s.T(), bytes.Contains(actual, []byte("Exif")))
 }
 
-func (s *ProcessingHandlerTestSuite) TestETagReqNoIfNotModified() {
-	config.ETagEnabled = true
+func (s *ProcessingHandlerTestSuite) TestReproducible() {
+	for _, ext := range []string{"jpg", "png", "webp"} {
+		path := fmt.Sprintf("/unsafe/rs:fit:4:4/rpr:1/plain/local:///test1.jpg@%s", ext)
 
-	poStr, imgdata, etag := s.sampleETagData("loremipsumdolor")
+		res := s.send(path).Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+		first := s.readBody(res)
 
-	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		require.Empty(s.T(), r.Header.Get("If-None-Match"))
+		res = s.send(path).Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+		second := s.readBody(res)
 
-		rw.Header().Set("ETag", imgdata.Headers["ETag"])
-		rw.WriteHeader(200)
-		rw.Write(s.readTestFile("test1.png"))
-	}))
-	defer ts.Close()
+		require.True(s.T(), bytes.Equal(first, second), "Results for %s differ", ext)
+	}
+}
 
-	rw := s.send(fmt.Sprintf("/unsafe/%s/plain/%s", poStr, ts.URL))
-	res := rw.Result()
+var (
+	testRed   = [3]uint8{255, 0, 0}
+	testGreen = [3]uint8{0, 255, 0}
+	testBlue  = [3]uint8{0, 0, 255}
+	testWhite = [3]uint8{255, 255, 255}
+)
 
+func (s *ProcessingHandlerTestSuite) TestFlip() {
+	res := s.send("/unsafe/flip:1/plain/local:///test-quadrants.png@png").Result()
 	require.Equal(s.T(), 200, res.StatusCode)
-	require.Equal(s.T(), etag, res.Header.Get("ETag"))
-}
 
-func (s *ProcessingHandlerTestSuite) TestETagDataNoIfNotModified() {
-	config.ETagEnabled = true
+	s.requirePixels(res, [][][3]uint8{
+		{testBlue, testWhite},
+		{testRed, testGreen},
+	})
+}
 
-	poStr, imgdata, etag := s.sampleETagData("")
+func (s *ProcessingHandlerTestSuite) TestFlop() {
+	res := s.send("/unsafe/flop:1/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
 
-	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		require.Empty(s.T(), r.Header.Get("If-None-Match"))
+	s.requirePixels(res, [][][3]uint8{
+		{testGreen, testRed},
+		{testWhite, testBlue},
+	})
+}
 
-		rw.WriteHeader(200)
-		rw.Write(imgdata.Data)
-	}))
-	defer ts.Close()
+func (s *ProcessingHandlerTestSuite) TestFlipFlop() {
+	res := s.send("/unsafe/flip:1/flop:1/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
 
-	rw := s.send(fmt.Sprintf("/unsafe/%s/plain/%s", poStr, ts.URL))
-	res := rw.Result()
+	s.requirePixels(res, [][][3]uint8{
+		{testWhite, testBlue},
+		{testGreen, testRed},
+	})
+}
 
+func (s *ProcessingHandlerTestSuite) TestFlopCropGravity() {
+	// Gravity is relative to the resulting image, so the west crop of the flopped image
+	// contains the pixels from the east side of the source image
+	res := s.send("/unsafe/c:1:2:we/flop:1/plain/local:///test-quadrants.png@png").Result()
 	require.Equal(s.T(), 200, res.StatusCode)
-	require.Equal(s.T(), etag, res.Header.Get("ETag"))
+
+	s.requirePixels(res, [][][3]uint8{
+		{testGreen},
+		{testWhite},
+	})
 }
 
-func (s *ProcessingHandlerTestSuite) TestETagReqMatch() {
-	config.ETagEnabled = true
+func (s *ProcessingHandlerTestSuite) TestResizingAlgorithmEnlarge() {
+	// Nearest neighbour enlarging doesn't produce new colors
+	res := s.send("/unsafe/rs:force:8:8:1/ra:cubic:nearest/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
 
-	poStr, imgdata, etag := s.sampleETagData(`"loremipsumdolor"`)
+	require.True(s.T(), s.onlyColors(res, testRed, testGreen, testBlue, testWhite))
 
-	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		require.Equal(s.T(), imgdata.Headers["ETag"], r.Header.Get("If-None-Match"))
+	// Resizing algorithm is used for enlarging when the enlarge algorithm is not set
+	res = s.send("/unsafe/rs:force:8:8:1/ra:cubic/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
 
-		rw.WriteHeader(304)
-	}))
-	defer ts.Close()
+	require.False(s.T(), s.onlyColors(res, testRed, testGreen, testBlue, testWhite))
+}
 
-	header := make(http.Header)
-	header.Set("If-None-Match", etag)
+func (s *ProcessingHandlerTestSuite) TestResizingAlgorithmDownscale() {
+	black := [3]uint8{0, 0, 0}
 
-	rw := s.send(fmt.Sprintf("/unsafe/%s/plain/%s", poStr, ts.URL), header)
-	res := rw.Result()
+	// Nearest neighbour downscaling doesn't produce new colors
+	res := s.send("/unsafe/rs:force:4:4/ra:nearest:cubic/plain/local:///test-checkerboard.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
 
-	require.Equal(s.T(), 304, res.StatusCode)
-	require.Equal(s.T(), etag, res.Header.Get("ETag"))
+	require.True(s.T(), s.onlyColors(res, black, testWhite))
+
+	// Enlarge algorithm is not used for downscaling
+	res = s.send("/unsafe/rs:force:4:4/ra:cubic:nearest/plain/local:///test-checkerboard.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.False(s.T(), s.onlyColors(res, black, testWhite))
 }
 
-func (s *ProcessingHandlerTestSuite) TestETagDataMatch() {
-	config.ETagEnabled = true
+func (s *ProcessingHandlerTestSuite) TestResizeToOnePixel() {
+	testCases := []struct {
+		path          string
+		width, height int
+	}{
+		{"/unsafe/rs:fit:1:1/plain/local:///test1.png@png", 1, 1},
+		{"/unsafe/rs:fill:1:1/plain/local:///test1.png@png", 1, 1},
+		{"/unsafe/rs:force:1:1/plain/local:///test1.png@png", 1, 1},
+		{"/unsafe/rs:force:1:1/plain/local:///test1.jpg@png", 1, 1},
+		{"/unsafe/rs:fit:1:1/ra:lanczos3/plain/local:///test1.png@png", 1, 1},
+		{"/unsafe/rs:fit:1:1/z:0.1/plain/local:///test1.png@png", 1, 1},
+		// Extreme aspect ratios: the smaller side can't be less than 1px
+		{"/unsafe/rs:fit:1:1/plain/local:///test-wide.png@png", 1, 1},
+		{"/unsafe/rs:fit:10:0/plain/local:///test-wide.png@png", 10, 1},
+		{"/unsafe/rs:fill:0:1/plain/local:///test-wide.png@png", 200, 1},
+		{"/unsafe/rs:fit:1:1/rot:90/plain/local:///test-wide.png@png", 1, 1},
+	}
 
-	poStr, imgdata, etag := s.sampleETagData("")
+	for _, tc := range testCases {
+		res := s.send(tc.path).Result()
+		require.Equal(s.T(), 200, res.StatusCode, tc.path)
 
-	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		require.Empty(s.T(), r.Header.Get("If-None-Match"))
+		img, err := png.Decode(res.Body)
+		require.Nil(s.T(), err, tc.path)
 
-		rw.WriteHeader(200)
-		rw.Write(imgdata.Data)
-	}))
-	defer ts.Close()
+		require.Equal(s.T(), tc.width, img.Bounds().Dx(), tc.path)
+		require.Equal(s.T(), tc.height, img.Bounds().Dy(), tc.path)
+	}
+}
 
-	header := make(http.Header)
-	header.Set("If-None-Match", etag)
+func (s *ProcessingHandlerTestSuite) TestColorSource() {
+	res := s.send("/unsafe/rs:fill:100:100/plain/color:ff0000@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "image/png", res.Header.Get("Content-Type"))
 
-	rw := s.send(fmt.Sprintf("/unsafe/%s/plain/%s", poStr, ts.URL), header)
-	res := rw.Result()
+	data := s.readBody(res)
 
-	require.Equal(s.T(), 304, res.StatusCode)
-	require.Equal(s.T(), etag, res.Header.Get("ETag"))
+	img, err := png.Decode(bytes.NewReader(data))
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 100, img.Bounds().Dx())
+	require.Equal(s.T(), 100, img.Bounds().Dy())
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(data))
+	require.True(s.T(), s.onlyColors(res, testRed))
 }
 
-func (s *ProcessingHandlerTestSuite) TestETagReqNotMatch() {
-	config.ETagEnabled = true
+func (s *ProcessingHandlerTestSuite) TestColorSourceDpr() {
+	res := s.send("/unsafe/rs:fill:100:50/dpr:2/plain/color:f00@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
 
-	poStr, imgdata, actualETag := s.sampleETagData(`"loremipsumdolor"`)
-	_, _, expectedETag := s.sampleETagData(`"loremipsum"`)
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 200, img.Bounds().Dx())
+	require.Equal(s.T(), 100, img.Bounds().Dy())
+}
 
-	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		require.Equal(s.T(), `"loremipsum"`, r.Header.Get("If-None-Match"))
+func (s *ProcessingHandlerTestSuite) TestColorSourceInvalid() {
+	res := s.send("/unsafe/rs:fill:100:100/plain/color:zzzzzz@png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+
+	res = s.send("/unsafe/rs:fill:100:0/plain/color:ff0000@png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+// rotateTestCells rotates the grid of cells clockwise by the angle
+func rotateTestCells(cells [][][3]uint8, angle int) [][][3]uint8 {
+	for ; angle > 0; angle -= 90 {
+		rotated := make([][][3]uint8, len(cells[0]))
+		for y := range rotated {
+			rotated[y] = make([][3]uint8, len(cells))
+			for x := range rotated[y] {
+				rotated[y][x] = cells[len(cells)-1-x][y]
+			}
+		}
+		cells = rotated
+	}
+	return cells
+}
+
+func (s *ProcessingHandlerTestSuite) TestRotateOrientationCropGravity() {
+	// test-orientation-N.jpg files have the EXIF orientation N, but all of them
+	// look the same when the orientation is applied: 4x2 cells of 16x16 pixels
+	cells := [][][3]uint8{
+		{{255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {255, 255, 0}},
+		{{0, 255, 255}, {255, 0, 255}, {255, 255, 255}, {0, 0, 0}},
+	}
+
+	gravities := map[string]func(cells [][][3]uint8) [][][3]uint8{
+		"no": func(cells [][][3]uint8) [][][3]uint8 { return cells[:1] },
+		"so": func(cells [][][3]uint8) [][][3]uint8 { return cells[len(cells)-1:] },
+		"we": func(cells [][][3]uint8) [][][3]uint8 {
+			res := make([][][3]uint8, len(cells))
+			for y, row := range cells {
+				res[y] = row[:1]
+			}
+			return res
+		},
+		"ea": func(cells [][][3]uint8) [][][3]uint8 {
+			res := make([][][3]uint8, len(cells))
+			for y, row := range cells {
+				res[y] = row[len(row)-1:]
+			}
+			return res
+		},
+	}
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		for _, angle := range []int{0, 90, 180, 270} {
+			for gravity, cropCells := range gravities {
+				// Crop a single row or column of cells
+				cropSize := "16:0"
+				if gravity == "no" || gravity == "so" {
+					cropSize = "0:16"
+				}
+
+				path := fmt.Sprintf(
+					"/unsafe/rot:%d/c:%s:%s/plain/local:///test-orientation-%d.jpg@png",
+					angle, cropSize, gravity, orientation,
+				)
+
+				res := s.send(path).Result()
+				require.Equal(s.T(), 200, res.StatusCode, path)
+
+				img, err := png.Decode(res.Body)
+				require.Nil(s.T(), err, path)
+
+				expected := cropCells(rotateTestCells(cells, angle))
+
+				require.Equal(s.T(), image.Rect(0, 0, len(expected[0])*16, len(expected)*16), img.Bounds(), path)
+
+				for y, row := range expected {
+					for x, c := range row {
+						r, g, b, _ := img.At(x*16+8, y*16+8).RGBA()
+
+						require.InDelta(s.T(), c[0], r>>8, 16, "%s: cell %d:%d", path, x, y)
+						require.InDelta(s.T(), c[1], g>>8, 16, "%s: cell %d:%d", path, x, y)
+						require.InDelta(s.T(), c[2], b>>8, 16, "%s: cell %d:%d", path, x, y)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestRotateOrientationCropFocusPoint() {
+	// See TestRotateOrientationCropGravity
+	cells := [][][3]uint8{
+		{{255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {255, 255, 0}},
+		{{0, 255, 255}, {255, 0, 255}, {255, 255, 255}, {0, 0, 0}},
+	}
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		for _, angle := range []int{0, 90, 180, 270} {
+			rotated := rotateTestCells(cells, angle)
+			rows, cols := len(rotated), len(rotated[0])
+
+			// Focus point is relative to the resulting image, so it should point
+			// to the same cell regardless of the orientation and the rotation
+			for _, cell := range [][2]int{{0, 0}, {cols - 1, 0}, {0, rows - 1}, {cols - 1, rows - 1}} {
+				x := (float64(cell[0]) + 0.5) / float64(cols)
+				y := (float64(cell[1]) + 0.5) / float64(rows)
+
+				path := fmt.Sprintf(
+					"/unsafe/rot:%d/c:16:16:fp:%g:%g/plain/local:///test-orientation-%d.jpg@png",
+					angle, x, y, orientation,
+				)
+
+				res := s.send(path).Result()
+				require.Equal(s.T(), 200, res.StatusCode, path)
+
+				img, err := png.Decode(res.Body)
+				require.Nil(s.T(), err, path)
+
+				require.Equal(s.T(), image.Rect(0, 0, 16, 16), img.Bounds(), path)
+
+				c := rotated[cell[1]][cell[0]]
+				r, g, b, _ := img.At(8, 8).RGBA()
+
+				require.InDelta(s.T(), c[0], r>>8, 16, path)
+				require.InDelta(s.T(), c[1], g>>8, 16, path)
+				require.InDelta(s.T(), c[2], b>>8, 16, path)
+			}
+		}
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestAutoRotateDisabled() {
+	// See TestRotateOrientationCropGravity
+	cells := [][][3]uint8{
+		{{255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {255, 255, 0}},
+		{{0, 255, 255}, {255, 0, 255}, {255, 255, 255}, {0, 0, 0}},
+	}
+
+	testCases := []struct {
+		path     string
+		expected [][][3]uint8
+	}{
+		// The orientation 6 requires a 90 degree rotation to be displayed properly,
+		// so the raw image is the displayed one rotated by 270 degrees
+		{"/unsafe/plain/local:///test-orientation-6.jpg@png", cells},
+		{"/unsafe/ar:0/plain/local:///test-orientation-6.jpg@png", rotateTestCells(cells, 270)},
+		{"/unsafe/ar:0/rot:90/plain/local:///test-orientation-6.jpg@png", cells},
+	}
+
+	for _, tc := range testCases {
+		res := s.send(tc.path).Result()
+		require.Equal(s.T(), 200, res.StatusCode, tc.path)
+
+		img, err := png.Decode(res.Body)
+		require.Nil(s.T(), err, tc.path)
+
+		require.Equal(s.T(), image.Rect(0, 0, len(tc.expected[0])*16, len(tc.expected)*16), img.Bounds(), tc.path)
+
+		for y, row := range tc.expected {
+			for x, c := range row {
+				r, g, b, _ := img.At(x*16+8, y*16+8).RGBA()
+
+				require.InDelta(s.T(), c[0], r>>8, 16, "%s: cell %d:%d", tc.path, x, y)
+				require.InDelta(s.T(), c[1], g>>8, 16, "%s: cell %d:%d", tc.path, x, y)
+				require.InDelta(s.T(), c[2], b>>8, 16, "%s: cell %d:%d", tc.path, x, y)
+			}
+		}
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestAutoRotateKeepsOrientation() {
+	testCases := []struct {
+		path        string
+		orientation int
+		width       int
+		height      int
+	}{
+		{"/unsafe/plain/local:///test-orientation-6.jpg@jpg", 1, 64, 32},
+		{"/unsafe/ar:0/plain/local:///test-orientation-6.jpg@jpg", 6, 32, 64},
+	}
+
+	for _, tc := range testCases {
+		res := s.send(tc.path).Result()
+		require.Equal(s.T(), 200, res.StatusCode, tc.path)
+
+		img := new(vips.Image)
+		err := img.Load(&imagedata.ImageData{Data: s.readBody(res), Type: imagetype.JPEG}, 1, 1.0, 1)
+		require.Nil(s.T(), err, tc.path)
+
+		require.Equal(s.T(), tc.orientation, int(img.Orientation()), tc.path)
+		require.Equal(s.T(), tc.width, img.Width(), tc.path)
+		require.Equal(s.T(), tc.height, img.Height(), tc.path)
+
+		img.Clear()
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropBasisRotated() {
+	// See TestRotateOrientationCropGravity.
+	// Rotated by 90 degrees, the cells are 2x4: C R / M G / W B / K Y
+	cyan, red, magenta, green := [3]uint8{0, 255, 255}, [3]uint8{255, 0, 0}, [3]uint8{255, 0, 255}, [3]uint8{0, 255, 0}
+
+	white, blue, black, yellow := [3]uint8{255, 255, 255}, [3]uint8{0, 0, 255}, [3]uint8{0, 0, 0}, [3]uint8{255, 255, 0}
+
+	testCases := []struct {
+		crop     string
+		basis    string
+		cellSize int
+		expected [][][3]uint8
+	}{
+		// The crop is taken from the source image, and the result is not enlarged
+		{"c:16:16:nowe", "source", 16, [][][3]uint8{{cyan}}},
+		// The image is downscaled to 16x32, and the crop is set in its pixels
+		{"c:16:16:nowe", "output", 8, [][][3]uint8{{cyan, red}, {magenta, green}}},
+		// The offset is set in the downscaled image pixels too
+		{"c:16:16:nowe:0:16", "output", 8, [][][3]uint8{{white, blue}, {black, yellow}}},
+		// The crop is set for the flopped image
+		{"c:16:16:nowe/flop:1", "output", 8, [][][3]uint8{{red, cyan}, {green, magenta}}},
+	}
+
+	for _, tc := range testCases {
+		for _, src := range []string{"rot:90/plain/local:///test-orientation-1.jpg", "rot:90/plain/local:///test-orientation-6.jpg"} {
+			path := fmt.Sprintf("/unsafe/rs:fit:0:32/%s/crop_basis:%s/%s@png", tc.crop, tc.basis, src)
+
+			res := s.send(path).Result()
+			require.Equal(s.T(), 200, res.StatusCode, path)
+
+			img, err := png.Decode(res.Body)
+			require.Nil(s.T(), err, path)
+
+			require.Equal(s.T(), image.Rect(0, 0, 16, 16), img.Bounds(), path)
+
+			for y, row := range tc.expected {
+				for x, c := range row {
+					r, g, b, _ := img.At(x*tc.cellSize+tc.cellSize/2, y*tc.cellSize+tc.cellSize/2).RGBA()
+
+					require.InDelta(s.T(), c[0], r>>8, 16, "%s: cell %d:%d", path, x, y)
+					require.InDelta(s.T(), c[1], g>>8, 16, "%s: cell %d:%d", path, x, y)
+					require.InDelta(s.T(), c[2], b>>8, 16, "%s: cell %d:%d", path, x, y)
+				}
+			}
+		}
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropOverflow() {
+	// Without overflow, the crop area is moved inside the image
+	res := s.send("/unsafe/c:2:2:nowe:-1:-1/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	s.requirePixels(res, [][][3]uint8{{testRed, testGreen}, {testBlue, testWhite}})
+
+	// Only the red quadrant is inside the crop area. The source image has no alpha,
+	// so the rest is filled with the default background color
+	res = s.send("/unsafe/c:2:2:nowe:-1:-1/cof:1/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	s.requirePixels(res, [][][3]uint8{{testWhite, testWhite}, {testWhite, testRed}})
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropOverflowAlpha() {
+	res := s.send("/unsafe/c:2:2:nowe:-1:-1/cof:1/plain/local:///test-alpha-blob.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), image.Rect(0, 0, 2, 2), img.Bounds())
+
+	// The source image has alpha, so the area outside of it is transparent
+	for _, p := range []image.Point{{0, 0}, {1, 0}, {0, 1}} {
+		_, _, _, a := img.At(p.X, p.Y).RGBA()
+		require.Zero(s.T(), a, "Pixel %d:%d", p.X, p.Y)
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropOverflowBackground() {
+	// Negative offset of the south gravity moves the crop area down
+	res := s.send("/unsafe/c:2:2:so:0:-1/cof:1/bg:ff00ff/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	magenta := [3]uint8{255, 0, 255}
+	s.requirePixels(res, [][][3]uint8{{testBlue, testWhite}, {magenta, magenta}})
+
+	// The default background color is used too
+	config.Background = "00ff00"
+
+	res = s.send("/unsafe/c:2:2:nowe:-1:-1/cof:1/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	s.requirePixels(res, [][][3]uint8{{testGreen, testGreen}, {testGreen, testRed}})
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropToResultFocusPoint() {
+	testCases := []struct {
+		path     string
+		expected [][][3]uint8
+	}{
+		{"/unsafe/rs:fill:1:2/g:fp:0.9:0.5/plain/local:///test-quadrants.png@png", [][][3]uint8{{testGreen}, {testWhite}}},
+		{"/unsafe/rs:fill:1:2/g:fp:0.1:0.5/plain/local:///test-quadrants.png@png", [][][3]uint8{{testRed}, {testBlue}}},
+		{"/unsafe/rs:fill:1:2/g:fp:0.9:0.5/flop:1/plain/local:///test-quadrants.png@png", [][][3]uint8{{testRed}, {testBlue}}},
+		{"/unsafe/rs:fill:1:2/g:fp:0.9:0.5/rot:90/plain/local:///test-quadrants.png@png", [][][3]uint8{{testRed}, {testGreen}}},
+	}
+
+	for _, tc := range testCases {
+		res := s.send(tc.path).Result()
+		require.Equal(s.T(), 200, res.StatusCode, tc.path)
+
+		s.requirePixels(res, tc.expected)
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkScaleContain() {
+	s.setWatermark("test-wm-red.png")
+
+	res := s.send("/unsafe/wm:1:nowe:0:0:0.5:contain/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(0, 0, 25, 25), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkScaleCover() {
+	s.setWatermark("test-wm-red.png")
+
+	res := s.send("/unsafe/wm:1:nowe:0:0:0.5:cover/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(0, 0, 50, 25), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkScaleAbsolute() {
+	s.setWatermark("test-wm-red.png")
+
+	res := s.send("/unsafe/wm:1:nowe:0:0:2:abs/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(0, 0, 20, 20), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkScaleLongest() {
+	s.setWatermark("test-wm-red.png")
+
+	// test-white.png is 100x50, so the longest side of the watermark is 30px
+	res := s.send("/unsafe/wm:1:soea:0:0:0.3:longest/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(70, 20, 100, 50), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkScaleLongestClamped() {
+	s.setWatermark("test-wm-red.png")
+
+	// 80px watermark doesn't fit the 50px height, so it's clamped
+	res := s.send("/unsafe/wm:1:nowe:0:0:0.8:longest/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(0, 0, 50, 50), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkScaleLongestResult() {
+	s.setWatermark("test-wm-red.png")
+
+	// The scale is relative to the resultant image
+	res := s.send("/unsafe/rs:fit:50:25/wm:1:nowe:0:0:0.2:longest/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(0, 0, 10, 10), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkDpr() {
+	s.setWatermark("test-wm-red.png")
+
+	// Both the watermark size and its offsets are multiplied by DPR
+	res := s.send("/unsafe/rs:fit:50:25/dpr:2/wm:1:nowe:5:5/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(10, 10, 30, 30), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkList() {
+	s.setWatermark("test-wm-red.png")
+
+	res := s.send("/unsafe/wm:1,1:nowe,soea:0:0:0.2:longest/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	red, white := [3]uint8{255, 0, 0}, [3]uint8{255, 255, 255}
+
+	s.requirePoints(img, []pixelPoint{
+		{5, 5, red},
+		{95, 45, red},
+		{50, 25, white},
+		{95, 5, white},
+		{5, 45, white},
+	})
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkListDpr() {
+	s.setWatermark("test-wm-red.png")
+
+	// Each watermark has its own offsets multiplied by DPR
+	res := s.send("/unsafe/rs:fit:50:25/dpr:2/wm:1,1:nowe,soea:5,0:5,0/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	red, white := [3]uint8{255, 0, 0}, [3]uint8{255, 255, 255}
+
+	s.requirePoints(img, []pixelPoint{
+		{5, 5, white},
+		{15, 15, red},
+		{25, 25, red},
+		{50, 25, white},
+		{85, 35, red},
+		{95, 45, red},
+	})
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkRegion() {
+	s.setWatermark("test-wm-red.png")
+
+	res := s.send("/unsafe/wm:1:re/wmr:0:0.8:0:0.2/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requireGolden(res, "watermark-region.png", 1)
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkRotate() {
+	s.setWatermark("test-wm-bar.png")
+
+	res := s.send("/unsafe/wm:1:ce/wmrt:45/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	// The bar has soft edges, so interpolation and half-pixel centering
+	// differences fit into the tolerance
+	s.requireGolden(res, "watermark-rotate-45.png", 48)
+}
+
+func (s *ProcessingHandlerTestSuite) TestWatermarkTint() {
+	s.setWatermark("test-wm-white.png")
+
+	res := s.send("/unsafe/wm:1:nowe/wmtn:0000ff/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	// The opaque half of the watermark takes the tint color
+	r, g, b, _ := img.At(2, 2).RGBA()
+	require.Equal(s.T(), [3]uint32{0, 0, 255}, [3]uint32{r >> 8, g >> 8, b >> 8})
+
+	// The semi-transparent half keeps its alpha, so it's blended with the image
+	r, g, b, _ = img.At(7, 2).RGBA()
+	require.InDelta(s.T(), 127, r>>8, 2)
+	require.InDelta(s.T(), 127, g>>8, 2)
+	require.Equal(s.T(), uint32(255), b>>8)
+
+	// The image outside of the watermark is not changed
+	r, g, b, _ = img.At(50, 25).RGBA()
+	require.Equal(s.T(), [3]uint32{255, 255, 255}, [3]uint32{r >> 8, g >> 8, b >> 8})
+}
+
+func (s *ProcessingHandlerTestSuite) TestUnsafeSVGStrip() {
+	config.SanitizeSvgMode = "strip"
+
+	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1-unsafe.svg")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	actual := string(s.readBody(res))
+
+	require.NotContains(s.T(), actual, "ENTITY")
+	require.NotContains(s.T(), actual, "script")
+	require.NotContains(s.T(), actual, "onload")
+	require.NotContains(s.T(), actual, "https://example.com")
+}
+
+func (s *ProcessingHandlerTestSuite) TestUnsafeSVGReject() {
+	config.SanitizeSvgMode = "reject"
+
+	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1-unsafe.svg")
+	res := rw.Result()
+
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestUnsafeSVGRejectToJPG() {
+	config.SanitizeSvgMode = "reject"
+
+	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1-unsafe.svg@jpg")
+	res := rw.Result()
+
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestNotSkipProcessingSVGToJPG() {
+	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.svg@jpg")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	actual := s.readBody(res)
+	expected := s.readTestFile("test1.svg")
+
+	require.False(s.T(), bytes.Equal(expected, actual))
+}
+
+func (s *ProcessingHandlerTestSuite) TestErrorSavingToSVG() {
+	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png@svg")
+	res := rw.Result()
+
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestFitWithoutExtend() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/rs:fit:8:4:0:0/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestFitWithExtend() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/rs:fit:8:4:0:1/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "8", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestProcessingOptionsDebugHeader() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/q:70/rs:fill:4:4/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	var resolved map[string]interface{}
+	err := json.Unmarshal([]byte(res.Header.Get("X-Processing-Options")), &resolved)
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), "fill", resolved["ResizingType"])
+	require.Equal(s.T(), 4.0, resolved["Width"])
+	require.Equal(s.T(), 4.0, resolved["Height"])
+	require.Equal(s.T(), 70.0, resolved["Quality"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestProcessingOptionsDebugHeaderDisabled() {
+	rw := s.send("/unsafe/q:70/rs:fill:4:4/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Processing-Options"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropBeforeResize() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/rs:fit:40:40/el:1/c:5:5/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "40", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "40", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropAfterResize() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/rs:fit:40:40/el:1/c:5:5/car:1/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "5", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "5", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropAfterResizeRelative() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/rs:fit:40:40/el:1/c:0.5:0.25/car:1/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "20", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropAfterResizeGravity() {
+	// The crop gravity takes precedence over the gravity
+	res := s.send("/unsafe/rs:force:4:4:1/ra:cubic:nearest/c:2:2:soea/g:nowe/car:1/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requirePixels(res, [][][3]uint8{
+		{testWhite, testWhite},
+		{testWhite, testWhite},
+	})
+
+	// The crop offsets are set in the resized image pixels
+	res = s.send("/unsafe/rs:force:4:4:1/ra:cubic:nearest/c:2:2:nowe:1:1/car:1/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requirePixels(res, [][][3]uint8{
+		{testRed, testGreen},
+		{testBlue, testWhite},
+	})
+}
+
+func (s *ProcessingHandlerTestSuite) TestCanvas() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/canvas:40:30:5/plain/local:///test1.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "40", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "30", res.Header.Get("X-Result-Height"))
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	// The image is scaled to 20x20 to fit 30x20 and centered on the 40x30 canvas
+	isOpaque := func(x, y int) bool {
+		_, _, _, a := img.At(x, y).RGBA()
+		return a == 0xffff
+	}
+
+	require.True(s.T(), isOpaque(10, 5))
+	require.True(s.T(), isOpaque(29, 24))
+
+	require.False(s.T(), isOpaque(9, 15))
+	require.False(s.T(), isOpaque(30, 15))
+	require.False(s.T(), isOpaque(20, 4))
+	require.False(s.T(), isOpaque(20, 25))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCanvasWithResize() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/rs:fit:4:4/canvas:20:20:2/plain/local:///test1.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "20", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "20", res.Header.Get("X-Result-Height"))
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	for _, p := range [][2]int{{1, 10}, {18, 10}, {10, 1}, {10, 18}} {
+		_, _, _, a := img.At(p[0], p[1]).RGBA()
+		require.Zero(s.T(), a, "Pixel %d:%d should be in the margin", p[0], p[1])
+	}
+
+	_, _, _, a := img.At(2, 2).RGBA()
+	require.Equal(s.T(), uint32(0xffff), a)
+}
+
+func (s *ProcessingHandlerTestSuite) TestTile() {
+	res := s.send("/unsafe/tile:5:3/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requirePixels(res, [][][3]uint8{
+		{testRed, testGreen, testRed, testGreen, testRed},
+		{testBlue, testWhite, testBlue, testWhite, testBlue},
+		{testRed, testGreen, testRed, testGreen, testRed},
+	})
+}
+
+func (s *ProcessingHandlerTestSuite) TestTileDpr() {
+	config.EnableDebugHeaders = true
+
+	res := s.send("/unsafe/tile:5:3/dpr:2/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "6", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestTileResolutionLimit() {
+	config.MaxSrcResolution = 100
+
+	res := s.send("/unsafe/tile:10:10/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	res = s.send("/unsafe/tile:10:11/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+
+	res = s.send("/unsafe/tile:5:5/dpr:3/plain/local:///test-quadrants.png@png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestVignette() {
+	res := s.send("/unsafe/vignette:0.8/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requireGolden(res, "vignette.png", 1)
+}
+
+func (s *ProcessingHandlerTestSuite) TestVignetteColor() {
+	res := s.send("/unsafe/vignette:1:ff0000/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	// The center stays intact while the corners get the vignette color
+	r, g, b, _ := img.At(50, 25).RGBA()
+	require.Equal(s.T(), testWhite, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)})
+
+	r, g, b, _ = img.At(0, 0).RGBA()
+	require.InDelta(s.T(), 255, r>>8, 1)
+	require.InDelta(s.T(), 5, g>>8, 5)
+	require.InDelta(s.T(), 5, b>>8, 5)
+}
+
+func (s *ProcessingHandlerTestSuite) TestGrain() {
+	res := s.send("/unsafe/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	plain := s.readBody(res)
+
+	res = s.send("/unsafe/grain:0.5:42/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	seeded := s.readBody(res)
+
+	require.NotEqual(s.T(), plain, seeded)
+
+	// The same seed produces the same grain
+	res = s.send("/unsafe/grain:0.5:42/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), seeded, s.readBody(res))
+
+	// A different seed produces a different grain
+	res = s.send("/unsafe/grain:0.5:43/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.NotEqual(s.T(), seeded, s.readBody(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestGrainReproducible() {
+	res := s.send("/unsafe/grain:0.5/rpr:1/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	first := s.readBody(res)
+
+	res = s.send("/unsafe/grain:0.5/rpr:1/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), first, s.readBody(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestDitherOrdered() {
+	res := s.send("/unsafe/dither:ordered/plain/local:///test-gray.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requireGolden(res, "dither-ordered.png", 1)
+}
+
+func (s *ProcessingHandlerTestSuite) TestDitherNone() {
+	res := s.send("/unsafe/dither:none/plain/local:///test-gray.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.True(s.T(), s.onlyColors(res, [3]uint8{128, 128, 128}))
+}
+
+func (s *ProcessingHandlerTestSuite) TestNormalize() {
+	levelsRange := func(path string) (uint8, uint8) {
+		res := s.send(path).Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+
+		img, err := png.Decode(res.Body)
+		require.Nil(s.T(), err)
+
+		min, max := uint8(255), uint8(0)
+
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				v := uint8(r >> 8)
+
+				if v < min {
+					min = v
+				}
+				if v > max {
+					max = v
+				}
+			}
+		}
+
+		return min, max
+	}
+
+	// test-lowcontrast.png has levels from 100 to 150
+	min, max := levelsRange("/unsafe/plain/local:///test-lowcontrast.png@png")
+	require.Equal(s.T(), uint8(100), min)
+	require.Equal(s.T(), uint8(150), max)
+
+	min, max = levelsRange("/unsafe/normalize:1/plain/local:///test-lowcontrast.png@png")
+	require.Equal(s.T(), uint8(0), min)
+	require.Equal(s.T(), uint8(255), max)
+
+	min, max = levelsRange("/unsafe/normalize:1:10/plain/local:///test-lowcontrast.png@png")
+	require.Equal(s.T(), uint8(0), min)
+	require.Equal(s.T(), uint8(255), max)
+}
+
+func (s *ProcessingHandlerTestSuite) TestAutoWhiteBalance() {
+	maxCast := func(path string) int {
+		res := s.send(path).Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+
+		img, err := png.Decode(res.Body)
+		require.Nil(s.T(), err)
+
+		cast := 0
+
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, _, b, _ := img.At(x, y).RGBA()
+				if d := int(r>>8) - int(b>>8); d > cast {
+					cast = d
+				} else if -d > cast {
+					cast = -d
+				}
+			}
+		}
+
+		return cast
+	}
+
+	// test-colorcast.png consists of gray patches with the warm color cast
+	require.Greater(s.T(), maxCast("/unsafe/plain/local:///test-colorcast.png@png"), 40)
+	require.LessOrEqual(s.T(), maxCast("/unsafe/auto_wb:1/plain/local:///test-colorcast.png@png"), 2)
+}
+
+func (s *ProcessingHandlerTestSuite) TestProcessingBudgetDegradesOptionalStages() {
+	// 1ms budget is spent before the filters are applied
+	res := s.send("/unsafe/pb:1/sh:1/vg:0.5/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "sharpen, vignette", res.Header.Get("X-Imgproxy-Degraded"))
+	require.Equal(s.T(), "no-store", res.Header.Get("Cache-Control"))
+	require.Empty(s.T(), res.Header.Get("Expires"))
+
+	// Blur is not optional
+	res = s.send("/unsafe/pb:1/bl:1/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Imgproxy-Degraded"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestProcessingBudgetDegradesMaxBytes() {
+	res := s.send("/unsafe/pb:1/mb:100/plain/local:///test1.png@jpg").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "max_bytes", res.Header.Get("X-Imgproxy-Degraded"))
+	require.Equal(s.T(), "no-store", res.Header.Get("Cache-Control"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestProcessingBudgetNotSpent() {
+	res := s.send("/unsafe/pb:60000/sh:1/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Imgproxy-Degraded"))
+	require.Equal(s.T(), fmt.Sprintf("max-age=%d, public", config.TTL), res.Header.Get("Cache-Control"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestProcessingBudgetFromConfig() {
+	config.ProcessingBudget = 1
+
+	res := s.send("/unsafe/sh:1/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "sharpen", res.Header.Get("X-Imgproxy-Degraded"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestWarningsHeaderClampedDpr() {
+	config.EnableWarningsHeader = true
+	config.MaxDpr = 2
+
+	res := s.send("/unsafe/rs:fill:4:4/dpr:5/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "DPR is limited to 2", res.Header.Get("X-Imgproxy-Warnings"))
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), image.Rect(0, 0, 8, 8), img.Bounds())
+}
+
+func (s *ProcessingHandlerTestSuite) TestWarningsHeaderNoWarnings() {
+	config.EnableWarningsHeader = true
+
+	res := s.send("/unsafe/rs:fill:4:4/dpr:2/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Imgproxy-Warnings"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestWarningsHeaderDisabled() {
+	config.MaxDpr = 2
+
+	res := s.send("/unsafe/rs:fill:4:4/dpr:5/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Imgproxy-Warnings"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestBlurSigmaLimitedWithDpr() {
+	config.MaxBlurSigma = 4
+	config.EnableWarningsHeader = true
+
+	res := s.send("/unsafe/bl:4/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Imgproxy-Warnings"))
+
+	res = s.send("/unsafe/bl:4/dpr:2/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "Blur sigma is limited to 4", res.Header.Get("X-Imgproxy-Warnings"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestFrameBest() {
+	// The second frame is a checkerboard while the others are smooth gradients
+	res := s.send("/unsafe/frame:best/c:2:2:nowe/plain/local:///test-frames.gif@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requirePixels(res, [][][3]uint8{
+		{{255, 255, 255}, {0, 0, 0}},
+		{{0, 0, 0}, {255, 255, 255}},
+	})
+}
+
+func (s *ProcessingHandlerTestSuite) TestFrameIndex() {
+	res := s.send("/unsafe/frame:0/c:2:2:nowe/plain/local:///test-frames.gif@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requirePixels(res, [][][3]uint8{
+		{{64, 64, 64}, {72, 72, 72}},
+		{{64, 64, 64}, {72, 72, 72}},
+	})
+
+	res = s.send("/unsafe/frame:2/c:2:2:nowe/plain/local:///test-frames.gif@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requirePixels(res, [][][3]uint8{
+		{{64, 64, 64}, {64, 64, 64}},
+		{{72, 72, 72}, {72, 72, 72}},
+	})
+}
+
+func (s *ProcessingHandlerTestSuite) TestFrameIndexOutOfRange() {
+	config.EnableWarningsHeader = true
+
+	res := s.send("/unsafe/frame:10/c:2:2:nowe/plain/local:///test-frames.gif@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "The image has only 3 frames. The last frame is used", res.Header.Get("X-Imgproxy-Warnings"))
+
+	s.requirePixels(res, [][][3]uint8{
+		{{64, 64, 64}, {64, 64, 64}},
+		{{72, 72, 72}, {72, 72, 72}},
+	})
+}
+
+func (s *ProcessingHandlerTestSuite) TestFrameResolutionLimit() {
+	// A single 16x16 frame fits the limit, but all 3 frames don't
+	config.MaxSrcResolution = 16 * 16 * 2
+
+	res := s.send("/unsafe/frame:0/plain/local:///test-frames.gif@png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestFrameAnimatedResult() {
+	res := s.send("/unsafe/frame:best/plain/local:///test-frames.gif@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	g, err := gif.DecodeAll(res.Body)
+	require.Nil(s.T(), err)
+	require.Len(s.T(), g.Image, 1)
+}
+
+// postOnlyOrigin starts an origin that serves test1.png only for POST requests
+// with a JSON body containing the expected path
+func (s *ProcessingHandlerTestSuite) postOnlyOrigin(requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		*requests++
+
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		require.Equal(s.T(), "application/json", r.Header.Get("Content-Type"))
+
+		var body struct{ Path string }
+		require.Nil(s.T(), json.NewDecoder(r.Body).Decode(&body))
+
+		if body.Path != "/images/42" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		rw.WriteHeader(200)
+		rw.Write(s.readTestFile("test1.png"))
+	}))
+}
+
+func (s *ProcessingHandlerTestSuite) setSourceRequest(pattern string) {
+	sr, err := config.NewSourceRequest("api", pattern, "post", `{"path": {{json .Path}}}`, "")
+	require.Nil(s.T(), err)
+
+	config.SourceRequests = []config.SourceRequest{sr}
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceRequestPost() {
+	var requests int
+	ts := s.postOnlyOrigin(&requests)
+	defer ts.Close()
+
+	s.setSourceRequest(ts.URL + "/images/*")
+
+	res := s.send("/unsafe/rs:fill:4:4/plain/" + ts.URL + "/images/42").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), 1, requests)
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceRequestPatternMismatch() {
+	var requests int
+	ts := s.postOnlyOrigin(&requests)
+	defer ts.Close()
+
+	s.setSourceRequest(ts.URL + "/images/*")
+
+	// The URL doesn't match the pattern, so the origin gets a GET request
+	res := s.send("/unsafe/rs:fill:4:4/plain/" + ts.URL + "/videos/42").Result()
+	require.Equal(s.T(), 404, res.StatusCode)
+	require.Equal(s.T(), 1, requests)
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceRequestSigned() {
+	var requests int
+	ts := s.postOnlyOrigin(&requests)
+	defer ts.Close()
+
+	s.setSourceRequest(ts.URL + "/images/*")
+
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+	config.BaseURL = ts.URL + "/"
+
+	res := s.send("/1e2a-SkBa5X12CS17HVxuybfd3Qmy4y4e-DBEuJzFaE/rs:fill:4:4/plain/images/42").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), 1, requests)
+
+	// The request body is built from the URL, so it can't be changed without
+	// breaking the signature
+	res = s.send("/1e2a-SkBa5X12CS17HVxuybfd3Qmy4y4e-DBEuJzFaE/rs:fill:4:4/plain/images/43").Result()
+	require.Equal(s.T(), 403, res.StatusCode)
+	require.Equal(s.T(), 1, requests)
+}
+
+// alphaError returns the mean absolute difference between the alpha planes
+// of the WebP response and the source image
+func (s *ProcessingHandlerTestSuite) alphaError(res *http.Response, srcName string) float64 {
+	img, err := webp.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	src, err := png.Decode(bytes.NewReader(s.readTestFile(srcName)))
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), src.Bounds(), img.Bounds())
+
+	var sum float64
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			_, _, _, srcA := src.At(x, y).RGBA()
+			sum += math.Abs(float64(a>>8) - float64(srcA>>8))
+		}
+	}
+
+	return sum / float64(bounds.Dx()*bounds.Dy())
+}
+
+func (s *ProcessingHandlerTestSuite) TestAlphaQuality() {
+	res := s.send("/unsafe/q:50/aq:100/plain/local:///test-alpha-gradient.png@webp").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	highErr := s.alphaError(res, "test-alpha-gradient.png")
+
+	res = s.send("/unsafe/q:50/aq:1/plain/local:///test-alpha-gradient.png@webp").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	lowErr := s.alphaError(res, "test-alpha-gradient.png")
+
+	// The alpha plane is compressed losslessly with the maximum quality
+	require.InDelta(s.T(), 0, highErr, 0.5)
+	require.Greater(s.T(), lowErr, highErr+1)
+}
+
+func (s *ProcessingHandlerTestSuite) TestPassthroughGif() {
+	config.PassthroughGif = true
+	config.StripMetadata = false
+
+	res := s.send("/unsafe/rs:fit:16:16/plain/local:///test-frames.gif@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "image/gif", res.Header.Get("Content-Type"))
+
+	require.True(s.T(), bytes.Equal(s.readTestFile("test-frames.gif"), s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestPassthroughGifDisabled() {
+	res := s.send("/unsafe/plain/local:///test-frames.gif@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.False(s.T(), bytes.Equal(s.readTestFile("test-frames.gif"), s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestPassthroughGifStripMetadata() {
+	config.PassthroughGif = true
+	config.StripMetadata = true
+
+	res := s.send("/unsafe/rs:fit:16:16/plain/local:///test-frames.gif@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.False(s.T(), bytes.Equal(s.readTestFile("test-frames.gif"), s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestPassthroughGifPixelsChanged() {
+	config.PassthroughGif = true
+
+	source := s.readTestFile("test-frames.gif")
+
+	for _, path := range []string{
+		"/unsafe/rs:fit:8:8/plain/local:///test-frames.gif@gif",
+		"/unsafe/bl:1/plain/local:///test-frames.gif@gif",
+		"/unsafe/dt:ordered/plain/local:///test-frames.gif@gif",
+		"/unsafe/frame:1/plain/local:///test-frames.gif@gif",
+	} {
+		res := s.send(path).Result()
+		require.Equal(s.T(), 200, res.StatusCode, path)
+		require.False(s.T(), bytes.Equal(source, s.readBody(res)), path)
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestPassthroughGifFramesLimited() {
+	config.PassthroughGif = true
+	config.MaxAnimationFrames = 2
+
+	res := s.send("/unsafe/plain/local:///test-frames.gif@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	g, err := gif.DecodeAll(res.Body)
+	require.Nil(s.T(), err)
+	require.Len(s.T(), g.Image, 2)
+}
+
+func (s *ProcessingHandlerTestSuite) TestMaxAnimationFramesOverride() {
+	config.MaxAnimationFrames = 10
+
+	res := s.send("/unsafe/plain/local:///test-frames.gif@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	g, err := gif.DecodeAll(res.Body)
+	require.Nil(s.T(), err)
+	require.Len(s.T(), g.Image, 3)
+
+	res = s.send("/unsafe/maf:2/plain/local:///test-frames.gif@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	g, err = gif.DecodeAll(res.Body)
+	require.Nil(s.T(), err)
+	require.Len(s.T(), g.Image, 2)
+}
+
+func (s *ProcessingHandlerTestSuite) TestMaxAnimationFramesOverrideStill() {
+	config.MaxAnimationFrames = 10
+
+	// A limit of 1 produces a still image
+	res := s.send("/unsafe/maf:1/plain/local:///test-frames.gif@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	g, err := gif.DecodeAll(res.Body)
+	require.Nil(s.T(), err)
+	require.Len(s.T(), g.Image, 1)
+}
+
+func (s *ProcessingHandlerTestSuite) TestMaxSrcResolutionOverride() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+
+	// test1.png is 10x10
+	config.MaxSrcResolution = 50
+	config.MaxSrcResolutionLimit = 1000
+
+	res := s.send("/My9d3xq_PYpVHsPrCyww0Kh1w5KZeZhIlWhsa4az1TI/rs:fill:4:4/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+
+	res = s.send("/FVak_XFKKLN-od50nbV15Q4bV4ct0FYicpHtoZg2uX0/msr:0.0002/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestMaxSrcResolutionOverrideLower() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+
+	res := s.send("/oy_T6L5SAjQjGpJRqg762ApKZfM29-S-FSFW_4H3Blw/msr:0.00001/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestMaxSrcResolutionOverrideCapped() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+	config.MaxSrcResolution = 50
+	config.MaxSrcResolutionLimit = 80
+
+	res := s.send("/Aog5uGWnEMGTKl56KxsnHnhKTtGo6ca4_MlrPoa_Dik/msr:100/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+
+	// Without the limit, the option can't raise the max source resolution at all
+	config.MaxSrcResolutionLimit = 0
+
+	res = s.send("/FVak_XFKKLN-od50nbV15Q4bV4ct0FYicpHtoZg2uX0/msr:0.0002/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestMaxSrcResolutionOverrideUnsigned() {
+	config.MaxSrcResolution = 50
+	config.MaxSrcResolutionLimit = 1000
+
+	res := s.send("/unsafe/msr:0.0002/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceConnectionsGauge() {
+	data := s.readTestFile("test1.png")
+
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		rw.WriteHeader(200)
+		rw.Write(data)
+	}))
+	defer ts.Close()
+
+	done := make(chan *http.Response)
+	go func() {
+		done <- s.send("/unsafe/rs:fill:4:4/plain/" + ts.URL).Result()
+	}()
+
+	<-arrived
+	require.Equal(s.T(), float64(1), stats.SourceConnections())
+
+	close(release)
+	res := <-done
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), float64(0), stats.SourceConnections())
+}
+
+func (s *ProcessingHandlerTestSuite) TestSmartCropEntropy() {
+	// The right quarter of the image is a checkerboard, the rest is flat gray
+	res := s.send("/unsafe/rs:fill:16:16/g:sm:entropy/plain/local:///test-smart.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.True(s.T(), s.onlyColors(res, [3]uint8{0, 0, 0}, [3]uint8{255, 255, 255}))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSmartCropOffsetNudge() {
+	// The detected area is nudged to the flat gray part of the image
+	res := s.send("/unsafe/rs:fill:16:16/g:sm:entropy:-16:0/plain/local:///test-smart.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.True(s.T(), s.onlyColors(res, [3]uint8{128, 128, 128}))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSmartCropNudgeOutOfBounds() {
+	// The nudged area can't leave the image
+	res := s.send("/unsafe/rs:fill:16:16/g:sm:entropy:100:100/plain/local:///test-smart.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.True(s.T(), s.onlyColors(res, [3]uint8{0, 0, 0}, [3]uint8{255, 255, 255}))
+}
+
+func (s *ProcessingHandlerTestSuite) setAllowedSourcesPolicies() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+
+	config.AllowedSources = []*regexp.Regexp{configurators.RegexpFromPattern("local:///test-white.png")}
+	config.AllowedSourcesPolicies = map[string][]*regexp.Regexp{
+		"png": {configurators.RegexpFromPattern("local:///*.png")},
+		"jpg": {configurators.RegexpFromPattern("local:///*.jpg")},
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestAllowedSourcesPolicy() {
+	s.setAllowedSourcesPolicies()
+
+	// /asp:png/plain/local:///test1.png
+	res := s.send("/DNOEvHNjUhxz9VHT1lgPy--Q5QEcd5nVb4kmCL20ksE/asp:png/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	// /asp:png/plain/local:///test1.jpg
+	res = s.send("/3uCRLvz3B4vbujq4ETo1x-e_j8IBmSagPW4xVL7OFCE/asp:png/plain/local:///test1.jpg").Result()
+	require.Equal(s.T(), 404, res.StatusCode)
+
+	// /asp:jpg/plain/local:///test1.jpg
+	res = s.send("/hmYbY3DgToG572iHKr1n82RULqWMTrCGXrSLC3E0QBs/asp:jpg/plain/local:///test1.jpg").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestAllowedSourcesPolicyNotSelected() {
+	s.setAllowedSourcesPolicies()
+
+	// /plain/local:///test1.png is checked against the global allowed sources
+	res := s.send("/RyFAmZJTJp-SdCeHdKcub8oRHwfMkiEI22bHEY1BT7U/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 404, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestAllowedSourcesPolicyUnsigned() {
+	config.AllowedSources = []*regexp.Regexp{configurators.RegexpFromPattern("local:///test-white.png")}
+	config.AllowedSourcesPolicies = map[string][]*regexp.Regexp{
+		"png": {configurators.RegexpFromPattern("local:///*.png")},
+	}
+
+	// The policy is ignored when signature checking is disabled
+	res := s.send("/unsafe/asp:png/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 404, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestEnforceThumbnailExif() {
+	// The image is red while its embedded 32x24 thumbnail is green
+	res := s.send("/unsafe/rs:fit:16:12/eth:1/plain/local:///test-exif-thumbnail.jpg@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rectangle{}, s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestEnforceThumbnailExifTooSmall() {
+	res := s.send("/unsafe/rs:fit:48:36/eth:1/plain/local:///test-exif-thumbnail.jpg@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(0, 0, 48, 36), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestEnforceThumbnailExifMissing() {
+	res := s.send("/unsafe/rs:fit:16:12/eth:1/plain/local:///test-no-exif-thumbnail.jpg@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(0, 0, 16, 12), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestEnforceThumbnailExifDisabled() {
+	res := s.send("/unsafe/rs:fit:16:12/plain/local:///test-exif-thumbnail.jpg@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(0, 0, 16, 12), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestResizeSmartFill() {
+	config.EnableDebugHeaders = true
+
+	// test1.png is 10x10, 8:7 aspect ratio differs from 1:1 by more than 0.1,
+	// so the image is filled
+	res := s.send("/unsafe/rs:smart:8:7/plain/local:///test1.png").Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "8", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "7", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestResizeSmartFit() {
+	config.EnableDebugHeaders = true
+
+	// With the 0.2 tolerance, 8:7 aspect ratio is close enough to 1:1,
+	// so the image is fit
+	res := s.send("/unsafe/rt:smart:0.2/s:8:7/plain/local:///test1.png").Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "7", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "7", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestPhysicalSize() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/physical_size:1:0.5:in:8/enlarge:1/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "8", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCorruptSourceImage() {
+	rw := s.send("/unsafe/rs:fit:4:4/plain/local:///test-corrupt.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 422, res.StatusCode)
+	require.Equal(s.T(), "Source image is corrupted or can't be decoded", string(s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCorruptSourceImageDevelopmentErrors() {
+	config.DevelopmentErrorsMode = true
+
+	rw := s.send("/unsafe/rs:fit:4:4/plain/local:///test-corrupt.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 422, res.StatusCode)
+	require.True(s.T(), strings.HasPrefix(string(s.readBody(res)), "Can't decode source image: "))
+}
+
+// largePngOrigin starts an origin that serves a large PNG image that takes
+// a noticeable time to decode
+func (s *ProcessingHandlerTestSuite) largePngOrigin() *httptest.Server {
+	img := image.NewGray(image.Rect(0, 0, 2000, 2000))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	buf := new(bytes.Buffer)
+	require.Nil(s.T(), png.Encode(buf, img))
+
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "image/png")
+		rw.WriteHeader(200)
+		rw.Write(buf.Bytes())
+	}))
+}
+
+func (s *ProcessingHandlerTestSuite) TestDecodeTimeout() {
+	ts := s.largePngOrigin()
+	defer ts.Close()
+
+	config.DecodeTimeout = 0.000001
+
+	rw := s.send("/unsafe/rs:fit:10:10/plain/" + ts.URL + "@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 422, res.StatusCode)
+	require.Equal(s.T(), "Invalid source image", string(s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestDecodeTimeoutWithTrim() {
+	ts := s.largePngOrigin()
+	defer ts.Close()
+
+	config.DecodeTimeout = 0.000001
+
+	rw := s.send("/unsafe/t:10/rs:fit:10:10/plain/" + ts.URL + "@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 422, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestDecodeTimeoutNotExceeded() {
+	ts := s.largePngOrigin()
+	defer ts.Close()
+
+	config.DecodeTimeout = 10
+
+	rw := s.send("/unsafe/rs:fit:10:10/plain/" + ts.URL + "@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), image.Rect(0, 0, 10, 10), img.Bounds())
+}
+
+func (s *ProcessingHandlerTestSuite) TestAllowPassthrough() {
+	config.AllowPassthrough = true
+	config.StripMetadata = false
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/rs:fit:20:20/plain/local:///test1.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "image/png", res.Header.Get("Content-Type"))
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Height"))
+
+	actual := s.readBody(res)
+	expected := s.readTestFile("test1.png")
+
+	require.True(s.T(), bytes.Equal(expected, actual))
+}
+
+func (s *ProcessingHandlerTestSuite) TestAllowPassthroughEnforceThumbnail() {
+	config.AllowPassthrough = true
+	config.StripMetadata = false
+
+	// PNG doesn't have embedded thumbnails, so the source image is loaded
+	// and can be served as is
+	rw := s.send("/unsafe/rs:fit:20:20/eth:1/plain/local:///test1.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.True(s.T(), bytes.Equal(s.readTestFile("test1.png"), s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestAllowPassthroughDisabled() {
+	config.AllowPassthrough = false
+	config.StripMetadata = false
+
+	rw := s.send("/unsafe/rs:fit:20:20/plain/local:///test1.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	actual := s.readBody(res)
+	expected := s.readTestFile("test1.png")
+
+	require.False(s.T(), bytes.Equal(expected, actual))
+}
+
+func (s *ProcessingHandlerTestSuite) TestAllowPassthroughDefaultSharpening() {
+	config.AllowPassthrough = true
+	config.StripMetadata = false
+	config.Sharpening = 0.5
+
+	expected := s.readTestFile("test1.png")
+
+	// The default sharpening doesn't prevent passthrough
+	res := s.send("/unsafe/rs:fit:20:20/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.True(s.T(), bytes.Equal(expected, s.readBody(res)))
+
+	// Explicit sharpening does
+	res = s.send("/unsafe/rs:fit:20:20/sh:1/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.False(s.T(), bytes.Equal(expected, s.readBody(res)))
+
+	// Even if it's the same as the default one
+	res = s.send("/unsafe/rs:fit:20:20/sh:0.5/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.False(s.T(), bytes.Equal(expected, s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestAllowPassthroughTransformsApplied() {
+	config.AllowPassthrough = true
+	config.StripMetadata = false
+
+	expected := s.readTestFile("test1.png")
+
+	s.setWatermark("test-wm-red.png")
+
+	for _, path := range []string{
+		// Metadata stripping requires re-encoding
+		"/unsafe/sm:1/plain/local:///test1.png@png",
+		// Watermark requires re-encoding
+		"/unsafe/wm:1/plain/local:///test1.png@png",
+		// Format differs
+		"/unsafe/plain/local:///test1.png@jpg",
+		// Downscaling
+		"/unsafe/rs:fit:5:5/plain/local:///test1.png@png",
+		// Enlarging
+		"/unsafe/rs:fit:20:20:1/plain/local:///test1.png@png",
+		// Cropping to the result size
+		"/unsafe/rs:fill:10:5/plain/local:///test1.png@png",
+		// Rotation
+		"/unsafe/rot:90/plain/local:///test1.png@png",
+		// Filters
+		"/unsafe/bl:2/plain/local:///test1.png@png",
+		// Padding
+		"/unsafe/pd:2/plain/local:///test1.png@png",
+	} {
+		rw := s.send(path)
+		res := rw.Result()
+
+		require.Equal(s.T(), 200, res.StatusCode, path)
+
+		actual := s.readBody(res)
+
+		require.False(s.T(), bytes.Equal(expected, actual), path)
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestStreamResult() {
+	config.StripMetadata = false
+
+	for _, path := range []string{
+		"/unsafe/rs:fit:8:8/plain/local:///test1.png@jpg",
+		"/unsafe/rs:fit:8:8/plain/local:///test1.png@png",
+		"/unsafe/rs:fill:8:8/q:50/plain/local:///test1.jpg@jpg",
+	} {
+		config.StreamResult = false
+
+		rw := s.send(path)
+		res := rw.Result()
+
+		require.Equal(s.T(), 200, res.StatusCode, path)
+
+		contentType := res.Header.Get("Content-Type")
+		buffered := s.readBody(res)
+		require.Equal(s.T(), strconv.Itoa(len(buffered)), res.Header.Get("Content-Length"), path)
+
+		config.StreamResult = true
+
+		rw = s.send(path)
+		res = rw.Result()
+
+		require.Equal(s.T(), 200, res.StatusCode, path)
+		require.Equal(s.T(), contentType, res.Header.Get("Content-Type"), path)
+		require.Empty(s.T(), res.Header.Get("Content-Length"), path)
+
+		streamed := s.readBody(res)
+		require.True(s.T(), bytes.Equal(buffered, streamed), path)
+	}
+}
+
+// slowResponseWriter blocks writes until it's unblocked
+type slowResponseWriter struct {
+	*httptest.ResponseRecorder
+
+	writing     chan struct{}
+	writingOnce sync.Once
+	unblock     chan struct{}
+}
+
+func (w *slowResponseWriter) Write(p []byte) (int, error) {
+	w.writingOnce.Do(func() { close(w.writing) })
+	<-w.unblock
+
+	return w.ResponseRecorder.Write(p)
+}
+
+func (s *ProcessingHandlerTestSuite) TestStreamResultSlowClient() {
+	config.StripMetadata = false
+	config.StreamResult = true
+	config.Concurrency = 1
+	config.WriteTimeout = 2
+
+	initProcessingHandler()
+	defer func() {
+		config.Reset()
+		initProcessingHandler()
+	}()
+
+	path := "/unsafe/rs:fit:8:8/plain/local:///test1.png@png"
+
+	slow := &slowResponseWriter{
+		ResponseRecorder: httptest.NewRecorder(),
+		writing:          make(chan struct{}),
+		unblock:          make(chan struct{}),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.router.ServeHTTP(slow, httptest.NewRequest(http.MethodGet, path, nil))
+	}()
+
+	<-slow.writing
+
+	// The slow client doesn't hold the only worker, so the next request is processed
+	res := s.send(path).Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	expected := s.readBody(res)
+
+	close(slow.unblock)
+	<-done
+
+	require.Equal(s.T(), 200, slow.Result().StatusCode)
+	require.True(s.T(), bytes.Equal(expected, s.readBody(slow.Result())))
+}
+
+func (s *ProcessingHandlerTestSuite) TestStreamResultMaxBytes() {
+	config.StreamResult = true
+
+	rw := s.send("/unsafe/rs:fit:8:8/mb:100000/plain/local:///test1.png@jpg")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	// max_bytes requires the whole result to be available, so it's buffered
+	body := s.readBody(res)
+	require.Equal(s.T(), strconv.Itoa(len(body)), res.Header.Get("Content-Length"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSkipResultCrop() {
+	config.EnableDebugHeaders = true
+
+	testCases := []struct {
+		path   string
+		width  string
+		height string
+	}{
+		// test1.png is 10x10, so filling 4x2 scales it to 4x4 and crops to 4x2
+		{"/unsafe/rs:fill:4:2/plain/local:///test1.png@png", "4", "2"},
+		{"/unsafe/rs:fill:4:2/skrc:1/plain/local:///test1.png@png", "4", "4"},
+		{"/unsafe/rs:fill-down:20:10/plain/local:///test1.png@png", "10", "5"},
+		{"/unsafe/rs:fill-down:20:10/skrc:1/plain/local:///test1.png@png", "10", "10"},
+		// Nothing to crop in the fit mode
+		{"/unsafe/rs:fit:4:2/skrc:1/plain/local:///test1.png@png", "2", "2"},
+	}
+
+	for _, tc := range testCases {
+		rw := s.send(tc.path)
+		res := rw.Result()
+
+		require.Equal(s.T(), 200, res.StatusCode, tc.path)
+		require.Equal(s.T(), tc.width, res.Header.Get("X-Result-Width"), tc.path)
+		require.Equal(s.T(), tc.height, res.Header.Get("X-Result-Height"), tc.path)
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestSkipResultCropKeepsPixels() {
+	// Without the crop, the filled image is the same as the image fitted
+	// into the cover size
+	cropped := s.readBody(s.send("/unsafe/rs:fill:4:2/plain/local:///test1.png@png").Result())
+	uncropped := s.readBody(s.send("/unsafe/rs:fill:4:2/skrc:1/plain/local:///test1.png@png").Result())
+	fitted := s.readBody(s.send("/unsafe/rs:fit:4:4/plain/local:///test1.png@png").Result())
+
+	require.False(s.T(), bytes.Equal(cropped, uncropped))
+	require.True(s.T(), bytes.Equal(fitted, uncropped))
+}
+
+func (s *ProcessingHandlerTestSuite) TestBackgroundNamed() {
+	// test-alpha-halo.png has a fully transparent 10px border
+	res := s.send("/unsafe/bg:lime/plain/local:///test-alpha-halo.png@png").Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	r, g, b, a := img.At(0, 0).RGBA()
+	require.Equal(s.T(), [4]uint32{0, 255, 0, 255}, [4]uint32{r >> 8, g >> 8, b >> 8, a >> 8})
+}
+
+func (s *ProcessingHandlerTestSuite) TestBackgroundDefault() {
+	config.Background = "255:255:0"
+
+	res := s.send("/unsafe/plain/local:///test-alpha-halo.png@png").Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	r, g, b, a := img.At(0, 0).RGBA()
+	require.Equal(s.T(), [4]uint32{255, 255, 0, 255}, [4]uint32{r >> 8, g >> 8, b >> 8, a >> 8})
+}
+
+func (s *ProcessingHandlerTestSuite) TestBackgroundBeforeWatermark() {
+	// test-wm-white.png is 10x10 with an opaque white left half and a half-transparent white right half
+	s.setWatermark("test-wm-white.png")
+
+	res := s.send("/unsafe/bg:lime/wm:1:nowe/plain/local:///test-alpha-halo.png@png").Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	pixel := func(x, y int) [4]uint32 {
+		r, g, b, a := img.At(x, y).RGBA()
+		return [4]uint32{r >> 8, g >> 8, b >> 8, a >> 8}
+	}
+
+	// The watermark sits on the solid background
+	require.Equal(s.T(), [4]uint32{255, 255, 255, 255}, pixel(0, 0))
+	require.Equal(s.T(), [4]uint32{0, 255, 0, 255}, pixel(15, 0))
+
+	blended := pixel(7, 5)
+	require.InDelta(s.T(), 128, blended[0], 2)
+	require.Equal(s.T(), uint32(255), blended[1])
+	require.InDelta(s.T(), 128, blended[2], 2)
+	require.Equal(s.T(), uint32(255), blended[3])
+}
+
+type stubObjectDetector struct {
+	rects   map[string][]image.Rectangle
+	classes [][]string
+}
+
+func (d *stubObjectDetector) Detect(img *vips.Image, classes []string) ([]image.Rectangle, error) {
+	d.classes = append(d.classes, classes)
+
+	if len(classes) == 0 {
+		var rects []image.Rectangle
+		for _, r := range d.rects {
+			rects = append(rects, r...)
+		}
+		return rects, nil
+	}
+
+	var rects []image.Rectangle
+	for _, c := range classes {
+		rects = append(rects, d.rects[c]...)
+	}
+	return rects, nil
+}
+
+func (s *ProcessingHandlerTestSuite) setObjectDetector(d processing.ObjectDetector) {
+	processing.SetObjectDetector(d)
+	s.T().Cleanup(func() { processing.SetObjectDetector(nil) })
+}
+
+func (s *ProcessingHandlerTestSuite) TestGravityObject() {
+	// test-edges.png is 20x20 with a black left half and a white right half
+	detector := &stubObjectDetector{
+		rects: map[string][]image.Rectangle{
+			"face": {image.Rect(12, 4, 16, 8), image.Rect(14, 10, 18, 16)},
+			"text": {image.Rect(0, 0, 6, 20)},
+		},
+	}
+	s.setObjectDetector(detector)
+
+	res := s.send("/unsafe/rs:fill:10:20/g:obj:face/plain/local:///test-edges.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.True(s.T(), s.onlyColors(res, [3]uint8{255, 255, 255}))
+
+	res = s.send("/unsafe/rs:fill:10:20/g:obj:text/plain/local:///test-edges.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.True(s.T(), s.onlyColors(res, [3]uint8{0, 0, 0}))
+
+	// The area containing both faces and text spans both halves of the image
+	res = s.send("/unsafe/rs:fill:10:20/g:obj:face:text/plain/local:///test-edges.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	r, _, _, _ := img.At(0, 0).RGBA()
+	require.Equal(s.T(), uint32(0), r>>8)
+	r, _, _, _ = img.At(9, 0).RGBA()
+	require.Equal(s.T(), uint32(255), r>>8)
+
+	require.Equal(s.T(), [][]string{{"face"}, {"text"}, {"face", "text"}}, detector.classes)
+}
+
+func (s *ProcessingHandlerTestSuite) TestGravityObjectAllClasses() {
+	detector := &stubObjectDetector{
+		rects: map[string][]image.Rectangle{
+			"face": {image.Rect(16, 0, 20, 20)},
+		},
+	}
+	s.setObjectDetector(detector)
+
+	res := s.send("/unsafe/rs:fill:10:20/g:obj/plain/local:///test-edges.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.True(s.T(), s.onlyColors(res, [3]uint8{255, 255, 255}))
+
+	require.Equal(s.T(), [][]string{nil}, detector.classes)
+}
+
+func (s *ProcessingHandlerTestSuite) TestGravityObjectCrop() {
+	detector := &stubObjectDetector{
+		rects: map[string][]image.Rectangle{
+			"face": {image.Rect(15, 0, 20, 20)},
+		},
+	}
+	s.setObjectDetector(detector)
+
+	res := s.send("/unsafe/c:8:20:obj:face/plain/local:///test-edges.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.True(s.T(), s.onlyColors(res, [3]uint8{255, 255, 255}))
+
+	require.Equal(s.T(), [][]string{{"face"}}, detector.classes)
+}
+
+func (s *ProcessingHandlerTestSuite) TestGravityObjectNotDetected() {
+	config.EnableDebugHeaders = true
+
+	detector := &stubObjectDetector{}
+	s.setObjectDetector(detector)
+
+	// Falls back to the smart crop
+	res := s.send("/unsafe/rs:fill:10:20/g:obj:face/plain/local:///test-edges.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "20", res.Header.Get("X-Result-Height"))
+
+	require.Equal(s.T(), [][]string{{"face"}}, detector.classes)
+}
+
+func (s *ProcessingHandlerTestSuite) TestGravityObjectDefaultDetector() {
+	config.EnableDebugHeaders = true
+
+	res := s.send("/unsafe/rs:fill:10:20/g:obj:face/plain/local:///test-edges.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "20", res.Header.Get("X-Result-Height"))
+}
+
+// statsdCounters collects the StatsD counters sent while f runs
+func (s *ProcessingHandlerTestSuite) statsdCounters(f func()) map[string]int {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(s.T(), err)
+	defer listener.Close()
+
+	config.StatsdAddr = listener.LocalAddr().String()
+	require.Nil(s.T(), statsd.Init())
+
+	f()
+
+	statsd.Stop()
+
+	counters := make(map[string]int)
+	buf := make([]byte, 1024)
+
+	for {
+		listener.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+
+		if packet := string(buf[:n]); strings.HasSuffix(packet, ":1|c") {
+			counters[strings.TrimSuffix(packet, ":1|c")]++
+		}
+	}
+
+	return counters
+}
+
+func (s *ProcessingHandlerTestSuite) TestSmartCropMetrics() {
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/rs:fill:16:16/g:sm/plain/local:///test-smart.png@png").Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.smart_crop_total"])
+	require.Zero(s.T(), counters["imgproxy.smart_crop_fallback_total"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestSmartCropMetricsStrategy() {
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/rs:fill:16:16/g:sm:entropy/plain/local:///test-smart.png@png").Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.smart_crop_total"])
+	require.Zero(s.T(), counters["imgproxy.smart_crop_fallback_total"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestSmartCropMetricsFallback() {
+	config.EnableDebugHeaders = true
+
+	// The smart crop area can't be detected for SVG sources,
+	// so imgproxy falls back to the center gravity
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/rs:fill:16:8/g:sm/plain/local:///test1.svg@png").Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+		require.Equal(s.T(), "16", res.Header.Get("X-Result-Width"))
+		require.Equal(s.T(), "8", res.Header.Get("X-Result-Height"))
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.smart_crop_total"])
+	require.Equal(s.T(), 1, counters["imgproxy.smart_crop_fallback_total"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestSmartCropMargin() {
+	config.EnableDebugHeaders = true
+
+	// test-smart-detail.png is 128x32 with a detailed area in the middle.
+	// The detected area is 32x32 at 40:0, the result crop is skipped,
+	// so the result is the padded area downscaled to fill 16x16
+	testCases := []struct {
+		margin string
+		width  string
+	}{
+		// 32x32 area
+		{"0", "16"},
+		// 48x32 area: grows by the margin horizontally and is clamped vertically
+		{"8", "24"},
+		// 122x32 area: is also clamped at the left edge
+		{"50", "61"},
+	}
+
+	for _, tc := range testCases {
+		path := fmt.Sprintf("/unsafe/rs:fill:16:16/g:sm/scm:%s/skrc:1/plain/local:///test-smart-detail.png@png", tc.margin)
+
+		res := s.send(path).Result()
+		require.Equal(s.T(), 200, res.StatusCode, path)
+		require.Equal(s.T(), tc.width, res.Header.Get("X-Result-Width"), path)
+		require.Equal(s.T(), "16", res.Header.Get("X-Result-Height"), path)
+	}
+}
+
+// requireCropRectangle checks that the response contains a PNG image that matches
+// the area of the source image reported by the header
+func (s *ProcessingHandlerTestSuite) requireCropRectangle(res *http.Response, header, srcName string) image.Rectangle {
+	var x, y, w, h int
+	_, err := fmt.Sscanf(res.Header.Get(header), "%d,%d,%d,%d", &x, &y, &w, &h)
+	require.Nil(s.T(), err, header)
+
+	src, err := png.Decode(bytes.NewReader(s.readTestFile(srcName)))
+	require.Nil(s.T(), err)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), image.Rect(0, 0, w, h), img.Bounds())
+
+	for dy := 0; dy < h; dy++ {
+		for dx := 0; dx < w; dx++ {
+			er, eg, eb, _ := src.At(x+dx, y+dy).RGBA()
+			ar, ag, ab, _ := img.At(dx, dy).RGBA()
+			require.Equal(s.T(), [3]uint32{er >> 8, eg >> 8, eb >> 8}, [3]uint32{ar >> 8, ag >> 8, ab >> 8}, "Pixel %d:%d", dx, dy)
+		}
+	}
+
+	return image.Rect(x, y, x+w, y+h)
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropRectangleHeader() {
+	config.EnableDebugHeaders = true
+
+	res := s.send("/unsafe/c:20:10:nowe:5:7/plain/local:///test-smart-detail.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Result-Crop-Rectangle"))
+
+	rect := s.requireCropRectangle(res, "X-Crop-Rectangle", "test-smart-detail.png")
+	require.Equal(s.T(), image.Rect(5, 7, 25, 17), rect)
+}
+
+func (s *ProcessingHandlerTestSuite) TestSmartCropRectangleHeader() {
+	config.EnableDebugHeaders = true
+
+	// test-smart-detail.png is 128x32, so the fill resizing doesn't scale it
+	// and the result crop area is set in the source image coordinates
+	res := s.send("/unsafe/rs:fill:32:32/g:sm/plain/local:///test-smart-detail.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Crop-Rectangle"))
+
+	rect := s.requireCropRectangle(res, "X-Result-Crop-Rectangle", "test-smart-detail.png")
+	require.Equal(s.T(), 32, rect.Dx())
+	require.Equal(s.T(), 32, rect.Dy())
+}
+
+func (s *ProcessingHandlerTestSuite) TestCropRectangleHeaderDisabled() {
+	res := s.send("/unsafe/c:20:10:nowe:5:7/plain/local:///test-smart-detail.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Crop-Rectangle"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSmartCropFallbackGravity() {
+	// The smart crop area can't be detected for SVG sources, so imgproxy
+	// falls back to the requested gravity. test1.svg is a 200x100 transparent image
+	// with a black rectangle 190x90 with a white border at the top left corner
+	testCases := []struct {
+		gravity string
+		check   func(img image.Image)
+	}{
+		{"sm", func(img image.Image) {
+			// Center: the crop is inside the rectangle
+			r, g, b, a := img.At(100, 0).RGBA()
+			require.Equal(s.T(), [4]uint32{0, 0, 0, 0xffff}, [4]uint32{r, g, b, a})
+		}},
+		{"sm:no", func(img image.Image) {
+			// North: the top row is the border
+			r, g, b, _ := img.At(100, 0).RGBA()
+			require.InDelta(s.T(), 255, r>>8, 2)
+			require.InDelta(s.T(), 255, g>>8, 2)
+			require.InDelta(s.T(), 255, b>>8, 2)
+
+			r, g, b, a := img.At(100, 5).RGBA()
+			require.Equal(s.T(), [4]uint32{0, 0, 0, 0xffff}, [4]uint32{r, g, b, a})
+		}},
+		{"sm:so", func(img image.Image) {
+			// South: the bottom rows are transparent
+			_, _, _, a := img.At(100, 9).RGBA()
+			require.Zero(s.T(), a)
+		}},
+	}
+
+	for _, tc := range testCases {
+		path := fmt.Sprintf("/unsafe/rs:fill:200:10/g:%s/plain/local:///test1.svg@png", tc.gravity)
+
+		res := s.send(path).Result()
+		require.Equal(s.T(), 200, res.StatusCode, path)
+
+		img, err := png.Decode(res.Body)
+		require.Nil(s.T(), err, path)
+		require.Equal(s.T(), image.Rect(0, 0, 200, 10), img.Bounds(), path)
+
+		tc.check(img)
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceResolutionMetrics() {
+	// test1.png is 10x10
+	config.MaxSrcResolution = 50
+
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/plain/local:///test1.png").Result()
+		require.Equal(s.T(), 422, res.StatusCode)
+		require.Equal(s.T(), "Invalid source image", string(s.readBody(res)))
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.errors_total.source_resolution"])
+	require.Zero(s.T(), counters["imgproxy.errors_total.download"])
+}
+
+// corruptedPNG returns test1.png with the broken IHDR checksum.
+// Its header is still valid, so only libvips fails to decode it
+func (s *ProcessingHandlerTestSuite) corruptedPNG() []byte {
+	data := s.readTestFile("test1.png")
+	data[29] ^= 0xff
+
+	return data
+}
+
+func (s *ProcessingHandlerTestSuite) TestUnprocessableImageMetrics() {
+	data := s.corruptedPNG()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write(data)
+	}))
+	defer ts.Close()
+
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/plain/" + ts.URL + "@jpg").Result()
+		require.Equal(s.T(), 422, res.StatusCode)
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.errors_total.unprocessable_image"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestUnprocessableWatermarkMetrics() {
+	prev := imagedata.Watermark
+	imagedata.Watermark = &imagedata.ImageData{Data: s.corruptedPNG(), Type: imagetype.PNG}
+	defer func() { imagedata.Watermark = prev }()
+
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/wm:1/plain/local:///test1.png@jpg").Result()
+		require.NotEqual(s.T(), 200, res.StatusCode)
+	})
+
+	// The watermark isn't the source image, so the source isn't unprocessable
+	require.Zero(s.T(), counters["imgproxy.errors_total.unprocessable_image"])
+	require.Equal(s.T(), 1, counters["imgproxy.errors_total.processing"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestSourceResolutionAnimated() {
+	// test-frames.gif has 3 frames of 16x16, so a single frame fits the limit
+	// while all the frames together don't
+	config.MaxSrcResolution = 500
+	config.MaxAnimationFrames = 10
+
+	res := s.send("/unsafe/maf:1/plain/local:///test-frames.gif@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/maf:3/plain/local:///test-frames.gif@gif").Result()
+		require.Equal(s.T(), 422, res.StatusCode)
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.errors_total.source_resolution"])
+	require.Zero(s.T(), counters["imgproxy.errors_total.processing"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestDownloadNotFoundMetrics() {
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/plain/local:///missing.png").Result()
+		require.Equal(s.T(), 404, res.StatusCode)
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.errors_total.download_not_found"])
+	require.Zero(s.T(), counters["imgproxy.errors_total.download_other"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestDownloadRefusedMetrics() {
+	// Get a free port and close it, so the connection is refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(s.T(), err)
+
+	addr := l.Addr().String()
+	l.Close()
+
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/plain/http://" + addr + "/test1.png").Result()
+		require.Equal(s.T(), 500, res.StatusCode)
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.errors_total.download_refused"])
+	require.Zero(s.T(), counters["imgproxy.errors_total.download_other"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestDownloadTimeoutMetrics() {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Respond after the download timeout
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	// The download timeout is applied to the HTTP client, so we need
+	// to reinitialize downloading
+	wd, err := os.Getwd()
+	require.Nil(s.T(), err)
+
+	config.DownloadTimeout = 1
+	require.Nil(s.T(), imagedata.Init())
+
+	defer func() {
+		config.Reset()
+		config.LocalFileSystemRoot = filepath.Join(wd, "/testdata")
+		require.Nil(s.T(), imagedata.Init())
+	}()
+
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/plain/" + ts.URL + "/test1.png").Result()
+		require.Equal(s.T(), 500, res.StatusCode)
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.errors_total.download_timeout"])
+	require.Zero(s.T(), counters["imgproxy.errors_total.download_other"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestDownloadOtherMetrics() {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(403)
+	}))
+	defer ts.Close()
+
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/plain/" + ts.URL + "/test1.png").Result()
+		require.Equal(s.T(), 404, res.StatusCode)
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.errors_total.download_other"])
+	require.Zero(s.T(), counters["imgproxy.errors_total.download_not_found"])
+}
+
+// animationOrigin starts an origin that serves a GIF animation with the given number
+// of frames. Each frame has its own gradient, so frames can be told apart
+func (s *ProcessingHandlerTestSuite) animationOrigin(framesCount, size int) *httptest.Server {
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.RGBA{uint8(i), uint8(255 - i), uint8(i * 3), 255}
+	}
+
+	anim := &gif.GIF{}
+
+	for i := 0; i < framesCount; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				frame.SetColorIndex(x, y, uint8(x+y+i*16))
+			}
+		}
+
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	buf := new(bytes.Buffer)
+	require.Nil(s.T(), gif.EncodeAll(buf, anim))
+
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "image/gif")
+		rw.WriteHeader(200)
+		rw.Write(buf.Bytes())
+	}))
+}
+
+func (s *ProcessingHandlerTestSuite) TestAnimationConcurrency() {
+	ts := s.animationOrigin(24, 64)
+	defer ts.Close()
+
+	config.MaxAnimationFrames = 24
+
+	process := func(concurrency int) *gif.GIF {
+		config.AnimationConcurrency = concurrency
+
+		res := s.send("/unsafe/rs:fit:32:32/bl:2/plain/" + ts.URL + "@gif").Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+
+		g, err := gif.DecodeAll(res.Body)
+		require.Nil(s.T(), err)
+		require.Len(s.T(), g.Image, 24)
+
+		return g
+	}
+
+	serial := process(1)
+	parallel := process(4)
+
+	for i := range serial.Image {
+		require.Equal(s.T(), serial.Image[i].Bounds(), parallel.Image[i].Bounds(), "frame %d", i)
+
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				require.Equal(s.T(), serial.Image[i].At(x, y), parallel.Image[i].At(x, y), "frame %d", i)
+			}
+		}
+	}
+
+	require.Equal(s.T(), serial.Delay, parallel.Delay)
+}
+
+func (s *ProcessingHandlerTestSuite) TestAnimationConcurrencyBusyWorkers() {
+	ts := s.animationOrigin(8, 16)
+	defer ts.Close()
+
+	config.MaxAnimationFrames = 8
+	config.AnimationConcurrency = 4
+
+	// Occupy all the workers except the one the request needs,
+	// so the frames can only be processed serially
+	tokens := make([]*semaphore.Token, 0, config.Concurrency)
+	for i := 1; i < config.Concurrency; i++ {
+		token, ok := processingSem.TryAquire()
+		require.True(s.T(), ok)
+		tokens = append(tokens, token)
+	}
+
+	res := s.send("/unsafe/bl:2/plain/" + ts.URL + "@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	g, err := gif.DecodeAll(res.Body)
+	require.Nil(s.T(), err)
+	require.Len(s.T(), g.Image, 8)
+
+	for _, token := range tokens {
+		token.Release()
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestAnimationConcurrencyReleasesWorkers() {
+	ts := s.animationOrigin(16, 16)
+	defer ts.Close()
+
+	config.MaxAnimationFrames = 16
+	config.AnimationConcurrency = 4
+
+	res := s.send("/unsafe/bl:2/plain/" + ts.URL + "@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	// All the workers should be free after the request is done
+	tokens := make([]*semaphore.Token, 0, config.Concurrency)
+	for i := 0; i < config.Concurrency; i++ {
+		token, ok := processingSem.TryAquire()
+		require.True(s.T(), ok, "worker %d is still busy", i)
+		tokens = append(tokens, token)
+	}
+
+	for _, token := range tokens {
+		token.Release()
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestAnimationTruncatedMetrics() {
+	ts := s.animationOrigin(8, 16)
+	defer ts.Close()
+
+	// Animation processing is disabled by default, so animations are processed
+	// as still images intentionally
+	counters := s.statsdCounters(func() {
+		res := s.send("/unsafe/plain/" + ts.URL + "@gif").Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+	})
+
+	require.Zero(s.T(), counters["imgproxy.errors_total.animation_truncated"])
+
+	config.MaxAnimationFrames = 4
+
+	counters = s.statsdCounters(func() {
+		res := s.send("/unsafe/plain/" + ts.URL + "@gif").Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+	})
+
+	require.Equal(s.T(), 1, counters["imgproxy.errors_total.animation_truncated"])
+}
+
+func (s *ProcessingHandlerTestSuite) TestFrameStep() {
+	ts := s.animationOrigin(10, 16)
+	defer ts.Close()
+
+	config.MaxAnimationFrames = 10
+
+	res := s.send("/unsafe/fs:2/plain/" + ts.URL + "@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	g, err := gif.DecodeAll(res.Body)
+	require.Nil(s.T(), err)
+	require.Len(s.T(), g.Image, 5)
+
+	// Each frame lasts as long as the two source frames
+	require.Equal(s.T(), []int{20, 20, 20, 20, 20}, g.Delay)
+
+	// The frames 0, 2, 4, 6, and 8 are kept. The top-left pixel of the source frame i
+	// has the palette color with index i*16
+	for i, frame := range g.Image {
+		r, gr, b, _ := frame.At(0, 0).RGBA()
+		idx := i * 2 * 16
+
+		require.InDelta(s.T(), idx, int(r>>8), 8, "frame %d", i)
+		require.InDelta(s.T(), 255-idx, int(gr>>8), 8, "frame %d", i)
+		require.InDelta(s.T(), uint8(idx*3), int(b>>8), 8, "frame %d", i)
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestFrameStepWithoutPreservingDuration() {
+	ts := s.animationOrigin(10, 16)
+	defer ts.Close()
+
+	config.MaxAnimationFrames = 10
+
+	res := s.send("/unsafe/fs:2:0/plain/" + ts.URL + "@gif").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	g, err := gif.DecodeAll(res.Body)
+	require.Nil(s.T(), err)
+	require.Len(s.T(), g.Image, 5)
+	require.Equal(s.T(), []int{10, 10, 10, 10, 10}, g.Delay)
+}
+
+func (s *ProcessingHandlerTestSuite) TestFormatHeader() {
+	config.SetFormatHeader = true
+
+	res := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png@jpg").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "jpeg", res.Header.Get("X-Imgproxy-Format"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestFormatHeaderNegotiated() {
+	config.SetFormatHeader = true
+	config.EnableWebpDetection = true
+
+	header := make(http.Header)
+	header.Set("Accept", "image/webp")
+
+	res := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png", header).Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "webp", res.Header.Get("X-Imgproxy-Format"))
+
+	res = s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "png", res.Header.Get("X-Imgproxy-Format"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestFormatHeaderDisabled() {
+	res := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png@jpg").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("X-Imgproxy-Format"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestDefaultFormat() {
+	config.PreferredFormats = []imagetype.Type{imagetype.JPEG}
+
+	for _, tc := range []struct {
+		defaultFormat string
+		expected      string
+	}{
+		{"preferred", "image/jpeg"},
+		{"source", "image/png"},
+		{"webp", "image/webp"},
+	} {
+		config.DefaultFormat = tc.defaultFormat
+
+		res := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png").Result()
+		require.Equal(s.T(), 200, res.StatusCode)
+		require.Equal(s.T(), tc.expected, res.Header.Get("Content-Type"), "Default format: %s", tc.defaultFormat)
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestDefaultFormatOverridden() {
+	config.DefaultFormat = "webp"
+	config.EnableAvifDetection = true
+
+	res := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "image/png", res.Header.Get("Content-Type"))
+
+	header := make(http.Header)
+	header.Set("Accept", "image/avif")
+
+	res = s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png", header).Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "image/avif", res.Header.Get("Content-Type"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestExtendLetterbox() {
+	// test-white.png is 100x50, so it's letterboxed with 25px bars at the top and bottom
+	res := s.send("/unsafe/rs:fit:100:100/ex:1/bg:ff00ff/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), image.Rect(0, 0, 100, 100), img.Bounds())
+
+	s.requirePoints(img, []pixelPoint{
+		{50, 10, [3]uint8{255, 0, 255}},
+		{50, 50, [3]uint8{255, 255, 255}},
+		{50, 90, [3]uint8{255, 0, 255}},
+	})
+}
+
+func (s *ProcessingHandlerTestSuite) TestExtendWatermark() {
+	s.setWatermark("test-wm-red.png")
+
+	// The watermark is placed relative to the extended canvas, not to the image inside it
+	res := s.send("/unsafe/rs:fit:100:100/ex:1/wm:1:soea:0:0:0.3:longest/plain/local:///test-white.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	require.Equal(s.T(), image.Rect(70, 70, 100, 100), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCanvasFill() {
+	res := s.send("/unsafe/canvas:20:16:3:00ff00:nowe/plain/local:///test-wm-red.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requireGolden(res, "canvas-fill.png", 0)
+}
+
+func (s *ProcessingHandlerTestSuite) TestCanvasGravity() {
+	res := s.send("/unsafe/canvas:20:16:3::soea:1:0/plain/local:///test-wm-red.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	// The inner area is 14x10 at 3:3, the image is placed at its south-east
+	// corner with the 1px horizontal offset
+	require.Equal(s.T(), image.Rect(6, 3, 16, 13), s.redBounds(res))
+}
+
+func (s *ProcessingHandlerTestSuite) TestGravityAlpha() {
+	rw := s.send("/unsafe/c:20:20:alpha/plain/local:///test-alpha-blob.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), image.Rect(0, 0, 20, 20), img.Bounds())
+
+	// The opaque blob is off-center, so the whole crop should be opaque
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			require.Equal(s.T(), uint32(0xffff), a, "Pixel %d:%d is not opaque", x, y)
+		}
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestGravityAlphaFallback() {
+	rw := s.send("/unsafe/c:4:4:alpha/plain/local:///test1.jpg@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	actual := s.readBody(res)
+
+	rw = s.send("/unsafe/c:4:4:ce/plain/local:///test1.jpg@png")
+	res = rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	expected := s.readBody(res)
+
+	require.True(s.T(), bytes.Equal(expected, actual))
+}
+
+func (s *ProcessingHandlerTestSuite) TestTrim() {
+	config.EnableDebugHeaders = true
+
+	// The background color is detected from the top-left pixel
+	rw := s.send("/unsafe/trim:10/plain/local:///test-trim-border.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "8", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "8", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestTrimColorThreshold() {
+	config.EnableDebugHeaders = true
+
+	// The border is slightly darker than the provided color
+	rw := s.send("/unsafe/trim:2:FFFFFF/plain/local:///test-trim-border.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "16", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "16", res.Header.Get("X-Result-Height"))
+
+	rw = s.send("/unsafe/trim:10:FFFFFF/plain/local:///test-trim-border.png@png")
+	res = rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "8", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "8", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestTrimUniform() {
+	config.EnableDebugHeaders = true
+
+	// Uniform images are left unchanged
+	rw := s.send("/unsafe/trim:10/plain/local:///test-uniform.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "8", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "8", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestTrimAlpha() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/trim:alpha/plain/local:///test-alpha-blob.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "20", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "20", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestTrimAlphaThreshold() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/trim:alpha/plain/local:///test-alpha-halo.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "30", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "30", res.Header.Get("X-Result-Height"))
+
+	rw = s.send("/unsafe/trim:alpha:100/plain/local:///test-alpha-halo.png@png")
+	res = rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestTrimAlphaNoAlpha() {
+	config.EnableDebugHeaders = true
+
+	rw := s.send("/unsafe/trim:alpha/plain/local:///test1.jpg@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Width"))
+	require.Equal(s.T(), "10", res.Header.Get("X-Result-Height"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestAlphaMask() {
+	rw := s.send("/unsafe/am:1/plain/local:///test-alpha-blob.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	mask, ok := img.(*image.Gray)
+	require.True(s.T(), ok, "Alpha mask should be a grayscale image")
+
+	require.Equal(s.T(), uint8(255), mask.GrayAt(70, 20).Y)
+	require.Equal(s.T(), uint8(0), mask.GrayAt(0, 0).Y)
+	require.Equal(s.T(), uint8(0), mask.GrayAt(90, 90).Y)
+}
+
+func (s *ProcessingHandlerTestSuite) TestAlphaMaskNoAlpha() {
+	rw := s.send("/unsafe/am:1/plain/local:///test1.jpg@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	mask, ok := img.(*image.Gray)
+	require.True(s.T(), ok, "Alpha mask should be a grayscale image")
+
+	bounds := mask.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			require.Equal(s.T(), uint8(255), mask.GrayAt(x, y).Y, "Pixel %d:%d is not white", x, y)
+		}
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestEdges() {
+	rw := s.send("/unsafe/edges:1/plain/local:///test-edges.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requireGolden(res, "edges.png", 2)
+}
+
+func (s *ProcessingHandlerTestSuite) TestEdgesStrength() {
+	rw := s.send("/unsafe/edges:0.1/plain/local:///test-edges.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requireGolden(res, "edges-0.1.png", 2)
+}
+
+func (s *ProcessingHandlerTestSuite) TestKernel() {
+	rw := s.send("/unsafe/plain/local:///test-kernel.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	original, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	for _, kernel := range []string{"emboss", "sharpen-strong", "blur-soft"} {
+		s.Run(kernel, func() {
+			rw := s.send("/unsafe/kernel:" + kernel + "/plain/local:///test-kernel.png@png")
+			res := rw.Result()
+
+			require.Equal(s.T(), 200, res.StatusCode)
+
+			img, err := png.Decode(res.Body)
+			require.Nil(s.T(), err)
+
+			require.Equal(s.T(), original.Bounds(), img.Bounds())
+			require.True(s.T(), s.imagesDiffer(original, img), "Kernel %s didn't change the image", kernel)
+		})
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestConvIdentity() {
+	rw := s.send("/unsafe/plain/local:///test-kernel.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	original, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	rw = s.send("/unsafe/conv:0,0,0,0,1,0,0,0,0/plain/local:///test-kernel.png@png")
+	res = rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	img, err := png.Decode(res.Body)
+	require.Nil(s.T(), err)
+
+	require.False(s.T(), s.imagesDiffer(original, img))
+}
+
+func (s *ProcessingHandlerTestSuite) TestConvSharpen() {
+	rw := s.send("/unsafe/conv:0,-1,0,-1,5,-1,0,-1,0/plain/local:///test-kernel.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	s.requireGolden(res, "conv-sharpen.png", 1)
+}
+
+// maxPixelDiff returns the max difference of the color channels of the two PNG images
+func (s *ProcessingHandlerTestSuite) maxPixelDiff(a, b []byte) uint32 {
+	imgA, err := png.Decode(bytes.NewReader(a))
+	require.Nil(s.T(), err)
+
+	imgB, err := png.Decode(bytes.NewReader(b))
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), imgA.Bounds(), imgB.Bounds())
+
+	diff := func(x, y uint32) uint32 {
+		if x > y {
+			return (x - y) >> 8
+		}
+		return (y - x) >> 8
+	}
+
+	var maxDiff uint32
+
+	bounds := imgA.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ra, ga, ba, _ := imgA.At(x, y).RGBA()
+			rb, gb, bb, _ := imgB.At(x, y).RGBA()
+
+			for _, d := range []uint32{diff(ra, rb), diff(ga, gb), diff(ba, bb)} {
+				if d > maxDiff {
+					maxDiff = d
+				}
+			}
+		}
+	}
+
+	return maxDiff
+}
+
+func (s *ProcessingHandlerTestSuite) TestSharpenFlatJagged() {
+	res := s.send("/unsafe/plain/local:///test-blurry.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	original := s.readBody(res)
+
+	res = s.send("/unsafe/sh:1/plain/local:///test-blurry.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Greater(s.T(), s.maxPixelDiff(original, s.readBody(res)), uint32(4))
+
+	// Zero sharpening amounts for both flat and jagged areas mean no sharpening
+	res = s.send("/unsafe/sh:1:0:0/plain/local:///test-blurry.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.LessOrEqual(s.T(), s.maxPixelDiff(original, s.readBody(res)), uint32(1))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSharpenConfig() {
+	res := s.send("/unsafe/plain/local:///test-blurry.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	original := s.readBody(res)
+
+	config.Sharpening = 1
+
+	res = s.send("/unsafe/plain/local:///test-blurry.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Greater(s.T(), s.maxPixelDiff(original, s.readBody(res)), uint32(4))
+
+	// Explicit sigma overrides the default one
+	res = s.send("/unsafe/sh:0/plain/local:///test-blurry.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.LessOrEqual(s.T(), s.maxPixelDiff(original, s.readBody(res)), uint32(1))
+}
+
+func (s *ProcessingHandlerTestSuite) TestSharpenLinearColorspace() {
+	res := s.send("/unsafe/sh:1/plain/local:///test-blurry.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	sharpened := s.readBody(res)
+
+	config.UseLinearColorspace = true
+
+	res = s.send("/unsafe/plain/local:///test-blurry.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	original := s.readBody(res)
+
+	res = s.send("/unsafe/sh:1/plain/local:///test-blurry.png@png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	sharpenedLinear := s.readBody(res)
+
+	require.Greater(s.T(), s.maxPixelDiff(original, sharpenedLinear), uint32(4))
+	// Sharpening in linear light gives a different result
+	require.Greater(s.T(), s.maxPixelDiff(sharpened, sharpenedLinear), uint32(1))
+}
+
+func (s *ProcessingHandlerTestSuite) TestConvInvalidMatrix() {
+	rw := s.send("/unsafe/conv:0,0,0,0,1,0,0,0/plain/local:///test-kernel.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 404, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) isPngInterlaced(data []byte) bool {
+	// PNG signature (8 bytes) + IHDR chunk length and type (8 bytes) +
+	// width, height, bit depth, color type, compression, and filter (12 bytes)
+	require.Greater(s.T(), len(data), 28)
+	return data[28] == 1
+}
+
+func (s *ProcessingHandlerTestSuite) TestPngInterlacedOption() {
+	rw := s.send("/unsafe/pngi:1/plain/local:///test1.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.True(s.T(), s.isPngInterlaced(s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestPngInterlacedConfig() {
+	config.PngInterlaced = true
+
+	rw := s.send("/unsafe/plain/local:///test1.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.True(s.T(), s.isPngInterlaced(s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestPngInterlacedOptionOverridesConfig() {
+	config.PngInterlaced = true
+
+	rw := s.send("/unsafe/pngi:0/plain/local:///test1.png@png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.False(s.T(), s.isPngInterlaced(s.readBody(res)))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCacheControlPassthrough() {
+	config.CacheControlPassthrough = true
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Cache-Control", "fake-cache-control")
+		rw.Header().Set("Expires", "fake-expires")
+		rw.WriteHeader(200)
+		rw.Write(s.readTestFile("test1.png"))
+	}))
+	defer ts.Close()
+
+	rw := s.send("/unsafe/rs:fill:4:4/plain/" + ts.URL)
+	res := rw.Result()
+
+	require.Equal(s.T(), "fake-cache-control", res.Header.Get("Cache-Control"))
+	require.Equal(s.T(), "fake-expires", res.Header.Get("Expires"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestCacheControlPassthroughDisabled() {
+	config.CacheControlPassthrough = false
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Cache-Control", "fake-cache-control")
+		rw.Header().Set("Expires", "fake-expires")
+		rw.WriteHeader(200)
+		rw.Write(s.readTestFile("test1.png"))
+	}))
+	defer ts.Close()
+
+	rw := s.send("/unsafe/rs:fill:4:4/plain/" + ts.URL)
+	res := rw.Result()
+
+	require.NotEqual(s.T(), "fake-cache-control", res.Header.Get("Cache-Control"))
+	require.NotEqual(s.T(), "fake-expires", res.Header.Get("Expires"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagDisabled() {
+	config.ETagEnabled = false
+
+	rw := s.send("/unsafe/rs:fill:4:4/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Empty(s.T(), res.Header.Get("ETag"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagReqNoIfNotModified() {
+	config.ETagEnabled = true
+
+	poStr, imgdata, etag := s.sampleETagData("loremipsumdolor")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.Empty(s.T(), r.Header.Get("If-None-Match"))
+
+		rw.Header().Set("ETag", imgdata.Headers["ETag"])
+		rw.WriteHeader(200)
+		rw.Write(s.readTestFile("test1.png"))
+	}))
+	defer ts.Close()
+
+	rw := s.send(fmt.Sprintf("/unsafe/%s/plain/%s", poStr, ts.URL))
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), etag, res.Header.Get("ETag"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagDataNoIfNotModified() {
+	config.ETagEnabled = true
+
+	poStr, imgdata, etag := s.sampleETagData("")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.Empty(s.T(), r.Header.Get("If-None-Match"))
+
+		rw.WriteHeader(200)
+		rw.Write(imgdata.Data)
+	}))
+	defer ts.Close()
+
+	rw := s.send(fmt.Sprintf("/unsafe/%s/plain/%s", poStr, ts.URL))
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), etag, res.Header.Get("ETag"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagReqMatch() {
+	config.ETagEnabled = true
+
+	poStr, imgdata, etag := s.sampleETagData(`"loremipsumdolor"`)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.Equal(s.T(), imgdata.Headers["ETag"], r.Header.Get("If-None-Match"))
+
+		rw.WriteHeader(304)
+	}))
+	defer ts.Close()
+
+	header := make(http.Header)
+	header.Set("If-None-Match", etag)
+
+	rw := s.send(fmt.Sprintf("/unsafe/%s/plain/%s", poStr, ts.URL), header)
+	res := rw.Result()
+
+	require.Equal(s.T(), 304, res.StatusCode)
+	require.Equal(s.T(), etag, res.Header.Get("ETag"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagDataMatch() {
+	config.ETagEnabled = true
+
+	poStr, imgdata, etag := s.sampleETagData("")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.Empty(s.T(), r.Header.Get("If-None-Match"))
+
+		rw.WriteHeader(200)
+		rw.Write(imgdata.Data)
+	}))
+	defer ts.Close()
+
+	header := make(http.Header)
+	header.Set("If-None-Match", etag)
+
+	rw := s.send(fmt.Sprintf("/unsafe/%s/plain/%s", poStr, ts.URL), header)
+	res := rw.Result()
+
+	require.Equal(s.T(), 304, res.StatusCode)
+	require.Equal(s.T(), etag, res.Header.Get("ETag"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagReqNotMatch() {
+	config.ETagEnabled = true
+
+	poStr, imgdata, actualETag := s.sampleETagData(`"loremipsumdolor"`)
+	_, _, expectedETag := s.sampleETagData(`"loremipsum"`)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.Equal(s.T(), `"loremipsum"`, r.Header.Get("If-None-Match"))
 
 		rw.Header().Set("ETag", imgdata.Headers["ETag"])
 		rw.WriteHeader(200)
@@ -525,6 +3845,491 @@ func (s *ProcessingHandlerTestSuite) TestETagProcessingOptionsNotMatch() {
 	require.Equal(s.T(), actualETag, res.Header.Get("ETag"))
 }
 
+func (s *ProcessingHandlerTestSuite) TestETagLastModifiedMatch() {
+	config.ETagEnabled = true
+
+	lastModified := "Wed, 21 Oct 2015 07:28:00 GMT"
+	requests := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			rw.WriteHeader(304)
+			return
+		}
+
+		rw.Header().Set("Last-Modified", lastModified)
+		rw.WriteHeader(200)
+		rw.Write(s.readTestFile("test1.png"))
+	}))
+	defer ts.Close()
+
+	res := s.send("/unsafe/rs:fill:4:4/plain/" + ts.URL).Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	etag := res.Header.Get("ETag")
+	require.Contains(s.T(), etag, "/M")
+
+	header := make(http.Header)
+	header.Set("If-None-Match", etag)
+
+	res = s.send("/unsafe/rs:fill:4:4/plain/"+ts.URL, header).Result()
+	require.Equal(s.T(), 304, res.StatusCode)
+	require.Equal(s.T(), etag, res.Header.Get("ETag"))
+	require.Equal(s.T(), 2, requests)
+}
+
+func (s *ProcessingHandlerTestSuite) TestETagOptionsOrder() {
+	config.ETagEnabled = true
+
+	res := s.send("/unsafe/rs:fill:4:4/q:50/bl:2/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+
+	etag := res.Header.Get("ETag")
+	require.NotEmpty(s.T(), etag)
+
+	res = s.send("/unsafe/bl:2/q:50/rs:fill:4:4/plain/local:///test1.png").Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), etag, res.Header.Get("ETag"))
+}
+
+func (s *ProcessingHandlerTestSuite) sendInfo(path string) infoResponse {
+	rw := s.send(path)
+	res := rw.Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "application/json", res.Header.Get("Content-Type"))
+
+	var info infoResponse
+	require.Nil(s.T(), json.Unmarshal(s.readBody(res), &info))
+
+	return info
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfo() {
+	info := s.sendInfo("/info/unsafe/plain/local:///test1.png")
+
+	require.Equal(s.T(), "png", info.Format)
+	require.Equal(s.T(), 10, info.Width)
+	require.Equal(s.T(), 10, info.Height)
+	require.Equal(s.T(), 1, info.Frames)
+	require.Equal(s.T(), len(s.readTestFile("test1.png")), info.Size)
+	require.True(s.T(), info.Processable)
+	require.Empty(s.T(), info.Reasons)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoResolutionTooBig() {
+	config.MaxSrcResolution = 50
+
+	info := s.sendInfo("/info/unsafe/plain/local:///test1.png")
+
+	require.False(s.T(), info.Processable)
+	require.Equal(s.T(), []string{"Source image resolution is too big"}, info.Reasons)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoSharpness() {
+	sharp := s.sendInfo("/info/unsafe/shp:1/plain/local:///test-sharp.png")
+	blurry := s.sendInfo("/info/unsafe/shp:1/plain/local:///test-blurry.png")
+
+	require.NotNil(s.T(), sharp.Sharpness)
+	require.NotNil(s.T(), blurry.Sharpness)
+	require.Greater(s.T(), *sharp.Sharpness, *blurry.Sharpness*2)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoDetectBlank() {
+	solid := s.sendInfo("/info/unsafe/db:1/plain/local:///test-uniform.png")
+	detailed := s.sendInfo("/info/unsafe/db:1/plain/local:///test-sharp.png")
+
+	require.NotNil(s.T(), solid.IsBlank)
+	require.True(s.T(), *solid.IsBlank)
+
+	require.NotNil(s.T(), detailed.IsBlank)
+	require.False(s.T(), *detailed.IsBlank)
+
+	require.Nil(s.T(), solid.Sharpness)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoDetectBlankThreshold() {
+	config.BlankThreshold = 200
+
+	info := s.sendInfo("/info/unsafe/db:1/plain/local:///test-sharp.png")
+
+	require.NotNil(s.T(), info.IsBlank)
+	require.True(s.T(), *info.IsBlank)
+
+	// The threshold from the URL overrides the config one
+	info = s.sendInfo("/info/unsafe/db:1:2/plain/local:///test-sharp.png")
+
+	require.NotNil(s.T(), info.IsBlank)
+	require.False(s.T(), *info.IsBlank)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoSharpnessNotRequested() {
+	info := s.sendInfo("/info/unsafe/plain/local:///test-sharp.png")
+
+	require.Nil(s.T(), info.Sharpness)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoFileTooBig() {
+	config.MaxSrcFileSize = 10
+
+	info := s.sendInfo("/info/unsafe/plain/local:///test1.png")
+
+	require.False(s.T(), info.Processable)
+	require.Equal(s.T(), []string{"Source image file is too big"}, info.Reasons)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoAnimation() {
+	config.MaxSrcResolution = 250
+	config.MaxAnimationFrames = 2
+
+	info := s.sendInfo("/info/unsafe/plain/local:///test-animated.gif")
+
+	require.Equal(s.T(), "gif", info.Format)
+	require.Equal(s.T(), 2, info.Frames)
+	require.True(s.T(), info.Processable)
+	require.Empty(s.T(), info.Reasons)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoAnimationTooBig() {
+	config.MaxSrcResolution = 250
+	config.MaxAnimationFrames = 10
+
+	info := s.sendInfo("/info/unsafe/plain/local:///test-animated.gif")
+
+	require.Equal(s.T(), 3, info.Frames)
+	require.False(s.T(), info.Processable)
+	require.Equal(s.T(), []string{"Source animation resolution is too big"}, info.Reasons)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoInvalidSignature() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+
+	rw := s.send("/info/unsafe/plain/local:///test1.png")
+	res := rw.Result()
+
+	require.Equal(s.T(), 403, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) sendDryRun(path string) dryRunResponse {
+	res := s.send(path).Result()
+
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "application/json", res.Header.Get("Content-Type"))
+
+	var resp dryRunResponse
+	require.Nil(s.T(), json.Unmarshal(s.readBody(res), &resp))
+
+	return resp
+}
+
+func (s *ProcessingHandlerTestSuite) TestDryRun() {
+	resp := s.sendDryRun("/dry-run/unsafe/rs:fill:4:4/plain/local:///test1.png@png")
+
+	require.Equal(s.T(), "png", resp.Source.Format)
+	require.Equal(s.T(), 10, resp.Source.Width)
+	require.Equal(s.T(), 10, resp.Source.Height)
+	require.Equal(s.T(), 1, resp.Source.Frames)
+
+	require.Equal(s.T(), "png", resp.Result.Format)
+	require.Equal(s.T(), 4, resp.Result.Width)
+	require.Equal(s.T(), 4, resp.Result.Height)
+	require.Equal(s.T(), 1, resp.Result.Frames)
+
+	require.False(s.T(), resp.Passthrough)
+}
+
+func (s *ProcessingHandlerTestSuite) TestDryRunAlpha() {
+	resp := s.sendDryRun("/dry-run/unsafe/rs:fill:4:4/plain/local:///test-alpha-blob.png@jpg")
+
+	require.True(s.T(), resp.Source.HasAlpha)
+
+	require.Equal(s.T(), "jpeg", resp.Result.Format)
+	require.False(s.T(), resp.Result.HasAlpha)
+}
+
+func (s *ProcessingHandlerTestSuite) TestDryRunAllowPassthrough() {
+	config.AllowPassthrough = true
+	config.StripMetadata = false
+
+	resp := s.sendDryRun("/dry-run/unsafe/rs:fit:20:20/plain/local:///test1.png@png")
+
+	require.True(s.T(), resp.Passthrough)
+	require.Equal(s.T(), "png", resp.Result.Format)
+	require.Equal(s.T(), 10, resp.Result.Width)
+	require.Equal(s.T(), 10, resp.Result.Height)
+}
+
+func (s *ProcessingHandlerTestSuite) TestDryRunSkipProcessing() {
+	config.SkipProcessingFormats = []imagetype.Type{imagetype.PNG}
+
+	resp := s.sendDryRun("/dry-run/unsafe/rs:fill:4:4/plain/local:///test1.png")
+
+	require.True(s.T(), resp.Passthrough)
+	require.Equal(s.T(), "png", resp.Source.Format)
+	require.Equal(s.T(), 10, resp.Source.Width)
+	require.Equal(s.T(), 1, resp.Source.Frames)
+	require.False(s.T(), resp.Source.HasAlpha)
+	require.Equal(s.T(), resp.Source, resp.Result)
+	require.Equal(s.T(), len(s.readTestFile("test1.png")), resp.EstimatedSize)
+
+	resp = s.sendDryRun("/dry-run/unsafe/rs:fill:4:4/plain/local:///test-alpha-blob.png")
+
+	require.True(s.T(), resp.Passthrough)
+	require.Equal(s.T(), 1, resp.Source.Frames)
+	require.True(s.T(), resp.Source.HasAlpha)
+	require.Equal(s.T(), resp.Source, resp.Result)
+}
+
+func (s *ProcessingHandlerTestSuite) TestDryRunEstimatedSize() {
+	low := s.sendDryRun("/dry-run/unsafe/rs:fill:4:4/q:50/plain/local:///test1.png@jpg")
+	high := s.sendDryRun("/dry-run/unsafe/rs:fill:4:4/q:90/plain/local:///test1.png@jpg")
+
+	require.Greater(s.T(), low.EstimatedSize, 0)
+	require.Greater(s.T(), high.EstimatedSize, low.EstimatedSize)
+
+	// Lossless formats are larger than lossy ones
+	png := s.sendDryRun("/dry-run/unsafe/rs:fill:4:4/plain/local:///test1.png@png")
+	require.Greater(s.T(), png.EstimatedSize, high.EstimatedSize)
+
+	// The size is estimated for all the frames
+	config.MaxAnimationFrames = 1
+	single := s.sendDryRun("/dry-run/unsafe/plain/local:///test-animated.gif@gif")
+
+	config.MaxAnimationFrames = 2
+	animated := s.sendDryRun("/dry-run/unsafe/plain/local:///test-animated.gif@gif")
+
+	require.Equal(s.T(), 2, animated.Result.Frames)
+	require.InDelta(s.T(), 2*single.EstimatedSize, animated.EstimatedSize, 1)
+}
+
+func (s *ProcessingHandlerTestSuite) TestDryRunAnimation() {
+	config.MaxAnimationFrames = 2
+
+	resp := s.sendDryRun("/dry-run/unsafe/plain/local:///test-animated.gif@gif")
+
+	require.Equal(s.T(), "gif", resp.Result.Format)
+	require.Equal(s.T(), 2, resp.Result.Frames)
+}
+
+func (s *ProcessingHandlerTestSuite) TestDryRunSourceNotFound() {
+	res := s.send("/dry-run/unsafe/plain/local:///not-existing.png").Result()
+	require.Equal(s.T(), 404, res.StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) sendInfoBatch(paths ...string) []infoBatchItem {
+	body, err := json.Marshal(infoBatchRequest{Paths: paths})
+	require.Nil(s.T(), err)
+
+	req := httptest.NewRequest(http.MethodPost, "/info/batch", bytes.NewReader(body))
+	rw := httptest.NewRecorder()
+
+	s.router.ServeHTTP(rw, req)
+
+	res := rw.Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "application/json", res.Header.Get("Content-Type"))
+
+	var items []infoBatchItem
+	require.Nil(s.T(), json.Unmarshal(s.readBody(res), &items))
+	require.Len(s.T(), items, len(paths))
+
+	return items
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoBatch() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+	config.InfoBatchConcurrency = 2
+
+	items := s.sendInfoBatch(
+		"/RyFAmZJTJp-SdCeHdKcub8oRHwfMkiEI22bHEY1BT7U/plain/local:///test1.png",
+		"/unsafe/plain/local:///test1.png",
+		"/efQpyIoahsVRy_LZwvJ9JA6W9Xl9Rd5WEwkS2HZQMhs/plain/local:///missing.png",
+		"/invalid",
+		"/RyFAmZJTJp-SdCeHdKcub8oRHwfMkiEI22bHEY1BT7U/plain/local:///test1.png",
+	)
+
+	for _, i := range []int{0, 4} {
+		require.NotNil(s.T(), items[i].infoResponse, i)
+		require.Empty(s.T(), items[i].Error, i)
+		require.Equal(s.T(), "png", items[i].Format, i)
+		require.Equal(s.T(), 10, items[i].Width, i)
+		require.Equal(s.T(), 10, items[i].Height, i)
+		require.True(s.T(), items[i].Processable, i)
+	}
+
+	// Invalid signature
+	require.Nil(s.T(), items[1].infoResponse)
+	require.Equal(s.T(), 403, items[1].Status)
+	require.Equal(s.T(), "Forbidden", items[1].Error)
+
+	// Missing source
+	require.Nil(s.T(), items[2].infoResponse)
+	require.Equal(s.T(), 404, items[2].Status)
+	require.Equal(s.T(), "Source image is unreachable", items[2].Error)
+
+	// Invalid path
+	require.Nil(s.T(), items[3].infoResponse)
+	require.Equal(s.T(), 404, items[3].Status)
+	require.Equal(s.T(), "Invalid URL", items[3].Error)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoBatchTooLarge() {
+	config.InfoBatchMaxSize = 2
+
+	body := `{"paths":["/unsafe/plain/local:///test1.png","/unsafe/plain/local:///test1.png","/unsafe/plain/local:///test1.png"]}`
+
+	req := httptest.NewRequest(http.MethodPost, "/info/batch", strings.NewReader(body))
+	rw := httptest.NewRecorder()
+
+	s.router.ServeHTTP(rw, req)
+
+	require.Equal(s.T(), 400, rw.Result().StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestInfoBatchInvalidBody() {
+	req := httptest.NewRequest(http.MethodPost, "/info/batch", strings.NewReader("not a json"))
+	rw := httptest.NewRecorder()
+
+	s.router.ServeHTTP(rw, req)
+
+	require.Equal(s.T(), 400, rw.Result().StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) setupTenants() {
+	config.Tenants = []config.Tenant{
+		{
+			Name:       "a",
+			PathPrefix: "/a",
+			Keys:       [][]byte{[]byte("key-a")},
+			Salts:      [][]byte{[]byte("salt-a")},
+		},
+		{
+			Name:       "b",
+			PathPrefix: "/b",
+			Keys:       [][]byte{[]byte("key-b")},
+			Salts:      [][]byte{[]byte("salt-b")},
+		},
+	}
+}
+
+func (s *ProcessingHandlerTestSuite) TestTenantsPathPrefix() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+	s.setupTenants()
+
+	rw := s.send("/a/nEUKdB_HtoVCZf4srIOgnIH-J4Xrl1qFihFDyaU7X4A/rs:fit:4:4/plain/local:///test1.png")
+	require.Equal(s.T(), 200, rw.Result().StatusCode)
+
+	rw = s.send("/b/uf6wl-OmqmC0tW9v9E0UI5Q8HCGB4NcLqKkZQhIWZdM/rs:fit:4:4/plain/local:///test1.png")
+	require.Equal(s.T(), 200, rw.Result().StatusCode)
+
+	// Signature of the tenant a is not valid for the tenant b
+	rw = s.send("/b/nEUKdB_HtoVCZf4srIOgnIH-J4Xrl1qFihFDyaU7X4A/rs:fit:4:4/plain/local:///test1.png")
+	require.Equal(s.T(), 403, rw.Result().StatusCode)
+
+	// Tenant signatures are not valid without the tenant prefix
+	rw = s.send("/nEUKdB_HtoVCZf4srIOgnIH-J4Xrl1qFihFDyaU7X4A/rs:fit:4:4/plain/local:///test1.png")
+	require.Equal(s.T(), 403, rw.Result().StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestTenantsHeader() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+	config.TenantHeader = "X-Tenant"
+	s.setupTenants()
+
+	header := make(http.Header)
+	header.Set("X-Tenant", "b")
+
+	rw := s.send("/uf6wl-OmqmC0tW9v9E0UI5Q8HCGB4NcLqKkZQhIWZdM/rs:fit:4:4/plain/local:///test1.png", header)
+	require.Equal(s.T(), 200, rw.Result().StatusCode)
+
+	header.Set("X-Tenant", "a")
+
+	rw = s.send("/uf6wl-OmqmC0tW9v9E0UI5Q8HCGB4NcLqKkZQhIWZdM/rs:fit:4:4/plain/local:///test1.png", header)
+	require.Equal(s.T(), 403, rw.Result().StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestTenantsHeaderWithoutKeys() {
+	config.Keys = [][]byte{[]byte("test-key")}
+	config.Salts = [][]byte{[]byte("test-salt")}
+	config.TenantHeader = "X-Tenant"
+	s.setupTenants()
+	config.Tenants[0].Keys, config.Tenants[0].Salts = nil, nil
+	config.Tenants[0].AllowedSources = []*regexp.Regexp{
+		regexp.MustCompile("^local:///test1\\.png$"),
+	}
+
+	// The tenant without its own keys can't be selected by the header,
+	// so the global settings are used
+	header := make(http.Header)
+	header.Set("X-Tenant", "a")
+
+	rw := s.send("/HYRX5M2FF-eaIQpODZZR7c0e2tSY_Fs5yo1pAUFThVk/rs:fit:4:4/plain/local:///test1.jpg", header)
+	require.Equal(s.T(), 200, rw.Result().StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestTenantsHeaderVary() {
+	config.TenantHeader = "X-Tenant"
+	s.setupTenants()
+
+	initProcessingHandler()
+	defer func() {
+		config.Reset()
+		initProcessingHandler()
+	}()
+
+	rw := s.send("/unsafe/rs:fit:4:4/plain/local:///test1.png")
+	require.Equal(s.T(), 200, rw.Result().StatusCode)
+	require.Equal(s.T(), "X-Tenant", rw.Result().Header.Get("Vary"))
+}
+
+func (s *ProcessingHandlerTestSuite) TestTenantsAllowedSources() {
+	s.setupTenants()
+	config.Tenants[0].Keys, config.Tenants[0].Salts = nil, nil
+	config.Tenants[1].Keys, config.Tenants[1].Salts = nil, nil
+	config.Tenants[0].AllowedSources = []*regexp.Regexp{
+		regexp.MustCompile("^local:///test1\\.png$"),
+	}
+
+	rw := s.send("/a/unsafe/rs:fit:4:4/plain/local:///test1.png")
+	require.Equal(s.T(), 200, rw.Result().StatusCode)
+
+	rw = s.send("/a/unsafe/rs:fit:4:4/plain/local:///test1.jpg")
+	require.Equal(s.T(), 404, rw.Result().StatusCode)
+
+	rw = s.send("/b/unsafe/rs:fit:4:4/plain/local:///test1.jpg")
+	require.Equal(s.T(), 200, rw.Result().StatusCode)
+}
+
+func (s *ProcessingHandlerTestSuite) TestTenantsPresets() {
+	config.EnableDebugHeaders = true
+	s.setupTenants()
+	config.Tenants[0].Keys, config.Tenants[0].Salts = nil, nil
+	config.Tenants[1].Keys, config.Tenants[1].Salts = nil, nil
+
+	require.Nil(s.T(), options.ParseTenantPresets("a", []string{"small=rs:fit:4:4"}))
+	require.Nil(s.T(), options.ParseTenantPresets("b", []string{"small=rs:fit:2:2"}))
+
+	rw := s.send("/a/unsafe/pr:small/plain/local:///test1.png")
+	res := rw.Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "4", res.Header.Get("X-Result-Width"))
+
+	rw = s.send("/b/unsafe/pr:small/plain/local:///test1.png")
+	res = rw.Result()
+	require.Equal(s.T(), 200, res.StatusCode)
+	require.Equal(s.T(), "2", res.Header.Get("X-Result-Width"))
+
+	// Tenant presets are not available outside the tenant
+	rw = s.send("/unsafe/pr:small/plain/local:///test1.png")
+	require.Equal(s.T(), 404, rw.Result().StatusCode)
+}
+
 func TestProcessingHandler(t *testing.T) {
 	suite.Run(t, new(ProcessingHandlerTestSuite))
 }
@@ -26,6 +26,11 @@ func ctxTime(ctx context.Context) time.Duration {
 	return 0
 }
 
+// RequestTime returns the time elapsed since the request start
+func RequestTime(ctx context.Context) time.Duration {
+	return ctxTime(ctx)
+}
+
 func CheckTimeout(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
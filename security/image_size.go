@@ -5,10 +5,35 @@ import (
 	"github.com/imgproxy/imgproxy/v3/ierrors"
 )
 
-var ErrSourceResolutionTooBig = ierrors.New(422, "Source image resolution is too big", "Invalid source image")
+// ErrTypeSourceResolution is the metrics error type of the source resolution limit errors
+const ErrTypeSourceResolution = "source_resolution"
+
+var ErrSourceResolutionTooBig = NewSourceResolutionError("Source image resolution is too big")
+
+// NewSourceResolutionError creates the error caused by the source image resolution limit
+func NewSourceResolutionError(msg string) *ierrors.Error {
+	err := ierrors.New(422, msg, "Invalid source image")
+	err.Type = ErrTypeSourceResolution
+
+	return err
+}
+
+// IsSourceResolutionError checks if the error is caused by the source image
+// resolution limit. The error may be wrapped with a prefix, so we can't compare
+// it with ErrSourceResolutionTooBig directly
+func IsSourceResolutionError(err error) bool {
+	ierr, ok := err.(*ierrors.Error)
+	return ok && ierr.Type == ErrTypeSourceResolution
+}
 
 func CheckDimensions(width, height int) error {
-	if width*height > config.MaxSrcResolution {
+	return CheckDimensionsLimit(width, height, config.MaxSrcResolution)
+}
+
+// CheckDimensionsLimit checks the image dimensions against the provided max resolution
+// instead of the configured one
+func CheckDimensionsLimit(width, height, maxResolution int) error {
+	if width*height > maxResolution {
 		return ErrSourceResolutionTooBig
 	}
 
@@ -14,8 +14,24 @@ var (
 	ErrInvalidSignatureEncoding = errors.New("Invalid signature encoding")
 )
 
+// IsSignatureEnabled returns true if URL signatures are verified
+func IsSignatureEnabled() bool {
+	return IsTenantSignatureEnabled(nil)
+}
+
+// IsTenantSignatureEnabled returns true if URL signatures of the tenant's requests
+// are verified. Tenants that don't have their own keys use the global ones
+func IsTenantSignatureEnabled(tenant *config.Tenant) bool {
+	keys, salts := signatureKeys(tenant)
+	return len(keys) > 0 && len(salts) > 0
+}
+
 func VerifySignature(signature, path string) error {
-	if len(config.Keys) == 0 || len(config.Salts) == 0 {
+	return VerifyTenantSignature(nil, signature, path)
+}
+
+func VerifyTenantSignature(tenant *config.Tenant, signature, path string) error {
+	if !IsTenantSignatureEnabled(tenant) {
 		return nil
 	}
 
@@ -24,8 +40,10 @@ func VerifySignature(signature, path string) error {
 		return ErrInvalidSignatureEncoding
 	}
 
-	for i := 0; i < len(config.Keys); i++ {
-		if hmac.Equal(messageMAC, signatureFor(path, config.Keys[i], config.Salts[i], config.SignatureSize)) {
+	keys, salts := signatureKeys(tenant)
+
+	for i := 0; i < len(keys); i++ {
+		if hmac.Equal(messageMAC, signatureFor(path, keys[i], salts[i], config.SignatureSize)) {
 			return nil
 		}
 	}
@@ -33,6 +51,14 @@ func VerifySignature(signature, path string) error {
 	return ErrInvalidSignature
 }
 
+func signatureKeys(tenant *config.Tenant) ([][]byte, [][]byte) {
+	if tenant != nil && len(tenant.Keys) > 0 {
+		return tenant.Keys, tenant.Salts
+	}
+
+	return config.Keys, config.Salts
+}
+
 func signatureFor(str string, key, salt []byte, signatureSize int) []byte {
 	mac := hmac.New(sha256.New, key)
 	mac.Write(salt)
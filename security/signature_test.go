@@ -51,6 +51,35 @@ func (s *SignatureTestSuite) TestVerifySignatureMultiplePairs() {
 	require.Error(s.T(), err)
 }
 
+func (s *SignatureTestSuite) TestIsSignatureEnabled() {
+	require.True(s.T(), IsSignatureEnabled())
+
+	config.Keys = nil
+	config.Salts = nil
+
+	require.False(s.T(), IsSignatureEnabled())
+}
+
+func (s *SignatureTestSuite) TestVerifyTenantSignature() {
+	tenant := &config.Tenant{
+		Keys:  [][]byte{[]byte("test-key2")},
+		Salts: [][]byte{[]byte("test-salt2")},
+	}
+
+	err := VerifyTenantSignature(tenant, "jbDffNPt1-XBgDccsaE-XJB9lx8JIJqdeYIZKgOqZpg", "asd")
+	require.Nil(s.T(), err)
+
+	err = VerifyTenantSignature(tenant, "dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
+	require.Error(s.T(), err)
+}
+
+func (s *SignatureTestSuite) TestVerifyTenantSignatureFallback() {
+	tenant := &config.Tenant{}
+
+	err := VerifyTenantSignature(tenant, "dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
+	require.Nil(s.T(), err)
+}
+
 func TestSignature(t *testing.T) {
 	suite.Run(t, new(SignatureTestSuite))
 }
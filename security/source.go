@@ -1,14 +1,43 @@
 package security
 
 import (
+	"regexp"
+
 	"github.com/imgproxy/imgproxy/v3/config"
 )
 
 func VerifySourceURL(imageURL string) bool {
-	if len(config.AllowedSources) == 0 {
+	return VerifyTenantSourceURL(nil, "", imageURL)
+}
+
+// VerifyTenantSourceURL checks the source URL against the tenant's allowed sources.
+// Tenants that don't have their own allowed sources use the global ones.
+// If the allowed sources policy is selected, its allowed sources are used instead
+// of the global ones. The policy can't extend the tenant's own allowed sources,
+// so the URL should match both of them
+func VerifyTenantSourceURL(tenant *config.Tenant, policy string, imageURL string) bool {
+	if tenant != nil && len(tenant.AllowedSources) > 0 {
+		if !matchAllowedSources(tenant.AllowedSources, imageURL) {
+			return false
+		}
+	} else if len(policy) == 0 {
+		return matchAllowedSources(config.GetAllowedSources(), imageURL)
+	}
+
+	if len(policy) > 0 {
+		return matchAllowedSources(config.AllowedSourcesPolicies[policy], imageURL)
+	}
+
+	return true
+}
+
+// matchAllowedSources checks if the URL matches any of the allowed sources.
+// Empty allowed sources allow any URL
+func matchAllowedSources(allowedSources []*regexp.Regexp, imageURL string) bool {
+	if len(allowedSources) == 0 {
 		return true
 	}
-	for _, allowedSource := range config.AllowedSources {
+	for _, allowedSource := range allowedSources {
 		if allowedSource.MatchString(imageURL) {
 			return true
 		}
@@ -0,0 +1,68 @@
+package security
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
+)
+
+type SourceTestSuite struct {
+	suite.Suite
+}
+
+func (s *SourceTestSuite) SetupTest() {
+	config.Reset()
+
+	config.AllowedSources = []*regexp.Regexp{configurators.RegexpFromPattern("local:///global/*")}
+	config.AllowedSourcesPolicies = map[string][]*regexp.Regexp{
+		"png": {configurators.RegexpFromPattern("local:///*/*.png")},
+	}
+}
+
+func (s *SourceTestSuite) TestGlobal() {
+	require.True(s.T(), VerifySourceURL("local:///global/test1.png"))
+	require.False(s.T(), VerifySourceURL("local:///tenant/test1.png"))
+}
+
+func (s *SourceTestSuite) TestPolicy() {
+	// The policy is used instead of the global allowed sources
+	require.True(s.T(), VerifyTenantSourceURL(nil, "png", "local:///tenant/test1.png"))
+	require.False(s.T(), VerifyTenantSourceURL(nil, "png", "local:///global/test1.jpg"))
+}
+
+func (s *SourceTestSuite) TestTenant() {
+	tenant := &config.Tenant{
+		AllowedSources: []*regexp.Regexp{configurators.RegexpFromPattern("local:///tenant/*")},
+	}
+
+	require.True(s.T(), VerifyTenantSourceURL(tenant, "", "local:///tenant/test1.jpg"))
+	require.False(s.T(), VerifyTenantSourceURL(tenant, "", "local:///global/test1.jpg"))
+}
+
+func (s *SourceTestSuite) TestTenantWithoutAllowedSources() {
+	tenant := &config.Tenant{}
+
+	require.True(s.T(), VerifyTenantSourceURL(tenant, "", "local:///global/test1.png"))
+	require.False(s.T(), VerifyTenantSourceURL(tenant, "", "local:///tenant/test1.png"))
+	require.True(s.T(), VerifyTenantSourceURL(tenant, "png", "local:///tenant/test1.png"))
+}
+
+func (s *SourceTestSuite) TestTenantPolicy() {
+	tenant := &config.Tenant{
+		AllowedSources: []*regexp.Regexp{configurators.RegexpFromPattern("local:///tenant/*")},
+	}
+
+	// The URL should match both the tenant's allowed sources and the policy
+	require.True(s.T(), VerifyTenantSourceURL(tenant, "png", "local:///tenant/test1.png"))
+	require.False(s.T(), VerifyTenantSourceURL(tenant, "png", "local:///tenant/test1.jpg"))
+	require.False(s.T(), VerifyTenantSourceURL(tenant, "png", "local:///global/test1.png"))
+}
+
+func TestSource(t *testing.T) {
+	suite.Run(t, new(SourceTestSuite))
+}
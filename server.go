@@ -34,6 +34,9 @@ func buildRouter() *router.Router {
 		r.GET(config.HealthCheckPath, handleHealth, true)
 	}
 	r.GET("/favicon.ico", handleFavicon, true)
+	r.POST("/info/batch", withMetrics(withPanicHandler(withCORS(withSecret(handleInfoBatch)))), true)
+	r.GET("/info/", withMetrics(withPanicHandler(withCORS(withSecret(handleInfo)))), false)
+	r.GET("/dry-run/", withMetrics(withPanicHandler(withCORS(withSecret(handleDryRun)))), false)
 	r.GET("/", withMetrics(withPanicHandler(withCORS(withSecret(handleProcessing)))), false)
 	r.HEAD("/", withCORS(handleHead), false)
 	r.OPTIONS("/", withCORS(handleHead), false)
@@ -134,7 +137,7 @@ func withPanicHandler(h router.RouteHandler) router.RouteHandler {
 		defer func() {
 			if rerr := recover(); rerr != nil {
 				err, ok := rerr.(error)
-				if !ok {
+				if !ok || err == http.ErrAbortHandler {
 					panic(rerr)
 				}
 
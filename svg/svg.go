@@ -2,14 +2,93 @@ package svg
 
 import (
 	"bytes"
+	"fmt"
+	"html"
 	"io"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/tdewolff/parse/v2"
 	"github.com/tdewolff/parse/v2/xml"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/ierrors"
+)
+
+const (
+	SanitizeModeStrip  = "strip"
+	SanitizeModeReject = "reject"
+)
+
+func newUnsafeError(reason string) error {
+	return ierrors.New(
+		422,
+		fmt.Sprintf("SVG contains unsafe content: %s", reason),
+		"Invalid source image",
+	)
+}
+
+var (
+	cssImportRe = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	cssURLRe    = regexp.MustCompile(`(?i)url\(\s*(?:"[^"]*"|'[^']*'|[^)]*)\s*\)`)
+	cssEscapeRe = regexp.MustCompile(`\\(?:([0-9a-fA-F]{1,6})\s?|(.))`)
 )
 
+func isExternalRef(val []byte) bool {
+	v := strings.ToLower(strings.TrimSpace(strings.Trim(string(val), `"'`)))
+
+	return len(v) > 0 && !strings.HasPrefix(v, "#") && !strings.HasPrefix(v, "data:")
+}
+
+// unescapeCSS replaces CSS escapes with the characters they stand for,
+// so escaped url() and @import can't sneak through
+func unescapeCSS(css string) string {
+	return cssEscapeRe.ReplaceAllStringFunc(css, func(esc string) string {
+		m := cssEscapeRe.FindStringSubmatch(esc)
+		if len(m[1]) == 0 {
+			return m[2]
+		}
+
+		code, err := strconv.ParseUint(m[1], 16, 32)
+		if err != nil || code == 0 || code > 0x10FFFF {
+			return "\uFFFD"
+		}
+
+		return string(rune(code))
+	})
+}
+
+// sanitizeCSS removes @import rules and replaces external url() references with none.
+// Returns false if the CSS doesn't contain anything to sanitize
+func sanitizeCSS(css string) (string, bool) {
+	css = unescapeCSS(css)
+	changed := false
+
+	css = cssImportRe.ReplaceAllStringFunc(css, func(string) string {
+		changed = true
+		return ""
+	})
+
+	css = cssURLRe.ReplaceAllStringFunc(css, func(u string) string {
+		if !isExternalRef([]byte(u[4 : len(u)-1])) {
+			return u
+		}
+
+		changed = true
+		return "none"
+	})
+
+	return css, changed
+}
+
+// Satitize removes scripts, event handlers, external references, processing
+// instructions, and DOCTYPE declarations (that may contain XXE vectors) from the SVG.
+// External references are removed from styles too.
+// If config.SanitizeSvgMode is "reject", an error is returned instead.
 func Satitize(data []byte) ([]byte, error) {
+	reject := config.SanitizeSvgMode == SanitizeModeReject
+
 	r := bytes.NewReader(data)
 	l := xml.NewLexer(parse.NewInput(r))
 
@@ -17,10 +96,17 @@ func Satitize(data []byte) ([]byte, error) {
 	buf.Grow(len(data))
 
 	ignoreTag := 0
+	ignorePI := false
+	inStyle := false
 
 	for {
 		tt, tdata := l.Next()
 
+		if ignorePI && tt != xml.ErrorToken {
+			ignorePI = tt != xml.StartTagClosePIToken
+			continue
+		}
+
 		if ignoreTag > 0 {
 			switch tt {
 			case xml.EndTagToken, xml.StartTagCloseVoidToken:
@@ -38,16 +124,91 @@ func Satitize(data []byte) ([]byte, error) {
 				return nil, l.Err()
 			}
 			return buf.Bytes(), nil
+		case xml.DOCTYPEToken:
+			if reject {
+				return nil, newUnsafeError("DOCTYPE declaration")
+			}
+			continue
+		case xml.StartTagPIToken:
+			// Only the XML declaration is allowed. Other processing instructions
+			// like xml-stylesheet may load external resources
+			if strings.ToLower(string(l.Text())) != "xml" {
+				if reject {
+					return nil, newUnsafeError("processing instruction")
+				}
+				ignorePI = true
+				continue
+			}
+			buf.Write(tdata)
 		case xml.StartTagToken:
-			if strings.ToLower(string(l.Text())) == "script" {
+			tagName := strings.ToLower(string(l.Text()))
+
+			if tagName == "script" {
+				if reject {
+					return nil, newUnsafeError("script")
+				}
 				ignoreTag++
 				continue
 			}
+
+			inStyle = tagName == "style"
+			buf.Write(tdata)
+		case xml.EndTagToken, xml.StartTagCloseVoidToken:
+			inStyle = false
+			buf.Write(tdata)
+		case xml.TextToken:
+			if inStyle {
+				if css, changed := sanitizeCSS(html.UnescapeString(string(tdata))); changed {
+					if reject {
+						return nil, newUnsafeError("external reference in styles")
+					}
+					buf.WriteString(html.EscapeString(css))
+					continue
+				}
+			}
+			buf.Write(tdata)
+		case xml.CDATAToken:
+			if inStyle {
+				if css, changed := sanitizeCSS(string(tdata)); changed {
+					if reject {
+						return nil, newUnsafeError("external reference in styles")
+					}
+					buf.WriteString(css)
+					continue
+				}
+			}
 			buf.Write(tdata)
 		case xml.AttributeToken:
-			if strings.ToLower(string(l.Text())) == "onload" {
+			attrName := strings.ToLower(string(l.Text()))
+
+			if strings.HasPrefix(attrName, "on") {
+				if reject {
+					return nil, newUnsafeError(fmt.Sprintf("%s event handler", attrName))
+				}
 				continue
 			}
+
+			if (attrName == "href" || strings.HasSuffix(attrName, ":href")) && isExternalRef(l.AttrVal()) {
+				if reject {
+					return nil, newUnsafeError("external reference")
+				}
+				continue
+			}
+
+			if attrName == "style" {
+				val := l.AttrVal()
+				css := html.UnescapeString(strings.Trim(string(val), `"'`))
+
+				if css, changed := sanitizeCSS(css); changed {
+					if reject {
+						return nil, newUnsafeError("external reference in styles")
+					}
+					buf.Write(tdata[:len(tdata)-len(val)])
+					buf.WriteString(`"` + html.EscapeString(css) + `"`)
+					continue
+				}
+			}
+
 			buf.Write(tdata)
 		default:
 			buf.Write(tdata)
@@ -0,0 +1,149 @@
+package svg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const unsafeSvg = `<?xml version="1.0" standalone="no"?>
+<!DOCTYPE svg [<!ENTITY xxe SYSTEM "file:///etc/passwd">]>
+<svg width="200" height="100" xmlns:xlink="http://www.w3.org/1999/xlink" onload="alert(1)">
+  <script>alert(document.cookie)</script>
+  <rect id="rect" width="190" height="90" onclick="alert(2)" />
+  <image width="10" height="10" xlink:href="https://example.com/image.png" />
+  <image width="10" height="10" href="data:image/png;base64,AAAA" />
+  <use href="#rect" />
+</svg>`
+
+type SvgTestSuite struct{ suite.Suite }
+
+func (s *SvgTestSuite) SetupTest() {
+	config.Reset()
+}
+
+func (s *SvgTestSuite) TestSanitizeStrip() {
+	config.SanitizeSvgMode = SanitizeModeStrip
+
+	res, err := Satitize([]byte(unsafeSvg))
+	require.Nil(s.T(), err)
+
+	str := string(res)
+
+	require.NotContains(s.T(), str, "DOCTYPE")
+	require.NotContains(s.T(), str, "ENTITY")
+	require.NotContains(s.T(), str, "script")
+	require.NotContains(s.T(), str, "alert")
+	require.NotContains(s.T(), str, "onload")
+	require.NotContains(s.T(), str, "onclick")
+	require.NotContains(s.T(), str, "https://example.com")
+
+	require.Contains(s.T(), str, `href="data:image/png;base64,AAAA"`)
+	require.Contains(s.T(), str, `href="#rect"`)
+	require.Contains(s.T(), str, `<rect id="rect"`)
+}
+
+func (s *SvgTestSuite) TestSanitizeReject() {
+	config.SanitizeSvgMode = SanitizeModeReject
+
+	_, err := Satitize([]byte(unsafeSvg))
+	require.NotNil(s.T(), err)
+}
+
+func (s *SvgTestSuite) TestSanitizeRejectSafe() {
+	config.SanitizeSvgMode = SanitizeModeReject
+
+	safe := strings.Join([]string{
+		`<svg width="200" height="100">`,
+		`<rect id="rect" width="190" height="90"/>`,
+		`<use href="#rect"/>`,
+		`</svg>`,
+	}, "")
+
+	res, err := Satitize([]byte(safe))
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), safe, string(res))
+}
+
+const unsafeStylesSvg = `<?xml version="1.0"?>
+<?xml-stylesheet type="text/css" href="https://example.com/pi.css"?>
+<svg width="200" height="100">
+  <style>@import url("https://example.com/import.css"); rect { fill: url(https://example.com/style.svg#p) }</style>
+  <style><![CDATA[@import 'https://example.com/cdata.css'; circle { fill: url('#local') }]]></style>
+  <style>rect { stroke: \75 rl(https://example.com/escaped.svg) }</style>
+  <rect width="190" height="90" style="fill: url(&quot;https://example.com/attr.svg&quot;); stroke: url(#local)" />
+  <circle r="10" style="fill: red" />
+</svg>`
+
+func (s *SvgTestSuite) TestSanitizeStripProcessingInstructions() {
+	config.SanitizeSvgMode = SanitizeModeStrip
+
+	res, err := Satitize([]byte(unsafeStylesSvg))
+	require.Nil(s.T(), err)
+
+	str := string(res)
+
+	require.NotContains(s.T(), str, "xml-stylesheet")
+	require.NotContains(s.T(), str, "pi.css")
+	require.Contains(s.T(), str, `<?xml version="1.0"?>`)
+}
+
+func (s *SvgTestSuite) TestSanitizeStripStyles() {
+	config.SanitizeSvgMode = SanitizeModeStrip
+
+	res, err := Satitize([]byte(unsafeStylesSvg))
+	require.Nil(s.T(), err)
+
+	str := string(res)
+
+	require.NotContains(s.T(), str, "@import")
+	require.NotContains(s.T(), str, "https://example.com")
+
+	require.Contains(s.T(), str, "fill: none")
+	require.Contains(s.T(), str, "stroke: none")
+	require.Contains(s.T(), str, "url('#local')")
+	require.Contains(s.T(), str, "stroke: url(#local)")
+	require.Contains(s.T(), str, `style="fill: red"`)
+}
+
+func (s *SvgTestSuite) TestSanitizeRejectUnsafeStyles() {
+	config.SanitizeSvgMode = SanitizeModeReject
+
+	vectors := []string{
+		`<?xml-stylesheet href="https://example.com/pi.css"?><svg/>`,
+		`<svg><style>@import "https://example.com/import.css";</style></svg>`,
+		`<svg><style>rect { fill: url(https://example.com/style.svg#p) }</style></svg>`,
+		`<svg><style><![CDATA[rect { fill: url(https://example.com/cdata.svg#p) }]]></style></svg>`,
+		`<svg><style>rect { fill: \75 rl(https://example.com/escaped.svg#p) }</style></svg>`,
+		`<svg><rect style="fill: url('https://example.com/attr.svg#p')"/></svg>`,
+		`<svg><rect style="fill: url&#40;https://example.com/entity.svg#p&#41;"/></svg>`,
+	}
+
+	for _, v := range vectors {
+		_, err := Satitize([]byte(v))
+		require.NotNil(s.T(), err, v)
+	}
+}
+
+func (s *SvgTestSuite) TestSanitizeRejectSafeStyles() {
+	config.SanitizeSvgMode = SanitizeModeReject
+
+	safe := strings.Join([]string{
+		`<?xml version="1.0"?>`,
+		`<svg width="200" height="100">`,
+		`<style>rect { fill: url(#grad) }</style>`,
+		`<rect width="190" height="90" style="stroke: url('#grad')"/>`,
+		`</svg>`,
+	}, "")
+
+	res, err := Satitize([]byte(safe))
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), safe, string(res))
+}
+
+func TestSvg(t *testing.T) {
+	suite.Run(t, new(SvgTestSuite))
+}
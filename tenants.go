@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+)
+
+// requestTenant finds the tenant the request belongs to. Tenants are matched by
+// the path prefix that follows the provided prefix first and then by the tenant header.
+// Returns the prefix extended with the tenant's path prefix if the tenant was matched by it.
+//
+// The tenant header isn't signed, so only the tenants that have their own keys
+// can be selected by it. Otherwise, a URL signed with the global keys could be
+// replayed with the header of any tenant to gain its settings
+func requestTenant(r *http.Request, prefix string) (*config.Tenant, string) {
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	for i, t := range config.Tenants {
+		if len(t.PathPrefix) > 0 && strings.HasPrefix(path, t.PathPrefix+"/") {
+			return &config.Tenants[i], prefix + t.PathPrefix
+		}
+	}
+
+	if len(config.TenantHeader) > 0 {
+		if name := r.Header.Get(config.TenantHeader); len(name) > 0 {
+			for i, t := range config.Tenants {
+				if t.Name == name && len(t.Keys) > 0 {
+					return &config.Tenants[i], prefix
+				}
+			}
+		}
+	}
+
+	return nil, prefix
+}
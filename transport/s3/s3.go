@@ -15,6 +15,7 @@ import (
 )
 
 // transport implements RoundTripper for the 's3' protocol.
+// It also serves the 'b2' protocol for Backblaze B2 S3-compatible API.
 type transport struct {
 	svc *s3.S3
 }
@@ -28,7 +29,7 @@ func New() (http.RoundTripper, error) {
 
 	if len(config.S3Endpoint) != 0 {
 		s3Conf.Endpoint = aws.String(config.S3Endpoint)
-		s3Conf.S3ForcePathStyle = aws.Bool(true)
+		s3Conf.S3ForcePathStyle = aws.Bool(config.S3UsePathStyle)
 	}
 
 	sess, err := session.NewSession()
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -110,6 +111,60 @@ func (s *S3TestSuite) TestRoundTripWithUpdatedETagReturns200() {
 	require.Equal(s.T(), http.StatusOK, response.StatusCode)
 }
 
+func (s *S3TestSuite) TestRoundTripSignedWithCustomEndpoint() {
+	defer func(endpoint, region string) {
+		config.S3Endpoint = endpoint
+		config.S3Region = region
+	}(config.S3Endpoint, config.S3Region)
+
+	var authorization, path string
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		path = r.URL.Path
+		rw.Write(make([]byte, 32))
+	}))
+	defer server.Close()
+
+	config.S3Endpoint = server.URL
+	config.S3Region = "us-west-004"
+
+	rt, err := New()
+	require.Nil(s.T(), err)
+
+	request, _ := http.NewRequest("GET", "b2://test/foo/test.png", nil)
+
+	response, err := rt.RoundTrip(request)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 200, response.StatusCode)
+
+	// Path-style addressing and the request is signed for the configured region
+	require.Equal(s.T(), "/test/foo/test.png", path)
+	require.Regexp(s.T(), regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=Foo/\d{8}/us-west-004/s3/aws4_request,`), authorization)
+}
+
+func (s *S3TestSuite) TestVirtualHostedStyleWithCustomEndpoint() {
+	defer func(endpoint string, pathStyle bool) {
+		config.S3Endpoint = endpoint
+		config.S3UsePathStyle = pathStyle
+	}(config.S3Endpoint, config.S3UsePathStyle)
+
+	config.S3Endpoint = "https://s3.example.com"
+	config.S3UsePathStyle = false
+
+	rt, err := New()
+	require.Nil(s.T(), err)
+
+	s3req, _ := rt.(transport).svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String("test"),
+		Key:    aws.String("foo/test.png"),
+	})
+	require.Nil(s.T(), s3req.Build())
+
+	require.Equal(s.T(), "test.s3.example.com", s3req.HTTPRequest.URL.Host)
+	require.Equal(s.T(), "/foo/test.png", s3req.HTTPRequest.URL.Path)
+}
+
 func TestS3Transport(t *testing.T) {
 	suite.Run(t, new(S3TestSuite))
 }
@@ -0,0 +1,290 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	fsTransport "github.com/imgproxy/imgproxy/v3/transport/fs"
+)
+
+const defaultPort = "22"
+
+// ConnectionError is returned when the transport can't establish or lost
+// the connection to the SFTP server
+type ConnectionError struct {
+	Addr string
+	Err  error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("can't connect to SFTP server %s: %s", e.Addr, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+type transport struct {
+	clientConfig *ssh.ClientConfig
+	allowedHosts map[string]struct{}
+
+	clients      map[string]*sftp.Client
+	clientsMutex sync.Mutex
+}
+
+func New() (http.RoundTripper, error) {
+	var auth []ssh.AuthMethod
+
+	if len(config.SFTPKey) > 0 {
+		signer, err := ssh.ParsePrivateKey([]byte(config.SFTPKey))
+		if err != nil {
+			return nil, fmt.Errorf("Can't parse SFTP key: %s", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if len(config.SFTPPassword) > 0 {
+		auth = append(auth, ssh.Password(config.SFTPPassword))
+	}
+
+	if len(auth) == 0 {
+		return nil, errors.New("SFTP key or password should be set")
+	}
+
+	// We send the credentials to the server, so we should be sure it's the one we trust
+	if len(config.SFTPKnownHosts) == 0 {
+		return nil, errors.New("SFTP known hosts file should be set")
+	}
+
+	hostKeyCallback, err := knownhosts.New(config.SFTPKnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("Can't load SFTP known hosts: %s", err)
+	}
+
+	if len(config.SFTPAllowedHosts) == 0 {
+		return nil, errors.New("SFTP allowed hosts should be set")
+	}
+
+	allowedHosts := make(map[string]struct{}, len(config.SFTPAllowedHosts))
+	for _, h := range config.SFTPAllowedHosts {
+		allowedHosts[hostAddr(h)] = struct{}{}
+	}
+
+	return &transport{
+		clientConfig: &ssh.ClientConfig{
+			User:            config.SFTPUser,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         time.Duration(config.DownloadTimeout) * time.Second,
+		},
+		allowedHosts: allowedHosts,
+		clients:      make(map[string]*sftp.Client),
+	}, nil
+}
+
+// hostAddr adds the default port to the host if it doesn't have one
+func hostAddr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	return net.JoinHostPort(host, defaultPort)
+}
+
+func (t *transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	// Users should have converted the URL in the format of sftp://{host}[:{port}]/{path}
+	addr := req.URL.Host
+	if len(req.URL.Port()) == 0 {
+		addr = net.JoinHostPort(req.URL.Hostname(), defaultPort)
+	}
+
+	// Credentials are the same for all the servers, so we connect only to the allowed ones
+	if _, ok := t.allowedHosts[addr]; !ok {
+		return nil, fmt.Errorf("SFTP host %s is not allowed", addr)
+	}
+
+	f, err := t.open(req.Context(), addr, req.URL.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return respNotFound(req, fmt.Sprintf("%s doesn't exist", req.URL.Path)), nil
+		}
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		f.Close()
+		return respNotFound(req, fmt.Sprintf("%s is directory", req.URL.Path)), nil
+	}
+
+	header := make(http.Header)
+
+	if config.ETagEnabled {
+		// Different servers can have files with the same path, so the address is a part of the ETag
+		etag := fsTransport.BuildEtag(addr+req.URL.Path, fi)
+		header.Set("ETag", etag)
+
+		if etag == req.Header.Get("If-None-Match") {
+			f.Close()
+
+			return &http.Response{
+				StatusCode:    http.StatusNotModified,
+				Proto:         "HTTP/1.0",
+				ProtoMajor:    1,
+				ProtoMinor:    0,
+				Header:        header,
+				ContentLength: 0,
+				Body:          nil,
+				Close:         false,
+				Request:       req,
+			}, nil
+		}
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		ContentLength: fi.Size(),
+		Body:          f,
+		Close:         true,
+		Request:       req,
+	}, nil
+}
+
+// open opens the file using the cached client for the address.
+// If the cached connection is lost, open reconnects once
+func (t *transport) open(ctx context.Context, addr, path string) (*sftp.File, error) {
+	client, err := t.client(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := client.Open(path)
+	if err == nil || !errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		return f, err
+	}
+
+	t.dropClient(addr, client)
+
+	if client, err = t.client(ctx, addr); err != nil {
+		return nil, err
+	}
+
+	f, err = client.Open(path)
+	if err != nil && errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		t.dropClient(addr, client)
+		return nil, &ConnectionError{Addr: addr, Err: err}
+	}
+
+	return f, err
+}
+
+func (t *transport) client(ctx context.Context, addr string) (*sftp.Client, error) {
+	t.clientsMutex.Lock()
+	client, ok := t.clients[addr]
+	t.clientsMutex.Unlock()
+
+	if ok {
+		return client, nil
+	}
+
+	// Dialing can be slow, so we don't hold the mutex to not block other servers
+	client, err := t.dial(ctx, addr)
+	if err != nil {
+		return nil, &ConnectionError{Addr: addr, Err: err}
+	}
+
+	t.clientsMutex.Lock()
+	defer t.clientsMutex.Unlock()
+
+	// Another request could connect to the same server while we were dialing
+	if existing, ok := t.clients[addr]; ok {
+		client.Close()
+		return existing, nil
+	}
+
+	t.clients[addr] = client
+
+	return client, nil
+}
+
+func (t *transport) dropClient(addr string, client *sftp.Client) {
+	t.clientsMutex.Lock()
+	defer t.clientsMutex.Unlock()
+
+	if t.clients[addr] == client {
+		delete(t.clients, addr)
+	}
+
+	client.Close()
+}
+
+func (t *transport) dial(ctx context.Context, addr string) (*sftp.Client, error) {
+	dialer := net.Dialer{Timeout: t.clientConfig.Timeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// SSH handshake doesn't respect the context, so we limit it with the deadline
+	deadline := time.Now().Add(t.clientConfig.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, t.clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return client, nil
+}
+
+func respNotFound(req *http.Request, msg string) *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusNotFound,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        make(http.Header),
+		ContentLength: int64(len(msg)),
+		Body:          io.NopCloser(strings.NewReader(msg)),
+		Close:         false,
+		Request:       req,
+	}
+}
@@ -0,0 +1,293 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+)
+
+type SftpTestSuite struct {
+	suite.Suite
+
+	listener   net.Listener
+	transport  http.RoundTripper
+	testPath   string
+	knownHosts string
+}
+
+func (s *SftpTestSuite) SetupSuite() {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(s.T(), err)
+
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.Nil(s.T(), err)
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "imgproxy" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(s.T(), err)
+
+	go s.serve(serverConfig)
+
+	wd, err := os.Getwd()
+	require.Nil(s.T(), err)
+
+	s.testPath = filepath.ToSlash(filepath.Join(wd, "..", "..", "testdata", "test1.png"))
+
+	_, port, err := net.SplitHostPort(s.listener.Addr().String())
+	require.Nil(s.T(), err)
+
+	hosts := []string{s.listener.Addr().String(), net.JoinHostPort("localhost", port)}
+
+	knownHosts, err := ioutil.TempFile("", "imgproxy-known-hosts")
+	require.Nil(s.T(), err)
+	defer knownHosts.Close()
+
+	_, err = knownHosts.WriteString(knownhosts.Line(hosts, hostSigner.PublicKey()) + "\n")
+	require.Nil(s.T(), err)
+
+	s.knownHosts = knownHosts.Name()
+
+	config.Reset()
+	config.SFTPUser = "imgproxy"
+	config.SFTPPassword = "secret"
+	config.SFTPKnownHosts = s.knownHosts
+	config.SFTPAllowedHosts = hosts
+
+	s.transport, err = New()
+	require.Nil(s.T(), err)
+}
+
+func (s *SftpTestSuite) TearDownSuite() {
+	s.listener.Close()
+	os.Remove(s.knownHosts)
+}
+
+func (s *SftpTestSuite) serve(serverConfig *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+
+			for newChan := range chans {
+				channel, requests, err := newChan.Accept()
+				if err != nil {
+					return
+				}
+
+				go func() {
+					for req := range requests {
+						req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+					}
+				}()
+
+				server, err := sftp.NewServer(channel, sftp.ReadOnly())
+				if err != nil {
+					return
+				}
+				server.Serve()
+				server.Close()
+			}
+		}()
+	}
+}
+
+func (s *SftpTestSuite) url(path string) string {
+	return "sftp://" + s.listener.Addr().String() + path
+}
+
+func (s *SftpTestSuite) TestRoundTripReturns200() {
+	config.ETagEnabled = false
+
+	request, _ := http.NewRequest("GET", s.url(s.testPath), nil)
+
+	response, err := s.transport.RoundTrip(request)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 200, response.StatusCode)
+
+	defer response.Body.Close()
+
+	expected, err := ioutil.ReadFile(s.testPath)
+	require.Nil(s.T(), err)
+
+	actual, err := ioutil.ReadAll(response.Body)
+	require.Nil(s.T(), err)
+
+	require.Equal(s.T(), int64(len(expected)), response.ContentLength)
+	require.Equal(s.T(), expected, actual)
+}
+
+func (s *SftpTestSuite) TestRoundTripNotFoundReturns404() {
+	request, _ := http.NewRequest("GET", s.url(s.testPath+".missing"), nil)
+
+	response, err := s.transport.RoundTrip(request)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 404, response.StatusCode)
+}
+
+func (s *SftpTestSuite) TestRoundTripWithIfNoneMatchReturns304() {
+	config.ETagEnabled = true
+	defer func() { config.ETagEnabled = false }()
+
+	request, _ := http.NewRequest("GET", s.url(s.testPath), nil)
+
+	response, err := s.transport.RoundTrip(request)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), 200, response.StatusCode)
+	response.Body.Close()
+
+	etag := response.Header.Get("ETag")
+	require.NotEmpty(s.T(), etag)
+
+	request, _ = http.NewRequest("GET", s.url(s.testPath), nil)
+	request.Header.Set("If-None-Match", etag)
+
+	response, err = s.transport.RoundTrip(request)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), http.StatusNotModified, response.StatusCode)
+}
+
+func (s *SftpTestSuite) TestRoundTripETagDependsOnHost() {
+	config.ETagEnabled = true
+	defer func() { config.ETagEnabled = false }()
+
+	_, port, err := net.SplitHostPort(s.listener.Addr().String())
+	require.Nil(s.T(), err)
+
+	etags := make([]string, 0, 2)
+
+	for _, host := range []string{s.listener.Addr().String(), net.JoinHostPort("localhost", port)} {
+		request, _ := http.NewRequest("GET", "sftp://"+host+s.testPath, nil)
+
+		response, err := s.transport.RoundTrip(request)
+		require.Nil(s.T(), err)
+		require.Equal(s.T(), 200, response.StatusCode)
+		response.Body.Close()
+
+		etags = append(etags, response.Header.Get("ETag"))
+	}
+
+	require.NotEqual(s.T(), etags[0], etags[1])
+}
+
+func (s *SftpTestSuite) TestRoundTripHostNotAllowed() {
+	_, port, err := net.SplitHostPort(s.listener.Addr().String())
+	require.Nil(s.T(), err)
+
+	request, _ := http.NewRequest("GET", "sftp://"+net.JoinHostPort("127.0.0.2", port)+s.testPath, nil)
+
+	_, err = s.transport.RoundTrip(request)
+	require.NotNil(s.T(), err)
+	require.Contains(s.T(), err.Error(), "is not allowed")
+}
+
+func (s *SftpTestSuite) TestRoundTripConnectionError() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(s.T(), err)
+
+	addr := l.Addr().String()
+	l.Close()
+
+	config.SFTPAllowedHosts = append(config.SFTPAllowedHosts, addr)
+	defer func() { config.SFTPAllowedHosts = config.SFTPAllowedHosts[:len(config.SFTPAllowedHosts)-1] }()
+
+	tr, err := New()
+	require.Nil(s.T(), err)
+
+	request, _ := http.NewRequest("GET", "sftp://"+addr+s.testPath, nil)
+
+	_, err = tr.RoundTrip(request)
+	require.NotNil(s.T(), err)
+
+	var connErr *ConnectionError
+	require.True(s.T(), errors.As(err, &connErr))
+	require.Equal(s.T(), addr, connErr.Addr)
+}
+
+func (s *SftpTestSuite) TestNewWithoutCredentials() {
+	defer func(pass string) { config.SFTPPassword = pass }(config.SFTPPassword)
+	config.SFTPPassword = ""
+
+	_, err := New()
+	require.NotNil(s.T(), err)
+}
+
+func (s *SftpTestSuite) TestNewWithoutKnownHosts() {
+	defer func(path string) { config.SFTPKnownHosts = path }(config.SFTPKnownHosts)
+	config.SFTPKnownHosts = ""
+
+	_, err := New()
+	require.NotNil(s.T(), err)
+}
+
+func (s *SftpTestSuite) TestNewWithoutAllowedHosts() {
+	defer func(hosts []string) { config.SFTPAllowedHosts = hosts }(config.SFTPAllowedHosts)
+	config.SFTPAllowedHosts = nil
+
+	_, err := New()
+	require.NotNil(s.T(), err)
+}
+
+func (s *SftpTestSuite) TestUnknownHostKey() {
+	otherKnownHosts, err := ioutil.TempFile("", "imgproxy-known-hosts")
+	require.Nil(s.T(), err)
+	defer os.Remove(otherKnownHosts.Name())
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(s.T(), err)
+
+	otherSigner, err := ssh.NewSignerFromKey(otherKey)
+	require.Nil(s.T(), err)
+
+	_, err = otherKnownHosts.WriteString(knownhosts.Line([]string{s.listener.Addr().String()}, otherSigner.PublicKey()) + "\n")
+	require.Nil(s.T(), err)
+	otherKnownHosts.Close()
+
+	defer func(path string) { config.SFTPKnownHosts = path }(config.SFTPKnownHosts)
+	config.SFTPKnownHosts = otherKnownHosts.Name()
+
+	tr, err := New()
+	require.Nil(s.T(), err)
+
+	request, _ := http.NewRequest("GET", s.url(s.testPath), nil)
+
+	_, err = tr.RoundTrip(request)
+	require.NotNil(s.T(), err)
+
+	var connErr *ConnectionError
+	require.True(s.T(), errors.As(err, &connErr))
+}
+
+func TestSftpTransport(t *testing.T) {
+	suite.Run(t, new(SftpTestSuite))
+}
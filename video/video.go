@@ -0,0 +1,330 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/ierrors"
+	"github.com/imgproxy/imgproxy/v3/security"
+)
+
+// Major brands of the ISO base media files that contain video
+var videoBrands = []string{
+	"isom", "iso2", "iso4", "iso5", "iso6",
+	"mp41", "mp42", "avc1", "M4V ", "qt  ",
+	"3gp4", "3gp5", "3gp6", "dash",
+}
+
+// maxLogSize is the maximum size of the ffmpeg log tail we keep to build error messages
+const maxLogSize = 4096
+
+var (
+	ffmpegPath  string
+	ffprobePath string
+)
+
+// Init checks if ffmpeg and ffprobe are available when video thumbnails are enabled.
+// If they're not, video thumbnails are disabled
+func Init() {
+	ffmpegPath = ""
+	ffprobePath = ""
+
+	if !config.EnableVideoThumbnails {
+		return
+	}
+
+	mpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		log.Warning("Video thumbnails are disabled since ffmpeg is not found")
+		return
+	}
+
+	probePath, err := exec.LookPath("ffprobe")
+	if err != nil {
+		log.Warning("Video thumbnails are disabled since ffprobe is not found")
+		return
+	}
+
+	ffmpegPath = mpegPath
+	ffprobePath = probePath
+}
+
+// Enabled checks if video sources can be converted to animations
+func Enabled() bool {
+	return len(ffmpegPath) > 0
+}
+
+// IsVideo checks if the file header belongs to a supported video container.
+// header should contain at least 12 first bytes of the file
+func IsVideo(header []byte) bool {
+	if len(header) < 12 || string(header[4:8]) != "ftyp" {
+		return false
+	}
+
+	brand := string(header[8:12])
+	for _, b := range videoBrands {
+		if b == brand {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ToAnimation converts the video to a lossless animated WebP. Frames are sampled
+// with IMGPROXY_VIDEO_THUMBNAIL_FPS rate, and only the first maxFrames frames are kept.
+// The summary resolution of the frames is checked against maxSrcResolution before decoding
+func ToAnimation(ctx context.Context, data []byte, maxFrames, maxSrcResolution int) ([]byte, error) {
+	var out []byte
+
+	err := withSource(ctx, data, func(ctx context.Context, dir, path string) error {
+		m, err := probe(ctx, path)
+		if err != nil {
+			return err
+		}
+
+		framesCount := maxFrames
+		if m.duration > 0 {
+			if n := int(math.Ceil(m.duration * float64(config.VideoThumbnailFPS))); n < framesCount {
+				framesCount = n
+			}
+		}
+
+		if err = security.CheckDimensionsLimit(m.width, m.height*framesCount, maxSrcResolution); err != nil {
+			return err
+		}
+
+		outPath := filepath.Join(dir, "animation.webp")
+
+		// The WebP muxer needs a seekable output to write an animation,
+		// so we can't use a pipe here
+		args := append(inputArgs(path),
+			"-an",
+			"-vf", fmt.Sprintf("fps=%d", config.VideoThumbnailFPS),
+			"-frames:v", strconv.Itoa(maxFrames),
+			"-c:v", "libwebp",
+			"-lossless", "1",
+			"-loop", "0",
+			"-f", "webp",
+			outPath,
+		)
+
+		if _, err = run(ctx, args); err != nil {
+			return err
+		}
+
+		out, err = ioutil.ReadFile(outPath)
+		return err
+	})
+
+	return out, err
+}
+
+// ExtractFrame extracts the frame at the time (in seconds) from the video as a PNG image.
+// The time should be less than the video duration.
+// The video resolution and duration are checked before decoding
+func ExtractFrame(ctx context.Context, data []byte, t float64, maxSrcResolution int) ([]byte, error) {
+	var out []byte
+
+	err := withSource(ctx, data, func(ctx context.Context, dir, path string) error {
+		m, err := probe(ctx, path)
+		if err != nil {
+			return err
+		}
+
+		if err = security.CheckDimensionsLimit(m.width, m.height, maxSrcResolution); err != nil {
+			return err
+		}
+
+		if m.duration > 0 && t >= m.duration {
+			return newTimeOutOfRangeError(t, m.duration)
+		}
+
+		args := append([]string{"-ss", strconv.FormatFloat(t, 'f', -1, 64)}, inputArgs(path)...)
+		args = append(args,
+			"-an",
+			"-frames:v", "1",
+			"-c:v", "png",
+			"-f", "image2pipe",
+			"pipe:1",
+		)
+
+		if out, err = run(ctx, args); err != nil {
+			return err
+		}
+
+		// ffmpeg produces nothing when the time is out of the video
+		// and the duration is unknown
+		if len(out) == 0 {
+			return newTimeOutOfRangeError(t, -1)
+		}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func newTimeOutOfRangeError(t, duration float64) error {
+	msg := fmt.Sprintf("Video time %g is out of the video duration", t)
+	if duration >= 0 {
+		msg = fmt.Sprintf("Video time %g is out of the video duration %g", t, duration)
+	}
+
+	return ierrors.New(422, msg, "Invalid source image")
+}
+
+// inputArgs returns ffmpeg/ffprobe arguments to read the video from the path.
+// We force the demuxer and allow reading local files only, so ffmpeg doesn't
+// guess the format of the source and doesn't follow any references in it
+func inputArgs(path string) []string {
+	return []string{"-f", "mov", "-protocol_whitelist", "file", "-i", path}
+}
+
+type videoMeta struct {
+	width, height int
+	duration      float64
+}
+
+type probeResult struct {
+	Streams []struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// probe gets the video dimensions and duration without decoding it
+func probe(ctx context.Context, path string) (videoMeta, error) {
+	var m videoMeta
+
+	cmd := exec.CommandContext(
+		ctx, ffprobePath,
+		"-v", "error",
+		"-f", "mov",
+		"-protocol_whitelist", "file",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		path,
+	)
+
+	out, err := execute(ctx, cmd, "Can't probe video")
+	if err != nil {
+		return m, err
+	}
+
+	var res probeResult
+	if err = json.Unmarshal(out, &res); err != nil {
+		return m, ierrors.New(422, fmt.Sprintf("Can't probe video: %s", err), "Invalid source image")
+	}
+
+	if len(res.Streams) == 0 || res.Streams[0].Width <= 0 || res.Streams[0].Height <= 0 {
+		return m, ierrors.New(422, "Can't probe video: no video stream found", "Invalid source image")
+	}
+
+	m.width = res.Streams[0].Width
+	m.height = res.Streams[0].Height
+	// Duration may be unknown, so we ignore the parsing error
+	m.duration, _ = strconv.ParseFloat(res.Format.Duration, 64)
+
+	return m, nil
+}
+
+// withSource writes the video to a temporary directory and calls fn with the file path.
+// Some containers have their index at the end, so ffmpeg needs a seekable input.
+// The context passed to fn is limited with IMGPROXY_DOWNLOAD_TIMEOUT.
+// When the limit is exceeded, context.DeadlineExceeded is returned
+func withSource(ctx context.Context, data []byte, fn func(ctx context.Context, dir, path string) error) error {
+	dir, err := ioutil.TempDir("", "imgproxy-video-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "source")
+
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.DownloadTimeout)*time.Second)
+	defer cancel()
+
+	return fn(ctx, dir, path)
+}
+
+// run runs ffmpeg with the arguments and returns its output
+func run(ctx context.Context, args []string) ([]byte, error) {
+	cmd := exec.CommandContext(
+		ctx, ffmpegPath,
+		append([]string{"-hide_banner", "-loglevel", "error", "-nostats", "-y"}, args...)...,
+	)
+
+	return execute(ctx, cmd, "Can't convert video")
+}
+
+// execute runs the command and returns its output. If the command fails,
+// the last line of its stderr is used as the error message.
+// If the command is killed because the context deadline is exceeded,
+// context.DeadlineExceeded is returned
+func execute(ctx context.Context, cmd *exec.Cmd, errPrefix string) ([]byte, error) {
+	var stdout bytes.Buffer
+
+	stderr := tailWriter{limit: maxLogSize}
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// The output of the killed command doesn't describe the actual error
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ctx.Err()
+		}
+
+		msg := err.Error()
+		if lines := strings.Split(strings.TrimSpace(string(stderr.buf)), "\n"); len(lines[len(lines)-1]) > 0 {
+			msg = lines[len(lines)-1]
+		}
+
+		return nil, ierrors.New(
+			422,
+			fmt.Sprintf("%s: %s", errPrefix, msg),
+			"Invalid source image",
+		)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// tailWriter keeps only the last limit bytes written to it
+type tailWriter struct {
+	buf   []byte
+	limit int
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	if len(w.buf) > w.limit {
+		w.buf = append(w.buf[:0:0], w.buf[len(w.buf)-w.limit:]...)
+	}
+
+	return len(p), nil
+}
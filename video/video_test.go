@@ -0,0 +1,222 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/imagemeta"
+	"github.com/imgproxy/imgproxy/v3/imagetype"
+	"github.com/imgproxy/imgproxy/v3/security"
+)
+
+type VideoTestSuite struct {
+	suite.Suite
+}
+
+func (s *VideoTestSuite) SetupTest() {
+	config.Reset()
+}
+
+// sampleVideo generates a 1 second MP4 video of 64x48 size with 25 fps
+func (s *VideoTestSuite) sampleVideo() []byte {
+	return s.generateVideo("-f", "lavfi", "-i", "testsrc=duration=1:size=64x48:rate=25")
+}
+
+// twoColorVideo generates a 4 seconds MP4 video that is red
+// for the first 2 seconds and blue for the rest
+func (s *VideoTestSuite) twoColorVideo() []byte {
+	return s.generateVideo(
+		"-filter_complex",
+		"color=c=red:s=32x32:r=10:d=2[a];color=c=blue:s=32x32:r=10:d=2[b];[a][b]concat=n=2:v=1:a=0",
+	)
+}
+
+func (s *VideoTestSuite) generateVideo(args ...string) []byte {
+	dir, err := ioutil.TempDir("", "imgproxy-video-test")
+	require.Nil(s.T(), err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sample.mp4")
+
+	args = append([]string{"-hide_banner", "-loglevel", "error"}, args...)
+	args = append(args, "-pix_fmt", "yuv420p", path)
+
+	out, err := exec.Command("ffmpeg", args...).CombinedOutput()
+	require.Nil(s.T(), err, string(out))
+
+	data, err := ioutil.ReadFile(path)
+	require.Nil(s.T(), err)
+
+	return data
+}
+
+func (s *VideoTestSuite) requireFFmpeg() {
+	config.EnableVideoThumbnails = true
+	Init()
+
+	if !Enabled() {
+		s.T().Skip("ffmpeg is not available")
+	}
+}
+
+func (s *VideoTestSuite) TestDisabled() {
+	config.EnableVideoThumbnails = false
+	Init()
+
+	require.False(s.T(), Enabled())
+}
+
+func (s *VideoTestSuite) TestIsVideo() {
+	require.True(s.T(), IsVideo([]byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00")))
+	require.True(s.T(), IsVideo([]byte("\x00\x00\x00\x14ftypqt  ")))
+
+	// HEIF and AVIF use the same container but they are images
+	require.False(s.T(), IsVideo([]byte("\x00\x00\x00\x1cftypavif")))
+	require.False(s.T(), IsVideo([]byte("\x00\x00\x00\x18ftypheic")))
+
+	require.False(s.T(), IsVideo([]byte("GIF89a")))
+	require.False(s.T(), IsVideo([]byte("\x00\x00\x00\x20ftyp")))
+}
+
+// requireAnimation checks that data is an animated WebP of the size and frames count
+func (s *VideoTestSuite) requireAnimation(data []byte, width, height, framesCount int) {
+	meta, err := imagemeta.DecodeMeta(bytes.NewReader(data))
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), imagetype.WEBP, meta.Format())
+	require.Equal(s.T(), width, meta.Width())
+	require.Equal(s.T(), height, meta.Height())
+
+	frames, err := imagemeta.CountFrames(bytes.NewReader(data), imagetype.WEBP, 0)
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), framesCount, frames)
+}
+
+func (s *VideoTestSuite) TestToAnimation() {
+	s.requireFFmpeg()
+
+	config.VideoThumbnailFPS = 5
+
+	data, err := ToAnimation(context.Background(), s.sampleVideo(), 100, config.MaxSrcResolution)
+	require.Nil(s.T(), err)
+
+	s.requireAnimation(data, 64, 48, 5)
+}
+
+func (s *VideoTestSuite) TestToAnimationFramesLimit() {
+	s.requireFFmpeg()
+
+	data, err := ToAnimation(context.Background(), s.sampleVideo(), 3, config.MaxSrcResolution)
+	require.Nil(s.T(), err)
+
+	s.requireAnimation(data, 64, 48, 3)
+}
+
+func (s *VideoTestSuite) TestToAnimationResolutionLimit() {
+	s.requireFFmpeg()
+
+	config.VideoThumbnailFPS = 5
+
+	// A single frame fits the limit but 5 frames don't
+	_, err := ToAnimation(context.Background(), s.sampleVideo(), 100, 64*48*2)
+	require.Equal(s.T(), security.ErrSourceResolutionTooBig, err)
+
+	// Only 2 frames are used, so they fit the limit
+	data, err := ToAnimation(context.Background(), s.sampleVideo(), 2, 64*48*2)
+	require.Nil(s.T(), err)
+
+	s.requireAnimation(data, 64, 48, 2)
+}
+
+func (s *VideoTestSuite) TestToAnimationInvalid() {
+	s.requireFFmpeg()
+
+	_, err := ToAnimation(context.Background(), []byte("\x00\x00\x00\x20ftypisom this is not a video"), 1, config.MaxSrcResolution)
+	require.Error(s.T(), err)
+}
+
+func (s *VideoTestSuite) TestToAnimationCanceled() {
+	s.requireFFmpeg()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ToAnimation(ctx, s.sampleVideo(), 1, config.MaxSrcResolution)
+	require.Error(s.T(), err)
+}
+
+func (s *VideoTestSuite) TestToAnimationTimeout() {
+	s.requireFFmpeg()
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	_, err := ToAnimation(ctx, s.sampleVideo(), 1, config.MaxSrcResolution)
+	require.True(s.T(), errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func (s *VideoTestSuite) TestExtractFrame() {
+	s.requireFFmpeg()
+
+	data := s.twoColorVideo()
+
+	for _, tc := range []struct {
+		time  float64
+		red   bool
+		color string
+	}{
+		{0.5, true, "red"},
+		{3, false, "blue"},
+	} {
+		frame, err := ExtractFrame(context.Background(), data, tc.time, config.MaxSrcResolution)
+		require.Nil(s.T(), err)
+
+		img, err := png.Decode(bytes.NewReader(frame))
+		require.Nil(s.T(), err)
+		require.Equal(s.T(), image.Rect(0, 0, 32, 32), img.Bounds())
+
+		r, _, b, _ := img.At(16, 16).RGBA()
+		require.Equal(s.T(), tc.red, r > b, "Frame at %g should be %s", tc.time, tc.color)
+	}
+}
+
+func (s *VideoTestSuite) TestExtractFrameOutOfDuration() {
+	s.requireFFmpeg()
+
+	_, err := ExtractFrame(context.Background(), s.twoColorVideo(), 10, config.MaxSrcResolution)
+	require.Error(s.T(), err)
+	require.Contains(s.T(), err.Error(), "out of the video duration 4")
+}
+
+func (s *VideoTestSuite) TestExtractFrameResolutionLimit() {
+	s.requireFFmpeg()
+
+	_, err := ExtractFrame(context.Background(), s.twoColorVideo(), 1, 32*32-1)
+	require.Equal(s.T(), security.ErrSourceResolutionTooBig, err)
+}
+
+func (s *VideoTestSuite) TestTailWriter() {
+	w := tailWriter{limit: 8}
+
+	w.Write([]byte("abc"))
+	require.Equal(s.T(), "abc", string(w.buf))
+
+	w.Write([]byte("defghijkl"))
+	require.Equal(s.T(), "efghijkl", string(w.buf))
+}
+
+func TestVideo(t *testing.T) {
+	suite.Run(t, new(VideoTestSuite))
+}
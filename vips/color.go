@@ -3,6 +3,7 @@ package vips
 import (
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 var hexColorRegex = regexp.MustCompile("^([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$")
@@ -14,6 +15,29 @@ const (
 
 type Color struct{ R, G, B uint8 }
 
+var namedColors = map[string]Color{
+	"black":   {0, 0, 0},
+	"white":   {255, 255, 255},
+	"gray":    {128, 128, 128},
+	"grey":    {128, 128, 128},
+	"silver":  {192, 192, 192},
+	"red":     {255, 0, 0},
+	"maroon":  {128, 0, 0},
+	"orange":  {255, 165, 0},
+	"yellow":  {255, 255, 0},
+	"olive":   {128, 128, 0},
+	"lime":    {0, 255, 0},
+	"green":   {0, 128, 0},
+	"aqua":    {0, 255, 255},
+	"cyan":    {0, 255, 255},
+	"teal":    {0, 128, 128},
+	"blue":    {0, 0, 255},
+	"navy":    {0, 0, 128},
+	"fuchsia": {255, 0, 255},
+	"magenta": {255, 0, 255},
+	"purple":  {128, 0, 128},
+}
+
 func ColorFromHex(hexcolor string) (Color, error) {
 	c := Color{}
 
@@ -32,3 +56,16 @@ func ColorFromHex(hexcolor string) (Color, error) {
 
 	return c, nil
 }
+
+// ColorFromString parses a hex color or a basic CSS color name
+func ColorFromString(color string) (Color, error) {
+	if c, ok := namedColors[strings.ToLower(color)]; ok {
+		return c, nil
+	}
+
+	if c, err := ColorFromHex(color); err == nil {
+		return c, nil
+	}
+
+	return Color{}, fmt.Errorf("Invalid color: %s", color)
+}
@@ -66,7 +66,7 @@ func (img *Image) saveAsIco() (*imagedata.ImageData, error) {
 		C.g_free_go(&ptr)
 	}()
 
-	if C.vips_pngsave_go(img.VipsImage, &ptr, &imgsize, 0, 0, 256) != 0 {
+	if C.vips_pngsave_go(img.VipsImage, &ptr, &imgsize, 0, 0, 256, C.DITHER_DEFAULT) != 0 {
 		return nil, Error()
 	}
 
@@ -0,0 +1,54 @@
+package vips
+
+/*
+#include "vips.h"
+*/
+import "C"
+import (
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// Writers can't be passed to C directly, so we register them in the map
+// and pass the handles instead
+var (
+	targetWriters      = make(map[C.uintptr_t]io.Writer)
+	targetWritersMu    sync.Mutex
+	targetWritersIndex C.uintptr_t
+)
+
+func registerTargetWriter(w io.Writer) C.uintptr_t {
+	targetWritersMu.Lock()
+	defer targetWritersMu.Unlock()
+
+	targetWritersIndex++
+	targetWriters[targetWritersIndex] = w
+
+	return targetWritersIndex
+}
+
+func unregisterTargetWriter(handle C.uintptr_t) {
+	targetWritersMu.Lock()
+	defer targetWritersMu.Unlock()
+
+	delete(targetWriters, handle)
+}
+
+//export vipsTargetWrite
+func vipsTargetWrite(handle C.uintptr_t, data unsafe.Pointer, length C.gint64) C.gint64 {
+	targetWritersMu.Lock()
+	w, ok := targetWriters[handle]
+	targetWritersMu.Unlock()
+
+	if !ok {
+		return -1
+	}
+
+	n, err := w.Write(ptrToBytes(data, int(length)))
+	if err != nil {
+		return -1
+	}
+
+	return C.gint64(n)
+}
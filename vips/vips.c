@@ -193,9 +193,9 @@ vips_rad2float_go(VipsImage *in, VipsImage **out) {
 }
 
 int
-vips_resize_go(VipsImage *in, VipsImage **out, double wscale, double hscale) {
+vips_resize_go(VipsImage *in, VipsImage **out, double wscale, double hscale, VipsKernel kernel) {
   if (!vips_image_hasalpha(in))
-    return vips_resize(in, out, wscale, "vscale", hscale, "kernel", VIPS_KERNEL_CUBIC, NULL);
+    return vips_resize(in, out, wscale, "vscale", hscale, "kernel", kernel, NULL);
 
   VipsBandFormat format = vips_band_format(in);
 
@@ -204,7 +204,7 @@ vips_resize_go(VipsImage *in, VipsImage **out, double wscale, double hscale) {
 
   int res =
     vips_premultiply(in, &t[0], NULL) ||
-    vips_resize(t[0], &t[1], wscale, "vscale", hscale, "kernel", VIPS_KERNEL_CUBIC, NULL) ||
+    vips_resize(t[0], &t[1], wscale, "vscale", hscale, "kernel", kernel, NULL) ||
     vips_unpremultiply(t[1], &t[2], NULL) ||
     vips_cast(t[2], out, format, NULL);
 
@@ -294,13 +294,102 @@ vips_flip_horizontal_go(VipsImage *in, VipsImage **out) {
 }
 
 int
-vips_smartcrop_go(VipsImage *in, VipsImage **out, int width, int height) {
-  return vips_smartcrop(in, out, width, height, NULL);
+vips_flip_vertical_go(VipsImage *in, VipsImage **out) {
+  return vips_flip(in, out, VIPS_DIRECTION_VERTICAL, NULL);
 }
 
 int
-vips_apply_filters(VipsImage *in, VipsImage **out, double blur_sigma,
-  double sharp_sigma, int pixelate_pixels) {
+vips_smartcrop_go(VipsImage *in, int width, int height, VipsInteresting interesting,
+  int *left, int *top) {
+
+  VipsImage *tmp;
+
+  if (vips_smartcrop(in, &tmp, width, height, "interesting", interesting, NULL))
+    return 1;
+
+  // vips_smartcrop extracts the selected area, and vips_extract_area stores
+  // the negated area position to the image offsets
+  *left = -tmp->Xoffset;
+  *top = -tmp->Yoffset;
+
+  clear_image(&tmp);
+
+  return 0;
+}
+
+/* Unsharp mask in linear light. vips_sharpen works on the LabS L channel,
+ * so we mimic it on the Y channel of XYZ, which is linear.
+ * Like in vips_sharpen, the difference is amplified with `flat` below 2
+ * and with `jagged` above it, and then it's clamped to [-20, 10]
+ */
+static int
+vips_sharpen_linear(VipsImage *in, VipsImage **out, double sigma, double flat, double jagged) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 21);
+
+  const double x1 = 2.0, y2 = 10.0, y3 = 20.0;
+
+  if (
+    vips_colourspace(in, &t[0], VIPS_INTERPRETATION_XYZ, NULL) ||
+    vips_extract_band(t[0], &t[1], 1, "n", 1, NULL) ||
+    vips_gaussblur(t[1], &t[2], sigma, NULL) ||
+    vips_subtract(t[1], t[2], &t[3], NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  /* clamp(d, -x1, x1) * 2 = |d + x1| - |d - x1|
+   * gain(d) = jagged * d + (flat - jagged) * clamp(d, -x1, x1)
+   */
+  if (
+    vips_linear1(t[3], &t[4], 1.0, x1, NULL) ||
+    vips_linear1(t[3], &t[5], 1.0, -x1, NULL) ||
+    vips_abs(t[4], &t[6], NULL) ||
+    vips_abs(t[5], &t[7], NULL) ||
+    vips_subtract(t[6], t[7], &t[8], NULL) ||
+    vips_linear1(t[8], &t[9], (flat - jagged) / 2.0, 0, NULL) ||
+    vips_linear1(t[3], &t[10], jagged, 0, NULL) ||
+    vips_add(t[9], t[10], &t[11], NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  /* clamp(g, -y3, y2) = (|g + y3| - |g - y2| + y2 - y3) / 2 */
+  if (
+    vips_linear1(t[11], &t[12], 1.0, y3, NULL) ||
+    vips_linear1(t[11], &t[13], 1.0, -y2, NULL) ||
+    vips_abs(t[12], &t[14], NULL) ||
+    vips_abs(t[13], &t[15], NULL) ||
+    vips_subtract(t[14], t[15], &t[16], NULL) ||
+    vips_linear1(t[16], &t[17], 0.5, (y2 - y3) / 2.0, NULL) ||
+    vips_add(t[1], t[17], &t[18], NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  if (
+    vips_extract_band(t[0], &t[19], 0, "n", 1, NULL) ||
+    vips_extract_band(t[0], &t[20], 2, "n", t[0]->Bands - 2, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  VipsImage *bands[3] = {t[19], t[18], t[20]};
+
+  int res = vips_bandjoin(bands, out, 3, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_apply_filters(VipsImage *in, VipsImage **out, double blur_sigma, double blur_min_ampl,
+  double sharp_sigma, double sharp_flat, double sharp_jagged, gboolean sharp_linear, int pixelate_pixels) {
 
   VipsImage *base = vips_image_new();
   VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 9);
@@ -308,9 +397,10 @@ vips_apply_filters(VipsImage *in, VipsImage **out, double blur_sigma,
   VipsInterpretation interpretation = in->Type;
   VipsBandFormat format = in->BandFmt;
   gboolean premultiplied = FALSE;
+  double max_alpha = vips_interpretation_max_alpha(interpretation);
 
   if ((blur_sigma > 0 || sharp_sigma > 0) && vips_image_hasalpha(in)) {
-    if (vips_premultiply(in, &t[0], NULL)) {
+    if (vips_premultiply(in, &t[0], "max_alpha", max_alpha, NULL)) {
       clear_image(&base);
       return 1;
     }
@@ -320,7 +410,7 @@ vips_apply_filters(VipsImage *in, VipsImage **out, double blur_sigma,
   }
 
   if (blur_sigma > 0.0) {
-    if (vips_gaussblur(in, &t[1], blur_sigma, NULL)) {
+    if (vips_gaussblur(in, &t[1], blur_sigma, "min_ampl", blur_min_ampl, NULL)) {
       clear_image(&base);
       return 1;
     }
@@ -329,7 +419,11 @@ vips_apply_filters(VipsImage *in, VipsImage **out, double blur_sigma,
   }
 
   if (sharp_sigma > 0.0) {
-    if (vips_sharpen(in, &t[2], "sigma", sharp_sigma, NULL)) {
+    int res = sharp_linear ?
+      vips_sharpen_linear(in, &t[2], sharp_sigma, sharp_flat, sharp_jagged) :
+      vips_sharpen(in, &t[2], "sigma", sharp_sigma, "m1", sharp_flat, "m2", sharp_jagged, NULL);
+
+    if (res) {
       clear_image(&base);
       return 1;
     }
@@ -373,41 +467,656 @@ vips_apply_filters(VipsImage *in, VipsImage **out, double blur_sigma,
           return 1;
       }
 
-      in = t[6];
+      in = t[6];
+    }
+  }
+
+  if (premultiplied) {
+    if (vips_unpremultiply(in, &t[7], "max_alpha", max_alpha, NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[7];
+  }
+
+  int res =
+    vips_colourspace(in, &t[8], interpretation, NULL) ||
+    vips_cast(t[8], out, format, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_edges(VipsImage *in, VipsImage **out, double strength, gboolean grayscale) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 14);
+
+  VipsInterpretation interpretation = in->Type;
+  VipsBandFormat format = in->BandFmt;
+
+  VipsImage *alpha = NULL;
+
+  if (vips_image_hasalpha(in)) {
+    if (
+      vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, "n", 1, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[0];
+    alpha = t[1];
+  }
+
+  if (grayscale) {
+    if (vips_colourspace(in, &t[2], VIPS_INTERPRETATION_B_W, NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[2];
+  }
+
+  // Sobel operator
+  t[3] = vips_image_new_matrixv(3, 3,
+    -1.0, 0.0, 1.0,
+    -2.0, 0.0, 2.0,
+    -1.0, 0.0, 1.0);
+  t[4] = vips_image_new_matrixv(3, 3,
+    -1.0, -2.0, -1.0,
+    0.0, 0.0, 0.0,
+    1.0, 2.0, 1.0);
+
+  if (
+    vips_conv(in, &t[5], t[3], "precision", VIPS_PRECISION_FLOAT, NULL) ||
+    vips_conv(in, &t[6], t[4], "precision", VIPS_PRECISION_FLOAT, NULL) ||
+    vips_abs(t[5], &t[7], NULL) ||
+    vips_abs(t[6], &t[8], NULL) ||
+    vips_add(t[7], t[8], &t[9], NULL) ||
+    vips_linear1(t[9], &t[10], strength, 0, NULL) ||
+    vips_cast(t[10], &t[11], format, NULL) ||
+    vips_colourspace(t[11], &t[12], interpretation, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  in = t[12];
+
+  if (alpha != NULL) {
+    if (vips_bandjoin2(in, alpha, &t[13], NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[13];
+  }
+
+  int res = vips_copy(in, out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_conv_go(VipsImage *in, VipsImage **out, double *matrix, int size, double scale, double offset) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 6);
+
+  VipsBandFormat format = in->BandFmt;
+
+  VipsImage *alpha = NULL;
+
+  if (vips_image_hasalpha(in)) {
+    if (
+      vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, "n", 1, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[0];
+    alpha = t[1];
+  }
+
+  t[2] = vips_image_new_matrix_from_array(size, size, matrix, size * size);
+  if (t[2] == NULL) {
+    clear_image(&base);
+    return 1;
+  }
+
+  vips_image_set_double(t[2], "scale", scale);
+  vips_image_set_double(t[2], "offset", offset);
+
+  if (
+    vips_conv(in, &t[3], t[2], "precision", VIPS_PRECISION_FLOAT, NULL) ||
+    vips_cast(t[3], &t[4], format, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  in = t[4];
+
+  if (alpha != NULL) {
+    if (vips_bandjoin2(in, alpha, &t[5], NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[5];
+  }
+
+  int res = vips_copy(in, out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_vignette_go(VipsImage *in, VipsImage **out, double strength, double r, double g, double b) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 13);
+
+  VipsBandFormat format = in->BandFmt;
+
+  VipsImage *alpha = NULL;
+
+  if (vips_image_hasalpha(in)) {
+    if (
+      vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, "n", 1, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[0];
+    alpha = t[1];
+  }
+
+  /* Coordinates of the pixel centers normalized to [-1, 1]
+   */
+  double cx = in->Xsize / 2.0;
+  double cy = in->Ysize / 2.0;
+  double norm_mul[2] = {1.0 / cx, 1.0 / cy};
+  double norm_add[2] = {(0.5 - cx) / cx, (0.5 - cy) / cy};
+
+  double color[3] = {r, g, b};
+  double zero[3] = {0, 0, 0};
+
+  /* The mask is the squared distance from the center normalized so it is 1
+   * at the corners and multiplied by the strength
+   */
+  if (
+    vips_xyz(&t[2], in->Xsize, in->Ysize, NULL) ||
+    vips_linear(t[2], &t[3], norm_mul, norm_add, 2, NULL) ||
+    vips_multiply(t[3], t[3], &t[4], NULL) ||
+    vips_bandmean(t[4], &t[5], NULL) ||
+    vips_linear1(t[5], &t[6], strength, 0, NULL) ||
+    vips_linear1(t[6], &t[7], -1, 1, NULL) ||
+    vips_multiply(in, t[7], &t[8], NULL) ||
+    vips_linear(t[6], &t[9], color, zero, 3, NULL) ||
+    vips_add(t[8], t[9], &t[10], NULL) ||
+    vips_cast(t[10], &t[11], format, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  in = t[11];
+
+  if (alpha != NULL) {
+    if (vips_bandjoin2(in, alpha, &t[12], NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[12];
+  }
+
+  int res = vips_copy(in, out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_grain_go(VipsImage *in, VipsImage **out, double sigma, gboolean seeded, int seed) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 6);
+
+  VipsBandFormat format = in->BandFmt;
+
+  VipsImage *alpha = NULL;
+
+  if (vips_image_hasalpha(in)) {
+    if (
+      vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, "n", 1, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[0];
+    alpha = t[1];
+  }
+
+  /* Single-band noise makes monochrome grain
+   */
+  int res = seeded ?
+    vips_gaussnoise(&t[2], in->Xsize, in->Ysize, "sigma", sigma, "mean", 0.0, "seed", seed, NULL) :
+    vips_gaussnoise(&t[2], in->Xsize, in->Ysize, "sigma", sigma, "mean", 0.0, NULL);
+
+  if (
+    res ||
+    vips_add(in, t[2], &t[3], NULL) ||
+    vips_cast(t[3], &t[4], format, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  in = t[4];
+
+  if (alpha != NULL) {
+    if (vips_bandjoin2(in, alpha, &t[5], NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[5];
+  }
+
+  res = vips_copy(in, out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_normalize_go(VipsImage *in, VipsImage **out, double clip) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 5);
+
+  VipsBandFormat format = in->BandFmt;
+
+  VipsImage *alpha = NULL;
+
+  if (vips_image_hasalpha(in)) {
+    if (
+      vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, "n", 1, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[0];
+    alpha = t[1];
+  }
+
+  /* The histogram of all the bands is used, so the colors are not shifted
+   */
+  int low, high;
+
+  if (
+    vips_percent(in, clip, &low, NULL) ||
+    vips_percent(in, 100.0 - clip, &high, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  if (high > low) {
+    double max = vips_interpretation_max_alpha(in->Type);
+    double a = max / (high - low);
+
+    if (
+      vips_linear1(in, &t[2], a, -low * a, NULL) ||
+      vips_cast(t[2], &t[3], format, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[3];
+  }
+
+  if (alpha != NULL) {
+    if (vips_bandjoin2(in, alpha, &t[4], NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[4];
+  }
+
+  int res = vips_copy(in, out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_auto_white_balance_go(VipsImage *in, VipsImage **out) {
+  int color_bands = vips_image_hasalpha(in) ? in->Bands - 1 : in->Bands;
+
+  /* Only RGB images can have a color cast
+   */
+  if (color_bands != 3)
+    return vips_copy(in, out, NULL);
+
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 8);
+
+  VipsBandFormat format = in->BandFmt;
+
+  VipsImage *alpha = NULL;
+
+  if (vips_image_hasalpha(in)) {
+    if (
+      vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, "n", 1, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[0];
+    alpha = t[1];
+  }
+
+  /* Gray world: the average color of the image is assumed to be gray,
+   * so each channel is scaled to make its average match the overall average
+   */
+  double avg[3];
+
+  for (int i = 0; i < 3; i++) {
+    if (
+      vips_extract_band(in, &t[2 + i], i, NULL) ||
+      vips_avg(t[2 + i], &avg[i], NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+  }
+
+  double gray = (avg[0] + avg[1] + avg[2]) / 3.0;
+
+  double mul[3];
+  double add[3] = {0, 0, 0};
+
+  for (int i = 0; i < 3; i++)
+    mul[i] = avg[i] > 0 ? gray / avg[i] : 1.0;
+
+  if (
+    vips_linear(in, &t[5], mul, add, 3, NULL) ||
+    vips_cast(t[5], &t[6], format, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  in = t[6];
+
+  if (alpha != NULL) {
+    if (vips_bandjoin2(in, alpha, &t[7], NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[7];
+  }
+
+  int res = vips_copy(in, out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+/* Luminance of the image without its alpha channel
+ */
+static int
+vips_luminance_go(VipsImage *in, VipsImage **out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+  if (vips_image_hasalpha(in)) {
+    if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    in = t[0];
+  }
+
+  int res = vips_bandmean(in, out, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+/* Laplacian of the image luminance
+ */
+static int
+vips_luminance_laplacian_go(VipsImage *in, VipsImage **out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2);
+
+  t[0] = vips_image_new_matrixv(3, 3,
+    0.0, 1.0, 0.0,
+    1.0, -4.0, 1.0,
+    0.0, 1.0, 0.0);
+
+  if (
+    vips_luminance_go(in, &t[1]) ||
+    vips_conv(t[1], out, t[0], "precision", VIPS_PRECISION_FLOAT, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  clear_image(&base);
+
+  return 0;
+}
+
+int
+vips_sharpness_go(VipsImage *in, double *out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2);
+
+  /* Mean absolute Laplacian of the luminance: the more fine details the image
+   * has, the greater the value is
+   */
+  if (
+    vips_luminance_laplacian_go(in, &t[0]) ||
+    vips_abs(t[0], &t[1], NULL) ||
+    vips_avg(t[1], out, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  clear_image(&base);
+
+  return 0;
+}
+
+int
+vips_laplacian_variance_go(VipsImage *in, double *out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+  double deviation;
+
+  /* Variance of the Laplacian of the luminance: blurry images have few edges,
+   * so their Laplacian is close to constant
+   */
+  if (
+    vips_luminance_laplacian_go(in, &t[0]) ||
+    vips_deviate(t[0], &deviation, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  *out = deviation * deviation;
+
+  clear_image(&base);
+
+  return 0;
+}
+
+int
+vips_luminance_deviation_go(VipsImage *in, double *out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+  if (
+    vips_luminance_go(in, &t[0]) ||
+    vips_deviate(t[0], out, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  clear_image(&base);
+
+  return 0;
+}
+
+int
+vips_flatten_go(VipsImage *in, VipsImage **out, double r, double g, double b) {
+  if (!vips_image_hasalpha(in))
+    return vips_copy(in, out, NULL);
+
+  VipsArrayDouble *bg = vips_array_double_newv(3, r, g, b);
+  int res = vips_flatten(in, out, "background", bg, NULL);
+  vips_area_unref((VipsArea *)bg);
+  return res;
+}
+
+int
+vips_extract_area_go(VipsImage *in, VipsImage **out, int left, int top, int width, int height) {
+  return vips_extract_area(in, out, left, top, width, height, NULL);
+}
+
+int
+vips_alpha_centroid(VipsImage *in, double *x, double *y) {
+  *x = -1.0;
+  *y = -1.0;
+
+  if (!vips_image_hasalpha(in))
+    return 0;
+
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+
+  double amin, amax;
+
+  if (
+    vips_extract_band(in, &t[0], in->Bands - 1, "n", 1, NULL) ||
+    vips_min(t[0], &amin, NULL) ||
+    vips_max(t[0], &amax, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  // Uniform alpha, the centroid is the geometric center
+  if (amin == amax) {
+    clear_image(&base);
+    return 0;
+  }
+
+  if (
+    vips_cast(t[0], &t[1], VIPS_FORMAT_DOUBLE, NULL) ||
+    vips_project(t[1], &t[2], &t[3], NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  size_t cols_size, rows_size;
+  double *cols = (double *) vips_image_write_to_memory(t[2], &cols_size);
+  double *rows = (double *) vips_image_write_to_memory(t[3], &rows_size);
+
+  clear_image(&base);
+
+  if (cols == NULL || rows == NULL) {
+    g_free(cols);
+    g_free(rows);
+    return 1;
+  }
+
+  double sum = 0, sum_x = 0, sum_y = 0;
+
+  for (int i = 0; i < in->Xsize; i++) {
+    sum += cols[i];
+    sum_x += cols[i] * (i + 0.5);
+  }
+
+  for (int i = 0; i < in->Ysize; i++)
+    sum_y += rows[i] * (i + 0.5);
+
+  g_free(cols);
+  g_free(rows);
+
+  if (sum > 0) {
+    *x = sum_x / sum / in->Xsize;
+    *y = sum_y / sum / in->Ysize;
+  }
+
+  return 0;
+}
+
+static int
+vips_trim_extract(VipsImage *in, VipsImage **out, int left, int top, int width, int height,
+                  gboolean equal_hor, gboolean equal_ver) {
+
+  int right, bot, diff;
+
+  if (equal_hor) {
+    right = in->Xsize - left - width;
+    diff = right - left;
+    if (diff > 0) {
+      width += diff;
+    } else if (diff < 0) {
+      left = right;
+      width -= diff;
     }
   }
 
-  if (premultiplied) {
-    if (vips_unpremultiply(in, &t[7], NULL)) {
-      clear_image(&base);
-      return 1;
+  if (equal_ver) {
+    bot = in->Ysize - top - height;
+    diff = bot - top;
+    if (diff > 0) {
+      height += diff;
+    } else if (diff < 0) {
+      top = bot;
+      height -= diff;
     }
-
-    in = t[7];
   }
 
-  int res =
-    vips_colourspace(in, &t[8], interpretation, NULL) ||
-    vips_cast(t[8], out, format, NULL);
-
-  clear_image(&base);
-
-  return res;
-}
-
-int
-vips_flatten_go(VipsImage *in, VipsImage **out, double r, double g, double b) {
-  if (!vips_image_hasalpha(in))
+  if (width == 0 || height == 0) {
     return vips_copy(in, out, NULL);
+  }
 
-  VipsArrayDouble *bg = vips_array_double_newv(3, r, g, b);
-  int res = vips_flatten(in, out, "background", bg, NULL);
-  vips_area_unref((VipsArea *)bg);
-  return res;
-}
-
-int
-vips_extract_area_go(VipsImage *in, VipsImage **out, int left, int top, int width, int height) {
   return vips_extract_area(in, out, left, top, width, height, NULL);
 }
 
@@ -451,7 +1160,7 @@ vips_trim(VipsImage *in, VipsImage **out, double threshold,
     bga = vips_array_double_newv(3, r, g, b);
   }
 
-  int left, right, top, bot, width, height, diff;
+  int left, top, width, height;
   int res = vips_find_trim(tmp, &left, &top, &width, &height, "background", bga, "threshold", threshold, NULL);
 
   clear_image(&base);
@@ -462,33 +1171,78 @@ vips_trim(VipsImage *in, VipsImage **out, double threshold,
     return 1;
   }
 
-  if (equal_hor) {
-    right = in->Xsize - left - width;
-    diff = right - left;
-    if (diff > 0) {
-      width += diff;
-    } else if (diff < 0) {
-      left = right;
-      width -= diff;
-    }
-  }
+  return vips_trim_extract(in, out, left, top, width, height, equal_hor, equal_ver);
+}
 
-  if (equal_ver) {
-    bot = in->Ysize - top - height;
-    diff = bot - top;
-    if (diff > 0) {
-      height += diff;
-    } else if (diff < 0) {
-      top = bot;
-      height -= diff;
-    }
-  }
+int
+vips_trim_alpha(VipsImage *in, VipsImage **out, double threshold,
+                gboolean equal_hor, gboolean equal_ver) {
 
-  if (width == 0 || height == 0) {
+  if (!vips_image_hasalpha(in))
     return vips_copy(in, out, NULL);
+
+  VipsImage *alpha;
+
+  if (vips_extract_band(in, &alpha, in->Bands - 1, "n", 1, NULL))
+    return 1;
+
+  if (vips_image_get_format(alpha) == VIPS_FORMAT_USHORT)
+    threshold *= 257.0;
+
+  VipsArrayDouble *bga = vips_array_double_newv(1, 0.0);
+
+  int left, top, width, height;
+  int res = vips_find_trim(alpha, &left, &top, &width, &height, "background", bga, "threshold", threshold, NULL);
+
+  clear_image(&alpha);
+  vips_area_unref((VipsArea *)bga);
+
+  if (res) {
+    return 1;
   }
 
-  return vips_extract_area(in, out, left, top, width, height, NULL);
+  return vips_trim_extract(in, out, left, top, width, height, equal_hor, equal_ver);
+}
+
+int
+vips_rotate_go(VipsImage *in, VipsImage **out, double angle) {
+  VipsImage *tmp;
+
+  if (vips_ensure_alpha(in, &tmp))
+    return 1;
+
+  // Fill the uncovered area with the transparent color
+  double *bg = g_new0(double, tmp->Bands);
+  VipsArrayDouble *bga = vips_array_double_new(bg, tmp->Bands);
+  g_free(bg);
+
+  int res = vips_rotate(tmp, out, angle, "background", bga, NULL);
+
+  clear_image(&tmp);
+  vips_area_unref((VipsArea *)bga);
+
+  return res;
+}
+
+int
+vips_tint_go(VipsImage *in, VipsImage **out, double r, double g, double b) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 6);
+
+  double mul[3] = {r / 255.0, g / 255.0, b / 255.0};
+  double add[3] = {0, 0, 0};
+
+  int res =
+    vips_ensure_alpha(in, &t[0]) ||
+    vips_extract_band(t[0], &t[1], 0, "n", t[0]->Bands - 1, NULL) ||
+    vips_extract_band(t[0], &t[2], t[0]->Bands - 1, "n", 1, NULL) ||
+    vips_linear(t[1], &t[3], mul, add, 3, NULL) ||
+    vips_bandjoin2(t[3], t[2], &t[4], NULL) ||
+    vips_cast(t[4], out, vips_image_get_format(in), NULL);
+
+  clear_image(&base);
+
+  return res;
 }
 
 int
@@ -565,13 +1319,92 @@ vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, doubl
   return res;
 }
 
+int
+vips_alpha_mask(VipsImage *in, VipsImage **out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  VipsBandFormat format = vips_image_get_format(in);
+
+  if (vips_image_hasalpha(in)) {
+    if (vips_extract_band(in, &t[0], in->Bands - 1, "n", 1, NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+  } else {
+    // No alpha means the image is fully opaque
+    double max_alpha = vips_interpretation_max_alpha(in->Type);
+
+    if (
+      vips_black(&t[1], in->Xsize, in->Ysize, NULL) ||
+      vips_linear1(t[1], &t[0], 1.0, max_alpha, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+  }
+
+  VipsInterpretation interpretation = format == VIPS_FORMAT_USHORT ?
+    VIPS_INTERPRETATION_GREY16 : VIPS_INTERPRETATION_B_W;
+
+  if (
+    vips_cast(t[0], &t[2], format, NULL) ||
+    vips_copy(t[2], out, "interpretation", interpretation, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  clear_image(&base);
+
+  vips_image_remove(*out, VIPS_META_ICC_NAME);
+
+  return 0;
+}
+
 int
 vips_arrayjoin_go(VipsImage **in, VipsImage **out, int n) {
   return vips_arrayjoin(in, out, n, "across", 1, NULL);
 }
 
+static void
+vips_decode_eval_cb(VipsImage *image, VipsProgress *progress, double *timeout) {
+  if (g_timer_elapsed(progress->start, NULL) > *timeout)
+    vips_image_set_kill(image, TRUE);
+}
+
+int
+vips_decode_go(VipsImage *in, VipsImage **out, double timeout, int *timed_out) {
+  VipsImage *mem = vips_image_new_memory();
+
+  *timed_out = 0;
+
+  vips_image_set_progress(mem, TRUE);
+  g_signal_connect(mem, "eval", G_CALLBACK(vips_decode_eval_cb), &timeout);
+
+  if (vips_image_write(in, mem)) {
+    *timed_out = vips_image_iskilled(mem);
+    clear_image(&mem);
+    return -1;
+  }
+
+  vips_image_set_progress(mem, FALSE);
+  g_signal_handlers_disconnect_by_func(mem, G_CALLBACK(vips_decode_eval_cb), &timeout);
+
+  *out = mem;
+
+  return 0;
+}
+
+int
+vips_set_resolution_go(VipsImage *in, VipsImage **out, double dpi) {
+  double res = dpi / 25.4;
+
+  return vips_copy(in, out, "xres", res, "yres", res, NULL);
+}
+
 int
-vips_strip(VipsImage *in, VipsImage **out, int keep_exif_copyright) {
+vips_strip(VipsImage *in, VipsImage **out, int keep_exif_copyright, int keep_orientation) {
   static double default_resolution = 72.0 / 25.4;
 
   if (vips_copy(
@@ -589,6 +1422,8 @@ vips_strip(VipsImage *in, VipsImage **out, int keep_exif_copyright) {
     if (strcmp(name, VIPS_META_ICC_NAME) == 0) continue;
     if (strcmp(name, "palette-bit-depth") == 0) continue;
 
+    if (keep_orientation && strcmp(name, VIPS_META_ORIENTATION) == 0) continue;
+
     if (keep_exif_copyright) {
       if (strcmp(name, VIPS_META_EXIF_NAME) == 0) continue;
       if (strcmp(name, "exif-ifd0-Copyright") == 0) continue;
@@ -603,6 +1438,21 @@ vips_strip(VipsImage *in, VipsImage **out, int keep_exif_copyright) {
   return 0;
 }
 
+static gint64
+vips_target_write_cb(VipsTargetCustom *target, const void *data, gint64 length, void *handle) {
+  return vipsTargetWrite((uintptr_t) handle, (void *) data, length);
+}
+
+/* Creates a target that passes the written data to the Go writer
+ * registered with the handle
+ */
+static VipsTarget *
+vips_target_go(uintptr_t handle) {
+  VipsTargetCustom *target = vips_target_custom_new();
+  g_signal_connect(target, "write", G_CALLBACK(vips_target_write_cb), (void *) handle);
+  return VIPS_TARGET(target);
+}
+
 int
 vips_jpegsave_go(VipsImage *in, void **buf, size_t *len, int quality, int interlace) {
   return vips_jpegsave_buffer(
@@ -615,24 +1465,182 @@ vips_jpegsave_go(VipsImage *in, void **buf, size_t *len, int quality, int interl
 }
 
 int
-vips_pngsave_go(VipsImage *in, void **buf, size_t *len, int interlace, int quantize, int colors) {
-  int bitdepth;
+vips_jpegsave_target_go(VipsImage *in, uintptr_t handle, int quality, int interlace) {
+  VipsTarget *target = vips_target_go(handle);
+
+  int res = vips_jpegsave_target(
+    in, target,
+    "Q", quality,
+    "optimize_coding", TRUE,
+    "interlace", interlace,
+    NULL
+  );
+
+  VIPS_UNREF(target);
+
+  return res;
+}
+
+/* Bayer 8x8 threshold matrix
+ */
+static double bayer_matrix[64] = {
+   0, 32,  8, 40,  2, 34, 10, 42,
+  48, 16, 56, 24, 50, 18, 58, 26,
+  12, 44,  4, 36, 14, 46,  6, 38,
+  60, 28, 52, 20, 62, 30, 54, 22,
+   3, 35, 11, 43,  1, 33,  9, 41,
+  51, 19, 59, 27, 49, 17, 57, 25,
+  15, 47,  7, 39, 13, 45,  5, 37,
+  63, 31, 55, 23, 61, 29, 53, 21
+};
+
+/* Adds the Bayer threshold pattern to the color bands of the image so the
+ * following quantization without error diffusion produces ordered dithering.
+ * The pattern amplitude is the distance between the color levels of the palette
+ */
+static int
+vips_ordered_dither(VipsImage *in, VipsImage **out, int colors) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 9);
+
+  int levels = 2;
+  while (levels * levels * levels < colors) levels++;
+
+  double amplitude = 255.0 / (levels - 1);
+
+  VipsImage *color = in;
+  VipsImage *alpha = NULL;
+
+  if (vips_image_hasalpha(in)) {
+    if (
+      vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, "n", 1, NULL)
+    ) {
+      clear_image(&base);
+      return 1;
+    }
+
+    color = t[0];
+    alpha = t[1];
+  }
+
+  t[2] = vips_image_new_matrix_from_array(8, 8, bayer_matrix, 64);
+  if (t[2] == NULL) {
+    clear_image(&base);
+    return 1;
+  }
+
+  if (
+    vips_replicate(t[2], &t[3], in->Xsize / 8 + 1, in->Ysize / 8 + 1, NULL) ||
+    vips_extract_area(t[3], &t[4], 0, 0, in->Xsize, in->Ysize, NULL) ||
+    vips_linear1(t[4], &t[5], amplitude / 64.0, amplitude * (0.5 / 64.0 - 0.5), NULL) ||
+    vips_add(color, t[5], &t[6], NULL) ||
+    vips_cast(t[6], &t[7], in->BandFmt, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  color = t[7];
+
+  if (alpha != NULL) {
+    if (vips_bandjoin2(color, alpha, &t[8], NULL)) {
+      clear_image(&base);
+      return 1;
+    }
+
+    color = t[8];
+  }
+
+  int res = vips_copy(color, out, "interpretation", in->Type, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+/* Returns the amount of the error diffusion dithering used during quantization
+ */
+static double
+vips_dither_amount(int dither) {
+  return (dither == DITHER_NONE || dither == DITHER_ORDERED) ? 0.0 : 1.0;
+}
 
-  if (quantize) {
-    bitdepth = 1;
-    if (colors > 16) bitdepth = 8;
-    else if (colors > 4) bitdepth = 4;
-    else if (colors > 2) bitdepth = 2;
+/* Calculates the bit depth of the resulting PNG and applies ordered dithering
+ * if needed. The dithered image should be cleared after saving
+ */
+static int
+vips_pngsave_prepare(VipsImage **in, int *quantize, int *colors, int *bitdepth, int dither, VipsImage **dithered) {
+  if (*quantize) {
+    *bitdepth = 1;
+    if (*colors > 16) *bitdepth = 8;
+    else if (*colors > 4) *bitdepth = 4;
+    else if (*colors > 2) *bitdepth = 2;
   } else {
-    bitdepth = vips_get_palette_bit_depth(in);
-    if (bitdepth) {
-      if (bitdepth > 4) bitdepth = 8;
-      else if (bitdepth > 2) bitdepth = 4;
-      quantize = 1;
-      colors = 1 << bitdepth;
+    *bitdepth = vips_get_palette_bit_depth(*in);
+    if (*bitdepth) {
+      if (*bitdepth > 4) *bitdepth = 8;
+      else if (*bitdepth > 2) *bitdepth = 4;
+      *quantize = 1;
+      *colors = 1 << *bitdepth;
     }
   }
 
+  if (*quantize && dither == DITHER_ORDERED) {
+    if (vips_ordered_dither(*in, dithered, *colors))
+      return 1;
+
+    *in = *dithered;
+  }
+
+  return 0;
+}
+
+int
+vips_pngsave_target_go(VipsImage *in, uintptr_t handle, int interlace, int quantize, int colors, int dither) {
+  int bitdepth;
+  VipsImage *dithered = NULL;
+
+  if (vips_pngsave_prepare(&in, &quantize, &colors, &bitdepth, dither, &dithered))
+    return 1;
+
+  VipsTarget *target = vips_target_go(handle);
+  int res;
+
+  if (!quantize)
+    res = vips_pngsave_target(
+      in, target,
+      "filter", VIPS_FOREIGN_PNG_FILTER_NONE,
+      "interlace", interlace,
+      NULL
+    );
+  else
+    res = vips_pngsave_target(
+      in, target,
+      "filter", VIPS_FOREIGN_PNG_FILTER_NONE,
+      "interlace", interlace,
+      "palette", quantize,
+      "bitdepth", bitdepth,
+      "dither", vips_dither_amount(dither),
+      NULL
+    );
+
+  VIPS_UNREF(target);
+
+  if (dithered != NULL)
+    clear_image(&dithered);
+
+  return res;
+}
+
+int
+vips_pngsave_go(VipsImage *in, void **buf, size_t *len, int interlace, int quantize, int colors, int dither) {
+  int bitdepth;
+  VipsImage *dithered = NULL;
+
+  if (vips_pngsave_prepare(&in, &quantize, &colors, &bitdepth, dither, &dithered))
+    return 1;
+
   if (!quantize)
     return vips_pngsave_buffer(
       in, buf, len,
@@ -641,18 +1649,32 @@ vips_pngsave_go(VipsImage *in, void **buf, size_t *len, int interlace, int quant
       NULL
     );
 
-  return vips_pngsave_buffer(
+  int res = vips_pngsave_buffer(
     in, buf, len,
     "filter", VIPS_FOREIGN_PNG_FILTER_NONE,
     "interlace", interlace,
     "palette", quantize,
     "bitdepth", bitdepth,
+    "dither", vips_dither_amount(dither),
     NULL
   );
+
+  if (dithered != NULL)
+    clear_image(&dithered);
+
+  return res;
 }
 
 int
-vips_webpsave_go(VipsImage *in, void **buf, size_t *len, int quality) {
+vips_webpsave_go(VipsImage *in, void **buf, size_t *len, int quality, int alpha_quality) {
+  if (alpha_quality > 0)
+    return vips_webpsave_buffer(
+      in, buf, len,
+      "Q", quality,
+      "alpha_q", alpha_quality,
+      NULL
+    );
+
   return vips_webpsave_buffer(
     in, buf, len,
     "Q", quality,
@@ -661,9 +1683,23 @@ vips_webpsave_go(VipsImage *in, void **buf, size_t *len, int quality) {
 }
 
 int
-vips_gifsave_go(VipsImage *in, void **buf, size_t *len) {
+vips_gifsave_go(VipsImage *in, void **buf, size_t *len, int dither) {
 #if VIPS_SUPPORT_GIFSAVE
-  return vips_gifsave_buffer(in, buf, len, NULL);
+  VipsImage *dithered = NULL;
+
+  if (dither == DITHER_ORDERED) {
+    if (vips_ordered_dither(in, &dithered, 256))
+      return 1;
+
+    in = dithered;
+  }
+
+  int res = vips_gifsave_buffer(in, buf, len, "dither", vips_dither_amount(dither), NULL);
+
+  if (dithered != NULL)
+    clear_image(&dithered);
+
+  return res;
 #else
   vips_error("vips_gifsave_go", "Saving GIF is not supported (libvips 8.12+ reuired)");
   return 1;
@@ -9,27 +9,58 @@ import "C"
 import (
 	"errors"
 	 "fmt"
+	"io"
 	"math"
-	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"unsafe"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/imgproxy/imgproxy/v3/config"
+	"github.com/imgproxy/imgproxy/v3/config/configurators"
 	"github.com/imgproxy/imgproxy/v3/ierrors"
 	"github.com/imgproxy/imgproxy/v3/imagedata"
+	"github.com/imgproxy/imgproxy/v3/imagemeta"
 	"github.com/imgproxy/imgproxy/v3/imagetype"
 	"github.com/imgproxy/imgproxy/v3/metrics/datadog"
 	"github.com/imgproxy/imgproxy/v3/metrics/newrelic"
 	"github.com/imgproxy/imgproxy/v3/metrics/prometheus"
+	"github.com/imgproxy/imgproxy/v3/metrics/statsd"
 )
 
 type Image struct {
 	VipsImage *C.VipsImage
 }
 
+type Kernel int
+
+const (
+	KernelNearest  = Kernel(C.VIPS_KERNEL_NEAREST)
+	KernelLinear   = Kernel(C.VIPS_KERNEL_LINEAR)
+	KernelCubic    = Kernel(C.VIPS_KERNEL_CUBIC)
+	KernelMitchell = Kernel(C.VIPS_KERNEL_MITCHELL)
+	KernelLanczos2 = Kernel(C.VIPS_KERNEL_LANCZOS2)
+	KernelLanczos3 = Kernel(C.VIPS_KERNEL_LANCZOS3)
+)
+
+type Dither int
+
+const (
+	DitherDefault        = Dither(C.DITHER_DEFAULT)
+	DitherNone           = Dither(C.DITHER_NONE)
+	DitherFloydSteinberg = Dither(C.DITHER_FLOYD_STEINBERG)
+	DitherOrdered        = Dither(C.DITHER_ORDERED)
+)
+
+type Interesting int
+
+const (
+	InterestingAttention = Interesting(C.VIPS_INTERESTING_ATTENTION)
+	InterestingEntropy   = Interesting(C.VIPS_INTERESTING_ENTROPY)
+)
+
 var (
 	typeSupportLoad sync.Map
 	typeSupportSave sync.Map
@@ -37,9 +68,11 @@ var (
 	gifResolutionLimit int
 )
 
+// AVIF speed used in the reproducible mode. Matches the default IMGPROXY_AVIF_SPEED
+const reproducibleAvifSpeed = 5
+
 var vipsConf struct {
 	JpegProgressive       C.int
-	PngInterlaced         C.int
 	PngQuantize           C.int
 	PngQuantizationColors C.int
 	AvifSpeed             C.int
@@ -65,18 +98,21 @@ func Init() error {
 	// It's better to disable it since profit it quite small
 	C.vips_vector_set_enabled(0)
 
-	if len(os.Getenv("IMGPROXY_VIPS_LEAK_CHECK")) > 0 {
+	var leakCheck, cacheTrace string
+	configurators.String(&leakCheck, "IMGPROXY_VIPS_LEAK_CHECK")
+	configurators.String(&cacheTrace, "IMGPROXY_VIPS_CACHE_TRACE")
+
+	if len(leakCheck) > 0 {
 		C.vips_leak_set(C.gboolean(1))
 	}
 
-	if len(os.Getenv("IMGPROXY_VIPS_CACHE_TRACE")) > 0 {
+	if len(cacheTrace) > 0 {
 		C.vips_cache_set_trace(C.gboolean(1))
 	}
 
 	gifResolutionLimit = int(C.gif_resolution_limit())
 
 	vipsConf.JpegProgressive = gbool(config.JpegProgressive)
-	vipsConf.PngInterlaced = gbool(config.PngInterlaced)
 	vipsConf.PngQuantize = gbool(config.PngQuantize)
 	vipsConf.PngQuantizationColors = C.int(config.PngQuantizationColors)
 	vipsConf.AvifSpeed = C.int(config.AvifSpeed)
@@ -101,6 +137,10 @@ func Init() error {
 	datadog.AddGaugeFunc("vips.max_memory", GetMemHighwater)
 	datadog.AddGaugeFunc("vips.allocs", GetAllocs)
 
+	statsd.AddGaugeFunc("vips.memory", GetMem)
+	statsd.AddGaugeFunc("vips.max_memory", GetMemHighwater)
+	statsd.AddGaugeFunc("vips.allocs", GetAllocs)
+
 	newrelic.AddGaugeFunc("vips.memory", GetMem)
 	newrelic.AddGaugeFunc("vips.max_memory", GetMemHighwater)
 	newrelic.AddGaugeFunc("vips.allocs", GetAllocs)
@@ -133,6 +173,24 @@ func Error() error {
 	return ierrors.NewUnexpected(C.GoString(C.vips_error_buffer()), 1)
 }
 
+var ErrDecodeTimeout = ierrors.New(422, "Source image decoding is timed out", "Invalid source image")
+
+// loadError returns the libvips error as an error of the image that can't be decoded.
+// The error is counted as unprocessable_image by the metrics. Callers that load
+// something other than the source image should reset the error type
+func loadError() error {
+	defer C.vips_error_clear()
+
+	err := ierrors.New(
+		422,
+		fmt.Sprintf("Can't decode source image: %s", strings.TrimSpace(C.GoString(C.vips_error_buffer()))),
+		"Source image is corrupted or can't be decoded",
+	)
+	err.Type = "unprocessable_image"
+
+	return err
+}
+
 func hasOperation(name string) bool {
 	return C.vips_type_find(cachedCString("VipsOperation"), cachedCString(name)) != 0
 }
@@ -254,7 +312,7 @@ func (img *Image) Load(imgdata *imagedata.ImageData, shrink int, scale float64,
 		return errors.New("Usupported image type to load")
 	}
 	if err != 0 {
-		return Error()
+		return loadError()
 	}
 
 	C.swap_and_clear(&img.VipsImage, tmp)
@@ -263,6 +321,10 @@ func (img *Image) Load(imgdata *imagedata.ImageData, shrink int, scale float64,
 }
 
 func (img *Image) LoadThumbnail(imgdata *imagedata.ImageData) error {
+	if imgdata.Type == imagetype.JPEG {
+		return img.loadJpegExifThumbnail(imgdata)
+	}
+
 	if imgdata.Type != imagetype.HEIC && imgdata.Type != imagetype.AVIF {
 		return errors.New("Usupported image type to load thumbnail")
 	}
@@ -281,7 +343,64 @@ func (img *Image) LoadThumbnail(imgdata *imagedata.ImageData) error {
 	return nil
 }
 
-func (img *Image) Save(imgtype imagetype.Type, quality int) (*imagedata.ImageData, error) {
+func (img *Image) loadJpegExifThumbnail(imgdata *imagedata.ImageData) error {
+	thumbnail := imagemeta.JpegExifThumbnail(imgdata.Data)
+	if thumbnail == nil {
+		return errors.New("Image doesn't have an embedded EXIF thumbnail")
+	}
+
+	var tmp *C.VipsImage
+
+	data := unsafe.Pointer(&thumbnail[0])
+	dataSize := C.size_t(len(thumbnail))
+
+	if err := C.vips_jpegload_go(data, dataSize, C.int(1), &tmp); err != 0 {
+		return Error()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// SaveOptions contains per-request saving options that override the ones
+// from the config
+type SaveOptions struct {
+	PngInterlaced bool
+	// Dither is the dithering applied during palette quantization.
+	// Any dithering except the default one enables PNG quantization
+	Dither Dither
+	// WebpAlphaQuality is the quality of the WebP alpha plane.
+	// 0 means the encoder default
+	WebpAlphaQuality int
+	// Reproducible makes the encoders ignore the config so the same image
+	// is always saved to the same bytes
+	Reproducible bool
+}
+
+// encoderSettings returns the encoder settings from the config with the per-request
+// overrides applied
+func encoderSettings(opts SaveOptions) (jpegProgressive, pngQuantize, avifSpeed C.int) {
+	jpegProgressive = vipsConf.JpegProgressive
+	pngQuantize = vipsConf.PngQuantize
+	avifSpeed = vipsConf.AvifSpeed
+
+	if opts.Dither != DitherDefault {
+		pngQuantize = gbool(true)
+	}
+
+	if opts.Reproducible {
+		// Quantization depends on the config and isn't guaranteed to be stable,
+		// so we pin the encoders to the default settings
+		jpegProgressive = gbool(false)
+		pngQuantize = gbool(false)
+		avifSpeed = C.int(reproducibleAvifSpeed)
+	}
+
+	return
+}
+
+func (img *Image) Save(imgtype imagetype.Type, quality int, opts SaveOptions) (*imagedata.ImageData, error) {
 	if imgtype == imagetype.ICO {
 		return img.saveAsIco()
 	}
@@ -295,20 +414,22 @@ func (img *Image) Save(imgtype imagetype.Type, quality int) (*imagedata.ImageDat
 		C.g_free_go(&ptr)
 	}
 
+	jpegProgressive, pngQuantize, avifSpeed := encoderSettings(opts)
+
 	err := C.int(0)
 	imgsize := C.size_t(0)
 
 	switch imgtype {
 	case imagetype.JPEG:
-		err = C.vips_jpegsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality), vipsConf.JpegProgressive)
+		err = C.vips_jpegsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality), jpegProgressive)
 	case imagetype.PNG:
-		err = C.vips_pngsave_go(img.VipsImage, &ptr, &imgsize, vipsConf.PngInterlaced, vipsConf.PngQuantize, vipsConf.PngQuantizationColors)
+		err = C.vips_pngsave_go(img.VipsImage, &ptr, &imgsize, gbool(opts.PngInterlaced), pngQuantize, vipsConf.PngQuantizationColors, C.int(opts.Dither))
 	case imagetype.WEBP:
-		err = C.vips_webpsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality))
+		err = C.vips_webpsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality), C.int(opts.WebpAlphaQuality))
 	case imagetype.GIF:
-		err = C.vips_gifsave_go(img.VipsImage, &ptr, &imgsize)
+		err = C.vips_gifsave_go(img.VipsImage, &ptr, &imgsize, C.int(opts.Dither))
 	case imagetype.AVIF:
-		err = C.vips_avifsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality), vipsConf.AvifSpeed)
+		err = C.vips_avifsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality), avifSpeed)
 	case imagetype.TIFF:
 		err = C.vips_tiffsave_go(img.VipsImage, &ptr, &imgsize, C.int(quality))
 	default:
@@ -329,6 +450,37 @@ func (img *Image) Save(imgtype imagetype.Type, quality int) (*imagedata.ImageDat
 	return &imgdata, nil
 }
 
+// CanSaveTo checks if the image of the type can be written by the encoder
+// incrementally with SaveTo
+func CanSaveTo(imgtype imagetype.Type) bool {
+	return imgtype == imagetype.JPEG || imgtype == imagetype.PNG
+}
+
+// SaveTo encodes the image and writes the result to w as the encoder produces it,
+// so the whole result doesn't need to be buffered
+func (img *Image) SaveTo(w io.Writer, imgtype imagetype.Type, quality int, opts SaveOptions) error {
+	handle := registerTargetWriter(w)
+	defer unregisterTargetWriter(handle)
+
+	jpegProgressive, pngQuantize, _ := encoderSettings(opts)
+
+	err := C.int(0)
+
+	switch imgtype {
+	case imagetype.JPEG:
+		err = C.vips_jpegsave_target_go(img.VipsImage, handle, C.int(quality), jpegProgressive)
+	case imagetype.PNG:
+		err = C.vips_pngsave_target_go(img.VipsImage, handle, gbool(opts.PngInterlaced), pngQuantize, vipsConf.PngQuantizationColors, C.int(opts.Dither))
+	default:
+		return errors.New("Usupported image type to save to writer")
+	}
+	if err != 0 {
+		return Error()
+	}
+
+	return nil
+}
+
 func (img *Image) Clear() {
 	if img.VipsImage != nil {
 		C.clear_image(&img.VipsImage)
@@ -465,10 +617,10 @@ func (img *Image) Rad2Float() error {
 	return nil
 }
 
-func (img *Image) Resize(wscale, hscale float64) error {
+func (img *Image) Resize(wscale, hscale float64, kernel Kernel) error {
 	var tmp *C.VipsImage
 
-	if C.vips_resize_go(img.VipsImage, &tmp, C.double(wscale), C.double(hscale)) != 0 {
+	if C.vips_resize_go(img.VipsImage, &tmp, C.double(wscale), C.double(hscale), C.VipsKernel(kernel)) != 0 {
 		return Error()
 	}
 
@@ -481,15 +633,32 @@ func (img *Image) Orientation() C.int {
 	return C.vips_get_orientation(img.VipsImage)
 }
 
+// Rotate rotates the image clockwise. It doesn't touch the EXIF orientation,
+// use RemoveOrientation when the orientation is applied
 func (img *Image) Rotate(angle int) error {
 	var tmp *C.VipsImage
 
 	vipsAngle := (angle / 90) % 4
 
+	if vipsAngle == 0 {
+		return nil
+	}
+
 	if C.vips_rot_go(img.VipsImage, &tmp, C.VipsAngle(vipsAngle)) != 0 {
 		return Error()
 	}
 
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) RemoveOrientation() error {
+	var tmp *C.VipsImage
+
+	if C.vips_copy_go(img.VipsImage, &tmp) != 0 {
+		return Error()
+	}
+
 	C.vips_autorot_remove_angle(tmp)
 
 	C.swap_and_clear(&img.VipsImage, tmp)
@@ -507,6 +676,17 @@ func (img *Image) Flip() error {
 	return nil
 }
 
+func (img *Image) FlipVertical() error {
+	var tmp *C.VipsImage
+
+	if C.vips_flip_vertical_go(img.VipsImage, &tmp) != 0 {
+		return Error()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
 func (img *Image) Crop(left, top, width, height int) error {
 	var tmp *C.VipsImage
 
@@ -525,15 +705,33 @@ func (img *Image) Extract(out *Image, left, top, width, height int) error {
 	return nil
 }
 
-func (img *Image) SmartCrop(width, height int) error {
-	// var tmp *C.VipsImage
+// SmartCropPosition returns the position of the most interesting area
+// of the provided size detected with the provided strategy
+func (img *Image) SmartCropPosition(width, height int, interesting Interesting) (left, top int, err error) {
+	var cLeft, cTop C.int
 
-	// if C.vips_smartcrop_go(img.VipsImage, &tmp, C.int(width), C.int(height)) != 0 {
-	// 	return Error()
-	// }
+	if C.vips_smartcrop_go(img.VipsImage, C.int(width), C.int(height), C.VipsInteresting(interesting), &cLeft, &cTop) != 0 {
+		return 0, 0, Error()
+	}
 
-	// C.swap_and_clear(&img.VipsImage, tmp)
-	return nil
+	return int(cLeft), int(cTop), nil
+}
+
+// AlphaCentroid returns the relative coordinates of the centroid
+// of non-transparent pixels. If the image has no alpha channel
+// or its alpha is uniform, ok is false.
+func (img *Image) AlphaCentroid() (x, y float64, ok bool, err error) {
+	var cx, cy C.double
+
+	if C.vips_alpha_centroid(img.VipsImage, &cx, &cy) != 0 {
+		return 0, 0, false, Error()
+	}
+
+	if cx < 0 || cy < 0 {
+		return 0, 0, false, nil
+	}
+
+	return float64(cx), float64(cy), true, nil
 }
 
 func (img *Image) Trim(threshold float64, smart bool, color Color, equalHor bool, equalVer bool) error {
@@ -553,6 +751,21 @@ func (img *Image) Trim(threshold float64, smart bool, color Color, equalHor bool
 	return nil
 }
 
+func (img *Image) TrimAlpha(threshold float64, equalHor bool, equalVer bool) error {
+	var tmp *C.VipsImage
+
+	if err := img.CopyMemory(); err != nil {
+		return err
+	}
+
+	if C.vips_trim_alpha(img.VipsImage, &tmp, C.double(threshold), gbool(equalHor), gbool(equalVer)) != 0 {
+		return Error()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
 func (img *Image) EnsureAlpha() error {
 	var tmp *C.VipsImage
 
@@ -564,6 +777,42 @@ func (img *Image) EnsureAlpha() error {
 	return nil
 }
 
+// Sharpness returns the mean absolute Laplacian of the image luminance.
+// Sharper images with more fine details have greater values
+func (img *Image) Sharpness() (float64, error) {
+	var sharpness C.double
+
+	if C.vips_sharpness_go(img.VipsImage, &sharpness) != 0 {
+		return 0, Error()
+	}
+
+	return float64(sharpness), nil
+}
+
+// LaplacianVariance returns the variance of the Laplacian of the image luminance.
+// It's a common blur metric: blurry images have low values
+func (img *Image) LaplacianVariance() (float64, error) {
+	var variance C.double
+
+	if C.vips_laplacian_variance_go(img.VipsImage, &variance) != 0 {
+		return 0, Error()
+	}
+
+	return float64(variance), nil
+}
+
+// LuminanceDeviation returns the standard deviation of the image luminance.
+// Images that are mostly one color have values close to zero
+func (img *Image) LuminanceDeviation() (float64, error) {
+	var deviation C.double
+
+	if C.vips_luminance_deviation_go(img.VipsImage, &deviation) != 0 {
+		return 0, Error()
+	}
+
+	return float64(deviation), nil
+}
+
 func (img *Image) Flatten(bg Color) error {
 	var tmp *C.VipsImage
 
@@ -575,10 +824,82 @@ func (img *Image) Flatten(bg Color) error {
 	return nil
 }
 
-func (img *Image) ApplyFilters(blurSigma, sharpSigma float32, pixelatePixels int) error {
+// ApplyFilters applies the gaussian blur, sharpening, and pixelation.
+// blurMinAmpl is the minimum amplitude of the gaussian mask.
+// sharpFlat and sharpJagged are the sharpening amounts for flat and jagged areas.
+// When sharpLinear is true, the image is sharpened in linear light
+func (img *Image) ApplyFilters(blurSigma, blurMinAmpl, sharpSigma, sharpFlat, sharpJagged float32, sharpLinear bool, pixelatePixels int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_apply_filters(
+		img.VipsImage, &tmp,
+		C.double(blurSigma), C.double(blurMinAmpl), C.double(sharpSigma), C.double(sharpFlat), C.double(sharpJagged),
+		gbool(sharpLinear), C.int(pixelatePixels),
+	) != 0 {
+		return Error()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *Image) Edges(strength float64, grayscale bool) error {
+	var tmp *C.VipsImage
+
+	if C.vips_edges(img.VipsImage, &tmp, C.double(strength), gbool(grayscale)) != 0 {
+		return Error()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// Conv convolves the image with the square matrix.
+// The matrix is expected to be in row-major order.
+func (img *Image) Conv(matrix []float64, scale, offset float64) error {
+	var tmp *C.VipsImage
+
+	size := int(math.Sqrt(float64(len(matrix))))
+	if size*size != len(matrix) {
+		return errors.New("Convolution matrix should be square")
+	}
+
+	cmatrix := make([]C.double, len(matrix))
+	for i, v := range matrix {
+		cmatrix[i] = C.double(v)
+	}
+
+	if C.vips_conv_go(img.VipsImage, &tmp, &cmatrix[0], C.int(size), C.double(scale), C.double(offset)) != 0 {
+		return Error()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// AutoWhiteBalance removes the color cast of the image using the gray world assumption.
+// The image should be in the sRGB colourspace
+func (img *Image) AutoWhiteBalance() error {
+	var tmp *C.VipsImage
+
+	if C.vips_auto_white_balance_go(img.VipsImage, &tmp) != 0 {
+		return Error()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// Normalize stretches the image levels so they cover the full range.
+// clip is the percentage of the darkest and the brightest pixels that are clipped
+func (img *Image) Normalize(clip float64) error {
 	var tmp *C.VipsImage
 
-	if C.vips_apply_filters(img.VipsImage, &tmp, C.double(blurSigma), C.double(sharpSigma), C.int(pixelatePixels)) != 0 {
+	if C.vips_normalize_go(img.VipsImage, &tmp, C.double(clip)) != 0 {
 		return Error()
 	}
 
@@ -587,6 +908,42 @@ func (img *Image) ApplyFilters(blurSigma, sharpSigma float32, pixelatePixels int
 	return nil
 }
 
+// Vignette darkens the image towards the corners by blending it with the provided color.
+// The image should be in the sRGB colourspace
+func (img *Image) Vignette(strength float64, color Color) error {
+	var tmp *C.VipsImage
+
+	if C.vips_vignette_go(img.VipsImage, &tmp, C.double(strength), C.double(color.R), C.double(color.G), C.double(color.B)) != 0 {
+		return Error()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// Grain adds monochrome gaussian noise with the provided standard deviation to the image.
+// When seeded is true, the noise is generated using the provided seed so it is reproducible
+func (img *Image) Grain(sigma float64, seeded bool, seed int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_grain_go(img.VipsImage, &tmp, C.double(sigma), gbool(seeded), C.int(seed)) != 0 {
+		return Error()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *Image) IsSRGB() bool {
+	return C.vips_image_guess_interpretation(img.VipsImage) == C.VIPS_INTERPRETATION_sRGB
+}
+
+func (img *Image) HasColourProfile() bool {
+	return C.vips_has_embedded_icc(img.VipsImage) != 0
+}
+
 func (img *Image) IsCMYK() bool {
 	return C.vips_image_guess_interpretation(img.VipsImage) == C.VIPS_INTERPRETATION_CMYK
 }
@@ -708,6 +1065,26 @@ func (img *Image) CopyMemory() error {
 	return nil
 }
 
+// Decode decodes the image to memory. If decoding takes longer than timeout
+// seconds, it's aborted and ErrDecodeTimeout is returned
+func (img *Image) Decode(timeout float64) error {
+	var (
+		tmp      *C.VipsImage
+		timedOut C.int
+	)
+
+	if C.vips_decode_go(img.VipsImage, &tmp, C.double(timeout), &timedOut) != 0 {
+		if timedOut != 0 {
+			C.vips_error_clear()
+			return ErrDecodeTimeout
+		}
+		return Error()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
 func (img *Image) Replicate(width, height int) error {
 	var tmp *C.VipsImage
 
@@ -730,6 +1107,32 @@ func (img *Image) Embed(width, height int, offX, offY int) error {
 	return nil
 }
 
+// RotateAngle rotates the image clockwise by the arbitrary angle.
+// The image is enlarged to fit the rotated content, the uncovered area is transparent
+func (img *Image) RotateAngle(angle float64) error {
+	var tmp *C.VipsImage
+
+	if C.vips_rotate_go(img.VipsImage, &tmp, C.double(angle)) != 0 {
+		return Error()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// Tint multiplies the image color channels by the provided color keeping the alpha intact.
+// The image should be in the sRGB colourspace
+func (img *Image) Tint(color Color) error {
+	var tmp *C.VipsImage
+
+	if C.vips_tint_go(img.VipsImage, &tmp, C.double(color.R), C.double(color.G), C.double(color.B)) != 0 {
+		return Error()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
 func (img *Image) ApplyWatermark(wm *Image, opacity float64) error {
 	var tmp *C.VipsImage
 
@@ -741,10 +1144,33 @@ func (img *Image) ApplyWatermark(wm *Image, opacity float64) error {
 	return nil
 }
 
-func (img *Image) Strip(keepExifCopyright bool) error {
+func (img *Image) AlphaMask() error {
+	var tmp *C.VipsImage
+
+	if C.vips_alpha_mask(img.VipsImage, &tmp) != 0 {
+		return Error()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// SetResolution sets the image resolution in dots per inch
+func (img *Image) SetResolution(dpi float64) error {
+	var tmp *C.VipsImage
+
+	if C.vips_set_resolution_go(img.VipsImage, &tmp, C.double(dpi)) != 0 {
+		return Error()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *Image) Strip(keepExifCopyright, keepOrientation bool) error {
 	var tmp *C.VipsImage
 
-	if C.vips_strip(img.VipsImage, &tmp, gbool(keepExifCopyright)) != 0 {
+	if C.vips_strip(img.VipsImage, &tmp, gbool(keepExifCopyright), gbool(keepOrientation)) != 0 {
 		return Error()
 	}
 	C.swap_and_clear(&img.VipsImage, tmp)
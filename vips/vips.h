@@ -4,6 +4,11 @@
 #include <vips/vips7compat.h>
 #include <vips/vector.h>
 
+#define DITHER_DEFAULT 0
+#define DITHER_NONE 1
+#define DITHER_FLOYD_STEINBERG 2
+#define DITHER_ORDERED 3
+
 int vips_initialize();
 
 void clear_image(VipsImage **in);
@@ -40,7 +45,7 @@ int vips_copy_go(VipsImage *in, VipsImage **out);
 int vips_cast_go(VipsImage *in, VipsImage **out, VipsBandFormat format);
 int vips_rad2float_go(VipsImage *in, VipsImage **out);
 
-int vips_resize_go(VipsImage *in, VipsImage **out, double wscale, double hscale);
+int vips_resize_go(VipsImage *in, VipsImage **out, double wscale, double hscale, VipsKernel kernel);
 
 int vips_icc_is_srgb_iec61966(VipsImage *in);
 int vips_has_embedded_icc(VipsImage *in);
@@ -53,33 +58,59 @@ int vips_colourspace_go(VipsImage *in, VipsImage **out, VipsInterpretation cs);
 
 int vips_rot_go(VipsImage *in, VipsImage **out, VipsAngle angle);
 int vips_flip_horizontal_go(VipsImage *in, VipsImage **out);
+int vips_flip_vertical_go(VipsImage *in, VipsImage **out);
 
 int vips_extract_area_go(VipsImage *in, VipsImage **out, int left, int top, int width, int height);
-int vips_smartcrop_go(VipsImage *in, VipsImage **out, int width, int height);
+int vips_smartcrop_go(VipsImage *in, int width, int height, VipsInteresting interesting,
+  int *left, int *top);
+int vips_alpha_centroid(VipsImage *in, double *x, double *y);
 int vips_trim(VipsImage *in, VipsImage **out, double threshold,
               gboolean smart, double r, double g, double b,
               gboolean equal_hor, gboolean equal_ver);
-
-int vips_apply_filters(VipsImage *in, VipsImage **out, double blur_sigma, double sharp_sigma, int pixelate_pixels);
+int vips_trim_alpha(VipsImage *in, VipsImage **out, double threshold,
+                    gboolean equal_hor, gboolean equal_ver);
+
+int vips_apply_filters(VipsImage *in, VipsImage **out, double blur_sigma, double blur_min_ampl,
+  double sharp_sigma, double sharp_flat, double sharp_jagged, gboolean sharp_linear, int pixelate_pixels);
+int vips_edges(VipsImage *in, VipsImage **out, double strength, gboolean grayscale);
+int vips_conv_go(VipsImage *in, VipsImage **out, double *matrix, int size, double scale, double offset);
+int vips_vignette_go(VipsImage *in, VipsImage **out, double strength, double r, double g, double b);
+int vips_grain_go(VipsImage *in, VipsImage **out, double sigma, gboolean seeded, int seed);
+int vips_normalize_go(VipsImage *in, VipsImage **out, double clip);
+int vips_auto_white_balance_go(VipsImage *in, VipsImage **out);
+int vips_sharpness_go(VipsImage *in, double *out);
+int vips_laplacian_variance_go(VipsImage *in, double *out);
+int vips_luminance_deviation_go(VipsImage *in, double *out);
 
 int vips_flatten_go(VipsImage *in, VipsImage **out, double r, double g, double b);
 
 int vips_replicate_go(VipsImage *in, VipsImage **out, int across, int down);
 int vips_embed_go(VipsImage *in, VipsImage **out, int x, int y, int width, int height);
+int vips_rotate_go(VipsImage *in, VipsImage **out, double angle);
+int vips_tint_go(VipsImage *in, VipsImage **out, double r, double g, double b);
 
 int vips_ensure_alpha(VipsImage *in, VipsImage **out);
 
 int vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, double opacity);
 
+int vips_alpha_mask(VipsImage *in, VipsImage **out);
+
 int vips_arrayjoin_go(VipsImage **in, VipsImage **out, int n);
 
-int vips_strip(VipsImage *in, VipsImage **out, int keep_exif_copyright);
+int vips_decode_go(VipsImage *in, VipsImage **out, double timeout, int *timed_out);
+int vips_set_resolution_go(VipsImage *in, VipsImage **out, double dpi);
+int vips_strip(VipsImage *in, VipsImage **out, int keep_exif_copyright, int keep_orientation);
 
 int vips_jpegsave_go(VipsImage *in, void **buf, size_t *len, int quality, int interlace);
-int vips_pngsave_go(VipsImage *in, void **buf, size_t *len, int interlace, int quantize, int colors);
-int vips_webpsave_go(VipsImage *in, void **buf, size_t *len, int quality);
-int vips_gifsave_go(VipsImage *in, void **buf, size_t *len);
+int vips_jpegsave_target_go(VipsImage *in, uintptr_t handle, int quality, int interlace);
+int vips_pngsave_go(VipsImage *in, void **buf, size_t *len, int interlace, int quantize, int colors, int dither);
+int vips_pngsave_target_go(VipsImage *in, uintptr_t handle, int interlace, int quantize, int colors, int dither);
+int vips_webpsave_go(VipsImage *in, void **buf, size_t *len, int quality, int alpha_quality);
+int vips_gifsave_go(VipsImage *in, void **buf, size_t *len, int dither);
 int vips_avifsave_go(VipsImage *in, void **buf, size_t *len, int quality, int speed);
 int vips_tiffsave_go(VipsImage *in, void **buf, size_t *len, int quality);
 
 void vips_cleanup();
+
+// from Go
+gint64 vipsTargetWrite(uintptr_t handle, void *data, gint64 length);